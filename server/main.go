@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"tab-blaster-server/routes"
+	"tab-blaster-server/services"
+	// Embed time zone data; the alpine runtime image has none
+	_ "time/tzdata"
 
 	"github.com/joho/godotenv"
 )
@@ -25,10 +29,45 @@ func main() {
 	// Setup routes
 	mux := routes.SetupRoutes()
 
+	// Start creating tasks from recurring task templates
+	if scheduler, err := services.NewTaskScheduler(); err != nil {
+		log.Printf("Warning: Failed to start task scheduler: %v", err)
+	} else {
+		go scheduler.Run(context.Background())
+	}
+
+	// Start delivering weekly reports to subscribed users
+	if reports, err := services.NewReportScheduler(); err != nil {
+		log.Printf("Warning: Failed to start report scheduler: %v", err)
+	} else {
+		go reports.Run(context.Background())
+	}
+
+	// Start checking saved links for breakage
+	if links, err := services.NewLinkChecker(); err != nil {
+		log.Printf("Warning: Failed to start link checker: %v", err)
+	} else {
+		go links.Run(context.Background())
+	}
+
+	// Start filling in missing page titles, favicons and details
+	if enrichment, err := services.NewEnrichmentWorker(); err != nil {
+		log.Printf("Warning: Failed to start enrichment worker: %v", err)
+	} else {
+		go enrichment.Run(context.Background())
+	}
+
+	// Start archiving the pages of saved tabs queued for it
+	if archives, err := services.NewArchiveWorker(); err != nil {
+		log.Printf("Warning: Failed to start archive worker: %v", err)
+	} else {
+		go archives.Run(context.Background())
+	}
+
 	// Setup server
 	server := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: routes.WithCompression(mux),
 	}
 
 	fmt.Printf("Server starting on port %s...\n", port)
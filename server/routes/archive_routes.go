@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// ArchiveManager is the consumer-driven interface for saved tab archives
+type ArchiveManager interface {
+	ArchiveSavedTabs(ctx context.Context, userID string, tabs []*services.SavedTab) error
+	ArchiveSavedTab(ctx context.Context, userID string, tabID int) (*services.SavedTab, error)
+	GetSavedTabArchive(ctx context.Context, userID string, tabID int, text bool) (*services.PageArchive, []byte, error)
+}
+
+// HandleTabArchive serves a saved tab's archived page (GET, with
+// format=text for its readable text) or archives it again (POST).
+// The token may be passed as access_token so archives open in a browser tab.
+func (udh *UserDataHandler) HandleTabArchive(w http.ResponseWriter, r *http.Request) {
+	r = withQueryAccessToken(r)
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	tabID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		udh.sendError(w, http.StatusBadRequest, "Invalid tab ID", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		text := r.URL.Query().Get("format") == "text"
+		archive, data, err := udh.userDataService.GetSavedTabArchive(ctx, userID, tabID, text)
+		if err != nil {
+			sendArchiveError(w, "Failed to fetch archive", err)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Cache-Control", "private, no-cache")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		if archivedAt, err := services.ParseTaskTime(archive.ArchivedAt); err == nil {
+			header.Set("Last-Modified", archivedAt.UTC().Format(http.TimeFormat))
+		}
+		if text {
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			header.Set("Content-Type", "text/html; charset=utf-8")
+			// Snapshots are sanitized already; the sandbox keeps anything
+			// missed from running or submitting, and only media may load
+			header.Set("Content-Security-Policy", "sandbox; default-src 'none'; img-src http: https: data:; style-src http: https: 'unsafe-inline'; font-src http: https: data:; media-src http: https:")
+		}
+		w.Write(data)
+
+	case http.MethodPost:
+		tab, err := udh.userDataService.ArchiveSavedTab(ctx, userID, tabID)
+		if err != nil {
+			sendArchiveError(w, "Failed to queue archive", err)
+			return
+		}
+		sendJSON(w, http.StatusAccepted, Response{
+			Message: "Archive queued successfully",
+			Data:    tab,
+		})
+
+	default:
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// sendArchiveError maps archive errors to status codes
+func sendArchiveError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrSavedTabNotFound), errors.Is(err, services.ErrArchiveNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrNotArchivable):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
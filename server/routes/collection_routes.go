@@ -0,0 +1,224 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for smart collection routes
+type CollectionManager interface {
+	ListCollections(ctx context.Context, userID string) ([]*services.SmartCollection, error)
+	GetCollection(ctx context.Context, userID, collectionID string) (*services.SmartCollection, error)
+	CreateCollection(ctx context.Context, userID string, collection *services.SmartCollection) error
+	UpdateCollection(ctx context.Context, userID string, collection *services.SmartCollection) error
+	DeleteCollection(ctx context.Context, userID, collectionID string) error
+	GetCollectionItems(ctx context.Context, userID, collectionID string) (*services.CollectionItems, error)
+}
+
+// CollectionHandler handles smart collection HTTP requests
+type CollectionHandler struct {
+	collectionManager CollectionManager
+	authService       UserAuthenticator
+}
+
+// NewCollectionHandler creates a new smart collection handler
+func NewCollectionHandler() (*CollectionHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CollectionHandler{
+		collectionManager: userDataService,
+		authService:       authService,
+	}, nil
+}
+
+// SetupCollectionRoutes adds smart collection routes to the provided mux
+func SetupCollectionRoutes(mux *http.ServeMux) error {
+	handler, err := NewCollectionHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/collections", handler.HandleCollections)
+	mux.HandleFunc("/api/collections/{id}", handler.HandleCollectionByID)
+	mux.HandleFunc("/api/collections/{id}/items", handler.HandleItems)
+
+	return nil
+}
+
+// HandleCollections lists (GET) or creates (POST) smart collections
+func (ch *CollectionHandler) HandleCollections(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, ch.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		collections, err := ch.collectionManager.ListCollections(ctx, userID)
+		if err != nil {
+			sendCollectionError(w, "Failed to fetch collections", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Collections retrieved successfully",
+			Data:    collections,
+		})
+
+	case http.MethodPost:
+		var collection services.SmartCollection
+		if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		if err := ch.collectionManager.CreateCollection(ctx, userID, &collection); err != nil {
+			sendCollectionError(w, "Failed to create collection", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Collection created successfully",
+			Data:    collection,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleCollectionByID gets, replaces (PUT), partially updates (PATCH) or
+// deletes a smart collection
+func (ch *CollectionHandler) HandleCollectionByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, ch.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	collectionID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		collection, err := ch.collectionManager.GetCollection(ctx, userID, collectionID)
+		if err != nil {
+			sendCollectionError(w, "Failed to fetch collection", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Collection retrieved successfully",
+			Data:    collection,
+		})
+
+	case http.MethodPut, http.MethodPatch:
+		var collection services.SmartCollection
+		if r.Method == http.MethodPatch {
+			// Fields missing from the body keep their current values
+			existing, err := ch.collectionManager.GetCollection(ctx, userID, collectionID)
+			if err != nil {
+				sendCollectionError(w, "Failed to fetch collection", err)
+				return
+			}
+			collection = *existing
+		}
+		if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		collection.ID = collectionID // Ensure ID matches URL
+		if err := ch.collectionManager.UpdateCollection(ctx, userID, &collection); err != nil {
+			sendCollectionError(w, "Failed to update collection", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Collection updated successfully",
+			Data:    collection,
+		})
+
+	case http.MethodDelete:
+		if err := ch.collectionManager.DeleteCollection(ctx, userID, collectionID); err != nil {
+			sendCollectionError(w, "Failed to delete collection", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Collection deleted successfully",
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleItems returns the sessions, saved tabs and favorites a smart
+// collection's query matches
+func (ch *CollectionHandler) HandleItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, ch.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	items, err := ch.collectionManager.GetCollectionItems(ctx, userID, r.PathValue("id"))
+	if err != nil {
+		sendCollectionError(w, "Failed to fetch collection items", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Collection items retrieved successfully",
+		Data:    items,
+	})
+}
+
+// sendCollectionError maps smart collection errors to status codes. Query
+// errors carry the position and token they point at.
+func sendCollectionError(w http.ResponseWriter, message string, err error) {
+	var queryErr *services.QueryError
+	if errors.As(err, &queryErr) {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Message: message,
+			Data:    queryErr,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrCollectionNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidCollection):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
@@ -0,0 +1,252 @@
+package routes
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// minCompressBytes is the smallest response body worth compressing
+	minCompressBytes = 1024
+	// maxDecompressedRequestBytes bounds a gzip request body once inflated
+	maxDecompressedRequestBytes = 64 << 20
+)
+
+// gzipWriters reuses compressors across responses
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// WithCompression gzips responses for clients that accept it and inflates
+// request bodies sent with Content-Encoding: gzip. WebSocket upgrades pass
+// straight through, and event streams are never compressed so each event is
+// delivered as it is flushed.
+func WithCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+		case "gzip":
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				sendError(w, http.StatusBadRequest, "Invalid gzip request body", err)
+				return
+			}
+			defer body.Close()
+			r.Body = http.MaxBytesReader(w, body, maxDecompressedRequestBytes)
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		default:
+			sendError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding", nil)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, status: http.StatusOK}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through a wildcard
+func acceptsGzip(header string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		allowed := true
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				q, err := strconv.ParseFloat(value, 64)
+				allowed = err == nil && q > 0
+			}
+		}
+		if name == "gzip" {
+			return allowed
+		}
+		wildcard = allowed
+	}
+	return wildcard
+}
+
+// compressibleTypes are media types that shrink well under gzip, besides
+// text/*
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/xhtml+xml":  true,
+	"application/rss+xml":    true,
+	"application/atom+xml":   true,
+	"application/x-ndjson":   true,
+	"image/svg+xml":          true,
+}
+
+// compressible reports whether a response with these headers should be
+// gzipped
+func compressible(header http.Header, status int) bool {
+	switch status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] || strings.HasSuffix(mediaType, "+json")
+}
+
+// compressWriter gzips a response once it has seen enough of the body to
+// know it is worth it. Until then the status and body are held back.
+type compressWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	// decided is set once the response is either passing through or gzipped
+	decided bool
+	gz      *gzip.Writer
+	buf     []byte
+}
+
+// WriteHeader records the status, passing it through unless the response
+// may still be compressed
+func (cw *compressWriter) WriteHeader(status int) {
+	// Informational responses such as 103 Early Hints precede the real one
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	if !compressible(cw.Header(), status) && cw.Header().Get("Content-Type") != "" {
+		cw.passThrough()
+	}
+}
+
+// Write holds the body back until it reaches minCompressBytes, then sends it
+// on compressed or not
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.gz != nil {
+			return cw.gz.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= minCompressBytes {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what has been written so far, compressed if the response is
+// compressible, as streaming handlers expect
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the response, sending short bodies uncompressed
+func (cw *compressWriter) Close() error {
+	if cw.wroteHeader && !cw.decided {
+		cw.sniffContentType()
+		if err := cw.passThrough(); err != nil {
+			return err
+		}
+	}
+	if cw.gz == nil {
+		return nil
+	}
+	err := cw.gz.Close()
+	cw.gz.Reset(nil)
+	gzipWriters.Put(cw.gz)
+	cw.gz = nil
+	return err
+}
+
+// decide starts gzipping the response if its type allows, otherwise sends
+// it as is, then writes out the held back body
+func (cw *compressWriter) decide() error {
+	cw.sniffContentType()
+	if !compressible(cw.Header(), cw.status) {
+		return cw.passThrough()
+	}
+	cw.decided = true
+	cw.Header().Del("Content-Length")
+	cw.Header().Set("Content-Encoding", "gzip")
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.gz = gzipWriters.Get().(*gzip.Writer)
+	cw.gz.Reset(cw.ResponseWriter)
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.gz.Write(buf)
+	return err
+}
+
+// passThrough sends the status and any held back body uncompressed
+func (cw *compressWriter) passThrough() error {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// sniffContentType sets the Content-Type net/http would otherwise detect
+// from the body, which it cannot once the body is compressed
+func (cw *compressWriter) sniffContentType() {
+	if _, set := cw.Header()["Content-Type"]; !set && len(cw.buf) > 0 {
+		cw.Header().Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+}
@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"br", false},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"gzip;q=abc", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"identity, *;q=0.1", true},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := acceptsGzip(tt.header); got != tt.want {
+				t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	large := strings.Repeat(`{"title":"tab"},`, minCompressBytes/8)
+	small := `{"title":"tab"}`
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		contentType    string // "-" leaves it unset
+		encoding       string // set by the handler
+		status         int
+		body           string
+		flush          bool
+		wantGzip       bool
+		wantType       string
+	}{
+		{name: "large JSON", acceptEncoding: "gzip", contentType: "application/json", body: large, wantGzip: true},
+		{name: "status kept", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusCreated, body: large, wantGzip: true},
+		{name: "vendor JSON", acceptEncoding: "gzip", contentType: "application/problem+json", body: large, wantGzip: true},
+		{name: "small body", acceptEncoding: "gzip", contentType: "application/json", body: small},
+		{name: "not accepted", acceptEncoding: "br", contentType: "application/json", body: large},
+		{name: "HEAD", method: http.MethodHead, acceptEncoding: "gzip", contentType: "application/json", body: large},
+		{name: "image", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "already encoded", acceptEncoding: "gzip", contentType: "application/json", encoding: "br", body: large},
+		{name: "no content", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusNoContent},
+		{name: "event stream", acceptEncoding: "gzip", contentType: "text/event-stream", body: large, flush: true},
+		{name: "type sniffed", acceptEncoding: "gzip", contentType: "-", body: "<html>" + large, wantGzip: true, wantType: "text/html; charset=utf-8"},
+		{name: "flushed early", acceptEncoding: "gzip", contentType: "text/plain", body: small, flush: true, wantGzip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := WithCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "-" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				if tt.flush {
+					io.WriteString(w, tt.body[:len(tt.body)/2])
+					w.(http.Flusher).Flush()
+					io.WriteString(w, tt.body[len(tt.body)/2:])
+					return
+				}
+				io.WriteString(w, tt.body)
+			}))
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			request := httptest.NewRequest(method, "/api/sessions", nil)
+			request.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			response := recorder.Result()
+
+			wantStatus := tt.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if response.StatusCode != wantStatus {
+				t.Errorf("status = %d, want %d", response.StatusCode, wantStatus)
+			}
+			if got := response.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q", got)
+			}
+			if tt.wantType != "" && response.Header.Get("Content-Type") != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", response.Header.Get("Content-Type"), tt.wantType)
+			}
+
+			body := recorder.Body.Bytes()
+			gzipped := response.Header.Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("gzipped = %v, want %v (Content-Encoding %q)", gzipped, tt.wantGzip, response.Header.Get("Content-Encoding"))
+			}
+			if gzipped {
+				reader, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestWithCompressionRequestBody(t *testing.T) {
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	io.WriteString(writer, `{"title":"tab"}`)
+	writer.Close()
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "plain", body: []byte(`{"title":"tab"}`), wantStatus: http.StatusOK, wantBody: `{"title":"tab"}`},
+		{name: "gzip", encoding: "gzip", body: gzipped.Bytes(), wantStatus: http.StatusOK, wantBody: `{"title":"tab"}`},
+		{name: "invalid gzip", encoding: "gzip", body: []byte("not gzip"), wantStatus: http.StatusBadRequest},
+		{name: "unsupported encoding", encoding: "br", body: []byte("x"), wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			handler := WithCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Encoding") != "" {
+					t.Error("Content-Encoding reached the handler")
+				}
+				body, _ := io.ReadAll(r.Body)
+				received = string(body)
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/api/sessions", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				request.Header.Set("Content-Encoding", tt.encoding)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if received != tt.wantBody {
+				t.Errorf("handler got %q, want %q", received, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWithCompressionPassesUpgradesThrough(t *testing.T) {
+	var got http.ResponseWriter
+	handler := WithCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = w
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/sync/ws", nil)
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if got != recorder {
+		t.Errorf("handler got %T, want the original writer", got)
+	}
+}
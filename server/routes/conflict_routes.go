@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// HandleConflicts lists sync conflicts; status defaults to open and may be
+// open, resolved or all
+func (udh *UserDataHandler) HandleConflicts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = services.ConflictStatusOpen
+	case "all":
+		status = ""
+	case services.ConflictStatusOpen, services.ConflictStatusResolved:
+	default:
+		udh.sendError(w, http.StatusBadRequest, "status must be open, resolved or all", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	conflicts, err := udh.userDataService.ListConflicts(ctx, userID, status)
+	if err != nil {
+		udh.sendError(w, http.StatusInternalServerError, "Failed to fetch conflicts", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Conflicts retrieved successfully",
+		Data:    conflicts,
+	})
+}
+
+// HandleConflictByID returns (GET) or discards (DELETE) one conflict
+func (udh *UserDataHandler) HandleConflictByID(w http.ResponseWriter, r *http.Request) {
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	conflictID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		conflict, err := udh.userDataService.GetConflict(ctx, userID, conflictID)
+		if err != nil {
+			sendConflictError(w, "Failed to fetch conflict", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Conflict retrieved successfully",
+			Data:    conflict,
+		})
+
+	case http.MethodDelete:
+		if err := udh.userDataService.DeleteConflict(ctx, userID, conflictID); err != nil {
+			sendConflictError(w, "Failed to delete conflict", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Conflict deleted successfully",
+		})
+
+	default:
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleResolveConflict resolves a conflict. The body names the resolution:
+// current keeps the stored (merged) document, client re-applies the client's
+// change, and custom stores the supplied data.
+func (udh *UserDataHandler) HandleResolveConflict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Resolution string          `json:"resolution"`
+		Data       json.RawMessage `json:"data,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		udh.sendError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	conflict, err := udh.userDataService.ResolveConflict(ctx, userID, r.PathValue("id"), requestBody.Resolution, requestBody.Data)
+	if err != nil {
+		sendConflictError(w, "Failed to resolve conflict", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Conflict resolved successfully",
+		Data:    conflict,
+	})
+}
+
+// sendConflictError maps conflict errors to status codes
+func sendConflictError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrConflictNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrConflictResolved):
+		statusCode = http.StatusConflict
+	case errors.Is(err, services.ErrInvalidResolution), errors.Is(err, services.ErrResolutionDataMissing), errors.Is(err, services.ErrInvalidSyncChange):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
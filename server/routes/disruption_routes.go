@@ -0,0 +1,265 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for disruption routes
+type DisruptionManager interface {
+	ListDisruptions(ctx context.Context, userID string, filter services.DisruptionFilter) ([]*services.Disruption, error)
+	GetDisruption(ctx context.Context, userID, disruptionID string) (*services.Disruption, error)
+	CreateDisruption(ctx context.Context, userID string, disruption *services.Disruption) error
+	UpdateDisruption(ctx context.Context, userID string, disruption *services.Disruption) error
+	DeleteDisruption(ctx context.Context, userID, disruptionID string) error
+	GetDisruptionSummary(ctx context.Context, userID string, filter services.DisruptionFilter) (*services.DisruptionSummary, error)
+}
+
+// DisruptionHandler handles disruption HTTP requests
+type DisruptionHandler struct {
+	disruptionManager DisruptionManager
+	authService       UserAuthenticator
+}
+
+// NewDisruptionHandler creates a new disruption handler
+func NewDisruptionHandler() (*DisruptionHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DisruptionHandler{
+		disruptionManager: userDataService,
+		authService:       authService,
+	}, nil
+}
+
+// SetupDisruptionRoutes adds disruption routes to the provided mux
+func SetupDisruptionRoutes(mux *http.ServeMux) error {
+	handler, err := NewDisruptionHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/disruptions", handler.HandleDisruptions)
+	mux.HandleFunc("/api/disruptions/summary", handler.HandleSummary)
+	mux.HandleFunc("/api/disruptions/{id}", handler.HandleDisruptionByID)
+
+	return nil
+}
+
+// HandleDisruptions lists (GET) or creates (POST) disruptions. List filters:
+// date, or from/to (YYYY-MM-DD, inclusive), category (comma-separated or
+// repeated) and task_id.
+func (dh *DisruptionHandler) HandleDisruptions(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, dh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		filter, err := parseDisruptionFilter(r.URL.Query())
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid filter", err)
+			return
+		}
+
+		disruptions, err := dh.disruptionManager.ListDisruptions(ctx, userID, filter)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to fetch disruptions", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Disruptions retrieved successfully",
+			Data:    disruptions,
+		})
+
+	case http.MethodPost:
+		var disruption services.Disruption
+		if err := json.NewDecoder(r.Body).Decode(&disruption); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		if err := dh.disruptionManager.CreateDisruption(ctx, userID, &disruption); err != nil {
+			sendDisruptionError(w, "Failed to create disruption", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Disruption created successfully",
+			Data:    disruption,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleDisruptionByID gets, replaces (PUT), partially updates (PATCH) or
+// deletes a disruption
+func (dh *DisruptionHandler) HandleDisruptionByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, dh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	disruptionID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		disruption, err := dh.disruptionManager.GetDisruption(ctx, userID, disruptionID)
+		if err != nil {
+			sendDisruptionError(w, "Failed to fetch disruption", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Disruption retrieved successfully",
+			Data:    disruption,
+		})
+
+	case http.MethodPut, http.MethodPatch:
+		var disruption services.Disruption
+		if r.Method == http.MethodPatch {
+			// Fields missing from the body keep their current values
+			existing, err := dh.disruptionManager.GetDisruption(ctx, userID, disruptionID)
+			if err != nil {
+				sendDisruptionError(w, "Failed to fetch disruption", err)
+				return
+			}
+			disruption = *existing
+			body, err := io.ReadAll(r.Body)
+			if err == nil {
+				err = services.PatchDisruption(&disruption, body)
+			}
+			if err != nil {
+				sendError(w, http.StatusBadRequest, "Invalid request body", err)
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&disruption); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		disruption.ID = disruptionID // Ensure ID matches URL
+		if err := dh.disruptionManager.UpdateDisruption(ctx, userID, &disruption); err != nil {
+			sendDisruptionError(w, "Failed to update disruption", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Disruption updated successfully",
+			Data:    disruption,
+		})
+
+	case http.MethodDelete:
+		if err := dh.disruptionManager.DeleteDisruption(ctx, userID, disruptionID); err != nil {
+			sendDisruptionError(w, "Failed to delete disruption", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Disruption deleted successfully",
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleSummary totals disruptions, taking the same filters as the list
+func (dh *DisruptionHandler) HandleSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, dh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	filter, err := parseDisruptionFilter(r.URL.Query())
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid filter", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	summary, err := dh.disruptionManager.GetDisruptionSummary(ctx, userID, filter)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to summarize disruptions", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Disruption summary retrieved successfully",
+		Data:    summary,
+	})
+}
+
+// parseDisruptionFilter reads disruption filters from query parameters
+func parseDisruptionFilter(query url.Values) (services.DisruptionFilter, error) {
+	filter := services.DisruptionFilter{
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+		Categories: queryList(query, "category"),
+		TaskID:     query.Get("task_id"),
+	}
+	if date := query.Get("date"); date != "" {
+		if filter.From != "" || filter.To != "" {
+			return filter, errors.New("date cannot be combined with from or to")
+		}
+		filter.From, filter.To = date, date
+	}
+
+	for name, value := range map[string]string{"from": filter.From, "to": filter.To} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return filter, fmt.Errorf("%s must be YYYY-MM-DD", name)
+		}
+	}
+	if filter.From != "" && filter.To != "" && filter.From > filter.To {
+		return filter, errors.New("from must not be after to")
+	}
+	return filter, nil
+}
+
+// sendDisruptionError maps disruption errors to status codes
+func sendDisruptionError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrDisruptionNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidDisruption):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
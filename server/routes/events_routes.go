@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseHeartbeatInterval keeps idle connections and proxies alive
+const sseHeartbeatInterval = 15 * time.Second
+
+// HandleEvents streams the user's change events as Server-Sent Events.
+// Clients resume with the Last-Event-ID header (or last_event_id query
+// parameter). Because EventSource cannot set headers, the bearer token may
+// also be passed as the access_token query parameter.
+func (udh *UserDataHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	r = withQueryAccessToken(r)
+	userID, err := udh.getUserIDFromAuth(r)
+	if err != nil {
+		udh.sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	// The caller's ID is kept apart from the namespace so that removing them
+	// from the workspace ends the stream
+	namespace := userID
+	if workspaceID := workspaceIDFromRequest(r); workspaceID != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		namespace, err = udh.workspaceService.AuthorizeWorkspace(ctx, userID, workspaceID, false)
+		cancel()
+		if err != nil {
+			sendWorkspaceError(w, "Workspace access denied", err)
+			return
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastVersion int64
+	if lastEventID != "" {
+		lastVersion, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			udh.sendError(w, http.StatusBadRequest, "Invalid Last-Event-ID", err)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		udh.sendError(w, http.StatusInternalServerError, "Streaming not supported", nil)
+		return
+	}
+
+	subscription := udh.userDataService.SubscribeChanges(namespace, userID, lastVersion)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if subscription.Reset {
+		writeSSE(w, "", "reset", map[string]string{"reason": "replay log does not reach Last-Event-ID; resynchronize"})
+	}
+	for _, event := range subscription.Replay {
+		writeSSE(w, strconv.FormatInt(event.Version, 10), "change", event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, open := <-subscription.Events():
+			if !open {
+				// Dropped for falling behind; the client reconnects and resumes
+				return
+			}
+			writeSSE(w, strconv.FormatInt(event.Version, 10), "change", event)
+			flusher.Flush()
+
+		case now := <-heartbeat.C:
+			writeSSE(w, "", "heartbeat", map[string]string{"timestamp": now.UTC().Format(time.RFC3339)})
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE writes one Server-Sent Event with a JSON payload
+func writeSSE(w http.ResponseWriter, id, event string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for favicon routes
+type FaviconProvider interface {
+	GetFavicon(ctx context.Context, rawURL string, size int) (*services.Favicon, error)
+}
+
+// FaviconHandler handles favicon proxy HTTP requests
+type FaviconHandler struct {
+	faviconProvider FaviconProvider
+	authService     UserAuthenticator
+}
+
+// NewFaviconHandler creates a new favicon handler
+func NewFaviconHandler() (*FaviconHandler, error) {
+	faviconService, err := services.NewFaviconService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FaviconHandler{
+		faviconProvider: faviconService,
+		authService:     authService,
+	}, nil
+}
+
+// SetupFaviconRoutes adds favicon proxy routes to the provided mux
+func SetupFaviconRoutes(mux *http.ServeMux) error {
+	handler, err := NewFaviconHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/favicons", handler.HandleFavicon)
+
+	return nil
+}
+
+// HandleFavicon serves the favicon at url (or of the page at url) as a
+// size x size PNG, so clients never contact the site themselves. Image tags
+// can authenticate with the access_token query parameter.
+func (fh *FaviconHandler) HandleFavicon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	if _, err := userIDFromRequest(withQueryAccessToken(r), fh.authService); err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	query := r.URL.Query()
+	size := 0
+	if value := query.Get("size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid size", err)
+			return
+		}
+		size = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	favicon, err := fh.faviconProvider.GetFavicon(ctx, query.Get("url"), size)
+	if err != nil {
+		sendFaviconError(w, "Failed to fetch favicon", err)
+		return
+	}
+
+	// Responses depend on the caller's token, so only the browser caches them
+	maxAge := max(0, int(time.Until(favicon.Expires).Seconds()))
+	header := w.Header()
+	header.Set("Content-Type", favicon.ContentType)
+	header.Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+	header.Set("ETag", favicon.ETag)
+	header.Set("Last-Modified", favicon.FetchedAt.UTC().Format(http.TimeFormat))
+	header.Set("X-Content-Type-Options", "nosniff")
+	// SVG can carry scripts; never let them run when opened directly
+	header.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == favicon.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(favicon.Data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(favicon.Data)
+	}
+}
+
+// sendFaviconError maps favicon errors to status codes
+func sendFaviconError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidFaviconRequest):
+		statusCode = http.StatusBadRequest
+	case errors.Is(err, services.ErrFaviconInvalid):
+		statusCode = http.StatusUnprocessableEntity
+	case errors.Is(err, services.ErrFaviconUnavailable):
+		statusCode = http.StatusBadGateway
+	}
+	sendError(w, statusCode, message, err)
+}
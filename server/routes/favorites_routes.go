@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for favorites routes
+type FavoritesManager interface {
+	ListFavorites(ctx context.Context, userID, sortBy string) ([]*services.FavoriteTab, error)
+	GetFavorite(ctx context.Context, userID, favoriteID string) (*services.FavoriteTab, error)
+	CreateFavorite(ctx context.Context, userID string, favorite *services.FavoriteTab) error
+	UpdateFavorite(ctx context.Context, userID string, favorite *services.FavoriteTab) error
+	DeleteFavorite(ctx context.Context, userID, favoriteID string) error
+	VisitFavorite(ctx context.Context, userID, favoriteID string) (*services.FavoriteTab, error)
+}
+
+// FavoritesHandler handles favorites HTTP requests
+type FavoritesHandler struct {
+	favoritesManager FavoritesManager
+	authService      UserAuthenticator
+	workspaceService WorkspaceAuthorizer
+}
+
+// NewFavoritesHandler creates a new favorites handler
+func NewFavoritesHandler() (*FavoritesHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	workspaceService, err := services.NewWorkspaceService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FavoritesHandler{
+		favoritesManager: userDataService,
+		authService:      authService,
+		workspaceService: workspaceService,
+	}, nil
+}
+
+// SetupFavoritesRoutes adds favorites routes to the provided mux
+func SetupFavoritesRoutes(mux *http.ServeMux) error {
+	handler, err := NewFavoritesHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/favorites", handler.HandleFavorites)
+	mux.HandleFunc("/api/favorites/{id}", handler.HandleFavoriteByID)
+	mux.HandleFunc("/api/favorites/{id}/visit", handler.HandleVisit)
+
+	return nil
+}
+
+// HandleFavorites lists (GET, sort=score ranks by score) or creates (POST)
+// favorites
+func (fh *FavoritesHandler) HandleFavorites(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeNamespace(w, r, fh.authService, fh.workspaceService, true)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		favorites, err := fh.favoritesManager.ListFavorites(ctx, userID, r.URL.Query().Get("sort"))
+		if err != nil {
+			sendFavoriteError(w, "Failed to fetch favorites", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Favorites retrieved successfully",
+			Data:    favorites,
+		})
+
+	case http.MethodPost:
+		var favorite services.FavoriteTab
+		if err := json.NewDecoder(r.Body).Decode(&favorite); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		if err := fh.favoritesManager.CreateFavorite(ctx, userID, &favorite); err != nil {
+			sendFavoriteError(w, "Failed to create favorite", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Favorite created successfully",
+			Data:    favorite,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleFavoriteByID gets, replaces (PUT), partially updates (PATCH) or
+// deletes a favorite
+func (fh *FavoritesHandler) HandleFavoriteByID(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizeNamespace(w, r, fh.authService, fh.workspaceService, true)
+	if !ok {
+		return
+	}
+
+	favoriteID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		favorite, err := fh.favoritesManager.GetFavorite(ctx, userID, favoriteID)
+		if err != nil {
+			sendFavoriteError(w, "Failed to fetch favorite", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Favorite retrieved successfully",
+			Data:    favorite,
+		})
+
+	case http.MethodPut, http.MethodPatch:
+		var favorite services.FavoriteTab
+		if r.Method == http.MethodPatch {
+			// Fields missing from the body keep their current values
+			existing, err := fh.favoritesManager.GetFavorite(ctx, userID, favoriteID)
+			if err != nil {
+				sendFavoriteError(w, "Failed to fetch favorite", err)
+				return
+			}
+			favorite = *existing
+		}
+		if err := json.NewDecoder(r.Body).Decode(&favorite); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		favorite.ID = favoriteID // Ensure ID matches URL
+		if err := fh.favoritesManager.UpdateFavorite(ctx, userID, &favorite); err != nil {
+			sendFavoriteError(w, "Failed to update favorite", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Favorite updated successfully",
+			Data:    favorite,
+		})
+
+	case http.MethodDelete:
+		if err := fh.favoritesManager.DeleteFavorite(ctx, userID, favoriteID); err != nil {
+			sendFavoriteError(w, "Failed to delete favorite", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Favorite deleted successfully",
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleVisit records a visit to a favorite
+func (fh *FavoritesHandler) HandleVisit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, ok := authorizeNamespace(w, r, fh.authService, fh.workspaceService, true)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	favorite, err := fh.favoritesManager.VisitFavorite(ctx, userID, r.PathValue("id"))
+	if err != nil {
+		sendFavoriteError(w, "Failed to record visit", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Visit recorded",
+		Data:    favorite,
+	})
+}
+
+// sendFavoriteError maps favorite errors to status codes
+func sendFavoriteError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrFavoriteNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrFavoriteExists), errors.Is(err, services.ErrSyncContention):
+		statusCode = http.StatusConflict
+	case errors.Is(err, services.ErrInvalidFavorite):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
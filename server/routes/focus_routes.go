@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for focus routes
+type FocusManager interface {
+	GetActiveFocusSession(ctx context.Context, userID string) (*services.ActiveFocusSession, error)
+	StartFocusSession(ctx context.Context, userID, taskID string) (*services.ActiveFocusSession, error)
+	PauseFocusSession(ctx context.Context, userID string) (*services.ActiveFocusSession, error)
+	ResumeFocusSession(ctx context.Context, userID string) (*services.ActiveFocusSession, error)
+	StopFocusSession(ctx context.Context, userID string) (*services.FocusStopResult, error)
+}
+
+// FocusHandler handles focus session HTTP requests
+type FocusHandler struct {
+	focusManager FocusManager
+	authService  UserAuthenticator
+}
+
+// NewFocusHandler creates a new focus handler
+func NewFocusHandler() (*FocusHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FocusHandler{
+		focusManager: userDataService,
+		authService:  authService,
+	}, nil
+}
+
+// SetupFocusRoutes adds focus session routes to the provided mux
+func SetupFocusRoutes(mux *http.ServeMux) error {
+	handler, err := NewFocusHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/focus", handler.HandleFocus)
+	mux.HandleFunc("/api/focus/start", handler.HandleStart)
+	mux.HandleFunc("/api/focus/pause", handler.HandlePause)
+	mux.HandleFunc("/api/focus/resume", handler.HandleResume)
+	mux.HandleFunc("/api/focus/stop", handler.HandleStop)
+
+	return nil
+}
+
+// HandleFocus returns the active focus session with its elapsed minutes
+func (fh *FocusHandler) HandleFocus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, fh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	session, err := fh.focusManager.GetActiveFocusSession(ctx, userID)
+	if err != nil {
+		sendFocusError(w, "Failed to fetch focus session", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Focus session retrieved successfully",
+		Data:    session,
+	})
+}
+
+// HandleStart starts a focus session on the task in the body's taskId
+func (fh *FocusHandler) HandleStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, fh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	var requestBody struct {
+		TaskID string `json:"taskId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if requestBody.TaskID == "" {
+		sendError(w, http.StatusBadRequest, "taskId is required", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	session, err := fh.focusManager.StartFocusSession(ctx, userID, requestBody.TaskID)
+	if err != nil {
+		sendFocusError(w, "Failed to start focus session", err)
+		return
+	}
+
+	sendJSON(w, http.StatusCreated, Response{
+		Message: "Focus session started",
+		Data:    session,
+	})
+}
+
+// HandlePause pauses the running focus session
+func (fh *FocusHandler) HandlePause(w http.ResponseWriter, r *http.Request) {
+	fh.handleTransition(w, r, fh.focusManager.PauseFocusSession, "pause", "Focus session paused")
+}
+
+// HandleResume resumes the paused focus session
+func (fh *FocusHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
+	fh.handleTransition(w, r, fh.focusManager.ResumeFocusSession, "resume", "Focus session resumed")
+}
+
+// handleTransition applies a pause or resume to the active session
+func (fh *FocusHandler) handleTransition(w http.ResponseWriter, r *http.Request, transition func(context.Context, string) (*services.ActiveFocusSession, error), action, message string) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, fh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	session, err := transition(ctx, userID)
+	if err != nil {
+		sendFocusError(w, "Failed to "+action+" focus session", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: message,
+		Data:    session,
+	})
+}
+
+// HandleStop ends the active focus session, returning the completed session
+// and the task's updated focus data
+func (fh *FocusHandler) HandleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, fh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := fh.focusManager.StopFocusSession(ctx, userID)
+	if err != nil {
+		sendFocusError(w, "Failed to stop focus session", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Focus session stopped",
+		Data:    result,
+	})
+}
+
+// sendFocusError maps focus session errors to status codes
+func sendFocusError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrNoActiveFocusSession), errors.Is(err, services.ErrTaskNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrFocusSessionActive), errors.Is(err, services.ErrFocusSessionState),
+		errors.Is(err, services.ErrSyncContention):
+		statusCode = http.StatusConflict
+	}
+	sendError(w, statusCode, message, err)
+}
@@ -0,0 +1,171 @@
+package routes
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// maxImportBytes caps the size of an uploaded archive
+const maxImportBytes = 32 << 20
+
+// Consumer-driven interfaces for import routes
+type AccountImporter interface {
+	ImportArchive(ctx context.Context, userID string, archive *services.AccountArchive, opts services.ImportOptions) (*services.ImportReport, error)
+}
+
+// ImportHandler handles account import HTTP requests
+type ImportHandler struct {
+	importer    AccountImporter
+	authService UserAuthenticator
+}
+
+// NewImportHandler creates a new import handler
+func NewImportHandler() (*ImportHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImportHandler{
+		importer:    userDataService,
+		authService: authService,
+	}, nil
+}
+
+// SetupImportRoutes adds import routes to the provided mux
+func SetupImportRoutes(mux *http.ServeMux) error {
+	handler, err := NewImportHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/import", handler.HandleImport)
+	mux.HandleFunc("/api/import/sessions", handler.HandleSessionImport)
+
+	return nil
+}
+
+// HandleImport imports an account archive or chrome.storage dump.
+// Query parameters: strategy=replace|merge|newest, dry_run=true|false
+func (ih *ImportHandler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, ih.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	strategy, err := services.ParseImportStrategy(r.URL.Query().Get("strategy"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid import strategy", err)
+		return
+	}
+
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid dry_run parameter", err)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		sendError(w, http.StatusRequestEntityTooLarge, "Failed to read import payload", err)
+		return
+	}
+
+	archive, err := services.ParseImportPayload(payload)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid import payload", err)
+		return
+	}
+
+	ih.applyImport(w, r, userID, archive, strategy, dryRun)
+}
+
+// HandleSessionImport imports sessions from a third-party export.
+// Query parameters: format=onetab|sessionbuddy|toby|bookmarks|firefox (detected
+// when omitted), strategy=replace|merge|newest, dry_run=true|false
+func (ih *ImportHandler) HandleSessionImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, ih.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	strategy, err := services.ParseImportStrategy(r.URL.Query().Get("strategy"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid import strategy", err)
+		return
+	}
+
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid dry_run parameter", err)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		sendError(w, http.StatusRequestEntityTooLarge, "Failed to read import payload", err)
+		return
+	}
+
+	archive, err := services.ParseSessionImport(r.URL.Query().Get("format"), payload)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid import payload", err)
+		return
+	}
+
+	ih.applyImport(w, r, userID, archive, strategy, dryRun)
+}
+
+// parseDryRun reads the optional dry_run query parameter
+func parseDryRun(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("dry_run")
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// applyImport runs a parsed archive through the importer and writes the report
+func (ih *ImportHandler) applyImport(w http.ResponseWriter, r *http.Request, userID string, archive *services.AccountArchive, strategy services.ImportStrategy, dryRun bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	report, err := ih.importer.ImportArchive(ctx, userID, archive, services.ImportOptions{
+		Strategy: strategy,
+		DryRun:   dryRun,
+	})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Import failed", err)
+		return
+	}
+
+	message := "Import completed"
+	if dryRun {
+		message = "Import dry run completed"
+	}
+	sendJSON(w, http.StatusOK, Response{
+		Message: message,
+		Data:    report,
+	})
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for link routes
+type LinkReporter interface {
+	GetBrokenLinks(ctx context.Context, userID string, redirects bool) ([]*services.BrokenLink, error)
+}
+
+// LinkHandler handles link health HTTP requests
+type LinkHandler struct {
+	linkReporter LinkReporter
+	authService  UserAuthenticator
+}
+
+// NewLinkHandler creates a new link handler
+func NewLinkHandler() (*LinkHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &LinkHandler{
+		linkReporter: userDataService,
+		authService:  authService,
+	}, nil
+}
+
+// SetupLinkRoutes adds link health routes to the provided mux
+func SetupLinkRoutes(mux *http.ServeMux) error {
+	handler, err := NewLinkHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/links/broken", handler.HandleBroken)
+
+	return nil
+}
+
+// HandleBroken lists the session tabs, saved tabs and favorites whose links
+// the checker found broken, and with redirects=true those that redirect
+func (lh *LinkHandler) HandleBroken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, lh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	links, err := lh.linkReporter.GetBrokenLinks(ctx, userID, r.URL.Query().Get("redirects") == "true")
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to fetch broken links", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Broken links retrieved successfully",
+		Data:    links,
+	})
+}
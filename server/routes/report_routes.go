@@ -0,0 +1,178 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for report routes
+type ReportProvider interface {
+	GetWeeklyReport(ctx context.Context, userID, week string, location *time.Location) (*services.WeeklyReport, error)
+	GetReportSubscription(ctx context.Context, userID string) (*services.ReportSubscription, error)
+	SubscribeWeeklyReport(ctx context.Context, userID string) (*services.ReportSubscription, error)
+	UnsubscribeWeeklyReport(ctx context.Context, userID string) error
+}
+
+// ReportHandler handles report HTTP requests
+type ReportHandler struct {
+	reportProvider ReportProvider
+	authService    UserAuthenticator
+}
+
+// NewReportHandler creates a new report handler
+func NewReportHandler() (*ReportHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReportHandler{
+		reportProvider: userDataService,
+		authService:    authService,
+	}, nil
+}
+
+// SetupReportRoutes adds report routes to the provided mux
+func SetupReportRoutes(mux *http.ServeMux) error {
+	handler, err := NewReportHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/reports/weekly", handler.HandleWeeklyReport)
+	mux.HandleFunc("/api/reports/weekly/subscription", handler.HandleSubscription)
+
+	return nil
+}
+
+// HandleWeeklyReport returns the report for week (YYYY-Www or a date in the
+// week; the current week by default) in the tz parameter or the user's
+// timeZone setting. format=json (default) wraps the report in the usual
+// response; html and markdown return the rendered document.
+func (rh *ReportHandler) HandleWeeklyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, rh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = services.ReportFormatJSON
+	}
+	contentType, _, err := services.ReportContentType(format)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid report format", err)
+		return
+	}
+
+	var location *time.Location
+	if name := query.Get("tz"); name != "" {
+		if location, err = time.LoadLocation(name); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid parameters", fmt.Errorf("unknown time zone %q", name))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	report, err := rh.reportProvider.GetWeeklyReport(ctx, userID, query.Get("week"), location)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidReportWeek) {
+			statusCode = http.StatusBadRequest
+		}
+		sendError(w, statusCode, "Failed to build weekly report", err)
+		return
+	}
+
+	if format == services.ReportFormatJSON {
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Weekly report retrieved successfully",
+			Data:    report,
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := services.RenderWeeklyReport(&buf, format, report); err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to render weekly report", err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// HandleSubscription gets (GET), starts (PUT) or stops (DELETE) delivery of
+// the user's weekly report
+func (rh *ReportHandler) HandleSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, rh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		subscription, err := rh.reportProvider.GetReportSubscription(ctx, userID)
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if errors.Is(err, services.ErrReportSubscriptionNotFound) {
+				statusCode = http.StatusNotFound
+			}
+			sendError(w, statusCode, "Failed to fetch report subscription", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Report subscription retrieved successfully",
+			Data:    subscription,
+		})
+
+	case http.MethodPut:
+		subscription, err := rh.reportProvider.SubscribeWeeklyReport(ctx, userID)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to subscribe to weekly reports", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Subscribed to weekly reports",
+			Data:    subscription,
+		})
+
+	case http.MethodDelete:
+		if err := rh.reportProvider.UnsubscribeWeeklyReport(ctx, userID); err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to unsubscribe from weekly reports", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Unsubscribed from weekly reports",
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
@@ -1,9 +1,11 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 )
 
 // Response represents a standard API response
@@ -13,6 +15,71 @@ type Response struct {
 	Error   string      `json:"error,omitempty"`
 }
 
+// userIDFromRequest extracts and verifies the bearer token on a request,
+// returning the authenticated user ID
+func userIDFromRequest(r *http.Request, authService UserAuthenticator) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", http.ErrNoCookie
+	}
+
+	// Expected format: "Bearer <token>"
+	const bearerPrefix = "Bearer "
+	if len(authHeader) <= len(bearerPrefix) || authHeader[:len(bearerPrefix)] != bearerPrefix {
+		return "", http.ErrNoCookie
+	}
+
+	token := authHeader[len(bearerPrefix):]
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	userID, err := authService.VerifyToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
+
+// withQueryAccessToken lets clients that cannot set headers (EventSource,
+// browser WebSockets, image tags) pass the bearer token as the access_token
+// query parameter
+func withQueryAccessToken(r *http.Request) *http.Request {
+	if r.Header.Get("Authorization") != "" {
+		return r
+	}
+	token := r.URL.Query().Get("access_token")
+	if token == "" {
+		return r
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// sendError writes a JSON error response
+func sendError(w http.ResponseWriter, statusCode int, message string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	errorMsg := message
+	if err != nil {
+		errorMsg = err.Error()
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Message: message,
+		Error:   errorMsg,
+	})
+}
+
+// sendJSON writes a JSON success response with the given status code
+func sendJSON(w http.ResponseWriter, statusCode int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
 // SetupRoutes configures all the application routes
 func SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
@@ -38,6 +105,71 @@ func SetupRoutes() *http.ServeMux {
 		log.Printf("Warning: Failed to setup User Data routes: %v", err)
 	}
 
+	// Setup Import routes
+	if err := SetupImportRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Import routes: %v", err)
+	}
+
+	// Setup Share routes
+	if err := SetupShareRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Share routes: %v", err)
+	}
+
+	// Setup Workspace routes
+	if err := SetupWorkspaceRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Workspace routes: %v", err)
+	}
+
+	// Setup Task routes
+	if err := SetupTaskRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Task routes: %v", err)
+	}
+
+	// Setup Focus routes
+	if err := SetupFocusRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Focus routes: %v", err)
+	}
+
+	// Setup Disruption routes
+	if err := SetupDisruptionRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Disruption routes: %v", err)
+	}
+
+	// Setup Timeline routes
+	if err := SetupTimelineRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Timeline routes: %v", err)
+	}
+
+	// Setup Favorites routes
+	if err := SetupFavoritesRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Favorites routes: %v", err)
+	}
+
+	// Setup Tag routes
+	if err := SetupTagRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Tag routes: %v", err)
+	}
+
+	// Setup Collection routes
+	if err := SetupCollectionRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Collection routes: %v", err)
+	}
+
+	// Setup Link routes
+	if err := SetupLinkRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Link routes: %v", err)
+	}
+
+	// Setup Favicon routes
+	if err := SetupFaviconRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Favicon routes: %v", err)
+	}
+
+	// Setup Report routes
+	if err := SetupReportRoutes(mux); err != nil {
+		log.Printf("Warning: Failed to setup Report routes: %v", err)
+	}
+
 	// Root endpoint
 	mux.HandleFunc("/", rootHandler)
 
@@ -85,15 +217,62 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 				"endpoints": {
 					"/health",
 					"/api/tabs",
+					"/api/tabs/{id}/archive",
 					"/api/sessions",
+					"/api/sessions/export",
+					"/api/sessions/{id}/export",
 					"/api/settings",
 					"/api/storage/{key}",
+					"/api/tasks",
+					"/api/tasks/stats",
+					"/api/tasks/{id}",
+					"/api/focus",
+					"/api/focus/start",
+					"/api/focus/pause",
+					"/api/focus/resume",
+					"/api/focus/stop",
+					"/api/disruptions",
+					"/api/disruptions/summary",
+					"/api/disruptions/{id}",
+					"/api/timeline",
+					"/api/favorites",
+					"/api/favorites/{id}",
+					"/api/favorites/{id}/visit",
+					"/api/tags",
+					"/api/tags/{name}",
+					"/api/tags/{name}/merge",
+					"/api/tags/{name}/items",
+					"/api/collections",
+					"/api/collections/{id}",
+					"/api/collections/{id}/items",
+					"/api/links/broken",
+					"/api/favicons",
+					"/api/reports/weekly",
+					"/api/reports/weekly/subscription",
+					"/api/events",
+					"/api/sync",
+					"/api/sync/ws",
+					"/api/conflicts",
+					"/api/conflicts/{id}",
+					"/api/conflicts/{id}/resolve",
 					"/api/firebase/testconnection",
 					"/api/firebase/auth/verify",
 					"/api/auth/login",
 					"/api/auth/logout",
 					"/api/auth/verify",
 					"/api/auth/me",
+					"/api/import",
+					"/api/import/sessions",
+					"/api/sessions/{id}/share",
+					"/api/shares",
+					"/api/shares/{token}",
+					"/s/{token}",
+					"/api/workspaces",
+					"/api/workspaces/{id}",
+					"/api/workspaces/{id}/invitations",
+					"/api/workspaces/{id}/members/{member}",
+					"/api/workspace-invitations",
+					"/api/workspace-invitations/{token}/accept",
 				},
 			},
 		}
@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// HandleSessionsExport exports several sessions at once.
+// Query parameters: format=html|markdown|opml|csv|urls, ids=comma-separated
+// session IDs (all sessions when omitted), download=true for an attachment
+func (udh *UserDataHandler) HandleSessionsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var sessions []*services.Session
+	var err error
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				continue
+			}
+			session, err := udh.userDataService.GetUserSession(ctx, userID, id)
+			if err != nil {
+				udh.sendError(w, http.StatusNotFound, "Session not found", fmt.Errorf("session %s: %w", id, err))
+				return
+			}
+			sessions = append(sessions, session)
+		}
+	} else {
+		sessions, err = udh.userDataService.GetUserSessions(ctx, userID)
+		if err != nil {
+			udh.sendError(w, http.StatusInternalServerError, "Failed to fetch sessions", err)
+			return
+		}
+		sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].CreatedAt < sessions[j].CreatedAt })
+	}
+
+	udh.writeSessionExport(w, r, "Tab Blaster sessions", sessions)
+}
+
+// handleSessionExport exports a single session (GET /api/sessions/{id}/export)
+func (udh *UserDataHandler) handleSessionExport(w http.ResponseWriter, r *http.Request, userID, sessionID string) {
+	if r.Method != http.MethodGet {
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	session, err := udh.userDataService.GetUserSession(ctx, userID, sessionID)
+	if err != nil {
+		udh.sendError(w, http.StatusNotFound, "Session not found", err)
+		return
+	}
+
+	udh.writeSessionExport(w, r, session.Name, []*services.Session{session})
+}
+
+// writeSessionExport renders the sessions in the requested format
+func (udh *UserDataHandler) writeSessionExport(w http.ResponseWriter, r *http.Request, title string, sessions []*services.Session) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = services.ExportFormatMarkdown
+	}
+
+	contentType, _, err := services.SessionExportContentType(format)
+	if err != nil {
+		udh.sendError(w, http.StatusBadRequest, "Invalid export format", err)
+		return
+	}
+
+	// Render into a buffer so a failure can still produce a JSON error
+	var buf bytes.Buffer
+	if err := services.RenderSessions(&buf, format, title, sessions); err != nil {
+		udh.sendError(w, http.StatusInternalServerError, "Failed to export sessions", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if r.URL.Query().Get("download") == "true" {
+		filename := services.SessionExportFilename(sessions, format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,297 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for share routes
+type SessionSharer interface {
+	CreateShare(ctx context.Context, userID, sessionID string, opts services.ShareOptions) (*services.SessionShare, error)
+	ListShares(ctx context.Context, userID, sessionID string) ([]*services.SessionShare, error)
+	RevokeShare(ctx context.Context, userID, token string) error
+}
+
+type ShareResolver interface {
+	ResolveShare(ctx context.Context, token, password string) (*services.SessionShare, *services.Session, error)
+}
+
+// ShareService combines the share interfaces
+type ShareService interface {
+	SessionSharer
+	ShareResolver
+}
+
+// ShareHandler handles session share HTTP requests
+type ShareHandler struct {
+	shareService ShareService
+	authService  UserAuthenticator
+}
+
+// sharedSessionResponse is the public JSON rendering of a shared session
+type sharedSessionResponse struct {
+	Mode      string            `json:"mode"`
+	CreatedAt string            `json:"createdAt"`
+	ExpiresAt string            `json:"expiresAt,omitempty"`
+	Session   *services.Session `json:"session"`
+}
+
+// NewShareHandler creates a new share handler
+func NewShareHandler() (*ShareHandler, error) {
+	shareService, err := services.NewShareService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ShareHandler{
+		shareService: shareService,
+		authService:  authService,
+	}, nil
+}
+
+// SetupShareRoutes adds share routes to the provided mux
+func SetupShareRoutes(mux *http.ServeMux) error {
+	handler, err := NewShareHandler()
+	if err != nil {
+		return err
+	}
+
+	// Owner routes
+	mux.HandleFunc("/api/sessions/{id}/share", handler.HandleSessionShares)
+	mux.HandleFunc("/api/shares", handler.HandleShares)
+	mux.HandleFunc("/api/shares/", handler.HandleShareByToken)
+
+	// Public read-only route
+	mux.HandleFunc("/s/", handler.HandlePublicShare)
+
+	return nil
+}
+
+// HandleSessionShares creates (POST) or lists (GET) shares for a session
+func (sh *ShareHandler) HandleSessionShares(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, sh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	sessionID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		shares, err := sh.shareService.ListShares(ctx, userID, sessionID)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to fetch shares", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Shares retrieved successfully",
+			Data:    shares,
+		})
+
+	case http.MethodPost:
+		var opts services.ShareOptions
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				sendError(w, http.StatusBadRequest, "Invalid request body", err)
+				return
+			}
+		}
+
+		share, err := sh.shareService.CreateShare(ctx, userID, sessionID, opts)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Failed to create share", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Share created successfully",
+			Data: map[string]interface{}{
+				"share": share,
+				"path":  "/s/" + share.Token,
+			},
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleShares lists all of the user's shares
+func (sh *ShareHandler) HandleShares(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, sh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	shares, err := sh.shareService.ListShares(ctx, userID, r.URL.Query().Get("session_id"))
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to fetch shares", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Shares retrieved successfully",
+		Data:    shares,
+	})
+}
+
+// HandleShareByToken revokes a share (DELETE /api/shares/{token})
+func (sh *ShareHandler) HandleShareByToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, sh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	token := r.URL.Path[len("/api/shares/"):]
+	if token == "" {
+		sendError(w, http.StatusBadRequest, "Share token is required", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := sh.shareService.RevokeShare(ctx, userID, token); err != nil {
+		if errors.Is(err, services.ErrShareNotFound) {
+			sendError(w, http.StatusNotFound, "Share not found", err)
+			return
+		}
+		sendError(w, http.StatusInternalServerError, "Failed to revoke share", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Share revoked successfully",
+	})
+}
+
+// HandlePublicShare renders a shared session without authentication.
+// JSON is returned for format=json or an Accept header preferring JSON; the
+// export formats (markdown, csv, ...) are also available via format. Passwords
+// are read from the X-Share-Password header or a posted password form field.
+func (sh *ShareHandler) HandlePublicShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	token := r.URL.Path[len("/s/"):]
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "html"
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			format = "json"
+		}
+	}
+
+	password := r.Header.Get("X-Share-Password")
+	if r.Method == http.MethodPost {
+		password = r.PostFormValue("password")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// Shared pages must never be cached by intermediaries
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	share, session, err := sh.shareService.ResolveShare(ctx, token, password)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrShareNotFound):
+			statusCode = http.StatusNotFound
+		case errors.Is(err, services.ErrShareExpired):
+			statusCode = http.StatusGone
+		case errors.Is(err, services.ErrSharePasswordRequired), errors.Is(err, services.ErrSharePasswordInvalid):
+			statusCode = http.StatusUnauthorized
+		}
+
+		if format == "html" {
+			passwordForm := errors.Is(err, services.ErrSharePasswordRequired) || errors.Is(err, services.ErrSharePasswordInvalid)
+			message := err.Error()
+			if errors.Is(err, services.ErrSharePasswordRequired) {
+				message = "This session is password protected."
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(statusCode)
+			services.RenderSharedSessionHTML(w, nil, message, passwordForm)
+			return
+		}
+		sendError(w, statusCode, "Shared session unavailable", err)
+		return
+	}
+
+	switch format {
+	case "html":
+		var buf bytes.Buffer
+		if err := services.RenderSharedSessionHTML(&buf, session, "", false); err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to render shared session", err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+
+	case "json":
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Shared session retrieved successfully",
+			Data: sharedSessionResponse{
+				Mode:      share.Mode,
+				CreatedAt: share.CreatedAt,
+				ExpiresAt: share.ExpiresAt,
+				Session:   session,
+			},
+		})
+
+	default:
+		contentType, _, err := services.SessionExportContentType(format)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid export format", err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := services.RenderSessions(&buf, format, session.Name, []*services.Session{session}); err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to export shared session", err)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	}
+}
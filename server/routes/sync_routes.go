@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// maxSyncBatchSize caps the changes accepted by one POST /api/sync
+const maxSyncBatchSize = 500
+
+// syncChangeResult reports the outcome of one submitted change
+type syncChangeResult struct {
+	Collection string                 `json:"collection"`
+	DocumentID string                 `json:"id,omitempty"`
+	Version    int64                  `json:"version,omitempty"`
+	Changes    []services.ChangeEvent `json:"changes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	services.SyncResult
+}
+
+// HandleSync serves delta sync. GET returns the documents created, updated
+// and deleted since the since token; POST applies a batch of client changes,
+// merging those whose baseVersion is older than the stored document.
+func (udh *UserDataHandler) HandleSync(w http.ResponseWriter, r *http.Request) {
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
+		return
+	}
+	shared := workspaceIDFromRequest(r) != ""
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		limit := 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 || limit > services.MaxSyncPageSize {
+				udh.sendError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", services.MaxSyncPageSize), err)
+				return
+			}
+		}
+
+		delta, err := udh.userDataService.GetChangesSince(ctx, userID, r.URL.Query().Get("since"), limit)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidSyncToken) {
+				udh.sendError(w, http.StatusBadRequest, "Invalid sync token", err)
+				return
+			}
+			udh.sendError(w, http.StatusInternalServerError, "Failed to fetch changes", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Changes retrieved successfully",
+			Data:    delta,
+		})
+
+	case http.MethodPost:
+		var requestBody struct {
+			Changes []services.SyncChange `json:"changes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			udh.sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		if len(requestBody.Changes) > maxSyncBatchSize {
+			udh.sendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("At most %d changes per request", maxSyncBatchSize), nil)
+			return
+		}
+
+		// Changes are applied in order; a failed change does not stop the rest
+		results := make([]syncChangeResult, 0, len(requestBody.Changes))
+		failed := 0
+		for _, change := range requestBody.Changes {
+			result := syncChangeResult{Collection: change.Collection, DocumentID: change.DocumentID}
+
+			err := checkWorkspaceChange(change, shared)
+			if err == nil {
+				changeCtx, recorder := services.RecordChanges(ctx, "")
+				var applied *services.SyncResult
+				applied, err = udh.userDataService.ApplyChange(changeCtx, userID, change)
+				if applied != nil {
+					result.SyncResult = *applied
+				}
+				result.Changes = recorder.Events()
+				if n := len(result.Changes); n > 0 {
+					result.Version = result.Changes[n-1].Version
+					result.DocumentID = result.Changes[n-1].DocumentID
+				}
+			}
+			if err != nil {
+				result.Error = err.Error()
+				failed++
+			}
+			results = append(results, result)
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: fmt.Sprintf("Applied %d of %d changes", len(results)-failed, len(results)),
+			Data: map[string]interface{}{
+				"results": results,
+				"failed":  failed,
+			},
+		})
+
+	default:
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// checkWorkspaceChange rejects changes to collections that are not shared
+// when syncing a workspace
+func checkWorkspaceChange(change services.SyncChange, shared bool) error {
+	if !shared {
+		return nil
+	}
+	switch collection := services.CollectionForStorageKey(change.Collection); collection {
+	case "sessions", "saved-tabs":
+		return nil
+	default:
+		if key := services.StorageKeyForCollection(collection); !services.WorkspaceStorageKeys[key] {
+			return fmt.Errorf("%s is not shared in workspaces", key)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,397 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"tab-blaster-server/services"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Sync socket limits
+const (
+	syncSendQueueSize   = 256
+	syncMaxMessageBytes = 4 << 20
+	syncWriteTimeout    = 10 * time.Second
+	syncMutationTimeout = 30 * time.Second
+	syncHeartbeatPeriod = 15 * time.Second
+	syncAllCollections  = "*"
+)
+
+// Sync socket message types
+const (
+	syncMessageSubscribe   = "subscribe"
+	syncMessageUnsubscribe = "unsubscribe"
+	syncMessageMutate      = "mutate"
+	syncMessagePing        = "ping"
+
+	syncMessageSubscribed = "subscribed"
+	syncMessageAck        = "ack"
+	syncMessageChange     = "change"
+	syncMessageReset      = "reset"
+	syncMessageError      = "error"
+	syncMessagePong       = "pong"
+	syncMessageHeartbeat  = "heartbeat"
+)
+
+// syncClientMessage is a message sent by a client over the sync socket
+type syncClientMessage struct {
+	Type string `json:"type"`
+	// ID is echoed in the reply so clients can match acks and errors
+	ID          string          `json:"id,omitempty"`
+	Collections []string        `json:"collections,omitempty"`
+	Since       int64           `json:"since,omitempty"`
+	Collection  string          `json:"collection,omitempty"`
+	Operation   string          `json:"operation,omitempty"`
+	DocumentID  string          `json:"documentId,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	BaseVersion int64           `json:"baseVersion,omitempty"`
+	Base        json.RawMessage `json:"base,omitempty"`
+}
+
+// syncServerMessage is a message sent to a client over the sync socket
+type syncServerMessage struct {
+	Type        string                 `json:"type"`
+	ID          string                 `json:"id,omitempty"`
+	Version     int64                  `json:"version,omitempty"`
+	Collections []string               `json:"collections,omitempty"`
+	Changes     []services.ChangeEvent `json:"changes,omitempty"`
+	Event       *services.ChangeEvent  `json:"event,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	Timestamp   string                 `json:"timestamp,omitempty"`
+	Merged      bool                   `json:"merged,omitempty"`
+	ConflictID  string                 `json:"conflictId,omitempty"`
+}
+
+// syncConnection is one client's sync socket
+type syncConnection struct {
+	id          string
+	handler     *UserDataHandler
+	ws          *websocket.Conn
+	userID      string // the data namespace: the user or a workspace
+	memberID    string // the authenticated user
+	workspaceID string
+	canWrite    bool
+	shared      bool // connected to a workspace
+
+	send   chan syncServerMessage
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+
+	mu           sync.Mutex
+	collections  map[string]bool
+	subscription *services.ChangeSubscription
+}
+
+// HandleSyncSocket upgrades to a WebSocket on which clients subscribe to
+// collections and submit mutations. Authentication uses the same bearer
+// token as the REST API, also accepted as the access_token query parameter
+// since browsers cannot set headers on WebSocket requests.
+func (udh *UserDataHandler) HandleSyncSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		udh.sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	r = withQueryAccessToken(r)
+	userID, err := udh.getUserIDFromAuth(r)
+	if err != nil {
+		udh.sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	namespace, canWrite := userID, true
+	workspaceID := workspaceIDFromRequest(r)
+	if workspaceID != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		namespace, err = udh.workspaceService.AuthorizeWorkspace(ctx, userID, workspaceID, false)
+		if err != nil {
+			sendWorkspaceError(w, "Workspace access denied", err)
+			return
+		}
+		_, err = udh.workspaceService.AuthorizeWorkspace(ctx, userID, workspaceID, true)
+		canWrite = err == nil
+	}
+
+	server := websocket.Server{
+		// Authentication is by bearer token rather than cookies, so
+		// cross-origin connections carry no ambient credentials
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = syncMaxMessageBytes
+			ctx, cancel := context.WithCancel(context.Background())
+			conn := &syncConnection{
+				id:          newSyncConnectionID(),
+				handler:     udh,
+				ws:          ws,
+				userID:      namespace,
+				memberID:    userID,
+				workspaceID: workspaceID,
+				canWrite:    canWrite,
+				shared:      workspaceID != "",
+				send:        make(chan syncServerMessage, syncSendQueueSize),
+				ctx:         ctx,
+				cancel:      cancel,
+				collections: make(map[string]bool),
+			}
+			conn.run()
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// newSyncConnectionID returns a random ID used as the origin of a
+// connection's changes
+func newSyncConnectionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// run serves the connection until the client disconnects or falls behind
+func (c *syncConnection) run() {
+	defer c.close()
+	go c.writeLoop()
+
+	for {
+		var msg syncClientMessage
+		if err := websocket.JSON.Receive(c.ws, &msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				c.enqueue(syncServerMessage{Type: syncMessageError, Error: "invalid JSON message"})
+				continue
+			}
+			return
+		}
+
+		switch msg.Type {
+		case syncMessageSubscribe:
+			c.subscribe(msg)
+		case syncMessageUnsubscribe:
+			c.unsubscribe(msg)
+		case syncMessageMutate:
+			c.mutate(msg)
+		case syncMessagePing:
+			c.enqueue(syncServerMessage{Type: syncMessagePong, ID: msg.ID})
+		default:
+			c.enqueue(syncServerMessage{Type: syncMessageError, ID: msg.ID, Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+		}
+
+		if c.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// close tears the connection down once
+func (c *syncConnection) close() {
+	c.once.Do(func() {
+		c.cancel()
+		c.mu.Lock()
+		if c.subscription != nil {
+			c.subscription.Close()
+		}
+		c.mu.Unlock()
+		c.ws.Close()
+	})
+}
+
+// enqueue queues a message for the writer. A client that stops reading fills
+// its queue and is disconnected rather than buffering without bound; it
+// reconnects and resumes with since.
+func (c *syncConnection) enqueue(msg syncServerMessage) {
+	select {
+	case c.send <- msg:
+	case <-c.ctx.Done():
+	default:
+		log.Printf("Closing slow sync connection %s for user %s", c.id, c.userID)
+		go c.close()
+	}
+}
+
+// writeLoop is the connection's only writer
+func (c *syncConnection) writeLoop() {
+	heartbeat := time.NewTicker(syncHeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		var msg syncServerMessage
+		select {
+		case <-c.ctx.Done():
+			return
+		case msg = <-c.send:
+		case now := <-heartbeat.C:
+			msg = syncServerMessage{Type: syncMessageHeartbeat, Timestamp: now.UTC().Format(time.RFC3339)}
+		}
+
+		c.ws.SetWriteDeadline(time.Now().Add(syncWriteTimeout))
+		if err := websocket.JSON.Send(c.ws, msg); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// subscribe adds collections to the connection's filter. The first subscribe
+// starts the change stream, replaying buffered changes newer than since.
+func (c *syncConnection) subscribe(msg syncClientMessage) {
+	if len(msg.Collections) == 0 {
+		c.enqueue(syncServerMessage{Type: syncMessageError, ID: msg.ID, Error: "collections are required"})
+		return
+	}
+
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+	for _, name := range msg.Collections {
+		c.collections[syncCollectionName(name)] = true
+	}
+	collections := c.subscribedLocked()
+	start := c.subscription == nil
+	if start {
+		c.subscription = c.handler.userDataService.SubscribeChanges(c.userID, c.memberID, msg.Since)
+	}
+	subscription := c.subscription
+	c.mu.Unlock()
+
+	c.enqueue(syncServerMessage{Type: syncMessageSubscribed, ID: msg.ID, Collections: collections})
+	if !start {
+		return
+	}
+
+	if subscription.Reset {
+		c.enqueue(syncServerMessage{Type: syncMessageReset, Error: "change log does not reach since; resynchronize"})
+	}
+	for _, event := range subscription.Replay {
+		c.deliver(event)
+	}
+	go c.forward(subscription)
+}
+
+// unsubscribe removes collections from the connection's filter
+func (c *syncConnection) unsubscribe(msg syncClientMessage) {
+	c.mu.Lock()
+	for _, name := range msg.Collections {
+		delete(c.collections, syncCollectionName(name))
+	}
+	collections := c.subscribedLocked()
+	c.mu.Unlock()
+
+	c.enqueue(syncServerMessage{Type: syncMessageSubscribed, ID: msg.ID, Collections: collections})
+}
+
+func (c *syncConnection) subscribedLocked() []string {
+	collections := make([]string, 0, len(c.collections))
+	for name := range c.collections {
+		collections = append(collections, name)
+	}
+	return collections
+}
+
+// forward relays live change events to the client
+func (c *syncConnection) forward(subscription *services.ChangeSubscription) {
+	for event := range subscription.Events() {
+		c.deliver(event)
+	}
+	if c.ctx.Err() != nil {
+		return
+	}
+	if subscription.Revoked() {
+		// Removed from the workspace, or its role changed; reconnecting
+		// checks access afresh
+		c.enqueue(syncServerMessage{Type: syncMessageError, Error: "workspace access changed; reconnect"})
+	} else {
+		// Dropped by the feed for falling behind; changes were lost
+		c.enqueue(syncServerMessage{Type: syncMessageReset, Error: "connection fell behind; resynchronize"})
+	}
+	c.close()
+}
+
+// deliver sends a change if the client subscribed to its collection. The
+// connection's own changes are skipped as they were already acknowledged.
+func (c *syncConnection) deliver(event services.ChangeEvent) {
+	if event.Origin == c.id {
+		return
+	}
+	c.mu.Lock()
+	wanted := c.collections[syncAllCollections] || c.collections[event.Collection]
+	c.mu.Unlock()
+	if !wanted {
+		return
+	}
+	c.enqueue(syncServerMessage{Type: syncMessageChange, Version: event.Version, Event: &event})
+}
+
+// mutate applies a client write and acknowledges it with the new versions.
+// Writes carrying a stale baseVersion are merged; the ack reports any conflict.
+func (c *syncConnection) mutate(msg syncClientMessage) {
+	if !c.canWrite {
+		c.enqueue(syncServerMessage{Type: syncMessageError, ID: msg.ID, Error: "workspace role does not allow edits"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, syncMutationTimeout)
+	defer cancel()
+	ctx, recorder := services.RecordChanges(ctx, c.id)
+
+	result, err := c.applyMutation(ctx, msg)
+	if err != nil {
+		c.enqueue(syncServerMessage{Type: syncMessageError, ID: msg.ID, Error: err.Error()})
+		return
+	}
+
+	changes := recorder.Events()
+	ack := syncServerMessage{
+		Type:       syncMessageAck,
+		ID:         msg.ID,
+		Changes:    changes,
+		Merged:     result.Merged,
+		ConflictID: result.ConflictID,
+	}
+	if len(changes) > 0 {
+		ack.Version = changes[len(changes)-1].Version
+	}
+	c.enqueue(ack)
+}
+
+// applyMutation applies a mutation through the user data service
+func (c *syncConnection) applyMutation(ctx context.Context, msg syncClientMessage) (*services.SyncResult, error) {
+	change := services.SyncChange{
+		Collection:  msg.Collection,
+		Operation:   msg.Operation,
+		DocumentID:  msg.DocumentID,
+		Data:        msg.Data,
+		BaseVersion: msg.BaseVersion,
+		Base:        msg.Base,
+	}
+	if err := checkWorkspaceChange(change, c.shared); err != nil {
+		return nil, err
+	}
+	if c.shared {
+		// The member's role may have changed since they connected
+		if _, err := c.handler.workspaceService.AuthorizeWorkspace(ctx, c.memberID, c.workspaceID, true); err != nil {
+			return nil, err
+		}
+	}
+	return c.handler.userDataService.ApplyChange(ctx, c.userID, change)
+}
+
+// syncCollectionName normalizes a storage key (e.g. taskFocusData) to the
+// collection name used in change events (task-focus-data)
+func syncCollectionName(name string) string {
+	return services.CollectionForStorageKey(name)
+}
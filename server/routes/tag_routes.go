@@ -0,0 +1,251 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for tag routes
+type TagManager interface {
+	ListTags(ctx context.Context, userID string) ([]*services.TagInfo, error)
+	GetTag(ctx context.Context, userID, name string) (*services.TagInfo, error)
+	CreateTag(ctx context.Context, userID string, tag *services.Tag) error
+	UpdateTag(ctx context.Context, userID, name string, update services.TagUpdate) (*services.TagInfo, error)
+	MergeTags(ctx context.Context, userID, target string, sources []string) (*services.TagInfo, error)
+	DeleteTag(ctx context.Context, userID, name string) error
+	GetTaggedItems(ctx context.Context, userID string, query services.TagQuery) (*services.TaggedItems, error)
+}
+
+// TagHandler handles tag HTTP requests
+type TagHandler struct {
+	tagManager  TagManager
+	authService UserAuthenticator
+}
+
+// NewTagHandler creates a new tag handler
+func NewTagHandler() (*TagHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TagHandler{
+		tagManager:  userDataService,
+		authService: authService,
+	}, nil
+}
+
+// SetupTagRoutes adds tag routes to the provided mux. Tag names go in the
+// path URL-encoded, so work/infra is /api/tags/work%2Finfra.
+func SetupTagRoutes(mux *http.ServeMux) error {
+	handler, err := NewTagHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/tags", handler.HandleTags)
+	mux.HandleFunc("/api/tags/{name}", handler.HandleTagByName)
+	mux.HandleFunc("/api/tags/{name}/merge", handler.HandleMerge)
+	mux.HandleFunc("/api/tags/{name}/items", handler.HandleItems)
+
+	return nil
+}
+
+// HandleTags lists (GET) or defines (POST) tags
+func (th *TagHandler) HandleTags(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		tags, err := th.tagManager.ListTags(ctx, userID)
+		if err != nil {
+			sendTagError(w, "Failed to fetch tags", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Tags retrieved successfully",
+			Data:    tags,
+		})
+
+	case http.MethodPost:
+		var tag services.Tag
+		if err := json.NewDecoder(r.Body).Decode(&tag); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		if err := th.tagManager.CreateTag(ctx, userID, &tag); err != nil {
+			sendTagError(w, "Failed to create tag", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Tag created successfully",
+			Data:    tag,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleTagByName gets, renames or recolors (PATCH), or deletes a tag.
+// Renames and deletes apply to the tag's descendants and every tagged item.
+func (th *TagHandler) HandleTagByName(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	name := r.PathValue("name")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		tag, err := th.tagManager.GetTag(ctx, userID, name)
+		if err != nil {
+			sendTagError(w, "Failed to fetch tag", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Tag retrieved successfully",
+			Data:    tag,
+		})
+
+	case http.MethodPatch:
+		var update services.TagUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		tag, err := th.tagManager.UpdateTag(ctx, userID, name, update)
+		if err != nil {
+			sendTagError(w, "Failed to update tag", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Tag updated successfully",
+			Data:    tag,
+		})
+
+	case http.MethodDelete:
+		if err := th.tagManager.DeleteTag(ctx, userID, name); err != nil {
+			sendTagError(w, "Failed to delete tag", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Tag deleted successfully",
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleMerge merges the tags in the body's sources into the tag in the path
+func (th *TagHandler) HandleMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	var requestBody struct {
+		Sources []string `json:"sources"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	tag, err := th.tagManager.MergeTags(ctx, userID, r.PathValue("name"), requestBody.Sources)
+	if err != nil {
+		sendTagError(w, "Failed to merge tags", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Tags merged successfully",
+		Data:    tag,
+	})
+}
+
+// HandleItems returns the sessions, saved tabs, favorites and tasks carrying
+// a tag or its descendants (exact=true for the tag alone), optionally
+// limited to type (comma-separated or repeated)
+func (th *TagHandler) HandleItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	query := r.URL.Query()
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	items, err := th.tagManager.GetTaggedItems(ctx, userID, services.TagQuery{
+		Tag:   r.PathValue("name"),
+		Types: queryList(query, "type"),
+		Exact: query.Get("exact") == "true",
+	})
+	if err != nil {
+		sendTagError(w, "Failed to fetch tagged items", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Tagged items retrieved successfully",
+		Data:    items,
+	})
+}
+
+// sendTagError maps tag errors to status codes
+func sendTagError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrTagNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrTagExists):
+		statusCode = http.StatusConflict
+	case errors.Is(err, services.ErrInvalidTag):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
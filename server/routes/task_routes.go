@@ -0,0 +1,319 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for task routes
+type TaskManager interface {
+	ListTasks(ctx context.Context, userID string, filter services.TaskFilter) ([]*services.Task, error)
+	GetTask(ctx context.Context, userID, taskID string) (*services.Task, error)
+	CreateTask(ctx context.Context, userID string, task *services.Task) error
+	UpdateTask(ctx context.Context, userID string, task *services.Task) error
+	DeleteTask(ctx context.Context, userID, taskID string) error
+	GetTaskStats(ctx context.Context, userID string, opts services.TaskStatsOptions) (*services.TaskStats, error)
+}
+
+// Limits on task statistics query parameters
+const (
+	maxTaskStatsWindows    = 10
+	maxTaskStatsWindowDays = 365
+	maxTaskStatsTrendDays  = 90
+)
+
+// TaskHandler handles task HTTP requests
+type TaskHandler struct {
+	taskManager TaskManager
+	authService UserAuthenticator
+}
+
+// NewTaskHandler creates a new task handler
+func NewTaskHandler() (*TaskHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TaskHandler{
+		taskManager: userDataService,
+		authService: authService,
+	}, nil
+}
+
+// SetupTaskRoutes adds task routes to the provided mux
+func SetupTaskRoutes(mux *http.ServeMux) error {
+	handler, err := NewTaskHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/tasks", handler.HandleTasks)
+	mux.HandleFunc("/api/tasks/stats", handler.HandleTaskStats)
+	mux.HandleFunc("/api/tasks/{id}", handler.HandleTaskByID)
+
+	return nil
+}
+
+// HandleTasks lists (GET) or creates (POST) tasks. List filters: status,
+// category, priority and size (comma-separated or repeated), tag, and
+// due_from/due_to (YYYY-MM-DD or RFC 3339; dates are inclusive).
+func (th *TaskHandler) HandleTasks(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		filter, err := parseTaskFilter(r.URL.Query())
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid filter", err)
+			return
+		}
+
+		tasks, err := th.taskManager.ListTasks(ctx, userID, filter)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to fetch tasks", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Tasks retrieved successfully",
+			Data:    tasks,
+		})
+
+	case http.MethodPost:
+		var task services.Task
+		if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		if err := th.taskManager.CreateTask(ctx, userID, &task); err != nil {
+			sendTaskError(w, "Failed to create task", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Task created successfully",
+			Data:    task,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleTaskByID gets, replaces (PUT), partially updates (PATCH) or deletes
+// a task
+func (th *TaskHandler) HandleTaskByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	taskID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		task, err := th.taskManager.GetTask(ctx, userID, taskID)
+		if err != nil {
+			sendTaskError(w, "Failed to fetch task", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Task retrieved successfully",
+			Data:    task,
+		})
+
+	case http.MethodPut, http.MethodPatch:
+		var task services.Task
+		if r.Method == http.MethodPatch {
+			// Fields missing from the body keep their current values
+			existing, err := th.taskManager.GetTask(ctx, userID, taskID)
+			if err != nil {
+				sendTaskError(w, "Failed to fetch task", err)
+				return
+			}
+			task = *existing
+		}
+		if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		task.ID = taskID // Ensure ID matches URL
+		if err := th.taskManager.UpdateTask(ctx, userID, &task); err != nil {
+			sendTaskError(w, "Failed to update task", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Task updated successfully",
+			Data:    task,
+		})
+
+	case http.MethodDelete:
+		if err := th.taskManager.DeleteTask(ctx, userID, taskID); err != nil {
+			sendTaskError(w, "Failed to delete task", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Task deleted successfully",
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleTaskStats returns task statistics. Day boundaries use the tz
+// parameter or the user's timeZone setting; windows lists trailing periods
+// in days (e.g. 7,30) and trend_days sets the length of the daily series.
+func (th *TaskHandler) HandleTaskStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	opts, err := parseTaskStatsOptions(r.URL.Query())
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid parameters", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	stats, err := th.taskManager.GetTaskStats(ctx, userID, opts)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to compute task statistics", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Task statistics retrieved successfully",
+		Data:    stats,
+	})
+}
+
+// parseTaskStatsOptions reads statistics options from query parameters
+func parseTaskStatsOptions(query url.Values) (services.TaskStatsOptions, error) {
+	var opts services.TaskStatsOptions
+
+	if name := query.Get("tz"); name != "" {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return opts, fmt.Errorf("unknown time zone %q", name)
+		}
+		opts.Location = location
+	}
+
+	windows := queryList(query, "windows")
+	if len(windows) > maxTaskStatsWindows {
+		return opts, fmt.Errorf("at most %d windows", maxTaskStatsWindows)
+	}
+	for _, value := range windows {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 || days > maxTaskStatsWindowDays {
+			return opts, fmt.Errorf("windows must be between 1 and %d days", maxTaskStatsWindowDays)
+		}
+		opts.Windows = append(opts.Windows, days)
+	}
+
+	if value := query.Get("trend_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 || days > maxTaskStatsTrendDays {
+			return opts, fmt.Errorf("trend_days must be between 1 and %d", maxTaskStatsTrendDays)
+		}
+		opts.TrendDays = days
+	}
+
+	return opts, nil
+}
+
+// parseTaskFilter reads task filters from query parameters
+func parseTaskFilter(query url.Values) (services.TaskFilter, error) {
+	filter := services.TaskFilter{
+		Statuses:   queryList(query, "status"),
+		Categories: queryList(query, "category"),
+		Priorities: queryList(query, "priority"),
+		Sizes:      queryList(query, "size"),
+		Tag:        query.Get("tag"),
+	}
+
+	if value := query.Get("due_from"); value != "" {
+		from, err := services.ParseTaskTime(value)
+		if err != nil {
+			return filter, errors.New("due_from must be YYYY-MM-DD or an RFC 3339 timestamp")
+		}
+		filter.DueFrom = from
+	}
+	if value := query.Get("due_to"); value != "" {
+		to, err := services.ParseTaskTime(value)
+		if err != nil {
+			return filter, errors.New("due_to must be YYYY-MM-DD or an RFC 3339 timestamp")
+		}
+		if len(value) == len("2006-01-02") {
+			// A date includes the whole day
+			to = to.Add(24*time.Hour - time.Nanosecond)
+		}
+		filter.DueTo = to
+	}
+
+	return filter, nil
+}
+
+// queryList collects a parameter given repeatedly or comma-separated
+func queryList(query url.Values, name string) []string {
+	var values []string
+	for _, value := range query[name] {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// sendTaskError maps task errors to status codes
+func sendTaskError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrTaskNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidTask):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for timeline routes
+type TimelineProvider interface {
+	GetTimeline(ctx context.Context, userID, from, to string, location *time.Location) (*services.Timeline, error)
+}
+
+// TimelineHandler handles timeline HTTP requests
+type TimelineHandler struct {
+	timelineProvider TimelineProvider
+	authService      UserAuthenticator
+}
+
+// NewTimelineHandler creates a new timeline handler
+func NewTimelineHandler() (*TimelineHandler, error) {
+	userDataService, err := services.NewUserDataService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TimelineHandler{
+		timelineProvider: userDataService,
+		authService:      authService,
+	}, nil
+}
+
+// SetupTimelineRoutes adds timeline routes to the provided mux
+func SetupTimelineRoutes(mux *http.ServeMux) error {
+	handler, err := NewTimelineHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/timeline", handler.HandleTimeline)
+
+	return nil
+}
+
+// HandleTimeline returns focus sessions and disruptions day by day for date,
+// or from/to (YYYY-MM-DD, inclusive), defaulting to today. Days follow the
+// tz parameter or the user's timeZone setting.
+func (th *TimelineHandler) HandleTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, th.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	query := r.URL.Query()
+	var location *time.Location
+	if name := query.Get("tz"); name != "" {
+		if location, err = time.LoadLocation(name); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid parameters", fmt.Errorf("unknown time zone %q", name))
+			return
+		}
+	}
+
+	from, to := query.Get("from"), query.Get("to")
+	if date := query.Get("date"); date != "" {
+		if from != "" || to != "" {
+			sendError(w, http.StatusBadRequest, "Invalid parameters", errors.New("date cannot be combined with from or to"))
+			return
+		}
+		from, to = date, date
+	}
+	if err := validateTimelineRange(from, to); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid parameters", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	timeline, err := th.timelineProvider.GetTimeline(ctx, userID, from, to, location)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to build timeline", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Timeline retrieved successfully",
+		Data:    timeline,
+	})
+}
+
+// validateTimelineRange checks a timeline's dates and length; neither
+// means today
+func validateTimelineRange(from, to string) error {
+	if from == "" && to == "" {
+		return nil
+	}
+	if from == "" || to == "" {
+		return errors.New("from and to must be given together")
+	}
+	fromDate, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return errors.New("from must be YYYY-MM-DD")
+	}
+	toDate, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return errors.New("to must be YYYY-MM-DD")
+	}
+	if toDate.Before(fromDate) {
+		return errors.New("from must not be after to")
+	}
+	if days := int(toDate.Sub(fromDate).Hours()/24) + 1; days > services.MaxTimelineDays {
+		return fmt.Errorf("at most %d days per request", services.MaxTimelineDays)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"tab-blaster-server/services"
 	"time"
 )
@@ -19,6 +20,7 @@ type SessionManager interface {
 type TabsManager interface {
 	GetUserSavedTabs(ctx context.Context, userID string) ([]*services.SavedTab, error)
 	StoreSavedTabs(ctx context.Context, userID string, tabs []*services.SavedTab) error
+	DeleteSavedTab(ctx context.Context, userID string, tabID int) error
 }
 
 type SettingsManager interface {
@@ -32,18 +34,39 @@ type DataManager interface {
 	DeleteUserData(ctx context.Context, userID string, key string) error
 }
 
+type ChangeSubscriber interface {
+	SubscribeChanges(userID, subscriberID string, lastVersion int64) *services.ChangeSubscription
+}
+
+type Synchronizer interface {
+	GetChangesSince(ctx context.Context, userID, since string, limit int) (*services.SyncDelta, error)
+	ApplyChange(ctx context.Context, userID string, change services.SyncChange) (*services.SyncResult, error)
+}
+
+type ConflictManager interface {
+	ListConflicts(ctx context.Context, userID, status string) ([]*services.SyncConflict, error)
+	GetConflict(ctx context.Context, userID, conflictID string) (*services.SyncConflict, error)
+	ResolveConflict(ctx context.Context, userID, conflictID, resolution string, data json.RawMessage) (*services.SyncConflict, error)
+	DeleteConflict(ctx context.Context, userID, conflictID string) error
+}
+
 // UserDataService combines all user data interfaces
 type UserDataServiceInterface interface {
 	SessionManager
 	TabsManager
 	SettingsManager
 	DataManager
+	ChangeSubscriber
+	Synchronizer
+	ConflictManager
+	ArchiveManager
 }
 
 // UserDataHandler handles user data HTTP requests
 type UserDataHandler struct {
-	userDataService UserDataServiceInterface
-	authService     UserAuthenticator
+	userDataService  UserDataServiceInterface
+	authService      UserAuthenticator
+	workspaceService WorkspaceAuthorizer
 }
 
 // NewUserDataHandler creates a new user data handler
@@ -58,51 +81,74 @@ func NewUserDataHandler() (*UserDataHandler, error) {
 		return nil, err
 	}
 
+	workspaceService, err := services.NewWorkspaceService()
+	if err != nil {
+		return nil, err
+	}
+
 	return &UserDataHandler{
-		userDataService: userDataService,
-		authService:     authService,
+		userDataService:  userDataService,
+		authService:      authService,
+		workspaceService: workspaceService,
 	}, nil
 }
 
 // Helper to extract user ID from Authorization header
 func (udh *UserDataHandler) getUserIDFromAuth(r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return "", http.ErrNoCookie
+	return userIDFromRequest(r, udh.authService)
+}
+
+// authorizeRequest authenticates the request and returns the ID whose data it
+// addresses (see authorizeNamespace)
+func (udh *UserDataHandler) authorizeRequest(w http.ResponseWriter, r *http.Request, workspaceAllowed bool) (string, bool) {
+	return authorizeNamespace(w, r, udh.authService, udh.workspaceService, workspaceAllowed)
+}
+
+// authorizeNamespace authenticates the request and returns the ID whose data
+// it addresses: the caller's own user ID, or a workspace namespace when a
+// workspace is selected with the X-Workspace-ID header or workspace_id query
+// parameter. Reads need any workspace role; writes need editor or owner. On
+// failure the error response has already been written.
+func authorizeNamespace(w http.ResponseWriter, r *http.Request, authService UserAuthenticator, workspaceService WorkspaceAuthorizer, workspaceAllowed bool) (string, bool) {
+	userID, err := userIDFromRequest(r, authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return "", false
 	}
 
-	// Expected format: "Bearer <token>"
-	const bearerPrefix = "Bearer "
-	if len(authHeader) <= len(bearerPrefix) || authHeader[:len(bearerPrefix)] != bearerPrefix {
-		return "", http.ErrNoCookie
+	workspaceID := workspaceIDFromRequest(r)
+	if workspaceID == "" {
+		return userID, true
+	}
+	if !workspaceAllowed {
+		sendError(w, http.StatusBadRequest, "This resource is not shared in workspaces", nil)
+		return "", false
 	}
 
-	token := authHeader[len(bearerPrefix):]
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	userID, err := udh.authService.VerifyToken(ctx, token)
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	namespace, err := workspaceService.AuthorizeWorkspace(ctx, userID, workspaceID, write)
 	if err != nil {
-		return "", err
+		sendWorkspaceError(w, "Workspace access denied", err)
+		return "", false
 	}
 
-	return userID, nil
+	return namespace, true
 }
 
-// Helper to send error responses
-func (udh *UserDataHandler) sendError(w http.ResponseWriter, statusCode int, message string, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-
-	errorMsg := message
-	if err != nil {
-		errorMsg = err.Error()
+// workspaceIDFromRequest returns the workspace selected by the request, if any
+func workspaceIDFromRequest(r *http.Request) string {
+	if workspaceID := r.Header.Get("X-Workspace-ID"); workspaceID != "" {
+		return workspaceID
 	}
+	return r.URL.Query().Get("workspace_id")
+}
 
-	json.NewEncoder(w).Encode(Response{
-		Message: message,
-		Error:   errorMsg,
-	})
+// Helper to send error responses
+func (udh *UserDataHandler) sendError(w http.ResponseWriter, statusCode int, message string, err error) {
+	sendError(w, statusCode, message, err)
 }
 
 // SetupUserDataRoutes adds user data routes to the provided mux
@@ -115,9 +161,11 @@ func SetupUserDataRoutes(mux *http.ServeMux) error {
 	// Session routes
 	mux.HandleFunc("/api/sessions", handler.HandleSessions)
 	mux.HandleFunc("/api/sessions/", handler.HandleSessionByID)
+	mux.HandleFunc("/api/sessions/export", handler.HandleSessionsExport)
 
 	// Tabs routes
 	mux.HandleFunc("/api/tabs", handler.HandleTabs)
+	mux.HandleFunc("/api/tabs/{id}/archive", handler.HandleTabArchive)
 
 	// Settings routes
 	mux.HandleFunc("/api/settings", handler.HandleSettings)
@@ -125,14 +173,25 @@ func SetupUserDataRoutes(mux *http.ServeMux) error {
 	// Generic storage routes
 	mux.HandleFunc("/api/storage/", handler.HandleStorage)
 
+	// Change feed
+	mux.HandleFunc("/api/events", handler.HandleEvents)
+
+	// Delta sync and the bidirectional sync channel
+	mux.HandleFunc("/api/sync", handler.HandleSync)
+	mux.HandleFunc("/api/sync/ws", handler.HandleSyncSocket)
+
+	// Sync conflicts
+	mux.HandleFunc("/api/conflicts", handler.HandleConflicts)
+	mux.HandleFunc("/api/conflicts/{id}", handler.HandleConflictByID)
+	mux.HandleFunc("/api/conflicts/{id}/resolve", handler.HandleResolveConflict)
+
 	return nil
 }
 
 // HandleSessions handles session collection requests
 func (udh *UserDataHandler) HandleSessions(w http.ResponseWriter, r *http.Request) {
-	userID, err := udh.getUserIDFromAuth(r)
-	if err != nil {
-		udh.sendError(w, http.StatusUnauthorized, "Authentication required", err)
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
 		return
 	}
 
@@ -179,19 +238,29 @@ func (udh *UserDataHandler) HandleSessions(w http.ResponseWriter, r *http.Reques
 
 // HandleSessionByID handles individual session requests
 func (udh *UserDataHandler) HandleSessionByID(w http.ResponseWriter, r *http.Request) {
-	userID, err := udh.getUserIDFromAuth(r)
-	if err != nil {
-		udh.sendError(w, http.StatusUnauthorized, "Authentication required", err)
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
 		return
 	}
 
 	// Extract session ID from URL path
-	sessionID := r.URL.Path[len("/api/sessions/"):]
+	sessionID, subresource, _ := strings.Cut(r.URL.Path[len("/api/sessions/"):], "/")
 	if sessionID == "" {
 		udh.sendError(w, http.StatusBadRequest, "Session ID is required", nil)
 		return
 	}
 
+	// Session sub-resources
+	switch subresource {
+	case "":
+	case "export":
+		udh.handleSessionExport(w, r, userID, sessionID)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
@@ -246,9 +315,8 @@ func (udh *UserDataHandler) HandleSessionByID(w http.ResponseWriter, r *http.Req
 
 // HandleTabs handles saved tabs requests
 func (udh *UserDataHandler) HandleTabs(w http.ResponseWriter, r *http.Request) {
-	userID, err := udh.getUserIDFromAuth(r)
-	if err != nil {
-		udh.sendError(w, http.StatusUnauthorized, "Authentication required", err)
+	userID, ok := udh.authorizeRequest(w, r, true)
+	if !ok {
 		return
 	}
 
@@ -272,6 +340,8 @@ func (udh *UserDataHandler) HandleTabs(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		var requestBody struct {
 			Tabs []*services.SavedTab `json:"tabs"`
+			// Archive queues the tabs' pages for archiving
+			Archive bool `json:"archive"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
@@ -279,7 +349,11 @@ func (udh *UserDataHandler) HandleTabs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := udh.userDataService.StoreSavedTabs(ctx, userID, requestBody.Tabs); err != nil {
+		store := udh.userDataService.StoreSavedTabs
+		if requestBody.Archive {
+			store = udh.userDataService.ArchiveSavedTabs
+		}
+		if err := store(ctx, userID, requestBody.Tabs); err != nil {
 			udh.sendError(w, http.StatusInternalServerError, "Failed to store saved tabs", err)
 			return
 		}
@@ -297,9 +371,8 @@ func (udh *UserDataHandler) HandleTabs(w http.ResponseWriter, r *http.Request) {
 
 // HandleSettings handles user settings requests
 func (udh *UserDataHandler) HandleSettings(w http.ResponseWriter, r *http.Request) {
-	userID, err := udh.getUserIDFromAuth(r)
-	if err != nil {
-		udh.sendError(w, http.StatusUnauthorized, "Authentication required", err)
+	userID, ok := udh.authorizeRequest(w, r, false)
+	if !ok {
 		return
 	}
 
@@ -344,12 +417,6 @@ func (udh *UserDataHandler) HandleSettings(w http.ResponseWriter, r *http.Reques
 
 // HandleStorage handles generic storage requests
 func (udh *UserDataHandler) HandleStorage(w http.ResponseWriter, r *http.Request) {
-	userID, err := udh.getUserIDFromAuth(r)
-	if err != nil {
-		udh.sendError(w, http.StatusUnauthorized, "Authentication required", err)
-		return
-	}
-
 	// Extract key from URL path
 	key := r.URL.Path[len("/api/storage/"):]
 	if key == "" {
@@ -357,6 +424,12 @@ func (udh *UserDataHandler) HandleStorage(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Only shared collections may be addressed in a workspace
+	userID, ok := udh.authorizeRequest(w, r, services.WorkspaceStorageKeys[key])
+	if !ok {
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
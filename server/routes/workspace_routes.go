@@ -0,0 +1,366 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"tab-blaster-server/services"
+	"time"
+)
+
+// Consumer-driven interfaces for workspace routes
+type WorkspaceManager interface {
+	CreateWorkspace(ctx context.Context, userID, name string) (*services.Workspace, error)
+	ListWorkspaces(ctx context.Context, userID string) ([]*services.Workspace, error)
+	GetWorkspace(ctx context.Context, userID, workspaceID string) (*services.Workspace, error)
+	RenameWorkspace(ctx context.Context, userID, workspaceID, name string) (*services.Workspace, error)
+	DeleteWorkspace(ctx context.Context, userID, workspaceID string) error
+}
+
+type WorkspaceMembership interface {
+	InviteMember(ctx context.Context, userID, workspaceID, email string, role services.WorkspaceRole) (*services.WorkspaceInvitation, error)
+	ListWorkspaceInvitations(ctx context.Context, userID, workspaceID string) ([]*services.WorkspaceInvitation, error)
+	ListUserInvitations(ctx context.Context, userID string) ([]*services.WorkspaceInvitation, error)
+	AcceptInvitation(ctx context.Context, userID, token string) (*services.Workspace, error)
+	SetMemberRole(ctx context.Context, userID, workspaceID, memberID string, role services.WorkspaceRole) (*services.Workspace, error)
+	RemoveMember(ctx context.Context, userID, workspaceID, memberID string) (*services.Workspace, error)
+}
+
+type WorkspaceAuthorizer interface {
+	AuthorizeWorkspace(ctx context.Context, userID, workspaceID string, write bool) (string, error)
+}
+
+// WorkspaceService combines the workspace interfaces
+type WorkspaceService interface {
+	WorkspaceManager
+	WorkspaceMembership
+}
+
+// WorkspaceHandler handles workspace HTTP requests
+type WorkspaceHandler struct {
+	workspaceService WorkspaceService
+	authService      UserAuthenticator
+}
+
+// NewWorkspaceHandler creates a new workspace handler
+func NewWorkspaceHandler() (*WorkspaceHandler, error) {
+	workspaceService, err := services.NewWorkspaceService()
+	if err != nil {
+		return nil, err
+	}
+
+	authService, err := services.NewAuthService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WorkspaceHandler{
+		workspaceService: workspaceService,
+		authService:      authService,
+	}, nil
+}
+
+// SetupWorkspaceRoutes adds workspace routes to the provided mux
+func SetupWorkspaceRoutes(mux *http.ServeMux) error {
+	handler, err := NewWorkspaceHandler()
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/api/workspaces", handler.HandleWorkspaces)
+	mux.HandleFunc("/api/workspaces/{id}", handler.HandleWorkspaceByID)
+	mux.HandleFunc("/api/workspaces/{id}/invitations", handler.HandleWorkspaceInvitations)
+	mux.HandleFunc("/api/workspaces/{id}/members/{member}", handler.HandleWorkspaceMember)
+	mux.HandleFunc("/api/workspace-invitations", handler.HandleUserInvitations)
+	mux.HandleFunc("/api/workspace-invitations/{token}/accept", handler.HandleAcceptInvitation)
+
+	return nil
+}
+
+// sendWorkspaceError maps workspace errors to HTTP status codes
+func sendWorkspaceError(w http.ResponseWriter, message string, err error) {
+	statusCode := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrWorkspaceNotFound), errors.Is(err, services.ErrInvitationNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, services.ErrWorkspaceForbidden), errors.Is(err, services.ErrInvitationEmail),
+		errors.Is(err, services.ErrEmailNotVerified):
+		statusCode = http.StatusForbidden
+	case errors.Is(err, services.ErrInvitationExpired):
+		statusCode = http.StatusGone
+	case errors.Is(err, services.ErrLastWorkspaceOwner), errors.Is(err, services.ErrInvalidWorkspaceRole):
+		statusCode = http.StatusBadRequest
+	}
+	sendError(w, statusCode, message, err)
+}
+
+// HandleWorkspaces lists (GET) or creates (POST) workspaces
+func (wh *WorkspaceHandler) HandleWorkspaces(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, wh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		workspaces, err := wh.workspaceService.ListWorkspaces(ctx, userID)
+		if err != nil {
+			sendWorkspaceError(w, "Failed to fetch workspaces", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Workspaces retrieved successfully",
+			Data:    workspaces,
+		})
+
+	case http.MethodPost:
+		var requestBody struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		workspace, err := wh.workspaceService.CreateWorkspace(ctx, userID, requestBody.Name)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Failed to create workspace", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Workspace created successfully",
+			Data:    workspace,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleWorkspaceByID gets, renames or deletes a workspace
+func (wh *WorkspaceHandler) HandleWorkspaceByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, wh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	workspaceID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		workspace, err := wh.workspaceService.GetWorkspace(ctx, userID, workspaceID)
+		if err != nil {
+			sendWorkspaceError(w, "Failed to fetch workspace", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Workspace retrieved successfully",
+			Data:    workspace,
+		})
+
+	case http.MethodPut, http.MethodPatch:
+		var requestBody struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		workspace, err := wh.workspaceService.RenameWorkspace(ctx, userID, workspaceID, requestBody.Name)
+		if err != nil {
+			sendWorkspaceError(w, "Failed to update workspace", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Workspace updated successfully",
+			Data:    workspace,
+		})
+
+	case http.MethodDelete:
+		if err := wh.workspaceService.DeleteWorkspace(ctx, userID, workspaceID); err != nil {
+			sendWorkspaceError(w, "Failed to delete workspace", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Workspace deleted successfully",
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleWorkspaceInvitations invites a member by email (POST) or lists pending invitations (GET)
+func (wh *WorkspaceHandler) HandleWorkspaceInvitations(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, wh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	workspaceID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		invitations, err := wh.workspaceService.ListWorkspaceInvitations(ctx, userID, workspaceID)
+		if err != nil {
+			sendWorkspaceError(w, "Failed to fetch invitations", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Invitations retrieved successfully",
+			Data:    invitations,
+		})
+
+	case http.MethodPost:
+		var requestBody struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		if requestBody.Role == "" {
+			requestBody.Role = string(services.WorkspaceRoleViewer)
+		}
+
+		invitation, err := wh.workspaceService.InviteMember(ctx, userID, workspaceID, requestBody.Email, services.WorkspaceRole(requestBody.Role))
+		if err != nil {
+			sendWorkspaceError(w, "Failed to invite member", err)
+			return
+		}
+
+		sendJSON(w, http.StatusCreated, Response{
+			Message: "Invitation created successfully",
+			Data:    invitation,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleWorkspaceMember changes a member's role (PUT) or removes them (DELETE)
+func (wh *WorkspaceHandler) HandleWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := userIDFromRequest(r, wh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	workspaceID := r.PathValue("id")
+	memberID := r.PathValue("member")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch:
+		var requestBody struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		workspace, err := wh.workspaceService.SetMemberRole(ctx, userID, workspaceID, memberID, services.WorkspaceRole(requestBody.Role))
+		if err != nil {
+			sendWorkspaceError(w, "Failed to update member", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Member updated successfully",
+			Data:    workspace,
+		})
+
+	case http.MethodDelete:
+		workspace, err := wh.workspaceService.RemoveMember(ctx, userID, workspaceID, memberID)
+		if err != nil {
+			sendWorkspaceError(w, "Failed to remove member", err)
+			return
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Message: "Member removed successfully",
+			Data:    workspace,
+		})
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// HandleUserInvitations lists invitations addressed to the current user
+func (wh *WorkspaceHandler) HandleUserInvitations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, wh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	invitations, err := wh.workspaceService.ListUserInvitations(ctx, userID)
+	if err != nil {
+		sendWorkspaceError(w, "Failed to fetch invitations", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Invitations retrieved successfully",
+		Data:    invitations,
+	})
+}
+
+// HandleAcceptInvitation joins the workspace an invitation was sent for
+func (wh *WorkspaceHandler) HandleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID, err := userIDFromRequest(r, wh.authService)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	workspace, err := wh.workspaceService.AcceptInvitation(ctx, userID, r.PathValue("token"))
+	if err != nil {
+		sendWorkspaceError(w, "Failed to accept invitation", err)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Message: "Invitation accepted successfully",
+		Data:    workspace,
+	})
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"maps"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Background workers keep what they learn about an item's URL in the user's
+// annotations collection rather than in the item, so their results bump no
+// sync versions, raise no conflicts and publish nothing to the change feed.
+// Annotations are overlaid on items as the API reads them; sync returns the
+// items as stored.
+
+// annotatedItem is one URL of a session tab, saved tab or favorite
+type annotatedItem struct {
+	itemType string // LinkTypeSession, LinkTypeSavedTab or LinkTypeFavorite
+	itemID   string
+	tabID    int // Tab within a session
+	url      string
+}
+
+// id returns the document ID of the item's annotation. An item whose URL
+// changes gets a fresh annotation.
+func (item annotatedItem) id() string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%d\x00%s", item.itemType, item.itemID, item.tabID, item.url))
+	return hex.EncodeToString(sum[:])
+}
+
+// itemAnnotation is what the workers learned about one item's URL
+type itemAnnotation struct {
+	ItemType   string      `firestore:"itemType"`
+	ItemID     string      `firestore:"itemId"`
+	TabID      int         `firestore:"tabId"`
+	URL        string      `firestore:"url"`
+	LinkStatus *LinkStatus `firestore:"linkStatus,omitempty"`
+	// Page is the fetched page's metadata, nil when the fetch failed
+	Page          *PageMetadata `firestore:"page,omitempty"`
+	FaviconBroken bool          `firestore:"faviconBroken,omitempty"`
+	EnrichedAt    string        `firestore:"enrichedAt,omitempty"`
+	Archive       *PageArchive  `firestore:"archive,omitempty"`
+	UpdatedAt     time.Time     `firestore:"updatedAt"`
+}
+
+// annotationSet is a user's annotations by document ID
+type annotationSet map[string]*itemAnnotation
+
+// get returns an item's annotation, empty when there is none
+func (as annotationSet) get(item annotatedItem) *itemAnnotation {
+	if annotation := as[item.id()]; annotation != nil {
+		return annotation
+	}
+	return &itemAnnotation{}
+}
+
+// sessionTabItem, savedTabItem and favoriteItem identify an item's URL
+func sessionTabItem(session *Session, tab *Tab) annotatedItem {
+	return annotatedItem{LinkTypeSession, session.ID, tab.ID, tab.URL}
+}
+
+func savedTabItem(tab *SavedTab) annotatedItem {
+	return annotatedItem{LinkTypeSavedTab, strconv.Itoa(tab.ID), 0, tab.URL}
+}
+
+func favoriteItem(favorite *FavoriteTab) annotatedItem {
+	return annotatedItem{LinkTypeFavorite, favorite.ID, 0, favorite.URL}
+}
+
+// annotateSessions overlays annotations on session tabs
+func (as annotationSet) annotateSessions(sessions []*Session) {
+	for _, session := range sessions {
+		for i := range session.Tabs {
+			tab := &session.Tabs[i]
+			annotation := as.get(sessionTabItem(session, tab))
+			tab.LinkStatus = annotation.LinkStatus
+			if annotation.Page != nil {
+				enrichTab(tab, annotation.Page)
+			}
+		}
+	}
+}
+
+// annotateSavedTabs overlays annotations on saved tabs
+func (as annotationSet) annotateSavedTabs(tabs []*SavedTab) {
+	for _, tab := range tabs {
+		annotation := as.get(savedTabItem(tab))
+		tab.LinkStatus = annotation.LinkStatus
+		tab.Archive = annotation.Archive
+		if annotation.EnrichedAt != "" {
+			enrichSavedTab(tab, annotation.Page, annotation.FaviconBroken, annotation.EnrichedAt)
+		}
+	}
+}
+
+// annotateFavorites overlays annotations on favorites
+func (as annotationSet) annotateFavorites(favorites []*FavoriteTab) {
+	for _, favorite := range favorites {
+		favorite.LinkStatus = as.get(favoriteItem(favorite)).LinkStatus
+	}
+}
+
+// getAnnotationsCollectionPath returns the path of a user's annotations
+func getAnnotationsCollectionPath(userID string) string {
+	return fmt.Sprintf("%s/%s/annotations", COLLECTION_NAME, userID)
+}
+
+// loadAnnotations reads all of the user's annotations
+func (uds *UserDataService) loadAnnotations(ctx context.Context, userID string) (annotationSet, error) {
+	iter := uds.firebaseService.firestore.Collection(getAnnotationsCollectionPath(userID)).Documents(ctx)
+	defer iter.Stop()
+
+	annotations := make(annotationSet)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate annotations: %w", err)
+		}
+
+		var annotation itemAnnotation
+		if err := doc.DataTo(&annotation); err != nil {
+			log.Printf("Failed to parse annotation %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		annotations[doc.Ref.ID] = &annotation
+	}
+	return annotations, nil
+}
+
+// annotationUpdate sets some fields of an item's annotation
+type annotationUpdate struct {
+	item   annotatedItem
+	fields map[string]interface{}
+}
+
+// writeAnnotations merges updates into the user's annotations. Fields not
+// in an update are left as they are, so workers do not overwrite each
+// other's results.
+func (uds *UserDataService) writeAnnotations(ctx context.Context, userID string, updates []annotationUpdate) error {
+	client := uds.firebaseService.firestore
+	collection := client.Collection(getAnnotationsCollectionPath(userID))
+	now := time.Now()
+
+	for start := 0; start < len(updates); start += firestoreBatchLimit {
+		batch := client.Batch()
+		for _, update := range updates[start:min(start+firestoreBatchLimit, len(updates))] {
+			data := map[string]interface{}{
+				"itemType":  update.item.itemType,
+				"itemId":    update.item.itemID,
+				"tabId":     update.item.tabID,
+				"url":       update.item.url,
+				"updatedAt": now,
+			}
+			maps.Copy(data, update.fields)
+			batch.Set(collection.Doc(update.item.id()), data, firestore.MergeAll)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to store annotations: %w", err)
+		}
+	}
+	return nil
+}
+
+// pruneAnnotations deletes the annotations of items or URLs that are gone.
+// Annotations written since before are kept, as their items may be newer
+// than the ones read.
+func (uds *UserDataService) pruneAnnotations(ctx context.Context, userID string, annotations annotationSet, current []annotatedItem, before time.Time) error {
+	keep := make(map[string]bool, len(current))
+	for _, item := range current {
+		keep[item.id()] = true
+	}
+
+	client := uds.firebaseService.firestore
+	collection := client.Collection(getAnnotationsCollectionPath(userID))
+	var stale []string
+	for id, annotation := range annotations {
+		if !keep[id] && annotation.UpdatedAt.Before(before) {
+			stale = append(stale, id)
+		}
+	}
+
+	for start := 0; start < len(stale); start += firestoreBatchLimit {
+		batch := client.Batch()
+		for _, id := range stale[start:min(start+firestoreBatchLimit, len(stale))] {
+			batch.Delete(collection.Doc(id))
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to prune annotations: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteItemAnnotations deletes an item's annotations for all its URLs
+func (uds *UserDataService) deleteItemAnnotations(ctx context.Context, userID, itemType, itemID string) error {
+	client := uds.firebaseService.firestore
+	iter := client.Collection(getAnnotationsCollectionPath(userID)).
+		Where("itemType", "==", itemType).
+		Where("itemId", "==", itemID).
+		Documents(ctx)
+	defer iter.Stop()
+
+	batch := client.Batch()
+	deletes := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate annotations: %w", err)
+		}
+		batch.Delete(doc.Ref)
+		deletes++
+	}
+	if deletes == 0 {
+		return nil
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to delete annotations: %w", err)
+	}
+	return nil
+}
+
+// annotatedItems lists the URLs of the user's session tabs, saved tabs and
+// favorites
+func annotatedItems(sessions []*Session, savedTabs []*SavedTab, favorites []*FavoriteTab) []annotatedItem {
+	var items []annotatedItem
+	for _, session := range sessions {
+		for i := range session.Tabs {
+			items = append(items, sessionTabItem(session, &session.Tabs[i]))
+		}
+	}
+	for _, tab := range savedTabs {
+		items = append(items, savedTabItem(tab))
+	}
+	for _, favorite := range favorites {
+		items = append(items, favoriteItem(favorite))
+	}
+	return items
+}
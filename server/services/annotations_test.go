@@ -0,0 +1,140 @@
+package services
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestAnnotationSetOverlay(t *testing.T) {
+	broken := &LinkStatus{URL: "https://a.example", Broken: true}
+	session := &Session{ID: "s1", Tabs: []Tab{{ID: 7, URL: "https://a.example"}}}
+	annotations := annotationSet{
+		sessionTabItem(session, &session.Tabs[0]).id():                     {LinkStatus: broken},
+		annotatedItem{LinkTypeSavedTab, "1", 0, "https://a.example"}.id():  {LinkStatus: broken},
+		annotatedItem{LinkTypeFavorite, "f1", 0, "https://a.example"}.id(): {LinkStatus: broken},
+	}
+
+	tests := []struct {
+		name string
+		got  func() *LinkStatus
+		want *LinkStatus
+	}{
+		{"session tab", func() *LinkStatus {
+			sessions := []*Session{{ID: "s1", Tabs: []Tab{{ID: 7, URL: "https://a.example"}}}}
+			annotations.annotateSessions(sessions)
+			return sessions[0].Tabs[0].LinkStatus
+		}, broken},
+		{"same URL in another session", func() *LinkStatus {
+			sessions := []*Session{{ID: "s2", Tabs: []Tab{{ID: 7, URL: "https://a.example"}}}}
+			annotations.annotateSessions(sessions)
+			return sessions[0].Tabs[0].LinkStatus
+		}, nil},
+		{"saved tab", func() *LinkStatus {
+			tabs := []*SavedTab{{ID: 1, URL: "https://a.example"}}
+			annotations.annotateSavedTabs(tabs)
+			return tabs[0].LinkStatus
+		}, broken},
+		{"saved tab whose URL changed drops a stale status", func() *LinkStatus {
+			tabs := []*SavedTab{{ID: 1, URL: "https://b.example", LinkStatus: broken}}
+			annotations.annotateSavedTabs(tabs)
+			return tabs[0].LinkStatus
+		}, nil},
+		{"favorite", func() *LinkStatus {
+			favorites := []*FavoriteTab{{ID: "f1", URL: "https://a.example"}}
+			annotations.annotateFavorites(favorites)
+			return favorites[0].LinkStatus
+		}, broken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.got(); got != tt.want {
+				t.Errorf("LinkStatus = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnnotationSetEnrichment(t *testing.T) {
+	page := &PageMetadata{Title: "Fetched", Favicon: "https://a.example/icon.png", Description: "About a"}
+	tests := []struct {
+		name        string
+		tab         SavedTab
+		annotation  *itemAnnotation
+		wantTitle   string
+		wantFavicon string
+		wantMeta    map[string]string
+	}{
+		{
+			name:       "not enriched",
+			tab:        SavedTab{ID: 1, URL: "https://a.example"},
+			annotation: &itemAnnotation{},
+		},
+		{
+			name:        "blank title and favicon filled",
+			tab:         SavedTab{ID: 1, URL: "https://a.example", Title: "https://a.example"},
+			annotation:  &itemAnnotation{Page: page, EnrichedAt: "2024-01-01T00:00:00.000Z"},
+			wantTitle:   "Fetched",
+			wantFavicon: page.Favicon,
+			wantMeta:    map[string]string{MetadataKeyDescription: "About a", MetadataKeyEnrichedAt: "2024-01-01T00:00:00.000Z"},
+		},
+		{
+			name:        "own title kept, broken favicon replaced",
+			tab:         SavedTab{ID: 1, URL: "https://a.example", Title: "Mine", FavIconUrl: "https://a.example/old.png"},
+			annotation:  &itemAnnotation{Page: page, FaviconBroken: true, EnrichedAt: "2024-01-01T00:00:00.000Z"},
+			wantTitle:   "Mine",
+			wantFavicon: page.Favicon,
+			wantMeta:    map[string]string{MetadataKeyDescription: "About a", MetadataKeyEnrichedAt: "2024-01-01T00:00:00.000Z"},
+		},
+		{
+			name:       "failed fetch only marks the tab",
+			tab:        SavedTab{ID: 1, URL: "https://a.example"},
+			annotation: &itemAnnotation{EnrichedAt: "2024-01-01T00:00:00.000Z"},
+			wantMeta:   map[string]string{MetadataKeyEnrichedAt: "2024-01-01T00:00:00.000Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tab := tt.tab
+			annotations := annotationSet{savedTabItem(&tab).id(): tt.annotation}
+			annotations.annotateSavedTabs([]*SavedTab{&tab})
+			if tt.wantTitle == "" {
+				tt.wantTitle = tt.tab.Title
+			}
+			if tab.Title != tt.wantTitle || tab.FavIconUrl != tt.wantFavicon {
+				t.Errorf("tab = %q %q, want %q %q", tab.Title, tab.FavIconUrl, tt.wantTitle, tt.wantFavicon)
+			}
+			if !maps.Equal(tab.Metadata, tt.wantMeta) {
+				t.Errorf("metadata = %v, want %v", tab.Metadata, tt.wantMeta)
+			}
+		})
+	}
+}
+
+func TestAnnotationSetArchive(t *testing.T) {
+	archive := &PageArchive{Status: ArchiveStatusArchived, SourceURL: "https://a.example"}
+	annotations := annotationSet{
+		annotatedItem{LinkTypeSavedTab, "1", 0, "https://a.example"}.id(): {Archive: archive},
+	}
+
+	tests := []struct {
+		name string
+		tab  SavedTab
+		want *PageArchive
+	}{
+		{"archived URL", SavedTab{ID: 1, URL: "https://a.example"}, archive},
+		{"URL changed since", SavedTab{ID: 1, URL: "https://b.example", Archive: archive}, nil},
+		{"another tab", SavedTab{ID: 2, URL: "https://a.example"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tab := tt.tab
+			annotations.annotateSavedTabs([]*SavedTab{&tab})
+			if tab.Archive != tt.want {
+				t.Errorf("Archive = %+v, want %+v", tab.Archive, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html/charset"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ARCHIVES_COLLECTION_NAME indexes the users with saved tabs waiting to be
+// archived by when the worker next needs to look at them
+const ARCHIVES_COLLECTION_NAME = "tab-blaster-5k-archives"
+
+// Archive worker defaults
+const (
+	DefaultArchiveInterval     = time.Minute
+	DefaultArchiveFetchTimeout = 20 * time.Second
+	DefaultArchiveMaxBytes     = 5 << 20
+	// ArchiveUserAgent identifies the archiver to sites and robots.txt
+	ArchiveUserAgent = "TabBlasterArchiver/1.0 (+https://github.com/glennmartinez/tab-blaster-5000)"
+	// archiveHostDelay is the minimum gap between requests to one host
+	archiveHostDelay = time.Second
+	// archiveUserPeriod is how often users are looked at without a request,
+	// which picks up tabs left pending by a restart
+	archiveUserPeriod = 24 * time.Hour
+	// maxArchivesPerUserRun bounds the pages fetched for one user per run;
+	// the rest continue on the next run
+	maxArchivesPerUserRun = 20
+	// archiveBatchSize is the number of users read per run
+	archiveBatchSize = 20
+	// archiveConcurrency is the number of pages fetched at once
+	archiveConcurrency = 4
+)
+
+// Archive statuses
+const (
+	ArchiveStatusPending  = "pending"
+	ArchiveStatusArchived = "archived"
+	ArchiveStatusFailed   = "failed"
+)
+
+// Archive errors
+var (
+	ErrSavedTabNotFound = errors.New("saved tab not found")
+	ErrArchiveNotFound  = errors.New("archive not found")
+	ErrNotArchivable    = errors.New("only http(s) pages can be archived")
+)
+
+// PageArchive links a saved tab to the archived copy of its page
+type PageArchive struct {
+	Status string `json:"status" firestore:"status"`
+	// SourceURL is the tab URL that was archived, FinalURL where it led
+	SourceURL  string `json:"sourceUrl" firestore:"sourceUrl"`
+	FinalURL   string `json:"finalUrl,omitempty" firestore:"finalUrl,omitempty"`
+	Title      string `json:"title,omitempty" firestore:"title,omitempty"`
+	ArchivedAt string `json:"archivedAt,omitempty" firestore:"archivedAt,omitempty"`
+	HTMLSize   int    `json:"htmlSize,omitempty" firestore:"htmlSize,omitempty"`
+	TextSize   int    `json:"textSize,omitempty" firestore:"textSize,omitempty"`
+	Error      string `json:"error,omitempty" firestore:"error,omitempty"`
+}
+
+// archiveBlobKey returns the blob key of one file of a saved tab's archive
+func archiveBlobKey(userID string, tabID int, name string) string {
+	return fmt.Sprintf("archives/%s/%d/%s", url.PathEscape(userID), tabID, name)
+}
+
+// Archive blob names
+const (
+	archiveHTMLBlob = "page.html"
+	archiveTextBlob = "text.txt"
+)
+
+// ArchiveSavedTabs stores saved tabs like StoreSavedTabs and queues their
+// pages for archiving. Tabs whose URL was already archived, or is pending,
+// keep that archive.
+func (uds *UserDataService) ArchiveSavedTabs(ctx context.Context, userID string, tabs []*SavedTab) error {
+	// Storing assigns IDs to new tabs
+	if err := uds.StoreSavedTabs(ctx, userID, tabs); err != nil {
+		return err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var updates []annotationUpdate
+	for _, tab := range tabs {
+		if !isHTTPURL(tab.URL) {
+			continue
+		}
+		item := savedTabItem(tab)
+		tab.Archive = annotations.get(item).Archive
+		if tab.Archive == nil || tab.Archive.Status == ArchiveStatusFailed {
+			tab.Archive = &PageArchive{Status: ArchiveStatusPending, SourceURL: tab.URL}
+			updates = append(updates, annotationUpdate{item, map[string]interface{}{"archive": tab.Archive}})
+		}
+	}
+
+	if err := uds.writeAnnotations(ctx, userID, updates); err != nil {
+		return fmt.Errorf("failed to queue archives: %w", err)
+	}
+	return uds.requestUserJob(ctx, ARCHIVES_COLLECTION_NAME, userID)
+}
+
+// ArchiveSavedTab queues a saved tab's page for archiving again
+func (uds *UserDataService) ArchiveSavedTab(ctx context.Context, userID string, tabID int) (*SavedTab, error) {
+	uds.mu.RLock()
+	tab, err := uds.loadSavedTab(ctx, userID, tabID)
+	uds.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPURL(tab.URL) {
+		return nil, ErrNotArchivable
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations.annotateSavedTabs([]*SavedTab{tab})
+
+	tab.Archive = &PageArchive{Status: ArchiveStatusPending, SourceURL: tab.URL}
+	update := annotationUpdate{savedTabItem(tab), map[string]interface{}{"archive": tab.Archive}}
+	if err := uds.writeAnnotations(ctx, userID, []annotationUpdate{update}); err != nil {
+		return nil, fmt.Errorf("failed to queue archive: %w", err)
+	}
+	if err := uds.requestUserJob(ctx, ARCHIVES_COLLECTION_NAME, userID); err != nil {
+		return nil, err
+	}
+	return tab, nil
+}
+
+// GetSavedTabArchive returns a saved tab's archive record and its sanitized
+// HTML, or its readable text when text is set
+func (uds *UserDataService) GetSavedTabArchive(ctx context.Context, userID string, tabID int, text bool) (*PageArchive, []byte, error) {
+	uds.mu.RLock()
+	tab, err := uds.loadSavedTab(ctx, userID, tabID)
+	uds.mu.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive := annotations.get(savedTabItem(tab)).Archive
+	if archive == nil {
+		return nil, nil, fmt.Errorf("%w: the tab was not archived", ErrArchiveNotFound)
+	}
+	if archive.Status != ArchiveStatusArchived {
+		return archive, nil, fmt.Errorf("%w: archiving is %s", ErrArchiveNotFound, archive.Status)
+	}
+
+	name := archiveHTMLBlob
+	if text {
+		name = archiveTextBlob
+	}
+	data, err := uds.blobs.Get(ctx, archiveBlobKey(userID, tabID, name))
+	if errors.Is(err, ErrBlobNotFound) {
+		return archive, nil, fmt.Errorf("%w: %v", ErrArchiveNotFound, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return archive, data, nil
+}
+
+// loadSavedTab reads one saved tab; callers hold uds.mu
+func (uds *UserDataService) loadSavedTab(ctx context.Context, userID string, tabID int) (*SavedTab, error) {
+	doc, err := uds.firebaseService.firestore.Collection(getSavedTabsCollectionPath(userID)).Doc(strconv.Itoa(tabID)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrSavedTabNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved tab: %w", err)
+	}
+	var tab SavedTab
+	if err := doc.DataTo(&tab); err != nil {
+		return nil, fmt.Errorf("failed to parse saved tab: %w", err)
+	}
+	return &tab, nil
+}
+
+// archiveTexts reads the archived text of the saved tabs that have one, by
+// tab ID. The tabs carry their annotations; callers hold uds.mu.
+func (uds *UserDataService) archiveTexts(ctx context.Context, userID string, tabs []*SavedTab) map[int]string {
+	texts := make(map[int]string)
+	for _, tab := range tabs {
+		if tab.Archive == nil || tab.Archive.Status != ArchiveStatusArchived {
+			continue
+		}
+		data, err := uds.blobs.Get(ctx, archiveBlobKey(userID, tab.ID, archiveTextBlob))
+		if err != nil {
+			log.Printf("Failed to read archived text of saved tab %d for user %s: %v", tab.ID, userID, err)
+			continue
+		}
+		texts[tab.ID] = string(data)
+	}
+	return texts
+}
+
+// deleteArchive removes a saved tab's archive files
+func (uds *UserDataService) deleteArchive(ctx context.Context, userID string, tabID int) error {
+	for _, name := range []string{archiveHTMLBlob, archiveTextBlob} {
+		if err := uds.blobs.Delete(ctx, archiveBlobKey(userID, tabID, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveWorker fetches the pages of saved tabs queued for archiving and
+// stores their snapshots
+type ArchiveWorker struct {
+	userDataService *UserDataService
+	client          *politeClient
+	maxBytes        int64
+	interval        time.Duration
+}
+
+var (
+	archiveWorker     *ArchiveWorker
+	archiveWorkerOnce sync.Once
+	archiveWorkerErr  error
+)
+
+// NewArchiveWorker returns the archive worker. ARCHIVE_INTERVAL sets how
+// often it runs (0 disables it), ARCHIVE_FETCH_TIMEOUT the per-request
+// timeout, ARCHIVE_MAX_BYTES how much of a page is kept and
+// ARCHIVE_ALLOW_PRIVATE=true lets it reach private addresses.
+func NewArchiveWorker() (*ArchiveWorker, error) {
+	archiveWorkerOnce.Do(func() {
+		userDataService, err := NewUserDataService()
+		if err != nil {
+			archiveWorkerErr = err
+			return
+		}
+
+		durations := map[string]time.Duration{
+			"ARCHIVE_INTERVAL":      DefaultArchiveInterval,
+			"ARCHIVE_FETCH_TIMEOUT": DefaultArchiveFetchTimeout,
+		}
+		for name, fallback := range durations {
+			value, err := time.ParseDuration(getEnvOrDefault(name, fallback.String()))
+			if err != nil || value < 0 {
+				archiveWorkerErr = fmt.Errorf("invalid %s: %v", name, err)
+				return
+			}
+			durations[name] = value
+		}
+		maxBytes, err := strconv.ParseInt(getEnvOrDefault("ARCHIVE_MAX_BYTES", strconv.Itoa(DefaultArchiveMaxBytes)), 10, 64)
+		if err != nil || maxBytes <= 0 {
+			archiveWorkerErr = fmt.Errorf("invalid ARCHIVE_MAX_BYTES: %v", err)
+			return
+		}
+
+		archiveWorker = &ArchiveWorker{
+			userDataService: userDataService,
+			client: newPoliteClient(
+				durations["ARCHIVE_FETCH_TIMEOUT"],
+				archiveHostDelay,
+				getEnvOrDefault("ARCHIVE_ALLOW_PRIVATE", "false") == "true",
+				ArchiveUserAgent,
+			),
+			maxBytes: maxBytes,
+			interval: durations["ARCHIVE_INTERVAL"],
+		}
+	})
+
+	return archiveWorker, archiveWorkerErr
+}
+
+// Run runs the worker until ctx is done
+func (aw *ArchiveWorker) Run(ctx context.Context) {
+	if aw.interval == 0 {
+		log.Printf("Archive worker disabled")
+		return
+	}
+
+	ticker := time.NewTicker(aw.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := aw.RunOnce(ctx); err != nil {
+			log.Printf("Archive worker run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce archives the pending tabs of the users that are due
+func (aw *ArchiveWorker) RunOnce(ctx context.Context) error {
+	return aw.userDataService.runDueUserJobs(ctx, ARCHIVES_COLLECTION_NAME, archiveBatchSize, aw.archiveUser)
+}
+
+// archiveResult is the outcome of archiving one URL
+type archiveResult struct {
+	snapshot *PageSnapshot
+	finalURL string
+	err      error
+}
+
+// archiveUser snapshots the pages of the user's pending tabs, stores them
+// and returns when to look again
+func (aw *ArchiveWorker) archiveUser(ctx context.Context, entry *userJobEntry) (time.Time, error) {
+	uds := aw.userDataService
+	userID := entry.UserID
+
+	uds.mu.RLock()
+	savedTabs, err := uds.loadSavedTabs(ctx, userID)
+	uds.mu.RUnlock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var pending []*SavedTab
+	var due []string
+	seen := make(map[string]bool)
+	for _, tab := range savedTabs {
+		if archive := annotations.get(savedTabItem(tab)).Archive; archive == nil || archive.Status != ArchiveStatusPending {
+			continue
+		}
+		pending = append(pending, tab)
+		if !seen[tab.URL] {
+			seen[tab.URL] = true
+			due = append(due, tab.URL)
+		}
+	}
+
+	nextRun := time.Now().Add(archiveUserPeriod)
+	if len(due) > maxArchivesPerUserRun {
+		due = due[:maxArchivesPerUserRun]
+		nextRun = time.Now()
+	}
+	if len(due) == 0 {
+		return nextRun, nil
+	}
+
+	results := make(map[string]*archiveResult)
+	var mu sync.Mutex
+	forEachConcurrently(due, archiveConcurrency, func(rawURL string) {
+		snapshot, finalURL, err := aw.fetchSnapshot(ctx, rawURL)
+		mu.Lock()
+		defer mu.Unlock()
+		results[rawURL] = &archiveResult{snapshot: snapshot, finalURL: finalURL, err: err}
+	})
+	if ctx.Err() != nil {
+		return time.Time{}, ctx.Err()
+	}
+
+	if err := aw.storeArchives(ctx, userID, pending, results); err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Archived %d pages for user %s", len(results), userID)
+	return nextRun, nil
+}
+
+// storeArchives writes each snapshot's files for the tabs pending on its URL
+// and then records the archives in the tabs' annotations. Tabs are reread
+// under the lock so files of tabs deleted or moved to another URL meanwhile
+// are removed instead.
+func (aw *ArchiveWorker) storeArchives(ctx context.Context, userID string, pending []*SavedTab, results map[string]*archiveResult) error {
+	uds := aw.userDataService
+	now := formatTaskTime(time.Now())
+
+	archives := make(map[int]*PageArchive)
+	for _, tab := range pending {
+		result := results[tab.URL]
+		if result == nil {
+			continue
+		}
+		archive := &PageArchive{Status: ArchiveStatusFailed, SourceURL: tab.URL, ArchivedAt: now}
+		err := result.err
+		if err == nil {
+			err = aw.putSnapshot(ctx, userID, tab.ID, result.snapshot)
+		}
+		if err != nil {
+			archive.Error = truncateString(err.Error(), maxLinkErrorBytes)
+		} else {
+			archive.Status = ArchiveStatusArchived
+			archive.FinalURL = result.finalURL
+			archive.Title = result.snapshot.Title
+			archive.HTMLSize = len(result.snapshot.HTML)
+			archive.TextSize = len(result.snapshot.Text)
+		}
+		archives[tab.ID] = archive
+	}
+
+	// DeleteSavedTab takes the lock too, so no tab goes between the check
+	// and the write
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	current, err := uds.loadSavedTabs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	var updates []annotationUpdate
+	for _, tab := range current {
+		archive, ok := archives[tab.ID]
+		if !ok || tab.URL != archive.SourceURL {
+			continue
+		}
+		delete(archives, tab.ID)
+		updates = append(updates, annotationUpdate{savedTabItem(tab), map[string]interface{}{"archive": archive}})
+	}
+	// Tabs deleted while their page was fetched leave no files behind
+	for tabID := range archives {
+		if err := uds.deleteArchive(ctx, userID, tabID); err != nil {
+			log.Printf("Failed to delete archive of saved tab %d for user %s: %v", tabID, userID, err)
+		}
+	}
+
+	if err := uds.writeAnnotations(ctx, userID, updates); err != nil {
+		return fmt.Errorf("failed to store archives: %w", err)
+	}
+	return nil
+}
+
+// putSnapshot stores a snapshot's HTML and text for a saved tab
+func (aw *ArchiveWorker) putSnapshot(ctx context.Context, userID string, tabID int, snapshot *PageSnapshot) error {
+	blobs := aw.userDataService.blobs
+	if err := blobs.Put(ctx, archiveBlobKey(userID, tabID, archiveHTMLBlob), snapshot.HTML); err != nil {
+		return err
+	}
+	return blobs.Put(ctx, archiveBlobKey(userID, tabID, archiveTextBlob), []byte(snapshot.Text))
+}
+
+// fetchSnapshot fetches a page and snapshots it, returning the URL it ended
+// up at
+func (aw *ArchiveWorker) fetchSnapshot(ctx context.Context, rawURL string) (*PageSnapshot, string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || !isHTTPURL(rawURL) || target.Host == "" {
+		return nil, "", fmt.Errorf("not an http(s) URL: %q", rawURL)
+	}
+	allowed, delay := aw.client.allowed(ctx, target)
+	if !allowed {
+		return nil, "", ErrRobotsDisallowed
+	}
+
+	resp, err := aw.client.do(ctx, http.MethodGet, target, delay, http.Header{
+		"Accept": {"text/html,application/xhtml+xml;q=0.9,*/*;q=0.1"},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("page returned status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, "", fmt.Errorf("%w: %s", ErrNotHTML, mediaType)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, aw.maxBytes), contentType)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode page: %w", err)
+	}
+	snapshot, err := SnapshotPage(body, resp.Request.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	return snapshot, resp.Request.URL.String(), nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrBlobNotFound is returned for keys with no stored blob
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores opaque values too large or too binary for Firestore
+// documents. Keys are slash-separated paths such as archives/{user}/{tab}/page.html;
+// callers escape user-supplied segments.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes a blob; deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	blobStore     BlobStore
+	blobStoreOnce sync.Once
+	blobStoreErr  error
+)
+
+// NewBlobStore returns the blob store. BLOB_STORE picks the backend: local
+// (the default) keeps blobs under BLOB_STORE_DIR, s3 in the S3_BUCKET bucket
+// at S3_ENDPOINT (AWS when empty) in S3_REGION, signed with
+// S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY. Buckets are addressed by path,
+// as MinIO expects; S3_PATH_STYLE=false addresses them as subdomains.
+func NewBlobStore() (BlobStore, error) {
+	blobStoreOnce.Do(func() {
+		switch backend := getEnvOrDefault("BLOB_STORE", "local"); backend {
+		case "local":
+			blobStore, blobStoreErr = NewLocalBlobStore(getEnvOrDefault("BLOB_STORE_DIR", "blobs"))
+		case "s3":
+			blobStore, blobStoreErr = NewS3BlobStore(
+				getEnvOrDefault("S3_ENDPOINT", ""),
+				getEnvOrDefault("S3_BUCKET", ""),
+				getEnvOrDefault("S3_REGION", "us-east-1"),
+				getEnvOrDefault("S3_ACCESS_KEY_ID", ""),
+				getEnvOrDefault("S3_SECRET_ACCESS_KEY", ""),
+				getEnvOrDefault("S3_PATH_STYLE", "true") == "true",
+			)
+		default:
+			blobStoreErr = fmt.Errorf("invalid BLOB_STORE %q (expected local or s3)", backend)
+		}
+	})
+	return blobStore, blobStoreErr
+}
+
+// LocalBlobStore keeps blobs as files under a directory
+type LocalBlobStore struct {
+	dir string
+}
+
+// NewLocalBlobStore creates a blob store in dir, creating it if needed
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob store directory: %w", err)
+	}
+	return &LocalBlobStore{dir: dir}, nil
+}
+
+// path maps a key to its file, rejecting keys that would leave the directory
+func (ls *LocalBlobStore) path(key string) (string, error) {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsRune(segment, '\\') {
+			return "", fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return filepath.Join(ls.dir, filepath.FromSlash(key)), nil
+}
+
+// Put stores data under key, replacing any previous blob atomically
+func (ls *LocalBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Dir(path), filepath.Base(path), data); err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get reads the blob stored under key
+func (ls *LocalBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Delete removes the blob stored under key
+func (ls *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob %s: %w", key, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to dir/name through a temporary file renamed
+// into place, so readers never see a partial file
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
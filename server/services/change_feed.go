@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Change operations
+const (
+	ChangeOperationUpsert = "upsert"
+	ChangeOperationDelete = "delete"
+)
+
+// Change feed defaults
+const (
+	defaultChangeReplaySize    = 1000
+	changeSubscriptionCapacity = 64
+	// changeLogIdleTTL is how long the replay log of a user without
+	// subscribers is kept after their last change
+	changeLogIdleTTL = time.Hour
+	// changeLogSweepInterval is how often idle logs are looked for
+	changeLogSweepInterval = time.Minute
+)
+
+// ChangeEvent describes one write to a user's data. Versions increase
+// monotonically across the whole feed, so a version doubles as the event ID.
+type ChangeEvent struct {
+	Version    int64  `json:"version"`
+	Collection string `json:"collection"`
+	DocumentID string `json:"id"`
+	Operation  string `json:"operation"`
+	Timestamp  string `json:"timestamp"`
+	// Origin identifies the client connection that made the change, if any
+	Origin string `json:"origin,omitempty"`
+}
+
+// ChangeRecorder collects the change events published by writes made with
+// the context returned from RecordChanges
+type ChangeRecorder struct {
+	origin string
+	mu     sync.Mutex
+	events []ChangeEvent
+}
+
+type changeRecorderKey struct{}
+
+// RecordChanges returns a context that tags writes with origin and records
+// the resulting change events, so callers can learn the versions they produced
+func RecordChanges(ctx context.Context, origin string) (context.Context, *ChangeRecorder) {
+	recorder := &ChangeRecorder{origin: origin}
+	return context.WithValue(ctx, changeRecorderKey{}, recorder), recorder
+}
+
+// Events returns the recorded change events in publish order
+func (r *ChangeRecorder) Events() []ChangeEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ChangeEvent(nil), r.events...)
+}
+
+func (r *ChangeRecorder) add(event ChangeEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// ChangeSubscription receives a user's change events as they are published
+type ChangeSubscription struct {
+	// Replay holds the buffered events newer than the requested version
+	Replay []ChangeEvent
+	// Reset is set when the requested version is older than the replay log,
+	// meaning events were missed and the client must resynchronize fully
+	Reset bool
+
+	userID string
+	// subscriberID is the account following userID's changes, which differs
+	// from userID for workspace members
+	subscriberID string
+	events       chan ChangeEvent
+	feed         *ChangeFeed
+	once         sync.Once
+	revoked      bool
+}
+
+// Events returns the live event channel. It is closed when the subscription
+// is closed or dropped because the subscriber fell too far behind.
+func (s *ChangeSubscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Revoked reports whether the subscription was closed because the subscriber
+// lost access, rather than for falling behind. It is valid once Events is
+// closed.
+func (s *ChangeSubscription) Revoked() bool {
+	return s.revoked
+}
+
+// Close stops the subscription
+func (s *ChangeSubscription) Close() {
+	s.feed.unsubscribe(s)
+}
+
+// ChangeFeed fans out change events to subscribers and keeps a bounded
+// per-user replay log for resuming. Logs of users without subscribers are
+// evicted once idle for changeLogIdleTTL.
+type ChangeFeed struct {
+	mu           sync.Mutex
+	lastVersion  int64
+	startVersion int64
+	replaySize   int
+	logs         map[string]*changeLog
+	subscribers  map[string]map[*ChangeSubscription]struct{}
+	lastSweep    time.Time
+	// evictedUpTo is the newest version in any evicted log; resuming from
+	// before it without a log may have missed events
+	evictedUpTo int64
+}
+
+// changeLog is one user's replay buffer
+type changeLog struct {
+	events      []ChangeEvent
+	trimmedUpTo int64     // version of the newest event dropped from the buffer
+	updated     time.Time // when the newest event was added
+}
+
+// NewChangeFeed creates a change feed keeping replaySize events per user
+func NewChangeFeed(replaySize int) *ChangeFeed {
+	if replaySize <= 0 {
+		replaySize = defaultChangeReplaySize
+	}
+	return &ChangeFeed{
+		startVersion: time.Now().UnixMicro(),
+		replaySize:   replaySize,
+		lastSweep:    time.Now(),
+		logs:         make(map[string]*changeLog),
+		subscribers:  make(map[string]map[*ChangeSubscription]struct{}),
+	}
+}
+
+// newChangeFeedFromEnv creates a change feed sized by CHANGE_FEED_REPLAY_SIZE
+func newChangeFeedFromEnv() *ChangeFeed {
+	replaySize, err := strconv.Atoi(getEnvOrDefault("CHANGE_FEED_REPLAY_SIZE", strconv.Itoa(defaultChangeReplaySize)))
+	if err != nil {
+		log.Printf("Invalid CHANGE_FEED_REPLAY_SIZE, using %d: %v", defaultChangeReplaySize, err)
+		replaySize = defaultChangeReplaySize
+	}
+	return NewChangeFeed(replaySize)
+}
+
+// NextVersion returns a new version: the current time in microseconds,
+// bumped when necessary so that versions never repeat or go backwards
+func (f *ChangeFeed) NextVersion() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nextVersionLocked()
+}
+
+func (f *ChangeFeed) nextVersionLocked() int64 {
+	version := time.Now().UnixMicro()
+	if version <= f.lastVersion {
+		version = f.lastVersion + 1
+	}
+	f.lastVersion = version
+	return version
+}
+
+// PublishContext publishes a change made with ctx, tagging it with the origin
+// of any ChangeRecorder attached to the context and recording it there
+func (f *ChangeFeed) PublishContext(ctx context.Context, userID, collection, documentID, operation string) ChangeEvent {
+	return f.publishContext(ctx, userID, collection, documentID, operation, 0)
+}
+
+// publishContext is PublishContext for a version already allocated, e.g. one
+// committed alongside the write
+func (f *ChangeFeed) publishContext(ctx context.Context, userID, collection, documentID, operation string, version int64) ChangeEvent {
+	recorder, _ := ctx.Value(changeRecorderKey{}).(*ChangeRecorder)
+	origin := ""
+	if recorder != nil {
+		origin = recorder.origin
+	}
+
+	event := f.publish(userID, collection, documentID, operation, origin, version)
+	if recorder != nil {
+		recorder.add(event)
+	}
+	return event
+}
+
+// Publish records a change for the user and delivers it to subscribers
+func (f *ChangeFeed) Publish(userID, collection, documentID, operation string) ChangeEvent {
+	return f.publish(userID, collection, documentID, operation, "", 0)
+}
+
+func (f *ChangeFeed) publish(userID, collection, documentID, operation, origin string, version int64) ChangeEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if version == 0 {
+		version = f.nextVersionLocked()
+	} else if version > f.lastVersion {
+		f.lastVersion = version
+	}
+	event := ChangeEvent{
+		Version:    version,
+		Collection: collection,
+		DocumentID: documentID,
+		Operation:  operation,
+		Timestamp:  time.UnixMicro(version).UTC().Format(time.RFC3339Nano),
+		Origin:     origin,
+	}
+
+	changes := f.logs[userID]
+	if changes == nil {
+		changes = &changeLog{}
+		f.logs[userID] = changes
+	}
+	changes.events = append(changes.events, event)
+	changes.updated = time.Now()
+	if overflow := len(changes.events) - f.replaySize; overflow > 0 {
+		changes.trimmedUpTo = changes.events[overflow-1].Version
+		changes.events = append([]ChangeEvent(nil), changes.events[overflow:]...)
+	}
+
+	for sub := range f.subscribers[userID] {
+		select {
+		case sub.events <- event:
+		default:
+			// The subscriber is not keeping up; drop it so it reconnects and
+			// resumes from its last event instead of blocking writers
+			log.Printf("Dropping slow change subscriber for user %s", userID)
+			f.removeLocked(sub)
+		}
+	}
+
+	if now := time.Now(); now.Sub(f.lastSweep) >= changeLogSweepInterval {
+		f.evictIdleLocked(now)
+	}
+	return event
+}
+
+// evictIdleLocked drops the logs of users without subscribers whose newest
+// event is older than changeLogIdleTTL
+func (f *ChangeFeed) evictIdleLocked(now time.Time) {
+	f.lastSweep = now
+	for userID, changes := range f.logs {
+		if len(f.subscribers[userID]) > 0 || now.Sub(changes.updated) < changeLogIdleTTL {
+			continue
+		}
+		if newest := changes.events[len(changes.events)-1].Version; newest > f.evictedUpTo {
+			f.evictedUpTo = newest
+		}
+		delete(f.logs, userID)
+	}
+}
+
+// Subscribe registers subscriberID for the user's changes. With a non-zero
+// lastVersion the buffered events after it are returned in Replay.
+func (f *ChangeFeed) Subscribe(userID, subscriberID string, lastVersion int64) *ChangeSubscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sub := &ChangeSubscription{
+		userID:       userID,
+		subscriberID: subscriberID,
+		events:       make(chan ChangeEvent, changeSubscriptionCapacity),
+		feed:         f,
+	}
+
+	if lastVersion > 0 {
+		// Events were lost if the client's position predates this process
+		// or the buffer has been trimmed past it
+		if lastVersion < f.startVersion {
+			sub.Reset = true
+		}
+		changes := f.logs[userID]
+		if changes == nil && lastVersion < f.evictedUpTo {
+			sub.Reset = true
+		}
+		if changes != nil {
+			if lastVersion < changes.trimmedUpTo {
+				sub.Reset = true
+			}
+			for _, event := range changes.events {
+				if event.Version > lastVersion {
+					sub.Replay = append(sub.Replay, event)
+				}
+			}
+		}
+	}
+
+	if f.subscribers[userID] == nil {
+		f.subscribers[userID] = make(map[*ChangeSubscription]struct{})
+	}
+	f.subscribers[userID][sub] = struct{}{}
+	return sub
+}
+
+// CloseUser closes the user's subscriptions and drops their replay log, for
+// a namespace whose data is gone
+func (f *ChangeFeed) CloseUser(userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subscribers[userID] {
+		f.revokeLocked(sub)
+	}
+	delete(f.logs, userID)
+}
+
+// CloseSubscriber closes the subscriptions subscriberID holds on the user's
+// changes, for a workspace member whose access was removed or reduced
+func (f *ChangeFeed) CloseSubscriber(userID, subscriberID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subscribers[userID] {
+		if sub.subscriberID == subscriberID {
+			f.revokeLocked(sub)
+		}
+	}
+}
+
+func (f *ChangeFeed) unsubscribe(sub *ChangeSubscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removeLocked(sub)
+}
+
+// revokeLocked closes a live subscription, marking it revoked before its
+// channel closes
+func (f *ChangeFeed) revokeLocked(sub *ChangeSubscription) {
+	sub.revoked = true
+	f.removeLocked(sub)
+}
+
+func (f *ChangeFeed) removeLocked(sub *ChangeSubscription) {
+	sub.once.Do(func() {
+		if subs := f.subscribers[sub.userID]; subs != nil {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(f.subscribers, sub.userID)
+			}
+		}
+		close(sub.events)
+	})
+}
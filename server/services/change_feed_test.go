@@ -0,0 +1,96 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangeFeedCloseUser(t *testing.T) {
+	feed := NewChangeFeed(10)
+	closed := feed.Subscribe("workspace:w1", "u1", 0)
+	other := feed.Subscribe("user1", "user1", 0)
+	feed.Publish("workspace:w1", "sessions", "s1", ChangeOperationUpsert)
+
+	feed.CloseUser("workspace:w1")
+
+	<-closed.Events() // The buffered event
+	if _, open := <-closed.Events(); open {
+		t.Error("subscription of a closed user is still open")
+	}
+	if replay := feed.Subscribe("workspace:w1", "u1", 1).Replay; len(replay) != 0 {
+		t.Errorf("closed user's log replayed %v", replay)
+	}
+
+	feed.Publish("user1", "sessions", "s1", ChangeOperationUpsert)
+	if event := <-other.Events(); event.DocumentID != "s1" {
+		t.Errorf("other user got %+v", event)
+	}
+}
+
+func TestChangeFeedCloseSubscriber(t *testing.T) {
+	feed := NewChangeFeed(10)
+	removed := feed.Subscribe("workspace:w1", "u1", 0)
+	member := feed.Subscribe("workspace:w1", "u2", 0)
+	own := feed.Subscribe("u1", "u1", 0)
+
+	feed.CloseSubscriber("workspace:w1", "u1")
+
+	if _, open := <-removed.Events(); open {
+		t.Fatal("removed member's subscription is still open")
+	}
+	if !removed.Revoked() {
+		t.Error("removed member's subscription not marked revoked")
+	}
+
+	feed.Publish("workspace:w1", "sessions", "s1", ChangeOperationUpsert)
+	feed.Publish("u1", "sessions", "s2", ChangeOperationUpsert)
+	if event := <-member.Events(); event.DocumentID != "s1" {
+		t.Errorf("other member got %+v", event)
+	}
+	if event := <-own.Events(); event.DocumentID != "s2" {
+		t.Errorf("removed member's own namespace got %+v", event)
+	}
+	if member.Revoked() || own.Revoked() {
+		t.Error("unrelated subscriptions marked revoked")
+	}
+}
+
+func TestChangeFeedEvictsIdleLogs(t *testing.T) {
+	tests := []struct {
+		name      string
+		subscribe bool
+		idle      time.Duration
+		wantLog   bool
+	}{
+		{name: "recent log kept", idle: changeLogIdleTTL / 2, wantLog: true},
+		{name: "idle log evicted", idle: changeLogIdleTTL},
+		{name: "idle log with a subscriber kept", subscribe: true, idle: changeLogIdleTTL, wantLog: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed := NewChangeFeed(10)
+			if tt.subscribe {
+				feed.Subscribe("user1", "user1", 0)
+			}
+			event := feed.Publish("user1", "sessions", "s1", ChangeOperationUpsert)
+
+			feed.mu.Lock()
+			feed.evictIdleLocked(time.Now().Add(tt.idle))
+			_, kept := feed.logs["user1"]
+			feed.mu.Unlock()
+			if kept != tt.wantLog {
+				t.Fatalf("log kept = %v, want %v", kept, tt.wantLog)
+			}
+
+			// Resuming from before an evicted event must resync
+			sub := feed.Subscribe("user1", "user1", event.Version-1)
+			if sub.Reset == tt.wantLog {
+				t.Errorf("Reset = %v, want %v", sub.Reset, !tt.wantLog)
+			}
+			if tt.wantLog && len(sub.Replay) != 1 {
+				t.Errorf("Replay = %v, want the event", sub.Replay)
+			}
+		})
+	}
+}
@@ -0,0 +1,513 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCollectionQueryLength bounds a saved query
+const maxCollectionQueryLength = 1000
+
+// ErrInvalidQuery is wrapped by every query parse error
+var ErrInvalidQuery = errors.New("invalid query")
+
+// QueryError is a query parse error at the token that caused it. Position
+// counts characters from 1.
+type QueryError struct {
+	Position int    `json:"position"`
+	Token    string `json:"token"`
+	Message  string `json:"message"`
+}
+
+func (e *QueryError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%v: %s at position %d", ErrInvalidQuery, e.Message, e.Position)
+	}
+	return fmt.Sprintf("%v: %s at position %d (%q)", ErrInvalidQuery, e.Message, e.Position, e.Token)
+}
+
+func (e *QueryError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+// Query item kinds, as used by the type field
+const (
+	QueryItemSessions  = "sessions"
+	QueryItemSavedTabs = "savedTabs"
+	QueryItemFavorites = "favorites"
+)
+
+// queryItem is what a query sees of a session, saved tab or favorite
+type queryItem struct {
+	kind   string
+	title  string
+	urls   []string
+	tags   []string
+	added  time.Time // zero when unknown
+	visits int
+	text   string // archived page text, lower case; only loaded for text queries
+}
+
+// queryToken is a lexical token of a query
+type queryToken struct {
+	pos  int
+	text string
+	kind queryTokenKind
+}
+
+type queryTokenKind int
+
+const (
+	tokenTerm queryTokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+// lexQuery splits a query into parentheses, AND/OR/NOT keywords and terms.
+// A leading - negates a term. Double quotes group words, either as a whole
+// term or as a field's value (title:"release notes").
+func lexQuery(query string) ([]queryToken, error) {
+	runes := []rune(query)
+	var tokens []queryToken
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, queryToken{pos: i + 1, text: "(", kind: tokenOpen})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, queryToken{pos: i + 1, text: ")", kind: tokenClose})
+			i++
+			continue
+		case r == '-' && i+1 < len(runes) && !strings.ContainsRune(" \t\n\r()", runes[i+1]):
+			tokens = append(tokens, queryToken{pos: i + 1, text: "-", kind: tokenNot})
+			i++
+			continue
+		}
+
+		start := i
+		inQuotes := false
+		for i < len(runes) {
+			r := runes[i]
+			if r == '"' {
+				inQuotes = !inQuotes
+			} else if !inQuotes && strings.ContainsRune(" \t\n\r()", r) {
+				break
+			}
+			i++
+		}
+		text := string(runes[start:i])
+		if inQuotes {
+			return nil, &QueryError{Position: start + 1, Token: text, Message: "unterminated quote"}
+		}
+
+		kind := tokenTerm
+		switch text {
+		case "AND":
+			kind = tokenAnd
+		case "OR":
+			kind = tokenOr
+		case "NOT":
+			kind = tokenNot
+		}
+		tokens = append(tokens, queryToken{pos: start + 1, text: text, kind: kind})
+	}
+	return tokens, nil
+}
+
+// queryNode is a parsed query: a term, or a boolean combination of nodes
+type queryNode struct {
+	op       string // "and", "or", "not" or "" for a term
+	children []*queryNode
+	term     *queryTerm
+}
+
+// queryTerm is one field test
+type queryTerm struct {
+	field      string
+	comparison string // =, <, <=, > or >=
+	value      string
+	number     int
+	date       time.Time // for added: a fixed date
+	relative   string    // for added: today, this-week, ... or Nd
+	days       int
+}
+
+// queryParser is a recursive descent parser over query tokens
+type queryParser struct {
+	tokens []queryToken
+	next   int
+	length int
+}
+
+// ParseCollectionQuery parses and validates a saved query
+func ParseCollectionQuery(query string) (*queryNode, error) {
+	if len([]rune(query)) > maxCollectionQueryLength {
+		return nil, &QueryError{Position: maxCollectionQueryLength + 1, Message: fmt.Sprintf("queries are at most %d characters", maxCollectionQueryLength)}
+	}
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &QueryError{Position: 1, Message: "query is empty"}
+	}
+
+	p := &queryParser{tokens: tokens, length: len([]rune(query))}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := p.peek(); ok {
+		return nil, &QueryError{Position: token.pos, Token: token.text, Message: "unexpected token"}
+	}
+	return node, nil
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.next < len(p.tokens) {
+		return p.tokens[p.next], true
+	}
+	return queryToken{}, false
+}
+
+// endError reports a query that stops where more was expected
+func (p *queryParser) endError(message string) error {
+	return &QueryError{Position: p.length + 1, Message: message}
+}
+
+func (p *queryParser) parseOr() (*queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	node := &queryNode{op: "or", children: []*queryNode{left}}
+	for {
+		token, ok := p.peek()
+		if !ok || token.kind != tokenOr {
+			break
+		}
+		p.next++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, right)
+	}
+	if len(node.children) == 1 {
+		return left, nil
+	}
+	return node, nil
+}
+
+// parseAnd reads terms joined by AND or just juxtaposed
+func (p *queryParser) parseAnd() (*queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	node := &queryNode{op: "and", children: []*queryNode{left}}
+	for {
+		token, ok := p.peek()
+		if !ok || token.kind == tokenOr || token.kind == tokenClose {
+			break
+		}
+		if token.kind == tokenAnd {
+			p.next++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, right)
+	}
+	if len(node.children) == 1 {
+		return left, nil
+	}
+	return node, nil
+}
+
+func (p *queryParser) parseUnary() (*queryNode, error) {
+	token, ok := p.peek()
+	if !ok {
+		return nil, p.endError("expected a term")
+	}
+	p.next++
+
+	switch token.kind {
+	case tokenNot:
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{op: "not", children: []*queryNode{child}}, nil
+	case tokenOpen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.peek()
+		if !ok {
+			return nil, &QueryError{Position: token.pos, Token: token.text, Message: "unclosed parenthesis"}
+		}
+		if closing.kind != tokenClose {
+			return nil, &QueryError{Position: closing.pos, Token: closing.text, Message: "expected )"}
+		}
+		p.next++
+		return node, nil
+	case tokenTerm:
+		term, err := parseQueryTerm(token)
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{term: term}, nil
+	default:
+		return nil, &QueryError{Position: token.pos, Token: token.text, Message: "expected a term"}
+	}
+}
+
+// queryFields lists the fields a term can test and whether they compare
+// with <, <=, > and >=
+var queryFields = map[string]bool{
+	"type":   false,
+	"domain": false,
+	"tag":    false,
+	"title":  false,
+	"text":   false,
+	"added":  true,
+	"visits": true,
+}
+
+// parseQueryTerm parses field:value; a bare word or quoted phrase tests the
+// title
+func parseQueryTerm(token queryToken) (*queryTerm, error) {
+	fail := func(message string) error {
+		return &QueryError{Position: token.pos, Token: token.text, Message: message}
+	}
+
+	field, value, hasField := strings.Cut(token.text, ":")
+	if !hasField || strings.HasPrefix(token.text, `"`) {
+		field, value = "title", token.text
+	}
+	field = strings.ToLower(field)
+	if field == "date" {
+		field = "added"
+	}
+	comparable, known := queryFields[field]
+	if !known {
+		return nil, fail(fmt.Sprintf("unknown field %q (expected type, domain, tag, title, text, added or visits)", field))
+	}
+
+	term := &queryTerm{field: field, comparison: "="}
+	for _, comparison := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, comparison) {
+			if !comparable && comparison != "=" {
+				return nil, fail(fmt.Sprintf("%s does not support %s", field, comparison))
+			}
+			term.comparison = comparison
+			value = value[len(comparison):]
+			break
+		}
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	} else if strings.Contains(value, `"`) {
+		return nil, fail("quotes must surround the whole value")
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, fail(fmt.Sprintf("%s needs a value", field))
+	}
+	term.value = value
+
+	switch field {
+	case "type":
+		for _, kind := range []string{QueryItemSessions, QueryItemSavedTabs, QueryItemFavorites} {
+			if strings.EqualFold(value, kind) {
+				term.value = kind
+				return term, nil
+			}
+		}
+		return nil, fail("type must be sessions, savedTabs or favorites")
+	case "domain":
+		term.value = strings.TrimPrefix(strings.ToLower(value), "www.")
+	case "tag":
+		name, err := NormalizeTagName(value)
+		if err != nil {
+			return nil, fail("tag has an empty level")
+		}
+		term.value = name
+	case "title", "text":
+		term.value = strings.ToLower(value)
+	case "visits":
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			return nil, fail("visits must be a whole number")
+		}
+		term.number = number
+	case "added":
+		switch value = strings.ToLower(value); value {
+		case "today", "yesterday", "this-week", "this-month", "this-year":
+			term.relative = value
+		default:
+			if days, ok := strings.CutSuffix(value, "d"); ok {
+				n, err := strconv.Atoi(days)
+				if err != nil || n < 1 {
+					return nil, fail("relative dates are a positive number of days, such as 30d")
+				}
+				term.relative, term.days = "days", n
+				break
+			}
+			date, err := time.Parse(disruptionDateFormat, value)
+			if err != nil {
+				return nil, fail("added must be YYYY-MM-DD, today, yesterday, this-week, this-month, this-year or Nd")
+			}
+			term.date = date
+		}
+	}
+	return term, nil
+}
+
+// usesField reports whether any term of the query tests field
+func (node *queryNode) usesField(field string) bool {
+	if node.term != nil {
+		return node.term.field == field
+	}
+	for _, child := range node.children {
+		if child.usesField(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryClock fixes "now" and the time zone relative dates resolve in
+type queryClock struct {
+	now      time.Time
+	location *time.Location
+}
+
+// matches evaluates the query against an item
+func (node *queryNode) matches(item *queryItem, clock queryClock) bool {
+	switch node.op {
+	case "and":
+		for _, child := range node.children {
+			if !child.matches(item, clock) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, child := range node.children {
+			if child.matches(item, clock) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !node.children[0].matches(item, clock)
+	default:
+		return node.term.matches(item, clock)
+	}
+}
+
+func (term *queryTerm) matches(item *queryItem, clock queryClock) bool {
+	switch term.field {
+	case "type":
+		return item.kind == term.value
+	case "domain":
+		for _, rawURL := range item.urls {
+			parsed, err := url.Parse(rawURL)
+			if err != nil {
+				continue
+			}
+			host := strings.ToLower(parsed.Hostname())
+			if host == term.value || strings.HasSuffix(host, "."+term.value) {
+				return true
+			}
+		}
+		return false
+	case "tag":
+		for _, tag := range item.tags {
+			if tagWithin(tag, term.value) {
+				return true
+			}
+		}
+		return false
+	case "title":
+		return strings.Contains(strings.ToLower(item.title), term.value)
+	case "text":
+		return strings.Contains(item.text, term.value)
+	case "visits":
+		return compareQueryValues(item.visits, term.number, term.comparison)
+	case "added":
+		if item.added.IsZero() {
+			return false
+		}
+		start, end := term.dateRange(clock)
+		switch term.comparison {
+		case ">":
+			return !item.added.Before(end)
+		case ">=":
+			return !item.added.Before(start)
+		case "<":
+			return item.added.Before(start)
+		case "<=":
+			return item.added.Before(end)
+		default:
+			return !item.added.Before(start) && item.added.Before(end)
+		}
+	}
+	return false
+}
+
+// dateRange resolves an added term to the period [start, end) it names
+func (term *queryTerm) dateRange(clock queryClock) (time.Time, time.Time) {
+	now := clock.now.In(clock.location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, clock.location)
+	switch term.relative {
+	case "today":
+		return today, today.AddDate(0, 0, 1)
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today
+	case "this-week":
+		monday := weekStart(today)
+		return monday, monday.AddDate(0, 0, 7)
+	case "this-month":
+		first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, clock.location)
+		return first, first.AddDate(0, 1, 0)
+	case "this-year":
+		first := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, clock.location)
+		return first, first.AddDate(1, 0, 0)
+	case "days":
+		// The last N days, up to now
+		return now.AddDate(0, 0, -term.days), now
+	default:
+		day := time.Date(term.date.Year(), term.date.Month(), term.date.Day(), 0, 0, 0, 0, clock.location)
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+func compareQueryValues(a, b int, comparison string) bool {
+	switch comparison {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCollectionQueryErrors(t *testing.T) {
+	tests := []struct {
+		query        string
+		wantPosition int
+		wantToken    string
+		wantMessage  string
+	}{
+		{query: "", wantPosition: 1, wantMessage: "query is empty"},
+		{query: strings.Repeat("a", 1001), wantPosition: 1001, wantMessage: "at most 1000 characters"},
+		{query: `title:"release notes`, wantPosition: 1, wantToken: `title:"release notes`, wantMessage: "unterminated quote"},
+		{query: "tag:work color:red", wantPosition: 10, wantToken: "color:red", wantMessage: `unknown field "color"`},
+		{query: "tag:", wantPosition: 1, wantToken: "tag:", wantMessage: "tag needs a value"},
+		{query: "tag:work/", wantPosition: 1, wantToken: "tag:work/", wantMessage: "empty level"},
+		{query: "type:tabs", wantPosition: 1, wantToken: "type:tabs", wantMessage: "type must be"},
+		{query: "domain:>github.com", wantPosition: 1, wantToken: "domain:>github.com", wantMessage: "domain does not support >"},
+		{query: "visits:>many", wantPosition: 1, wantToken: "visits:>many", wantMessage: "whole number"},
+		{query: "added:0d", wantPosition: 1, wantToken: "added:0d", wantMessage: "positive number of days"},
+		{query: "a added:last-week", wantPosition: 3, wantToken: "added:last-week", wantMessage: "added must be"},
+		{query: `title:a"b"`, wantPosition: 1, wantToken: `title:a"b"`, wantMessage: "quotes must surround"},
+		{query: "a (b", wantPosition: 3, wantToken: "(", wantMessage: "unclosed parenthesis"},
+		{query: "a )", wantPosition: 3, wantToken: ")", wantMessage: "unexpected token"},
+		{query: "a OR", wantPosition: 5, wantMessage: "expected a term"},
+		{query: "(a b) AND", wantPosition: 10, wantMessage: "expected a term"},
+		{query: "a AND OR b", wantPosition: 7, wantToken: "OR", wantMessage: "expected a term"},
+		{query: "NOT", wantPosition: 4, wantMessage: "expected a term"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseCollectionQuery(tt.query)
+			var queryErr *QueryError
+			if !errors.As(err, &queryErr) {
+				t.Fatalf("ParseCollectionQuery() error = %v, want a QueryError", err)
+			}
+			if !errors.Is(err, ErrInvalidQuery) {
+				t.Error("error does not wrap ErrInvalidQuery")
+			}
+			if queryErr.Position != tt.wantPosition || queryErr.Token != tt.wantToken || !strings.Contains(queryErr.Message, tt.wantMessage) {
+				t.Errorf("error = %d %q %q, want %d %q %q", queryErr.Position, queryErr.Token, queryErr.Message,
+					tt.wantPosition, tt.wantToken, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestCollectionQueryMatches(t *testing.T) {
+	items := map[string]*queryItem{
+		"session": {
+			kind:  QueryItemSessions,
+			title: "Release notes",
+			urls:  []string{"https://www.github.com/x", "https://docs.example.com"},
+			tags:  []string{"work/infra"},
+			added: time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC),
+		},
+		"savedTab": {
+			kind:   QueryItemSavedTabs,
+			title:  "Cooking",
+			urls:   []string{"https://recipes.example.org"},
+			tags:   []string{"home"},
+			added:  time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC),
+			visits: 3,
+			text:   "pasta carbonara",
+		},
+		"favorite": {
+			kind:   QueryItemFavorites,
+			title:  "GitHub",
+			urls:   []string{"https://github.com"},
+			tags:   []string{"Work"},
+			visits: 10,
+		},
+	}
+	clock := queryClock{now: time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC), location: time.UTC}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"tag:work", []string{"favorite", "session"}},
+		{"tag:work -type:favorites", []string{"session"}},
+		{"tag:work/infra", []string{"session"}},
+		{"type:SAVEDTABS", []string{"savedTab"}},
+		{"domain:github.com", []string{"favorite", "session"}},
+		{"domain:example.com", []string{"session"}},
+		{`"release notes"`, []string{"session"}},
+		{"notes release", []string{"session"}},
+		{`title:"release notes" OR text:carbonara`, []string{"savedTab", "session"}},
+		{"NOT tag:home", []string{"favorite", "session"}},
+		{"(tag:home OR tag:work/infra) AND visits:0", []string{"session"}},
+		{"tag:infra", nil},
+		{"visits:>=3", []string{"favorite", "savedTab"}},
+		{"visits:<3", []string{"session"}},
+		{"added:this-month", []string{"session"}},
+		{"added:this-week", nil},
+		{"date:this-year", []string{"savedTab", "session"}},
+		{"added:30d", []string{"session"}},
+		{"added:<2024-05-01", []string{"savedTab"}},
+		{"added:>2024-05-09", []string{"session"}},
+		{"added:2024-05-10", []string{"session"}},
+		{"-added:2024-05-10", []string{"favorite", "savedTab"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := ParseCollectionQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseCollectionQuery() error = %v", err)
+			}
+			var got []string
+			for name, item := range items {
+				if query.matches(item, clock) {
+					got = append(got, name)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
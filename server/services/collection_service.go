@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// smartCollectionsStorageKey is the generic storage key smart collections
+// are kept under, as one list
+const smartCollectionsStorageKey = "smartCollections"
+
+// maxSmartCollections bounds how many collections a user can save
+const maxSmartCollections = 200
+
+// Smart collection errors
+var (
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrInvalidCollection  = errors.New("invalid collection")
+)
+
+// SmartCollection is a named, saved query over sessions, saved tabs and
+// favorites
+type SmartCollection struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// CollectionItems are the items a smart collection's query matches, newest
+// first
+type CollectionItems struct {
+	Collection *SmartCollection `json:"collection"`
+	Sessions   []*Session       `json:"sessions"`
+	SavedTabs  []*SavedTab      `json:"savedTabs"`
+	Favorites  []*FavoriteTab   `json:"favorites"`
+	Total      int              `json:"total"`
+}
+
+// normalizeCollection validates a collection, including its query
+func normalizeCollection(collection *SmartCollection) error {
+	collection.Name = strings.TrimSpace(collection.Name)
+	if collection.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCollection)
+	}
+	collection.Query = strings.TrimSpace(collection.Query)
+	if _, err := ParseCollectionQuery(collection.Query); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListCollections returns the user's smart collections
+func (uds *UserDataService) ListCollections(ctx context.Context, userID string) ([]*SmartCollection, error) {
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+	return uds.getCollections(ctx, userID)
+}
+
+// GetCollection returns one smart collection
+func (uds *UserDataService) GetCollection(ctx context.Context, userID, collectionID string) (*SmartCollection, error) {
+	collections, err := uds.ListCollections(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if i := findCollection(collections, collectionID); i >= 0 {
+		return collections[i], nil
+	}
+	return nil, ErrCollectionNotFound
+}
+
+// CreateCollection saves a smart collection, assigning it an ID
+func (uds *UserDataService) CreateCollection(ctx context.Context, userID string, collection *SmartCollection) error {
+	if err := normalizeCollection(collection); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collections, err := uds.getCollections(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(collections) >= maxSmartCollections {
+		return fmt.Errorf("%w: at most %d collections can be saved", ErrInvalidCollection, maxSmartCollections)
+	}
+
+	now := time.Now()
+	collection.ID = fmt.Sprintf("col_%d_%s", now.UnixMilli(), strings.ToLower(uds.firebaseService.firestore.Collection(COLLECTION_NAME).NewDoc().ID[:9]))
+	collection.CreatedAt = formatTaskTime(now)
+	collection.UpdatedAt = collection.CreatedAt
+
+	collections = append(collections, collection)
+	if err := uds.putCollectionsLocked(ctx, userID, collections); err != nil {
+		return err
+	}
+
+	log.Printf("Created smart collection %s for user %s", collection.ID, userID)
+	return nil
+}
+
+// UpdateCollection replaces a smart collection's name and query
+func (uds *UserDataService) UpdateCollection(ctx context.Context, userID string, collection *SmartCollection) error {
+	if err := normalizeCollection(collection); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collections, err := uds.getCollections(ctx, userID)
+	if err != nil {
+		return err
+	}
+	i := findCollection(collections, collection.ID)
+	if i < 0 {
+		return ErrCollectionNotFound
+	}
+
+	collection.CreatedAt = collections[i].CreatedAt
+	collection.UpdatedAt = formatTaskTime(time.Now())
+	collections[i] = collection
+	return uds.putCollectionsLocked(ctx, userID, collections)
+}
+
+// DeleteCollection removes a smart collection
+func (uds *UserDataService) DeleteCollection(ctx context.Context, userID, collectionID string) error {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collections, err := uds.getCollections(ctx, userID)
+	if err != nil {
+		return err
+	}
+	i := findCollection(collections, collectionID)
+	if i < 0 {
+		return ErrCollectionNotFound
+	}
+
+	collections = append(collections[:i], collections[i+1:]...)
+	if err := uds.putCollectionsLocked(ctx, userID, collections); err != nil {
+		return err
+	}
+
+	log.Printf("Deleted smart collection %s for user %s", collectionID, userID)
+	return nil
+}
+
+// GetCollectionItems evaluates a smart collection's query. Relative dates
+// resolve in the user's time zone.
+func (uds *UserDataService) GetCollectionItems(ctx context.Context, userID, collectionID string) (*CollectionItems, error) {
+	location := uds.GetUserLocation(ctx, userID)
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	collections, err := uds.getCollections(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	i := findCollection(collections, collectionID)
+	if i < 0 {
+		return nil, ErrCollectionNotFound
+	}
+	collection := collections[i]
+
+	query, err := ParseCollectionQuery(collection.Query)
+	if err != nil {
+		// Only possible for a collection stored by another client
+		return nil, err
+	}
+	clock := queryClock{now: time.Now(), location: location}
+
+	sessions, err := uds.loadSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	savedTabs, err := uds.loadSavedTabs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	favorites, err := uds.getFavorites(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations.annotateSessions(sessions)
+	annotations.annotateSavedTabs(savedTabs)
+	annotations.annotateFavorites(favorites)
+	var texts map[int]string
+	if query.usesField("text") {
+		texts = uds.archiveTexts(ctx, userID, savedTabs)
+	}
+
+	items := &CollectionItems{
+		Collection: collection,
+		Sessions:   []*Session{},
+		SavedTabs:  []*SavedTab{},
+		Favorites:  []*FavoriteTab{},
+	}
+	for _, session := range sessions {
+		if query.matches(sessionQueryItem(session), clock) {
+			items.Sessions = append(items.Sessions, session)
+		}
+	}
+	for _, tab := range savedTabs {
+		item := savedTabQueryItem(tab)
+		item.text = strings.ToLower(texts[tab.ID])
+		if query.matches(item, clock) {
+			items.SavedTabs = append(items.SavedTabs, tab)
+		}
+	}
+	scoreFavorites(favorites, clock.now)
+	for _, favorite := range favorites {
+		if query.matches(favoriteQueryItem(favorite), clock) {
+			items.Favorites = append(items.Favorites, favorite)
+		}
+	}
+
+	sort.SliceStable(items.Sessions, func(i, j int) bool {
+		return items.Sessions[i].CreatedAt > items.Sessions[j].CreatedAt
+	})
+	sort.SliceStable(items.SavedTabs, func(i, j int) bool {
+		return items.SavedTabs[i].SavedAt > items.SavedTabs[j].SavedAt
+	})
+	sort.SliceStable(items.Favorites, func(i, j int) bool {
+		return items.Favorites[i].DateAdded > items.Favorites[j].DateAdded
+	})
+	items.Total = len(items.Sessions) + len(items.SavedTabs) + len(items.Favorites)
+	return items, nil
+}
+
+// sessionQueryItem describes a session to queries: its name, every tab's
+// URL and the sum of its tabs' visits
+func sessionQueryItem(session *Session) *queryItem {
+	item := &queryItem{kind: QueryItemSessions, title: session.Name, tags: session.Tags}
+	for _, tab := range session.Tabs {
+		item.urls = append(item.urls, tab.URL)
+		if tab.Usage != nil {
+			item.visits += tab.Usage.VisitCount
+		}
+	}
+	if createdAt, err := ParseTaskTime(session.CreatedAt); err == nil {
+		item.added = createdAt
+	}
+	return item
+}
+
+func savedTabQueryItem(tab *SavedTab) *queryItem {
+	item := &queryItem{kind: QueryItemSavedTabs, title: tab.Title, urls: []string{tab.URL}, tags: tab.Tags}
+	if tab.Usage != nil {
+		item.visits = tab.Usage.VisitCount
+	}
+	if savedAt, err := ParseTaskTime(tab.SavedAt); err == nil {
+		item.added = savedAt
+	}
+	return item
+}
+
+func favoriteQueryItem(favorite *FavoriteTab) *queryItem {
+	item := &queryItem{
+		kind:   QueryItemFavorites,
+		title:  favorite.Title,
+		urls:   []string{favorite.URL},
+		tags:   favorite.Tags,
+		visits: favorite.Usage.VisitCount,
+	}
+	if dateAdded, err := ParseTaskTime(favorite.DateAdded); err == nil {
+		item.added = dateAdded
+	}
+	return item
+}
+
+// getCollections reads the smart collections list; callers hold uds.mu
+func (uds *UserDataService) getCollections(ctx context.Context, userID string) ([]*SmartCollection, error) {
+	collections := []*SmartCollection{}
+	if _, err := uds.readStoredValue(ctx, userID, smartCollectionsStorageKey, &collections); err != nil {
+		return nil, err
+	}
+
+	kept := collections[:0]
+	for _, collection := range collections {
+		if collection != nil {
+			kept = append(kept, collection)
+		}
+	}
+	return kept, nil
+}
+
+// putCollectionsLocked stores the smart collections list; callers hold
+// uds.mu
+func (uds *UserDataService) putCollectionsLocked(ctx context.Context, userID string, collections []*SmartCollection) error {
+	batch := &changeBatch{}
+	change, err := uds.stageStoredValue(ctx, batch, userID, smartCollectionsStorageKey, collections)
+	if err != nil {
+		return err
+	}
+	if err := uds.commitChanges(ctx, batch, userID, change); err != nil {
+		return fmt.Errorf("failed to store smart collections: %w", err)
+	}
+	return nil
+}
+
+// findCollection returns the index of the collection with id, or -1
+func findCollection(collections []*SmartCollection, id string) int {
+	for i, collection := range collections {
+		if id != "" && collection.ID == id {
+			return i
+		}
+	}
+	return -1
+}
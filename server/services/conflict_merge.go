@@ -0,0 +1,367 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mergeStrategy describes how concurrent edits to a collection combine
+type mergeStrategy struct {
+	// timestamp is the record field compared for newest-wins
+	timestamp string
+	// union lists array fields merged as sets, mapped to the element field
+	// that identifies a member ("" compares whole elements)
+	union map[string]string
+	// max lists fields that keep the larger value
+	max map[string]bool
+}
+
+// defaultMergeStrategy applies to collections without their own rules:
+// records merge field by field and newer records win
+var defaultMergeStrategy = mergeStrategy{timestamp: "updatedAt"}
+
+// mergeStrategies are the per-collection merge rules. Fields are relative to
+// the record, with nested fields joined by dots (usage.visitCount).
+var mergeStrategies = map[string]mergeStrategy{
+	"sessions": {
+		timestamp: "lastModified",
+		union:     map[string]string{"tags": "", "tabs": "url"},
+	},
+	"saved-tabs": {
+		timestamp: "savedAt",
+		union:     map[string]string{"tags": ""},
+	},
+	"tasks": {
+		timestamp: "updatedAt",
+		union:     map[string]string{"tags": "", "focusSessions": "id"},
+		max:       map[string]bool{"totalFocusTime": true, "averageFocusTime": true, "totalSessions": true},
+	},
+	"task-focus-data": {
+		union: map[string]string{"sessions": "id"},
+		max:   map[string]bool{"totalFocusTime": true, "averageFocusTime": true, "totalSessions": true},
+	},
+	"favorites": {
+		timestamp: "dateAdded",
+		union:     map[string]string{"tags": ""},
+		max:       map[string]bool{"usage.visitCount": true, "usage.lastAccess": true},
+	},
+}
+
+// merger merges one document and collects the fields it could not decide
+type merger struct {
+	strategy   mergeStrategy
+	unresolved []string
+}
+
+// mergeDocuments merges the server and client versions of a document that
+// both changed since base, the version the client edited. Values are
+// JSON-shaped. Comparing each side with base tells edits from untouched
+// values, so fields and records the client removed stay removed unless the
+// server changed them meanwhile. Undecidable fields keep the server value and
+// are returned as paths.
+func mergeDocuments(collection string, base, server, client interface{}) (interface{}, []string) {
+	strategy, exists := mergeStrategies[collection]
+	if !exists {
+		strategy = defaultMergeStrategy
+	}
+	m := &merger{strategy: strategy}
+
+	var merged interface{}
+	s, serverIsMap := server.(map[string]interface{})
+	c, clientIsMap := client.(map[string]interface{})
+	switch {
+	case serverIsMap && clientIsMap:
+		b, _ := base.(map[string]interface{})
+		merged = m.mergeRecord("", b, s, c)
+	case isRecordList(server) && isRecordList(client):
+		b, _ := base.([]interface{})
+		merged = m.mergeRecordList("", b, server.([]interface{}), client.([]interface{}))
+	default:
+		merged = m.mergeValue("", "", base, server, client, 0)
+	}
+
+	// Counts derived from merged tabs must follow them
+	if record, ok := merged.(map[string]interface{}); ok && collection == "sessions" {
+		if tabs, ok := record["tabs"].([]interface{}); ok {
+			if _, counted := record["tab_count"]; counted {
+				record["tab_count"] = float64(len(tabs))
+				m.unresolved = slices.DeleteFunc(m.unresolved, func(path string) bool { return path == "tab_count" })
+			}
+		}
+	}
+
+	return merged, m.unresolved
+}
+
+// mergeRecord merges two versions of one record; base is nil when the
+// record is new on both sides
+func (m *merger) mergeRecord(path string, base, server, client map[string]interface{}) map[string]interface{} {
+	newer := 0
+	if m.strategy.timestamp != "" {
+		newer = compareValues(client[m.strategy.timestamp], server[m.strategy.timestamp])
+	}
+	return m.mergeFields(path, "", base, server, client, newer)
+}
+
+// mergeFields merges the fields of a record or of a map nested in one. A
+// field missing from the result was removed by the side that changed it.
+func (m *merger) mergeFields(path, prefix string, base, server, client map[string]interface{}, newer int) map[string]interface{} {
+	keys := make([]string, 0, len(server)+len(client))
+	seen := make(map[string]bool, len(server)+len(client))
+	for _, fields := range []map[string]interface{}{base, server, client} {
+		for key := range fields {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	merged := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		field := key
+		if prefix != "" {
+			field = prefix + "." + key
+		}
+		value := m.mergeValue(joinPath(path, key), field, base[key], server[key], client[key], newer)
+		_, inServer := server[key]
+		_, inClient := client[key]
+		if value != nil || (inServer && inClient) {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// mergeValue merges one field, nil standing for a missing value. newer is
+// positive when the client's record is newer, negative when the server's
+// is, and zero when unknown.
+func (m *merger) mergeValue(path, field string, base, server, client interface{}, newer int) interface{} {
+	switch {
+	case reflect.DeepEqual(server, client):
+		return server
+	case reflect.DeepEqual(base, server):
+		// Only the client changed it, possibly by removing it
+		return client
+	case reflect.DeepEqual(base, client):
+		return server
+	case server == nil:
+		// Removed on the server but edited on the client: edits win
+		return client
+	case client == nil:
+		return server
+	}
+
+	if key, isUnion := m.strategy.union[field]; isUnion {
+		s, serverIsList := server.([]interface{})
+		c, clientIsList := client.([]interface{})
+		if serverIsList && clientIsList {
+			b, _ := base.([]interface{})
+			return unionLists(b, s, c, key)
+		}
+	}
+	if m.strategy.max[field] || (field == m.strategy.timestamp && field != "") {
+		if compareValues(client, server) > 0 {
+			return client
+		}
+		if compareValues(server, client) > 0 {
+			return server
+		}
+	}
+
+	switch s := server.(type) {
+	case map[string]interface{}:
+		if c, ok := client.(map[string]interface{}); ok {
+			b, _ := base.(map[string]interface{})
+			return m.mergeFields(path, field, b, s, c, newer)
+		}
+	case []interface{}:
+		if isRecordList(server) && isRecordList(client) {
+			b, _ := base.([]interface{})
+			if !isRecordList(b) {
+				b = nil
+			}
+			return m.mergeRecordList(path, b, s, client.([]interface{}))
+		}
+	}
+
+	switch {
+	case newer > 0:
+		return client
+	case newer < 0:
+		return server
+	}
+	m.unresolved = append(m.unresolved, path)
+	return server
+}
+
+// mergeRecordList merges lists of records matched by id. Records added on
+// either side are kept, so offline additions from both devices survive.
+// Records one side deleted are dropped unless the other side edited them,
+// in which case the edit is kept and the record reported as unresolved.
+func (m *merger) mergeRecordList(path string, base, server, client []interface{}) []interface{} {
+	baseByID := recordsByID(base)
+	clientByID := recordsByID(client)
+	serverByID := recordsByID(server)
+
+	merged := make([]interface{}, 0, len(server)+len(client))
+	for _, element := range server {
+		record := element.(map[string]interface{})
+		id := recordID(record)
+		recordPath := fmt.Sprintf("%s[id=%s]", path, id)
+		baseRecord, inBase := baseByID[id]
+		if clientRecord, exists := clientByID[id]; exists {
+			merged = append(merged, m.mergeRecord(recordPath, baseRecord, record, clientRecord))
+			continue
+		}
+		if inBase {
+			// Deleted on the client
+			if reflect.DeepEqual(baseRecord, record) {
+				continue
+			}
+			m.unresolved = append(m.unresolved, recordPath)
+		}
+		merged = append(merged, record)
+	}
+	for _, element := range client {
+		record := element.(map[string]interface{})
+		id := recordID(record)
+		if _, exists := serverByID[id]; exists {
+			continue
+		}
+		if baseRecord, inBase := baseByID[id]; inBase {
+			// Deleted on the server
+			if reflect.DeepEqual(baseRecord, record) {
+				continue
+			}
+			m.unresolved = append(m.unresolved, fmt.Sprintf("%s[id=%s]", path, id))
+		}
+		merged = append(merged, record)
+	}
+	return merged
+}
+
+// recordsByID indexes a record list by id
+func recordsByID(list []interface{}) map[string]map[string]interface{} {
+	records := make(map[string]map[string]interface{}, len(list))
+	for _, element := range list {
+		if record, ok := element.(map[string]interface{}); ok {
+			records[recordID(record)] = record
+		}
+	}
+	return records
+}
+
+// recordID formats a record's id; numeric ids print without exponents
+func recordID(record map[string]interface{}) string {
+	if id, ok := record["id"].(float64); ok {
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	}
+	return fmt.Sprint(record["id"])
+}
+
+// unionLists returns the server list without the members the client removed
+// since base, followed by the client's additions
+func unionLists(base, server, client []interface{}, key string) []interface{} {
+	identity := func(element interface{}) string {
+		if record, ok := element.(map[string]interface{}); ok && key != "" {
+			return fmt.Sprint(record[key])
+		}
+		data, _ := json.Marshal(element)
+		return string(data)
+	}
+	members := func(list []interface{}) map[string]bool {
+		set := make(map[string]bool, len(list))
+		for _, element := range list {
+			set[identity(element)] = true
+		}
+		return set
+	}
+	inBase, inClient := members(base), members(client)
+
+	merged := make([]interface{}, 0, len(server)+len(client))
+	seen := make(map[string]bool, len(server))
+	for _, element := range server {
+		id := identity(element)
+		if inBase[id] && !inClient[id] {
+			continue
+		}
+		seen[id] = true
+		merged = append(merged, element)
+	}
+	for _, element := range client {
+		if id := identity(element); !seen[id] && !inBase[id] {
+			seen[id] = true
+			merged = append(merged, element)
+		}
+	}
+	return merged
+}
+
+// isRecordList reports whether value is a list of objects with ids
+func isRecordList(value interface{}) bool {
+	list, ok := value.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, element := range list {
+		record, ok := element.(map[string]interface{})
+		if !ok || record["id"] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues orders numbers numerically and strings as timestamps when
+// both parse, lexically otherwise. Mismatched or other types compare equal.
+func compareValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av > bv:
+				return 1
+			case av < bv:
+				return -1
+			}
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			at, aok := parseTimestamp(av)
+			bt, bok := parseTimestamp(bv)
+			if aok && bok {
+				return at.Compare(bt)
+			}
+			return strings.Compare(av, bv)
+		}
+	}
+	return 0
+}
+
+// joinPath appends a field to a JSON path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// toJSONValue converts a value to its generic JSON shape
+func toJSONValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
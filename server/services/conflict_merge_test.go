@@ -0,0 +1,197 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// jsonValue parses a JSON literal for merge tests
+func jsonValue(t *testing.T, literal string) interface{} {
+	t.Helper()
+	if literal == "" {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(literal), &value); err != nil {
+		t.Fatalf("invalid JSON %s: %v", literal, err)
+	}
+	return value
+}
+
+func TestMergeDocuments(t *testing.T) {
+	tests := []struct {
+		name       string
+		collection string
+		base       string
+		server     string
+		client     string
+		want       string
+		unresolved []string
+	}{
+		{
+			name:       "disjoint field edits",
+			collection: "settings",
+			base:       `{"theme":"light","zoom":1}`,
+			server:     `{"theme":"dark","zoom":1}`,
+			client:     `{"theme":"light","zoom":2}`,
+			want:       `{"theme":"dark","zoom":2}`,
+		},
+		{
+			name:       "field removed on the client",
+			collection: "settings",
+			base:       `{"theme":"light","zoom":1}`,
+			server:     `{"theme":"dark","zoom":1}`,
+			client:     `{"theme":"light"}`,
+			want:       `{"theme":"dark"}`,
+		},
+		{
+			name:       "field removed on the client but edited on the server",
+			collection: "settings",
+			base:       `{"theme":"light","zoom":1}`,
+			server:     `{"theme":"light","zoom":3}`,
+			client:     `{"theme":"light"}`,
+			want:       `{"theme":"light","zoom":3}`,
+		},
+		{
+			name:       "field added on the client",
+			collection: "settings",
+			base:       `{"theme":"light"}`,
+			server:     `{"theme":"dark"}`,
+			client:     `{"theme":"light","zoom":2}`,
+			want:       `{"theme":"dark","zoom":2}`,
+		},
+		{
+			name:       "both edit a field without timestamps",
+			collection: "settings",
+			base:       `{"theme":"light"}`,
+			server:     `{"theme":"dark"}`,
+			client:     `{"theme":"blue"}`,
+			want:       `{"theme":"dark"}`,
+			unresolved: []string{"theme"},
+		},
+		{
+			name:       "newer client wins a field both edited",
+			collection: "tasks",
+			base:       `{"title":"a","updatedAt":"2024-01-01T00:00:00Z"}`,
+			server:     `{"title":"b","updatedAt":"2024-01-02T00:00:00Z"}`,
+			client:     `{"title":"c","updatedAt":"2024-01-03T00:00:00Z"}`,
+			want:       `{"title":"c","updatedAt":"2024-01-03T00:00:00Z"}`,
+		},
+		{
+			name:       "tags unioned with removals kept",
+			collection: "saved-tabs",
+			base:       `{"tags":["a","b"]}`,
+			server:     `{"tags":["a","b","c"]}`,
+			client:     `{"tags":["b","d"]}`,
+			want:       `{"tags":["b","c","d"]}`,
+		},
+		{
+			name:       "tag removed on the server stays removed",
+			collection: "saved-tabs",
+			base:       `{"tags":["a","b"]}`,
+			server:     `{"tags":["b"]}`,
+			client:     `{"tags":["a","b","c"]}`,
+			want:       `{"tags":["b","c"]}`,
+		},
+		{
+			name:       "tabs unioned by url and counted",
+			collection: "sessions",
+			base:       `{"tab_count":2,"tabs":[{"url":"a"},{"url":"b"}]}`,
+			server:     `{"tab_count":3,"tabs":[{"url":"a"},{"url":"b"},{"url":"c"}]}`,
+			client:     `{"tab_count":1,"tabs":[{"url":"b"}]}`,
+			want:       `{"tab_count":2,"tabs":[{"url":"b"},{"url":"c"}]}`,
+		},
+		{
+			name:       "focus totals keep the larger value",
+			collection: "tasks",
+			base:       `{"totalFocusTime":10}`,
+			server:     `{"totalFocusTime":25}`,
+			client:     `{"totalFocusTime":15}`,
+			want:       `{"totalFocusTime":25}`,
+		},
+		{
+			name:       "nested maps merge by field",
+			collection: "favorites",
+			base:       `{"usage":{"visitCount":1,"pinned":false}}`,
+			server:     `{"usage":{"visitCount":4,"pinned":false}}`,
+			client:     `{"usage":{"visitCount":2,"pinned":true}}`,
+			want:       `{"usage":{"visitCount":4,"pinned":true}}`,
+		},
+		{
+			name:       "record deleted on the client",
+			collection: "bookmarks",
+			base:       `[{"id":1,"t":"a"},{"id":2,"t":"b"}]`,
+			server:     `[{"id":1,"t":"a"},{"id":2,"t":"b"},{"id":3,"t":"c"}]`,
+			client:     `[{"id":1,"t":"a"}]`,
+			want:       `[{"id":1,"t":"a"},{"id":3,"t":"c"}]`,
+		},
+		{
+			name:       "record deleted on the client but edited on the server",
+			collection: "bookmarks",
+			base:       `[{"id":1,"t":"a"},{"id":2,"t":"b"}]`,
+			server:     `[{"id":1,"t":"a"},{"id":2,"t":"B"}]`,
+			client:     `[{"id":1,"t":"a"}]`,
+			want:       `[{"id":1,"t":"a"},{"id":2,"t":"B"}]`,
+			unresolved: []string{"[id=2]"},
+		},
+		{
+			name:       "record deleted on the server",
+			collection: "bookmarks",
+			base:       `[{"id":1,"t":"a"},{"id":2,"t":"b"}]`,
+			server:     `[{"id":2,"t":"b"}]`,
+			client:     `[{"id":1,"t":"a"},{"id":2,"t":"b"},{"id":4,"t":"d"}]`,
+			want:       `[{"id":2,"t":"b"},{"id":4,"t":"d"}]`,
+		},
+		{
+			name:       "nested record list merged by id",
+			collection: "tasks",
+			base:       `{"focusSessions":[{"id":"s1","minutes":5}]}`,
+			server:     `{"focusSessions":[{"id":"s1","minutes":5},{"id":"s2","minutes":9}]}`,
+			client:     `{"focusSessions":[{"id":"s3","minutes":1}]}`,
+			want:       `{"focusSessions":[{"id":"s2","minutes":9},{"id":"s3","minutes":1}]}`,
+		},
+		{
+			name:       "no base falls back to keeping both sides",
+			collection: "bookmarks",
+			server:     `[{"id":1},{"id":2}]`,
+			client:     `[{"id":1},{"id":3}]`,
+			want:       `[{"id":1},{"id":2},{"id":3}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unresolved := mergeDocuments(tt.collection, jsonValue(t, tt.base), jsonValue(t, tt.server), jsonValue(t, tt.client))
+			if want := jsonValue(t, tt.want); !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.Marshal(got)
+				t.Errorf("mergeDocuments() = %s, want %s", gotJSON, tt.want)
+			}
+			if !slices.Equal(unresolved, tt.unresolved) {
+				t.Errorf("mergeDocuments() unresolved = %v, want %v", unresolved, tt.unresolved)
+			}
+		})
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+		want int
+	}{
+		{"numbers", 2.0, 1.0, 1},
+		{"timestamps across zones", "2024-01-01T10:00:00+02:00", "2024-01-01T09:00:00Z", -1},
+		{"plain strings", "b", "a", 1},
+		{"mismatched types", "1", 1.0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareValues(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareValues(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
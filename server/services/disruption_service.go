@@ -0,0 +1,656 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// disruptionsStorageKey addresses the per-disruption documents as a list
+	// in generic storage, as it did when they were a single value
+	disruptionsStorageKey = "disruptions"
+	// legacyDisruptionsDocID is the document that held that value
+	legacyDisruptionsDocID = "disruptions"
+	// disruptionIDsStorageKey was a client-maintained index of disruption
+	// IDs; it is now derived from the documents
+	disruptionIDsStorageKey = "disruption_ids"
+	// disruptionDateFormat is the format of a disruption's date
+	disruptionDateFormat = "2006-01-02"
+)
+
+var disruptionCategories = map[string]bool{
+	"meeting": true, "email": true, "slack": true, "bug-fix": true,
+	"support": true, "break": true, "research": true, "other": true,
+}
+
+// Disruption errors
+var (
+	ErrDisruptionNotFound = errors.New("disruption not found")
+	ErrInvalidDisruption  = errors.New("invalid disruption")
+)
+
+// Disruption is an interruption to focused work
+type Disruption struct {
+	ID        string `json:"id" firestore:"id"`
+	Title     string `json:"title" firestore:"title"`
+	Category  string `json:"category" firestore:"category"`
+	StartTime string `json:"startTime" firestore:"startTime"`
+	EndTime   string `json:"endTime,omitempty" firestore:"endTime,omitempty"`
+	// Duration is in whole minutes, derived from the start and end times
+	// unless the disruption was entered manually without an end
+	Duration      float64 `json:"duration,omitempty" firestore:"duration,omitempty"`
+	Description   string  `json:"description,omitempty" firestore:"description,omitempty"`
+	IsManualEntry bool    `json:"isManualEntry,omitempty" firestore:"isManualEntry,omitempty"`
+	TaskID        string  `json:"taskId,omitempty" firestore:"taskId,omitempty"`
+	// Date is the day the disruption started (YYYY-MM-DD) in the user's
+	// time zone
+	Date      string `json:"date" firestore:"date"`
+	UpdatedAt string `json:"updatedAt,omitempty" firestore:"updatedAt,omitempty"`
+}
+
+// DisruptionFilter selects disruptions. Empty fields match everything.
+type DisruptionFilter struct {
+	// From and To bound the date (YYYY-MM-DD, inclusive)
+	From       string
+	To         string
+	Categories []string
+	TaskID     string
+}
+
+// DisruptionSummary totals disruptions; times are in minutes
+type DisruptionSummary struct {
+	TotalDisruptions         int                `json:"totalDisruptions"`
+	TotalDisruptionTime      float64            `json:"totalDisruptionTime"`
+	AverageDisruptionLength  float64            `json:"averageDisruptionLength"`
+	MostCommonCategory       string             `json:"mostCommonCategory"`
+	DisruptionsByCategory    map[string]int     `json:"disruptionsByCategory"`
+	DisruptionTimeByCategory map[string]float64 `json:"disruptionTimeByCategory"`
+}
+
+// getDisruptionsCollectionPath returns the path for the disruptions
+// collection
+func getDisruptionsCollectionPath(userID string) string {
+	return getCollectionPath(userID, disruptionsStorageKey)
+}
+
+// normalizeDisruption validates a disruption, fills defaults and derives its
+// duration and date; location sets the day a disruption falls on
+func normalizeDisruption(disruption *Disruption, location *time.Location) error {
+	disruption.Title = strings.TrimSpace(disruption.Title)
+	if disruption.Title == "" {
+		return fmt.Errorf("%w: title is required", ErrInvalidDisruption)
+	}
+	if disruption.Category == "" {
+		disruption.Category = "other"
+	}
+	if !disruptionCategories[disruption.Category] {
+		return fmt.Errorf("%w: category must be meeting, email, slack, bug-fix, support, break, research or other", ErrInvalidDisruption)
+	}
+	if disruption.Duration < 0 {
+		return fmt.Errorf("%w: duration cannot be negative", ErrInvalidDisruption)
+	}
+
+	if disruption.StartTime == "" {
+		return fmt.Errorf("%w: startTime is required", ErrInvalidDisruption)
+	}
+	start, err := ParseTaskTime(disruption.StartTime)
+	if err != nil {
+		return fmt.Errorf("%w: invalid startTime %q", ErrInvalidDisruption, disruption.StartTime)
+	}
+	disruption.StartTime = formatTaskTime(start)
+
+	switch {
+	case disruption.EndTime != "":
+		end, err := ParseTaskTime(disruption.EndTime)
+		if err != nil {
+			return fmt.Errorf("%w: invalid endTime %q", ErrInvalidDisruption, disruption.EndTime)
+		}
+		if end.Before(start) {
+			return fmt.Errorf("%w: endTime is before startTime", ErrInvalidDisruption)
+		}
+		disruption.EndTime = formatTaskTime(end)
+		disruption.Duration = math.Floor(end.Sub(start).Minutes())
+	case disruption.IsManualEntry && disruption.Duration > 0:
+		// Manual entries may give a start and a duration
+		disruption.Duration = math.Floor(disruption.Duration)
+		disruption.EndTime = formatTaskTime(start.Add(time.Duration(disruption.Duration) * time.Minute))
+	default:
+		// Still in progress
+		disruption.Duration = 0
+	}
+
+	if disruption.Date == "" {
+		disruption.Date = start.In(location).Format(disruptionDateFormat)
+	} else if _, err := time.Parse(disruptionDateFormat, disruption.Date); err != nil {
+		return fmt.Errorf("%w: date must be YYYY-MM-DD", ErrInvalidDisruption)
+	}
+	return nil
+}
+
+// PatchDisruption applies a partial update in JSON to a disruption. When the
+// patch moves the start, the date is derived again and the end moves with
+// it, keeping the duration, unless the patch sets them too.
+func PatchDisruption(disruption *Disruption, patch []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return err
+	}
+	previousStart := disruption.StartTime
+	if err := json.Unmarshal(patch, disruption); err != nil {
+		return err
+	}
+	if _, ok := fields["startTime"]; !ok {
+		return nil
+	}
+
+	from, err := ParseTaskTime(previousStart)
+	if err != nil {
+		return nil
+	}
+	to, err := ParseTaskTime(disruption.StartTime)
+	if err != nil || to.Equal(from) {
+		return nil // normalizeDisruption rejects a bad start
+	}
+	if _, ok := fields["date"]; !ok {
+		disruption.Date = ""
+	}
+	if _, ok := fields["endTime"]; !ok && disruption.EndTime != "" {
+		if end, err := ParseTaskTime(disruption.EndTime); err == nil {
+			disruption.EndTime = formatTaskTime(end.Add(to.Sub(from)))
+		}
+	}
+	return nil
+}
+
+// matches reports whether a disruption passes the filter
+func (f DisruptionFilter) matches(disruption *Disruption) bool {
+	if (f.From != "" && disruption.Date < f.From) || (f.To != "" && disruption.Date > f.To) {
+		return false
+	}
+	if f.TaskID != "" && disruption.TaskID != f.TaskID {
+		return false
+	}
+	if len(f.Categories) > 0 {
+		for _, category := range f.Categories {
+			if category == disruption.Category {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ListDisruptions returns the user's disruptions matching filter, newest
+// first
+func (uds *UserDataService) ListDisruptions(ctx context.Context, userID string, filter DisruptionFilter) ([]*Disruption, error) {
+	if err := uds.migrateLegacyDisruptions(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	query := uds.firebaseService.firestore.Collection(getDisruptionsCollectionPath(userID)).Query
+	if filter.From != "" {
+		query = query.Where("date", ">=", filter.From)
+	}
+	if filter.To != "" {
+		query = query.Where("date", "<=", filter.To)
+	}
+	all, err := uds.loadDisruptions(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	disruptions := []*Disruption{}
+	for _, disruption := range all {
+		if filter.matches(disruption) {
+			disruptions = append(disruptions, disruption)
+		}
+	}
+	sort.SliceStable(disruptions, func(i, j int) bool {
+		return disruptions[i].StartTime > disruptions[j].StartTime
+	})
+
+	log.Printf("Retrieved %d disruptions for user %s", len(disruptions), userID)
+	return disruptions, nil
+}
+
+// GetDisruption returns one disruption
+func (uds *UserDataService) GetDisruption(ctx context.Context, userID, disruptionID string) (*Disruption, error) {
+	if err := uds.migrateLegacyDisruptions(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	return uds.getDisruption(ctx, userID, disruptionID)
+}
+
+// CreateDisruption stores a new disruption, assigning its ID
+func (uds *UserDataService) CreateDisruption(ctx context.Context, userID string, disruption *Disruption) error {
+	if err := uds.migrateLegacyDisruptions(ctx, userID); err != nil {
+		return err
+	}
+
+	disruption.UpdatedAt = formatTaskTime(time.Now())
+	if err := normalizeDisruption(disruption, uds.GetUserLocation(ctx, userID)); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collection := uds.firebaseService.firestore.Collection(getDisruptionsCollectionPath(userID))
+	var docRef *firestore.DocumentRef
+	if disruption.ID != "" && disruption.ID != legacyDisruptionsDocID {
+		docRef = collection.Doc(disruption.ID)
+	} else {
+		docRef = collection.NewDoc()
+		disruption.ID = docRef.ID
+	}
+
+	batch := &changeBatch{}
+	batch.Create(docRef, disruption)
+	if err := uds.commitChanges(ctx, batch, userID, documentChange{"disruptions", disruption.ID, ChangeOperationUpsert}); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return fmt.Errorf("%w: disruption %s already exists", ErrInvalidDisruption, disruption.ID)
+		}
+		return fmt.Errorf("failed to create disruption: %w", err)
+	}
+
+	log.Printf("Created disruption %s for user %s", disruption.ID, userID)
+	return nil
+}
+
+// UpdateDisruption replaces an existing disruption
+func (uds *UserDataService) UpdateDisruption(ctx context.Context, userID string, disruption *Disruption) error {
+	if err := uds.migrateLegacyDisruptions(ctx, userID); err != nil {
+		return err
+	}
+
+	disruption.UpdatedAt = formatTaskTime(time.Now())
+	if err := normalizeDisruption(disruption, uds.GetUserLocation(ctx, userID)); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	if _, err := uds.getDisruption(ctx, userID, disruption.ID); err != nil {
+		return err
+	}
+	return uds.putDisruptionsLocked(ctx, userID, []*Disruption{disruption})
+}
+
+// DeleteDisruption removes a disruption
+func (uds *UserDataService) DeleteDisruption(ctx context.Context, userID, disruptionID string) error {
+	if err := uds.migrateLegacyDisruptions(ctx, userID); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	if _, err := uds.getDisruption(ctx, userID, disruptionID); err != nil {
+		return err
+	}
+	if err := uds.deleteDisruptionsLocked(ctx, userID, []string{disruptionID}); err != nil {
+		return err
+	}
+
+	log.Printf("Deleted disruption %s for user %s", disruptionID, userID)
+	return nil
+}
+
+// upsertDisruption stores a disruption as received from sync
+func (uds *UserDataService) upsertDisruption(ctx context.Context, userID string, disruption *Disruption) error {
+	if err := uds.migrateLegacyDisruptions(ctx, userID); err != nil {
+		return err
+	}
+	if disruption.ID == "" || disruption.ID == legacyDisruptionsDocID {
+		return uds.CreateDisruption(ctx, userID, disruption)
+	}
+
+	if disruption.UpdatedAt == "" {
+		disruption.UpdatedAt = formatTaskTime(time.Now())
+	}
+	if err := normalizeDisruption(disruption, uds.GetUserLocation(ctx, userID)); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	return uds.putDisruptionsLocked(ctx, userID, []*Disruption{disruption})
+}
+
+// GetDisruptionSummary totals the disruptions matching filter
+func (uds *UserDataService) GetDisruptionSummary(ctx context.Context, userID string, filter DisruptionFilter) (*DisruptionSummary, error) {
+	disruptions, err := uds.ListDisruptions(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeDisruptions(disruptions), nil
+}
+
+// summarizeDisruptions totals disruptions by category. Ties for the most
+// common category go to the one with more time, then by name.
+func summarizeDisruptions(disruptions []*Disruption) *DisruptionSummary {
+	summary := &DisruptionSummary{
+		TotalDisruptions:         len(disruptions),
+		DisruptionsByCategory:    make(map[string]int),
+		DisruptionTimeByCategory: make(map[string]float64),
+	}
+
+	for _, disruption := range disruptions {
+		summary.TotalDisruptionTime += disruption.Duration
+		summary.DisruptionsByCategory[disruption.Category]++
+		summary.DisruptionTimeByCategory[disruption.Category] += disruption.Duration
+	}
+	if summary.TotalDisruptions > 0 {
+		summary.AverageDisruptionLength = summary.TotalDisruptionTime / float64(summary.TotalDisruptions)
+	}
+
+	for category, count := range summary.DisruptionsByCategory {
+		best := summary.MostCommonCategory
+		bestCount := summary.DisruptionsByCategory[best]
+		switch {
+		case best == "", count > bestCount:
+		case count < bestCount:
+			continue
+		case summary.DisruptionTimeByCategory[category] > summary.DisruptionTimeByCategory[best]:
+		case summary.DisruptionTimeByCategory[category] < summary.DisruptionTimeByCategory[best]:
+			continue
+		case category > best:
+			continue
+		}
+		summary.MostCommonCategory = category
+	}
+	return summary
+}
+
+// getDisruption reads one disruption; callers hold uds.mu
+func (uds *UserDataService) getDisruption(ctx context.Context, userID, disruptionID string) (*Disruption, error) {
+	if disruptionID == "" || disruptionID == legacyDisruptionsDocID {
+		return nil, ErrDisruptionNotFound
+	}
+
+	doc, err := uds.firebaseService.firestore.Collection(getDisruptionsCollectionPath(userID)).Doc(disruptionID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrDisruptionNotFound
+		}
+		return nil, fmt.Errorf("failed to get disruption: %w", err)
+	}
+
+	var disruption Disruption
+	if err := doc.DataTo(&disruption); err != nil {
+		return nil, fmt.Errorf("failed to parse disruption: %w", err)
+	}
+	return &disruption, nil
+}
+
+// loadDisruptions reads the disruptions a query returns; callers hold uds.mu
+func (uds *UserDataService) loadDisruptions(ctx context.Context, query firestore.Query) ([]*Disruption, error) {
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	var disruptions []*Disruption
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate disruptions: %w", err)
+		}
+		if doc.Ref.ID == legacyDisruptionsDocID {
+			continue
+		}
+
+		var disruption Disruption
+		if err := doc.DataTo(&disruption); err != nil {
+			log.Printf("Failed to parse disruption %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		disruptions = append(disruptions, &disruption)
+	}
+	return disruptions, nil
+}
+
+// putDisruptionsLocked writes disruptions in batches with their sync log
+// entries; callers hold uds.mu
+func (uds *UserDataService) putDisruptionsLocked(ctx context.Context, userID string, disruptions []*Disruption) error {
+	collection := uds.firebaseService.firestore.Collection(getDisruptionsCollectionPath(userID))
+
+	const chunkSize = changeWriteLimit / 2
+	for start := 0; start < len(disruptions); start += chunkSize {
+		batch := &changeBatch{}
+		var changes []documentChange
+		for _, disruption := range disruptions[start:min(start+chunkSize, len(disruptions))] {
+			batch.Set(collection.Doc(disruption.ID), disruption)
+			changes = append(changes, documentChange{"disruptions", disruption.ID, ChangeOperationUpsert})
+		}
+		if err := uds.commitChanges(ctx, batch, userID, changes...); err != nil {
+			return fmt.Errorf("failed to store disruptions: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteDisruptionsLocked deletes disruptions in batches; callers hold uds.mu
+func (uds *UserDataService) deleteDisruptionsLocked(ctx context.Context, userID string, disruptionIDs []string) error {
+	collection := uds.firebaseService.firestore.Collection(getDisruptionsCollectionPath(userID))
+
+	const chunkSize = changeWriteLimit / 2
+	for start := 0; start < len(disruptionIDs); start += chunkSize {
+		batch := &changeBatch{}
+		var changes []documentChange
+		for _, disruptionID := range disruptionIDs[start:min(start+chunkSize, len(disruptionIDs))] {
+			batch.Delete(collection.Doc(disruptionID))
+			changes = append(changes, documentChange{"disruptions", disruptionID, ChangeOperationDelete})
+		}
+		if err := uds.commitChanges(ctx, batch, userID, changes...); err != nil {
+			return fmt.Errorf("failed to delete disruptions: %w", err)
+		}
+	}
+	return nil
+}
+
+// decodeDisruptionList converts a stored or submitted disruptions value to
+// disruptions
+func decodeDisruptionList(value interface{}) ([]*Disruption, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var disruptions []*Disruption
+	if err := json.Unmarshal(data, &disruptions); err != nil {
+		return nil, fmt.Errorf("%w: disruptions must be a list of disruptions: %v", ErrInvalidDisruption, err)
+	}
+	return disruptions, nil
+}
+
+// getDisruptionsValue returns all disruptions as a generic list, for the
+// "disruptions" storage key
+func (uds *UserDataService) getDisruptionsValue(ctx context.Context, userID string) (interface{}, error) {
+	disruptions, err := uds.ListDisruptions(ctx, userID, DisruptionFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return toJSONValue(disruptions)
+}
+
+// getDisruptionIDsValue derives the "disruption_ids" storage key from the
+// stored disruptions
+func (uds *UserDataService) getDisruptionIDsValue(ctx context.Context, userID string) (interface{}, error) {
+	disruptions, err := uds.ListDisruptions(ctx, userID, DisruptionFilter{})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, len(disruptions))
+	for i, disruption := range disruptions {
+		ids[i] = disruption.ID
+	}
+	return ids, nil
+}
+
+// replaceDisruptions stores a whole list submitted under the "disruptions"
+// storage key, writing only changed disruptions and deleting missing ones
+func (uds *UserDataService) replaceDisruptions(ctx context.Context, userID string, value interface{}) error {
+	if err := uds.migrateLegacyDisruptions(ctx, userID); err != nil {
+		return err
+	}
+
+	disruptions, err := decodeDisruptionList(value)
+	if err != nil {
+		return err
+	}
+	location := uds.GetUserLocation(ctx, userID)
+	for _, disruption := range disruptions {
+		if err := normalizeDisruption(disruption, location); err != nil {
+			return err
+		}
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collection := uds.firebaseService.firestore.Collection(getDisruptionsCollectionPath(userID))
+	existing, err := uds.loadDisruptions(ctx, collection.Query)
+	if err != nil {
+		return err
+	}
+	existingByID := make(map[string]*Disruption, len(existing))
+	for _, disruption := range existing {
+		existingByID[disruption.ID] = disruption
+	}
+
+	now := formatTaskTime(time.Now())
+	var changed []*Disruption
+	keep := make(map[string]bool, len(disruptions))
+	for _, disruption := range disruptions {
+		if disruption.ID == "" || disruption.ID == legacyDisruptionsDocID {
+			disruption.ID = collection.NewDoc().ID
+		}
+		keep[disruption.ID] = true
+
+		// Lists from clients carry no update times; unchanged disruptions
+		// keep the stored one and changed ones are stamped now
+		current, exists := existingByID[disruption.ID]
+		stamp := disruption.UpdatedAt == ""
+		if stamp && exists {
+			disruption.UpdatedAt = current.UpdatedAt
+		}
+		if exists && reflect.DeepEqual(current, disruption) {
+			continue
+		}
+		if stamp {
+			disruption.UpdatedAt = now
+		}
+		changed = append(changed, disruption)
+	}
+	var removed []string
+	for _, disruption := range existing {
+		if !keep[disruption.ID] {
+			removed = append(removed, disruption.ID)
+		}
+	}
+
+	if err := uds.putDisruptionsLocked(ctx, userID, changed); err != nil {
+		return err
+	}
+	if err := uds.deleteDisruptionsLocked(ctx, userID, removed); err != nil {
+		return err
+	}
+
+	log.Printf("Replaced disruptions for user %s: %d written, %d deleted, %d unchanged", userID, len(changed), len(removed), len(disruptions)-len(changed))
+	return nil
+}
+
+// deleteAllDisruptions removes every disruption, for deleting the
+// "disruptions" storage key
+func (uds *UserDataService) deleteAllDisruptions(ctx context.Context, userID string) error {
+	return uds.replaceDisruptions(ctx, userID, []interface{}{})
+}
+
+// migrateLegacyDisruptions moves disruptions stored as a single value into
+// one document per disruption and drops the disruption_ids index. Each user
+// is checked once per process.
+func (uds *UserDataService) migrateLegacyDisruptions(ctx context.Context, userID string) error {
+	if _, done := uds.disruptionsMigrated.Load(userID); done {
+		return nil
+	}
+
+	location := uds.GetUserLocation(ctx, userID)
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collection := uds.firebaseService.firestore.Collection(getDisruptionsCollectionPath(userID))
+	idsRef := uds.firebaseService.firestore.Collection(getCollectionPath(userID, disruptionIDsStorageKey)).Doc(disruptionIDsStorageKey)
+	docs, err := uds.firebaseService.firestore.GetAll(ctx, []*firestore.DocumentRef{collection.Doc(legacyDisruptionsDocID), idsRef})
+	if err != nil {
+		return fmt.Errorf("failed to check for legacy disruptions: %w", err)
+	}
+	legacy, index := docs[0], docs[1]
+
+	var valid []*Disruption
+	if legacy.Exists() {
+		disruptions, err := decodeDisruptionList(legacy.Data()["value"])
+		if err != nil {
+			return fmt.Errorf("failed to migrate legacy disruptions: %w", err)
+		}
+		now := formatTaskTime(time.Now())
+		for _, disruption := range disruptions {
+			if err := normalizeDisruption(disruption, location); err != nil {
+				log.Printf("Skipping invalid legacy disruption %s for user %s: %v", disruption.ID, userID, err)
+				continue
+			}
+			if disruption.ID == "" || disruption.ID == legacyDisruptionsDocID {
+				disruption.ID = collection.NewDoc().ID
+			}
+			disruption.UpdatedAt = now
+			valid = append(valid, disruption)
+		}
+		if err := uds.putDisruptionsLocked(ctx, userID, valid); err != nil {
+			return err
+		}
+	}
+
+	batch := &changeBatch{}
+	var changes []documentChange
+	if legacy.Exists() {
+		batch.Delete(legacy.Ref)
+		changes = append(changes, documentChange{"disruptions", legacyDisruptionsDocID, ChangeOperationDelete})
+	}
+	if index.Exists() {
+		batch.Delete(idsRef)
+		changes = append(changes, documentChange{getCollectionType(disruptionIDsStorageKey), disruptionIDsStorageKey, ChangeOperationDelete})
+	}
+	if len(changes) > 0 {
+		if err := uds.commitChanges(ctx, batch, userID, changes...); err != nil {
+			return fmt.Errorf("failed to remove legacy disruptions: %w", err)
+		}
+		log.Printf("Migrated %d legacy disruptions to documents for user %s", len(valid), userID)
+	}
+
+	uds.disruptionsMigrated.Store(userID, true)
+	return nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPatchDisruption(t *testing.T) {
+	stored := Disruption{
+		ID:        "d1",
+		Title:     "Standup",
+		StartTime: "2024-05-01T09:00:00.000Z",
+		EndTime:   "2024-05-01T09:15:00.000Z",
+		Date:      "2024-05-01",
+	}
+	tests := []struct {
+		name      string
+		patch     string
+		wantStart string
+		wantEnd   string
+		wantDate  string
+		wantMins  float64
+	}{
+		{
+			name:      "title only",
+			patch:     `{"title":"Sync"}`,
+			wantStart: "2024-05-01T09:00:00.000Z", wantEnd: "2024-05-01T09:15:00.000Z", wantDate: "2024-05-01", wantMins: 15,
+		},
+		{
+			name:      "moved start re-derives date and shifts end",
+			patch:     `{"startTime":"2024-05-03T23:30:00.000Z"}`,
+			wantStart: "2024-05-03T23:30:00.000Z", wantEnd: "2024-05-03T23:45:00.000Z", wantDate: "2024-05-04", wantMins: 15,
+		},
+		{
+			name:      "moved start with its own end and date",
+			patch:     `{"startTime":"2024-05-03T10:00:00.000Z","endTime":"2024-05-03T11:00:00.000Z","date":"2024-05-02"}`,
+			wantStart: "2024-05-03T10:00:00.000Z", wantEnd: "2024-05-03T11:00:00.000Z", wantDate: "2024-05-02", wantMins: 60,
+		},
+		{
+			name:      "same start in another form",
+			patch:     `{"startTime":"2024-05-01T11:00:00+02:00"}`,
+			wantStart: "2024-05-01T09:00:00.000Z", wantEnd: "2024-05-01T09:15:00.000Z", wantDate: "2024-05-01", wantMins: 15,
+		},
+	}
+
+	location := time.FixedZone("UTC+2", 2*60*60)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disruption := stored
+			if err := PatchDisruption(&disruption, []byte(tt.patch)); err != nil {
+				t.Fatalf("PatchDisruption() error = %v", err)
+			}
+			if err := normalizeDisruption(&disruption, location); err != nil {
+				t.Fatalf("normalizeDisruption() error = %v", err)
+			}
+			if disruption.StartTime != tt.wantStart || disruption.EndTime != tt.wantEnd || disruption.Date != tt.wantDate {
+				t.Errorf("got %s-%s on %s, want %s-%s on %s", disruption.StartTime, disruption.EndTime, disruption.Date,
+					tt.wantStart, tt.wantEnd, tt.wantDate)
+			}
+			if disruption.Duration != tt.wantMins {
+				t.Errorf("duration = %v, want %v", disruption.Duration, tt.wantMins)
+			}
+		})
+	}
+
+	if err := PatchDisruption(&Disruption{}, []byte("[")); err == nil {
+		t.Error("PatchDisruption() accepted invalid JSON")
+	}
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ENRICHMENT_COLLECTION_NAME indexes the users whose tabs are enriched with
+// page metadata by when the worker next needs to look at them
+const ENRICHMENT_COLLECTION_NAME = "tab-blaster-5k-enrichment"
+
+// Enrichment worker defaults
+const (
+	DefaultEnrichmentInterval = 5 * time.Minute
+	// enrichmentUserPeriod is how often a user's tabs are looked at, which
+	// bounds how long a new tab waits for its metadata
+	enrichmentUserPeriod = time.Hour
+	// maxEnrichmentsPerUserRun bounds the pages fetched for one user per
+	// run; the rest continue on the next run
+	maxEnrichmentsPerUserRun = 100
+	// enrichmentBatchSize is the number of users read per run
+	enrichmentBatchSize = 20
+	// enrichmentConcurrency is the number of pages fetched at once
+	enrichmentConcurrency = 4
+)
+
+// Saved tab metadata keys filled by enrichment
+const (
+	MetadataKeyDescription  = "description"
+	MetadataKeyCanonicalURL = "canonicalUrl"
+	MetadataKeyImage        = "image"
+	// MetadataKeyEnrichedAt marks a saved tab as enriched, successfully or
+	// not, so it is not fetched again
+	MetadataKeyEnrichedAt = "enrichedAt"
+)
+
+// needsTitle reports whether a title is blank: empty or just the URL, as
+// the extension stores tabs that never finished loading
+func needsTitle(title, rawURL string) bool {
+	title = strings.TrimSpace(title)
+	return title == "" || title == rawURL
+}
+
+// needsFavicon reports whether a favicon URL is missing or one only the
+// browser can load
+func needsFavicon(favicon string) bool {
+	return !isHTTPURL(favicon) && !strings.HasPrefix(favicon, "data:image/")
+}
+
+// enrichTab fills a session tab's blank title and favicon
+func enrichTab(tab *Tab, metadata *PageMetadata) {
+	if needsTitle(tab.Title, tab.URL) && metadata.Title != "" {
+		tab.Title = metadata.Title
+	}
+	if needsFavicon(tab.FavIconUrl) && metadata.Favicon != "" {
+		tab.FavIconUrl = metadata.Favicon
+	}
+}
+
+// enrichSavedTab fills a saved tab's blank title and favicon, replaces a
+// favicon that no longer loads, adds the description, canonical URL and
+// image, and marks the tab enriched at enrichedAt. metadata is nil when the
+// page could not be fetched.
+func enrichSavedTab(tab *SavedTab, metadata *PageMetadata, faviconBroken bool, enrichedAt string) {
+	if tab.Metadata == nil {
+		tab.Metadata = map[string]string{}
+	}
+	tab.Metadata[MetadataKeyEnrichedAt] = enrichedAt
+	if metadata == nil {
+		return
+	}
+
+	if needsTitle(tab.Title, tab.URL) && metadata.Title != "" {
+		tab.Title = metadata.Title
+	}
+	if (needsFavicon(tab.FavIconUrl) || faviconBroken) && metadata.Favicon != "" {
+		tab.FavIconUrl = metadata.Favicon
+	}
+	for key, value := range map[string]string{
+		MetadataKeyDescription:  metadata.Description,
+		MetadataKeyCanonicalURL: metadata.CanonicalURL,
+		MetadataKeyImage:        metadata.Image,
+	} {
+		if value != "" && tab.Metadata[key] == "" {
+			tab.Metadata[key] = value
+		}
+	}
+}
+
+// EnrichmentWorker fills in missing titles, favicons and page details on
+// saved tabs and session tabs
+type EnrichmentWorker struct {
+	userDataService *UserDataService
+	fetcher         *MetadataFetcher
+	interval        time.Duration
+}
+
+var (
+	enrichmentWorker     *EnrichmentWorker
+	enrichmentWorkerOnce sync.Once
+	enrichmentWorkerErr  error
+)
+
+// NewEnrichmentWorker returns the enrichment worker. METADATA_ENRICH_INTERVAL
+// sets how often it runs (0 disables it), METADATA_FETCH_TIMEOUT the
+// per-request timeout, METADATA_MAX_BYTES how much of a page is read,
+// METADATA_CACHE_TTL how long fetched metadata is reused and
+// METADATA_ALLOW_PRIVATE=true lets it reach private addresses.
+func NewEnrichmentWorker() (*EnrichmentWorker, error) {
+	enrichmentWorkerOnce.Do(func() {
+		userDataService, err := NewUserDataService()
+		if err != nil {
+			enrichmentWorkerErr = err
+			return
+		}
+
+		durations := map[string]time.Duration{
+			"METADATA_ENRICH_INTERVAL": DefaultEnrichmentInterval,
+			"METADATA_FETCH_TIMEOUT":   DefaultMetadataFetchTimeout,
+			"METADATA_CACHE_TTL":       DefaultMetadataCacheTTL,
+		}
+		for name, fallback := range durations {
+			value, err := time.ParseDuration(getEnvOrDefault(name, fallback.String()))
+			if err != nil || value < 0 {
+				enrichmentWorkerErr = fmt.Errorf("invalid %s: %v", name, err)
+				return
+			}
+			durations[name] = value
+		}
+		maxBytes, err := strconv.ParseInt(getEnvOrDefault("METADATA_MAX_BYTES", strconv.Itoa(DefaultMetadataMaxBytes)), 10, 64)
+		if err != nil || maxBytes <= 0 {
+			enrichmentWorkerErr = fmt.Errorf("invalid METADATA_MAX_BYTES: %v", err)
+			return
+		}
+
+		enrichmentWorker = &EnrichmentWorker{
+			userDataService: userDataService,
+			fetcher: NewMetadataFetcher(
+				durations["METADATA_FETCH_TIMEOUT"],
+				maxBytes,
+				durations["METADATA_CACHE_TTL"],
+				getEnvOrDefault("METADATA_ALLOW_PRIVATE", "false") == "true",
+			),
+			interval: durations["METADATA_ENRICH_INTERVAL"],
+		}
+	})
+
+	return enrichmentWorker, enrichmentWorkerErr
+}
+
+// Run runs the worker until ctx is done
+func (ew *EnrichmentWorker) Run(ctx context.Context) {
+	if ew.interval == 0 {
+		log.Printf("Enrichment worker disabled")
+		return
+	}
+
+	ticker := time.NewTicker(ew.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := ew.RunOnce(ctx); err != nil {
+			log.Printf("Enrichment worker run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce enriches the tabs of the users that are due
+func (ew *EnrichmentWorker) RunOnce(ctx context.Context) error {
+	return ew.userDataService.runDueUserJobs(ctx, ENRICHMENT_COLLECTION_NAME, enrichmentBatchSize, ew.enrichUser)
+}
+
+// enrichmentItem is an item whose page is due to be fetched, with the
+// saved tab favicon checked alongside it
+type enrichmentItem struct {
+	annotatedItem
+	favicon string
+}
+
+// enrichUser fetches the pages of saved tabs not yet enriched and of session
+// tabs missing a title or favicon, stores what was learned and returns when
+// to look again. Each item's URL is fetched once, successfully or not.
+func (ew *EnrichmentWorker) enrichUser(ctx context.Context, entry *userJobEntry) (time.Time, error) {
+	uds := ew.userDataService
+	userID := entry.UserID
+
+	uds.mu.RLock()
+	sessions, err := uds.loadSessions(ctx, userID)
+	var savedTabs []*SavedTab
+	if err == nil {
+		savedTabs, err = uds.loadSavedTabs(ctx, userID)
+	}
+	uds.mu.RUnlock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var items []enrichmentItem
+	var due []string
+	seen := make(map[string]bool)
+	consider := func(item annotatedItem, favicon string) {
+		if !isHTTPURL(item.url) || annotations.get(item).EnrichedAt != "" {
+			return
+		}
+		items = append(items, enrichmentItem{item, favicon})
+		if !seen[item.url] {
+			seen[item.url] = true
+			due = append(due, item.url)
+		}
+	}
+	// Existing saved tab favicons are checked once, alongside their page
+	var favicons []string
+	for _, tab := range savedTabs {
+		favicon := ""
+		if isHTTPURL(tab.FavIconUrl) {
+			favicon = tab.FavIconUrl
+		}
+		consider(savedTabItem(tab), favicon)
+	}
+	for _, session := range sessions {
+		for i := range session.Tabs {
+			tab := &session.Tabs[i]
+			if needsTitle(tab.Title, tab.URL) || needsFavicon(tab.FavIconUrl) {
+				consider(sessionTabItem(session, tab), "")
+			}
+		}
+	}
+
+	nextRun := time.Now().Add(enrichmentUserPeriod)
+	if len(due) > maxEnrichmentsPerUserRun {
+		due = due[:maxEnrichmentsPerUserRun]
+		nextRun = time.Now()
+	}
+	if len(due) == 0 {
+		return nextRun, nil
+	}
+	fetching := make(map[string]bool, len(due))
+	for _, rawURL := range due {
+		fetching[rawURL] = true
+	}
+	for _, item := range items {
+		if item.favicon != "" && fetching[item.url] {
+			favicons = append(favicons, item.favicon)
+		}
+	}
+
+	pages := make(map[string]*PageMetadata)
+	failed := make(map[string]bool)
+	var mu sync.Mutex
+	forEachConcurrently(due, enrichmentConcurrency, func(rawURL string) {
+		metadata, err := ew.fetcher.Fetch(ctx, rawURL)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failed[rawURL] = ctx.Err() == nil
+			return
+		}
+		pages[rawURL] = metadata
+	})
+	brokenFavicons := make(map[string]bool)
+	forEachConcurrently(favicons, enrichmentConcurrency, func(favicon string) {
+		ok := ew.fetcher.FaviconOK(ctx, favicon)
+		mu.Lock()
+		defer mu.Unlock()
+		brokenFavicons[favicon] = !ok && ctx.Err() == nil
+	})
+	if ctx.Err() != nil {
+		return time.Time{}, ctx.Err()
+	}
+
+	if err := uds.storeEnrichment(ctx, userID, items, pages, failed, brokenFavicons); err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Fetched metadata for %d pages for user %s (%d failed)", len(pages), userID, len(failed))
+	return nextRun, nil
+}
+
+// storeEnrichment records fetched metadata, or that the fetch failed, in the
+// annotations of every item with a fetched URL
+func (uds *UserDataService) storeEnrichment(ctx context.Context, userID string, items []enrichmentItem, pages map[string]*PageMetadata, failed, brokenFavicons map[string]bool) error {
+	enrichedAt := formatTaskTime(time.Now())
+	var updates []annotationUpdate
+	for _, item := range items {
+		metadata := pages[item.url]
+		if metadata == nil && !failed[item.url] {
+			continue
+		}
+		fields := map[string]interface{}{"page": metadata, "enrichedAt": enrichedAt}
+		if item.favicon != "" {
+			fields["faviconBroken"] = brokenFavicons[item.favicon]
+		}
+		updates = append(updates, annotationUpdate{item.annotatedItem, fields})
+	}
+
+	if err := uds.writeAnnotations(ctx, userID, updates); err != nil {
+		return fmt.Errorf("failed to store page metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"  // Register the GIF decoder for favicons
+	_ "image/jpeg" // Register the JPEG decoder for favicons
+	"image/png"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// maxFaviconDimension bounds the width and height of a decoded favicon, to
+// keep small files from expanding into huge images
+const maxFaviconDimension = 1024
+
+// pngSignature starts every PNG file, including PNG icons inside ICO files
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Favicon content types
+const (
+	faviconTypePNG = "image/png"
+	faviconTypeSVG = "image/svg+xml"
+)
+
+// sniffFaviconType identifies a favicon from its bytes, falling back to the
+// declared type for SVG, which has no signature
+func sniffFaviconType(data []byte, declared string) string {
+	detected := http.DetectContentType(data)
+	if strings.HasPrefix(detected, "image/") {
+		return detected
+	}
+	head := strings.ToLower(string(data[:min(len(data), 1024)]))
+	if strings.HasPrefix(declared, faviconTypeSVG) || strings.Contains(head, "<svg") {
+		return faviconTypeSVG
+	}
+	return detected
+}
+
+// decodeFavicon decodes a PNG, GIF, JPEG or ICO favicon
+func decodeFavicon(data []byte, contentType string) (image.Image, error) {
+	if contentType == "image/x-icon" || contentType == "image/vnd.microsoft.icon" {
+		return decodeICO(data)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFaviconInvalid, err)
+	}
+	if config.Width > maxFaviconDimension || config.Height > maxFaviconDimension {
+		return nil, fmt.Errorf("%w: %dx%d is larger than %dx%d", ErrFaviconInvalid, config.Width, config.Height, maxFaviconDimension, maxFaviconDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFaviconInvalid, err)
+	}
+	return img, nil
+}
+
+// decodeICO decodes the largest image in an ICO file, which holds PNGs or
+// BMP bitmaps with a 1-bit transparency mask
+func decodeICO(data []byte) (image.Image, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: ico %s", ErrFaviconInvalid, reason)
+	}
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[0:]) != 0 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+		return nil, invalid("header is malformed")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 || len(data) < 6+16*count {
+		return nil, invalid("has no images")
+	}
+
+	best, bestWidth, bestBits := -1, 0, 0
+	for i := range count {
+		entry := data[6+16*i:]
+		width := int(entry[0])
+		if width == 0 {
+			width = 256
+		}
+		bits := int(binary.LittleEndian.Uint16(entry[6:]))
+		if width > bestWidth || (width == bestWidth && bits > bestBits) {
+			best, bestWidth, bestBits = i, width, bits
+		}
+	}
+	entry := data[6+16*best:]
+	size := int(binary.LittleEndian.Uint32(entry[8:]))
+	offset := int(binary.LittleEndian.Uint32(entry[12:]))
+	if offset < 0 || size <= 0 || offset > len(data) || size > len(data)-offset {
+		return nil, invalid("image is out of bounds")
+	}
+	payload := data[offset : offset+size]
+
+	if bytes.HasPrefix(payload, pngSignature) {
+		return decodeFavicon(payload, faviconTypePNG)
+	}
+	return decodeICOBitmap(payload)
+}
+
+// decodeICOBitmap decodes an uncompressed 1, 4, 8, 24 or 32-bit bitmap as
+// stored in ICO files: a BITMAPINFOHEADER with doubled height, the palette,
+// the colour rows bottom-up and then the transparency mask
+func decodeICOBitmap(data []byte) (image.Image, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: ico bitmap %s", ErrFaviconInvalid, reason)
+	}
+	if len(data) < 40 {
+		return nil, invalid("is truncated")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[0:]))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2
+	bits := int(binary.LittleEndian.Uint16(data[14:]))
+	compression := binary.LittleEndian.Uint32(data[16:])
+	colorsUsed := int(binary.LittleEndian.Uint32(data[32:]))
+	if headerSize < 40 || headerSize > len(data) || width <= 0 || height <= 0 || width > 256 || height > 256 {
+		return nil, invalid("has an invalid header")
+	}
+	// 3 is BI_BITFIELDS, which 32-bit icons use with the standard masks
+	if compression != 0 && !(compression == 3 && bits == 32) {
+		return nil, invalid("is compressed")
+	}
+
+	var palette []color.NRGBA
+	position := headerSize
+	if compression == 3 {
+		position += 12
+	}
+	switch bits {
+	case 1, 4, 8:
+		if colorsUsed == 0 || colorsUsed > 1<<bits {
+			colorsUsed = 1 << bits
+		}
+		if position+4*colorsUsed > len(data) {
+			return nil, invalid("palette is truncated")
+		}
+		for i := range colorsUsed {
+			c := data[position+4*i:]
+			palette = append(palette, color.NRGBA{R: c[2], G: c[1], B: c[0], A: 255})
+		}
+		position += 4 * colorsUsed
+	case 24, 32:
+	default:
+		return nil, invalid(fmt.Sprintf("has unsupported depth %d", bits))
+	}
+
+	stride := (width*bits + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+	if position+stride*height > len(data) {
+		return nil, invalid("is truncated")
+	}
+	pixels := data[position:]
+	mask := data[position+stride*height:]
+	hasMask := len(mask) >= maskStride*height
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	anyAlpha := false
+	for y := range height {
+		row := pixels[(height-1-y)*stride:]
+		for x := range width {
+			var c color.NRGBA
+			switch bits {
+			case 32:
+				c = color.NRGBA{R: row[4*x+2], G: row[4*x+1], B: row[4*x], A: row[4*x+3]}
+				anyAlpha = anyAlpha || c.A != 0
+			case 24:
+				c = color.NRGBA{R: row[3*x+2], G: row[3*x+1], B: row[3*x], A: 255}
+			default:
+				perByte := 8 / bits
+				index := int(row[x/perByte]>>(8-bits*(x%perByte+1))) & (1<<bits - 1)
+				if index < len(palette) {
+					c = palette[index]
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// The mask decides transparency unless a 32-bit image has its own alpha
+	if hasMask && !(bits == 32 && anyAlpha) {
+		for y := range height {
+			row := mask[(height-1-y)*maskStride:]
+			for x := range width {
+				c := img.NRGBAAt(x, y)
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					c.A = 0
+				} else {
+					c.A = 255
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	return img, nil
+}
+
+// resizeFavicon scales img to fit a size x size square, centred on a
+// transparent background, averaging the source pixels each target pixel
+// covers
+func resizeFavicon(img image.Image, size int) *image.NRGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	sw, sh := float64(bounds.Dx()), float64(bounds.Dy())
+	scale := min(float64(size)/sw, float64(size)/sh)
+	w := max(1, int(math.Round(sw*scale)))
+	h := max(1, int(math.Round(sh*scale)))
+	offsetX, offsetY := (size-w)/2, (size-h)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for dy := range h {
+		y0, y1 := float64(dy)*sh/float64(h), float64(dy+1)*sh/float64(h)
+		for dx := range w {
+			x0, x1 := float64(dx)*sw/float64(w), float64(dx+1)*sw/float64(w)
+
+			var r, g, b, a, total float64
+			for sy := int(y0); sy < int(math.Ceil(y1)) && sy < src.Rect.Dy(); sy++ {
+				wy := math.Min(y1, float64(sy+1)) - math.Max(y0, float64(sy))
+				for sx := int(x0); sx < int(math.Ceil(x1)) && sx < src.Rect.Dx(); sx++ {
+					weight := wy * (math.Min(x1, float64(sx+1)) - math.Max(x0, float64(sx)))
+					p := src.RGBAAt(sx, sy) // premultiplied, so colours blend correctly
+					r += weight * float64(p.R)
+					g += weight * float64(p.G)
+					b += weight * float64(p.B)
+					a += weight * float64(p.A)
+					total += weight
+				}
+			}
+			if total == 0 || a == 0 {
+				continue
+			}
+			unpremultiply := func(v float64) uint8 {
+				return uint8(math.Min(255, math.Round(v/a*255)))
+			}
+			dst.SetNRGBA(offsetX+dx, offsetY+dy, color.NRGBA{
+				R: unpremultiply(r),
+				G: unpremultiply(g),
+				B: unpremultiply(b),
+				A: uint8(math.Round(a / total)),
+			})
+		}
+	}
+	return dst
+}
+
+// encodeFaviconPNG encodes a resized favicon
+func encodeFaviconPNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
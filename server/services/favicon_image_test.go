@@ -0,0 +1,254 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+var (
+	red         = color.NRGBA{R: 255, A: 255}
+	green       = color.NRGBA{G: 255, A: 255}
+	blue        = color.NRGBA{B: 255, A: 255}
+	transparent = color.NRGBA{}
+)
+
+// testPNG encodes a width x height PNG filled with c
+func testPNG(t *testing.T, width, height int, c color.NRGBA) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// icoImage is one image of a test ICO file
+type icoImage struct {
+	width   byte // 0 means 256
+	bits    uint16
+	payload []byte
+}
+
+// testICO assembles an ICO file from images
+func testICO(images ...icoImage) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, uint16(len(images))})
+	offset := 6 + 16*len(images)
+	for _, img := range images {
+		buf.Write([]byte{img.width, img.width, 0, 0})
+		binary.Write(&buf, binary.LittleEndian, []uint16{1, img.bits})
+		binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(img.payload)), uint32(offset)})
+		offset += len(img.payload)
+	}
+	for _, img := range images {
+		buf.Write(img.payload)
+	}
+	return buf.Bytes()
+}
+
+// testBitmap assembles an ICO bitmap: a BITMAPINFOHEADER, the palette, the
+// bottom-up colour rows and the mask rows, each given top row first
+func testBitmap(width, height int, bits uint16, compression uint32, palette [][4]byte, rows, mask [][]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{40, uint32(width), uint32(2 * height)})
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, bits})
+	binary.Write(&buf, binary.LittleEndian, []uint32{compression, 0, 0, 0, uint32(len(palette)), 0})
+	for _, c := range palette {
+		buf.Write(c[:])
+	}
+	for _, block := range [][][]byte{rows, mask} {
+		for i := len(block) - 1; i >= 0; i-- {
+			row := block[i]
+			buf.Write(row)
+			buf.Write(make([]byte, (4-len(row)%4)%4)) // Rows are padded to 32 bits
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeICO(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		wantSize   int
+		wantPixels []color.NRGBA // Along the top row
+		wantErr    string
+	}{
+		{
+			name:       "largest image chosen",
+			data:       testICO(icoImage{16, 32, testPNG(t, 16, 16, red)}, icoImage{32, 32, testPNG(t, 32, 32, blue)}, icoImage{24, 32, testPNG(t, 24, 24, green)}),
+			wantSize:   32,
+			wantPixels: []color.NRGBA{blue},
+		},
+		{
+			name:       "deeper image chosen at the same size",
+			data:       testICO(icoImage{16, 8, testPNG(t, 16, 16, red)}, icoImage{16, 32, testPNG(t, 16, 16, green)}),
+			wantSize:   16,
+			wantPixels: []color.NRGBA{green},
+		},
+		{
+			name:       "width 0 is 256",
+			data:       testICO(icoImage{64, 32, testPNG(t, 64, 64, red)}, icoImage{0, 32, testPNG(t, 256, 256, blue)}),
+			wantSize:   256,
+			wantPixels: []color.NRGBA{blue},
+		},
+		{
+			name: "32-bit bitmap keeps its alpha over the mask",
+			data: testICO(icoImage{2, 32, testBitmap(2, 2, 32, 0, nil,
+				[][]byte{{0, 0, 255, 255, 255, 0, 0, 128}, {0, 255, 0, 0, 0, 0, 0, 0}},
+				[][]byte{{0xc0}, {0xc0}})}),
+			wantSize:   2,
+			wantPixels: []color.NRGBA{red, {B: 255, A: 128}},
+		},
+		{
+			name: "32-bit bitfields bitmap without alpha uses the mask",
+			data: testICO(icoImage{2, 32, testBitmap(2, 1, 32, 3, [][4]byte{{0, 0, 255, 0}, {0, 255, 0, 0}, {255, 0, 0, 0}},
+				[][]byte{{0, 0, 255, 0, 255, 0, 0, 0}},
+				[][]byte{{0x40}})}),
+			wantSize:   2,
+			wantPixels: []color.NRGBA{red, {B: 255}},
+		},
+		{
+			name: "24-bit bitmap with mask",
+			data: testICO(icoImage{2, 24, testBitmap(2, 1, 24, 0, nil,
+				[][]byte{{0, 255, 0, 0, 0, 255}},
+				[][]byte{{0x80}})}),
+			wantSize:   2,
+			wantPixels: []color.NRGBA{{G: 255}, red},
+		},
+		{
+			name: "1-bit palette bitmap",
+			data: testICO(icoImage{3, 1, testBitmap(3, 1, 1, 0, [][4]byte{{0, 0, 255, 0}, {255, 0, 0, 0}},
+				[][]byte{{0b01000000}},
+				[][]byte{{0x00}})}),
+			wantSize:   3,
+			wantPixels: []color.NRGBA{red, blue, red},
+		},
+		{
+			name: "4-bit palette bitmap",
+			data: testICO(icoImage{2, 4, testBitmap(2, 1, 4, 0, [][4]byte{{0, 0, 0, 0}, {0, 255, 0, 0}, {255, 0, 0, 0}},
+				[][]byte{{0x21}},
+				nil)}),
+			wantSize:   2,
+			wantPixels: []color.NRGBA{blue, green},
+		},
+		{name: "not an icon", data: []byte("GIF89a"), wantErr: "header is malformed"},
+		{name: "no images", data: testICO(), wantErr: "has no images"},
+		{name: "image out of bounds", data: testICO(icoImage{16, 32, nil}), wantErr: "out of bounds"},
+		{name: "compressed bitmap", data: testICO(icoImage{2, 8, testBitmap(2, 1, 8, 1, nil, [][]byte{{0, 0}}, nil)}), wantErr: "is compressed"},
+		{name: "unsupported depth", data: testICO(icoImage{2, 16, testBitmap(2, 1, 16, 0, nil, [][]byte{{0, 0, 0, 0}}, nil)}), wantErr: "unsupported depth 16"},
+		{name: "truncated rows", data: testICO(icoImage{2, 24, testBitmap(2, 4, 24, 0, nil, [][]byte{{0, 0, 0, 0, 0, 0}}, nil)}), wantErr: "is truncated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := decodeICO(tt.data)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrFaviconInvalid) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeICO() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeICO() error = %v", err)
+			}
+			if bounds := img.Bounds(); bounds.Dx() != tt.wantSize || bounds.Dy() > tt.wantSize {
+				t.Errorf("size = %v, want %d wide", bounds, tt.wantSize)
+			}
+			for x, want := range tt.wantPixels {
+				if got := color.NRGBAModel.Convert(img.At(x, 0)).(color.NRGBA); got != want && !(got.A == 0 && want.A == 0) {
+					t.Errorf("pixel %d = %v, want %v", x, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSniffFaviconType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		declared string
+		want     string
+	}{
+		{"png", testPNG(t, 1, 1, red), "text/html", "image/png"},
+		{"ico", testICO(icoImage{1, 32, testPNG(t, 1, 1, red)}), "", "image/x-icon"},
+		{"svg declared", []byte(`<?xml version="1.0"?><!-- icon -->`), "image/svg+xml; charset=utf-8", faviconTypeSVG},
+		{"svg sniffed", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "text/plain", faviconTypeSVG},
+		{"html error page", []byte("<html><body>Not found</body></html>"), "image/png", "text/html; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffFaviconType(tt.data, tt.declared); got != tt.want {
+				t.Errorf("sniffFaviconType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeFaviconRejectsHugeImages(t *testing.T) {
+	data := testPNG(t, maxFaviconDimension+1, 1, red)
+	if _, err := decodeFavicon(data, faviconTypePNG); !errors.Is(err, ErrFaviconInvalid) {
+		t.Errorf("decodeFavicon() error = %v, want ErrFaviconInvalid", err)
+	}
+}
+
+func TestResizeFavicon(t *testing.T) {
+	wide := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	wide.SetNRGBA(0, 0, red)
+	wide.SetNRGBA(1, 0, blue)
+	half := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	half.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	half.SetNRGBA(0, 1, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		size   int
+		pixels map[image.Point]color.NRGBA
+	}{
+		{
+			name: "aspect ratio kept and centred",
+			img:  wide,
+			size: 4,
+			pixels: map[image.Point]color.NRGBA{
+				{0, 0}: transparent, {0, 1}: red, {3, 2}: blue, {3, 3}: transparent,
+			},
+		},
+		{
+			name: "transparent pixels do not darken colours",
+			img:  half,
+			size: 1,
+			pixels: map[image.Point]color.NRGBA{
+				{0, 0}: {R: 255, G: 255, B: 255, A: 128},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resizeFavicon(tt.img, tt.size)
+			if got.Bounds() != image.Rect(0, 0, tt.size, tt.size) {
+				t.Fatalf("bounds = %v", got.Bounds())
+			}
+			for point, want := range tt.pixels {
+				if pixel := got.NRGBAAt(point.X, point.Y); pixel != want {
+					t.Errorf("pixel %v = %v, want %v", point, pixel, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Favicon proxy defaults
+const (
+	DefaultFaviconSize         = 32
+	DefaultFaviconCacheTTL     = 7 * 24 * time.Hour
+	DefaultFaviconMaxBytes     = 256 << 10
+	DefaultFaviconFetchTimeout = 10 * time.Second
+	// faviconFailureTTL is how long a failed fetch is remembered, shorter
+	// than the TTL so sites that come back are picked up
+	faviconFailureTTL = time.Hour
+	// FaviconUserAgent identifies the proxy to sites
+	FaviconUserAgent = "TabBlasterFavicons/1.0 (+https://github.com/glennmartinez/tab-blaster-5000)"
+)
+
+// FaviconSizes are the square sizes favicons are served at
+var FaviconSizes = []int{16, 32, 48, 64, 128}
+
+// Favicon errors
+var (
+	ErrInvalidFaviconRequest = errors.New("invalid favicon request")
+	ErrFaviconUnavailable    = errors.New("favicon could not be fetched")
+	ErrFaviconInvalid        = errors.New("not a usable favicon")
+)
+
+// Favicon is a cached favicon ready to serve
+type Favicon struct {
+	Data        []byte
+	ContentType string
+	ETag        string
+	FetchedAt   time.Time
+	Expires     time.Time
+}
+
+// faviconRecord describes a cached favicon on disk: the sizes as
+// {size}.png, or the original for SVG
+type faviconRecord struct {
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType,omitempty"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+	Expires     time.Time `json:"expires"`
+	// Error is set when the fetch failed, so the failure is cached too
+	Error string `json:"error,omitempty"`
+	// Invalid is set when the failure was the favicon's, not the fetch's
+	Invalid bool `json:"invalid,omitempty"`
+}
+
+// FaviconService fetches favicons on behalf of clients, validates and
+// resizes them and caches the results on disk
+type FaviconService struct {
+	client   *http.Client
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+
+	mu       sync.Mutex
+	inflight map[string]*faviconCall
+}
+
+// faviconCall lets concurrent requests for one URL share a fetch
+type faviconCall struct {
+	done chan struct{}
+}
+
+var (
+	faviconService     *FaviconService
+	faviconServiceOnce sync.Once
+	faviconServiceErr  error
+)
+
+// NewFaviconService returns the favicon service. FAVICON_CACHE_DIR sets the
+// cache directory, FAVICON_CACHE_TTL how long favicons are kept,
+// FAVICON_MAX_BYTES the largest favicon accepted, FAVICON_FETCH_TIMEOUT the
+// per-request timeout and FAVICON_ALLOW_PRIVATE=true lets it reach private
+// addresses.
+func NewFaviconService() (*FaviconService, error) {
+	faviconServiceOnce.Do(func() {
+		ttl, err := time.ParseDuration(getEnvOrDefault("FAVICON_CACHE_TTL", DefaultFaviconCacheTTL.String()))
+		if err != nil || ttl <= 0 {
+			faviconServiceErr = fmt.Errorf("invalid FAVICON_CACHE_TTL: %v", err)
+			return
+		}
+		timeout, err := time.ParseDuration(getEnvOrDefault("FAVICON_FETCH_TIMEOUT", DefaultFaviconFetchTimeout.String()))
+		if err != nil || timeout <= 0 {
+			faviconServiceErr = fmt.Errorf("invalid FAVICON_FETCH_TIMEOUT: %v", err)
+			return
+		}
+		maxBytes, err := strconv.ParseInt(getEnvOrDefault("FAVICON_MAX_BYTES", strconv.Itoa(DefaultFaviconMaxBytes)), 10, 64)
+		if err != nil || maxBytes <= 0 {
+			faviconServiceErr = fmt.Errorf("invalid FAVICON_MAX_BYTES: %v", err)
+			return
+		}
+
+		faviconService = &FaviconService{
+			client:   newOutboundClient(timeout, getEnvOrDefault("FAVICON_ALLOW_PRIVATE", "false") == "true"),
+			dir:      getEnvOrDefault("FAVICON_CACHE_DIR", "favicons"),
+			ttl:      ttl,
+			maxBytes: maxBytes,
+			inflight: make(map[string]*faviconCall),
+		}
+	})
+
+	return faviconService, faviconServiceErr
+}
+
+// GetFavicon returns the favicon at rawURL as a size x size PNG, or as is
+// for SVG. rawURL may also be a page, whose declared icon (or /favicon.ico)
+// is used.
+func (fs *FaviconService) GetFavicon(ctx context.Context, rawURL string, size int) (*Favicon, error) {
+	if !isHTTPURL(rawURL) {
+		return nil, fmt.Errorf("%w: url must be an http(s) URL", ErrInvalidFaviconRequest)
+	}
+	if size == 0 {
+		size = DefaultFaviconSize
+	}
+	if !slices.Contains(FaviconSizes, size) {
+		return nil, fmt.Errorf("%w: size must be one of %v", ErrInvalidFaviconRequest, FaviconSizes)
+	}
+
+	dir := fs.cacheDir(rawURL)
+	record, err := fs.readRecord(dir)
+	if err != nil || time.Now().After(record.Expires) {
+		if record, err = fs.refresh(ctx, rawURL, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if record.Error != "" {
+		if record.Invalid {
+			return nil, fmt.Errorf("%w: %s", ErrFaviconInvalid, record.Error)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrFaviconUnavailable, record.Error)
+	}
+	name := strconv.Itoa(size) + ".png"
+	if record.ContentType == faviconTypeSVG {
+		name = "original.svg"
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached favicon: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return &Favicon{
+		Data:        data,
+		ContentType: record.ContentType,
+		ETag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+		FetchedAt:   record.FetchedAt,
+		Expires:     record.Expires,
+	}, nil
+}
+
+// cacheDir returns the cache directory for a favicon URL
+func (fs *FaviconService) cacheDir(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(fs.dir, key[:2], key)
+}
+
+func (fs *FaviconService) readRecord(dir string) (*faviconRecord, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "favicon.json"))
+	if err != nil {
+		return nil, err
+	}
+	var record faviconRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// refresh fetches a favicon into the cache, sharing the fetch with
+// concurrent requests for the same URL
+func (fs *FaviconService) refresh(ctx context.Context, rawURL, dir string) (*faviconRecord, error) {
+	fs.mu.Lock()
+	if call, ok := fs.inflight[rawURL]; ok {
+		fs.mu.Unlock()
+		select {
+		case <-call.done:
+			return fs.readRecord(dir)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &faviconCall{done: make(chan struct{})}
+	fs.inflight[rawURL] = call
+	fs.mu.Unlock()
+
+	defer func() {
+		fs.mu.Lock()
+		delete(fs.inflight, rawURL)
+		fs.mu.Unlock()
+		close(call.done)
+	}()
+
+	now := time.Now()
+	record := &faviconRecord{URL: rawURL, FetchedAt: now, Expires: now.Add(fs.ttl)}
+	files, err := fs.fetch(ctx, rawURL, record)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		record.Invalid = errors.Is(err, ErrFaviconInvalid)
+		sentinel := ErrFaviconUnavailable
+		if record.Invalid {
+			sentinel = ErrFaviconInvalid
+		}
+		record.Error = strings.TrimPrefix(err.Error(), sentinel.Error()+": ")
+		record.Expires = now.Add(min(fs.ttl, faviconFailureTTL))
+		files = nil
+	}
+
+	if err := fs.writeCache(dir, record, files); err != nil {
+		log.Printf("Failed to cache favicon %s: %v", rawURL, err)
+		return nil, err
+	}
+	return record, nil
+}
+
+// fetch downloads and converts a favicon, returning the files to cache and
+// setting the record's content type
+func (fs *FaviconService) fetch(ctx context.Context, rawURL string, record *faviconRecord) (map[string][]byte, error) {
+	data, contentType, finalURL, err := fs.download(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// A page: use the icon it declares, or the site's /favicon.ico
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		iconURL := ParsePageMetadata(bytes.NewReader(data), finalURL).Favicon
+		if iconURL == "" {
+			iconURL = (&url.URL{Scheme: finalURL.Scheme, Host: finalURL.Host, Path: "/favicon.ico"}).String()
+		}
+		if data, contentType, _, err = fs.download(ctx, iconURL); err != nil {
+			return nil, err
+		}
+	}
+
+	record.ContentType = sniffFaviconType(data, contentType)
+	if record.ContentType == faviconTypeSVG {
+		return map[string][]byte{"original.svg": data}, nil
+	}
+	if record.ContentType == "image/webp" || record.ContentType == "image/bmp" {
+		return nil, fmt.Errorf("%w: %s is not supported", ErrFaviconInvalid, record.ContentType)
+	}
+	if !isImageContent(record.ContentType, data) {
+		return nil, fmt.Errorf("%w: got %s", ErrFaviconInvalid, record.ContentType)
+	}
+
+	img, err := decodeFavicon(data, record.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	record.ContentType = faviconTypePNG
+	files := make(map[string][]byte, len(FaviconSizes))
+	for _, size := range FaviconSizes {
+		encoded, err := encodeFaviconPNG(resizeFavicon(img, size))
+		if err != nil {
+			return nil, err
+		}
+		files[strconv.Itoa(size)+".png"] = encoded
+	}
+	return files, nil
+}
+
+// download fetches up to maxBytes from rawURL, rejecting anything larger
+func (fs *FaviconService) download(ctx context.Context, rawURL string) ([]byte, string, *url.URL, error) {
+	req, err := newOutboundRequest(ctx, http.MethodGet, rawURL, FaviconUserAgent)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrInvalidFaviconRequest, err)
+	}
+	req.Header.Set("Accept", "image/*,text/html;q=0.5")
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrFaviconUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", nil, fmt.Errorf("%w: status %d", ErrFaviconUnavailable, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, fs.maxBytes+1))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrFaviconUnavailable, err)
+	}
+	if int64(len(data)) > fs.maxBytes {
+		return nil, "", nil, fmt.Errorf("%w: larger than %d bytes", ErrFaviconInvalid, fs.maxBytes)
+	}
+	return data, resp.Header.Get("Content-Type"), resp.Request.URL, nil
+}
+
+// writeCache replaces a cached favicon: files first, then the record, each
+// renamed into place so readers never see partial files
+func (fs *FaviconService) writeCache(dir string, record *faviconRecord, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create favicon cache directory: %w", err)
+	}
+	recordData, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	for name, data := range files {
+		if err := writeFileAtomic(dir, name, data); err != nil {
+			return fmt.Errorf("failed to write favicon: %w", err)
+		}
+	}
+	if err := writeFileAtomic(dir, "favicon.json", recordData); err != nil {
+		return fmt.Errorf("failed to write favicon record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// favoritesStorageKey is the generic storage key the extension keeps
+// favorites under, as one list
+const favoritesStorageKey = "favorites"
+
+// Favorite priorities
+const (
+	MinFavoritePriority     = 1
+	MaxFavoritePriority     = 5
+	DefaultFavoritePriority = 3
+)
+
+// FavoriteSortScore ranks favorites by calculated score
+const FavoriteSortScore = "score"
+
+// Favorite errors
+var (
+	ErrFavoriteNotFound = errors.New("favorite not found")
+	ErrFavoriteExists   = errors.New("a favorite with this URL already exists")
+	ErrInvalidFavorite  = errors.New("invalid favorite")
+)
+
+// FavoriteTab is a favorite, as stored by the extension
+type FavoriteTab struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	URL       string   `json:"url"`
+	Favicon   string   `json:"favicon,omitempty"`
+	DateAdded string   `json:"dateAdded"`
+	Tags      []string `json:"tags"`
+	TabID     int      `json:"tabId,omitempty"` // Chrome tab ID if it's an active tab
+	Priority  int      `json:"priority"`
+	Usage     Usage    `json:"usage"`
+	// LinkStatus is the link checker's latest result for URL, read from the
+	// favorite's annotations and never stored
+	LinkStatus *LinkStatus `json:"linkStatus,omitempty"`
+	// CalculatedScore is derived from priority and usage on every write
+	CalculatedScore float64 `json:"calculatedScore"`
+}
+
+// normalizeFavorite validates a favorite and fills defaults
+func normalizeFavorite(favorite *FavoriteTab, now time.Time) error {
+	favorite.URL = strings.TrimSpace(favorite.URL)
+	if favorite.URL == "" {
+		return fmt.Errorf("%w: url is required", ErrInvalidFavorite)
+	}
+	favorite.Title = strings.TrimSpace(favorite.Title)
+	if favorite.Title == "" {
+		favorite.Title = favorite.URL
+	}
+
+	if favorite.Priority == 0 {
+		favorite.Priority = DefaultFavoritePriority
+	}
+	if favorite.Priority < MinFavoritePriority || favorite.Priority > MaxFavoritePriority {
+		return fmt.Errorf("%w: priority must be between %d and %d", ErrInvalidFavorite, MinFavoritePriority, MaxFavoritePriority)
+	}
+	if favorite.Usage.VisitCount < 0 {
+		return fmt.Errorf("%w: usage.visitCount must not be negative", ErrInvalidFavorite)
+	}
+	if favorite.Usage.LastAccess != "" {
+		lastAccess, err := ParseTaskTime(favorite.Usage.LastAccess)
+		if err != nil {
+			return fmt.Errorf("%w: usage.lastAccess must be an ISO timestamp", ErrInvalidFavorite)
+		}
+		favorite.Usage.LastAccess = formatTaskTime(lastAccess)
+	}
+
+	if favorite.DateAdded == "" {
+		favorite.DateAdded = formatTaskTime(now)
+	} else if dateAdded, err := ParseTaskTime(favorite.DateAdded); err == nil {
+		favorite.DateAdded = formatTaskTime(dateAdded)
+	} else {
+		return fmt.Errorf("%w: dateAdded must be an ISO timestamp", ErrInvalidFavorite)
+	}
+
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range favorite.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	favorite.Tags = tags
+	return nil
+}
+
+// scoreFavorites recalculates every favorite's score, as the extension does:
+// priority counts 50%, visits relative to the most visited favorite 30% and
+// recency 20% (full within a week of the last visit, half within 30 days)
+func scoreFavorites(favorites []*FavoriteTab, now time.Time) {
+	maxVisits := 1
+	for _, favorite := range favorites {
+		maxVisits = max(maxVisits, favorite.Usage.VisitCount)
+	}
+
+	for _, favorite := range favorites {
+		recency := 0.0
+		if lastAccess, err := ParseTaskTime(favorite.Usage.LastAccess); err == nil {
+			switch days := now.Sub(lastAccess).Hours() / 24; {
+			case days < 7:
+				recency = 1
+			case days < 30:
+				recency = 0.5
+			}
+		}
+		favorite.CalculatedScore = float64(favorite.Priority)*0.5 +
+			float64(favorite.Usage.VisitCount)/float64(maxVisits)*0.3 +
+			recency*0.2
+	}
+}
+
+// ListFavorites returns the user's favorites with current scores, in stored
+// order or, when sortBy is FavoriteSortScore, highest score first
+func (uds *UserDataService) ListFavorites(ctx context.Context, userID, sortBy string) ([]*FavoriteTab, error) {
+	if sortBy != "" && sortBy != FavoriteSortScore {
+		return nil, fmt.Errorf("%w: unsupported sort %q (expected score)", ErrInvalidFavorite, sortBy)
+	}
+
+	uds.mu.RLock()
+	favorites, err := uds.getFavorites(ctx, userID)
+	uds.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations.annotateFavorites(favorites)
+
+	// Recency decays between writes
+	scoreFavorites(favorites, time.Now())
+	if sortBy == FavoriteSortScore {
+		sort.SliceStable(favorites, func(i, j int) bool {
+			return favorites[i].CalculatedScore > favorites[j].CalculatedScore
+		})
+	}
+	return favorites, nil
+}
+
+// GetFavorite returns one favorite with its current score
+func (uds *UserDataService) GetFavorite(ctx context.Context, userID, favoriteID string) (*FavoriteTab, error) {
+	favorites, err := uds.ListFavorites(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	if i := findFavorite(favorites, favoriteID); i >= 0 {
+		return favorites[i], nil
+	}
+	return nil, ErrFavoriteNotFound
+}
+
+// CreateFavorite adds a favorite, assigning an ID when it has none. URLs
+// are unique.
+func (uds *UserDataService) CreateFavorite(ctx context.Context, userID string, favorite *FavoriteTab) error {
+	now := time.Now()
+	if err := normalizeFavorite(favorite, now); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	favorites, err := uds.getFavorites(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if favorite.ID == "" {
+		favorite.ID = fmt.Sprintf("fav_%d_%s", now.UnixMilli(), strings.ToLower(uds.firebaseService.firestore.Collection(COLLECTION_NAME).NewDoc().ID[:9]))
+	}
+	for _, existing := range favorites {
+		if existing.ID == favorite.ID || existing.URL == favorite.URL {
+			return ErrFavoriteExists
+		}
+	}
+
+	favorites = append(favorites, favorite)
+	if err := uds.putFavoritesLocked(ctx, userID, favorites, now); err != nil {
+		return err
+	}
+
+	log.Printf("Created favorite %s for user %s", favorite.ID, userID)
+	return nil
+}
+
+// UpdateFavorite replaces a favorite
+func (uds *UserDataService) UpdateFavorite(ctx context.Context, userID string, favorite *FavoriteTab) error {
+	now := time.Now()
+	if err := normalizeFavorite(favorite, now); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	favorites, err := uds.getFavorites(ctx, userID)
+	if err != nil {
+		return err
+	}
+	i := findFavorite(favorites, favorite.ID)
+	if i < 0 {
+		return ErrFavoriteNotFound
+	}
+	for j, existing := range favorites {
+		if j != i && existing.URL == favorite.URL {
+			return ErrFavoriteExists
+		}
+	}
+
+	favorites[i] = favorite
+	return uds.putFavoritesLocked(ctx, userID, favorites, now)
+}
+
+// DeleteFavorite removes a favorite
+func (uds *UserDataService) DeleteFavorite(ctx context.Context, userID, favoriteID string) error {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	favorites, err := uds.getFavorites(ctx, userID)
+	if err != nil {
+		return err
+	}
+	i := findFavorite(favorites, favoriteID)
+	if i < 0 {
+		return ErrFavoriteNotFound
+	}
+
+	favorites = append(favorites[:i], favorites[i+1:]...)
+	if err := uds.putFavoritesLocked(ctx, userID, favorites, time.Now()); err != nil {
+		return err
+	}
+
+	log.Printf("Deleted favorite %s for user %s", favoriteID, userID)
+	return nil
+}
+
+// VisitFavorite records a visit: the visit count goes up by one and the
+// last access becomes now, in a single write. The write commits only while
+// the list is at the version read, so a visit racing another server
+// instance is retried rather than lost.
+func (uds *UserDataService) VisitFavorite(ctx context.Context, userID, favoriteID string) (*FavoriteTab, error) {
+	for attempt := 1; ; attempt++ {
+		favorite, err := uds.visitFavorite(ctx, userID, favoriteID)
+		if !errors.Is(err, errVersionChanged) {
+			return favorite, err
+		}
+		if attempt == maxCheckedApplyAttempts {
+			return nil, ErrSyncContention
+		}
+	}
+}
+
+// visitFavorite makes one attempt at VisitFavorite. It fails with
+// errVersionChanged if the list changes before the write commits.
+func (uds *UserDataService) visitFavorite(ctx context.Context, userID, favoriteID string) (*FavoriteTab, error) {
+	now := time.Now()
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collection := getCollectionType(favoritesStorageKey)
+	entry, err := uds.getSyncLogEntry(ctx, userID, collection, favoritesStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	var version int64
+	if entry != nil {
+		version = entry.Version
+	}
+
+	favorites, err := uds.getFavorites(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	i := findFavorite(favorites, favoriteID)
+	if i < 0 {
+		return nil, ErrFavoriteNotFound
+	}
+
+	favorite := favorites[i]
+	favorite.Usage.VisitCount++
+	favorite.Usage.LastAccess = formatTaskTime(now)
+	checked := withExpectedVersion(ctx, collection, favoritesStorageKey, version)
+	if err := uds.putFavoritesLocked(checked, userID, favorites, now); err != nil {
+		return nil, err
+	}
+	return favorite, nil
+}
+
+// getFavorites reads the favorites list; callers hold uds.mu
+func (uds *UserDataService) getFavorites(ctx context.Context, userID string) ([]*FavoriteTab, error) {
+	favorites := []*FavoriteTab{}
+	if _, err := uds.readStoredValue(ctx, userID, favoritesStorageKey, &favorites); err != nil {
+		return nil, err
+	}
+
+	// Favorites saved before priorities and usage were tracked
+	kept := favorites[:0]
+	for _, favorite := range favorites {
+		if favorite == nil {
+			continue
+		}
+		if favorite.Priority == 0 {
+			favorite.Priority = DefaultFavoritePriority
+		}
+		if favorite.Tags == nil {
+			favorite.Tags = []string{}
+		}
+		kept = append(kept, favorite)
+	}
+	return kept, nil
+}
+
+// putFavoritesLocked rescores and stores the favorites list; callers hold
+// uds.mu
+func (uds *UserDataService) putFavoritesLocked(ctx context.Context, userID string, favorites []*FavoriteTab, now time.Time) error {
+	scoreFavorites(favorites, now)
+	for _, favorite := range favorites {
+		favorite.LinkStatus = nil
+	}
+
+	batch := &changeBatch{}
+	change, err := uds.stageStoredValue(ctx, batch, userID, favoritesStorageKey, favorites)
+	if err != nil {
+		return err
+	}
+	if err := uds.commitChanges(ctx, batch, userID, change); err != nil {
+		return fmt.Errorf("failed to store favorites: %w", err)
+	}
+	return nil
+}
+
+// findFavorite returns the index of the favorite with id, or -1
+func findFavorite(favorites []*FavoriteTab, id string) int {
+	for i, favorite := range favorites {
+		if id != "" && favorite.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Focus session storage keys, shared with the generic storage API
+const (
+	currentFocusSessionKey = "currentFocusSession"
+	taskFocusDataKey       = "taskFocusData"
+)
+
+// Active focus session states
+const (
+	FocusStatusRunning = "running"
+	FocusStatusPaused  = "paused"
+)
+
+// Focus session errors
+var (
+	ErrFocusSessionActive   = errors.New("a focus session is already active")
+	ErrNoActiveFocusSession = errors.New("no active focus session")
+	ErrFocusSessionState    = errors.New("focus session cannot change state")
+)
+
+// ActiveFocusSession is the user's running or paused focus session
+type ActiveFocusSession struct {
+	FocusSession
+	Status string `json:"status"`
+	// PausedAt is set while paused; PausedMinutes totals earlier pauses
+	PausedAt      string  `json:"pausedAt,omitempty"`
+	PausedMinutes float64 `json:"pausedMinutes,omitempty"`
+	// ElapsedMinutes is the focused time so far, computed when read
+	ElapsedMinutes float64 `json:"elapsedMinutes"`
+}
+
+// TaskFocusData rolls up a task's completed focus sessions
+type TaskFocusData struct {
+	TaskID           string         `json:"taskId"`
+	TotalFocusTime   float64        `json:"totalFocusTime"`
+	AverageFocusTime float64        `json:"averageFocusTime"`
+	TotalSessions    int            `json:"totalSessions"`
+	Sessions         []FocusSession `json:"sessions"`
+}
+
+// FocusStopResult is a completed session with its task's updated totals
+type FocusStopResult struct {
+	Session       FocusSession  `json:"session"`
+	TaskFocusData TaskFocusData `json:"taskFocusData"`
+}
+
+// getActiveFocusSession reads the active session; callers hold uds.mu
+func (uds *UserDataService) getActiveFocusSession(ctx context.Context, userID string) (*ActiveFocusSession, error) {
+	var session ActiveFocusSession
+	exists, err := uds.readStoredValue(ctx, userID, currentFocusSessionKey, &session)
+	if err != nil {
+		return nil, err
+	}
+	if !exists || session.ID == "" || session.EndTime != "" {
+		return nil, ErrNoActiveFocusSession
+	}
+	if session.Status == "" {
+		// Sessions started by clients before the server kept them
+		session.Status = FocusStatusRunning
+	}
+	return &session, nil
+}
+
+// putActiveFocusSession stores the active session; callers hold uds.mu
+func (uds *UserDataService) putActiveFocusSession(ctx context.Context, userID string, session *ActiveFocusSession) error {
+	stored := *session
+	stored.ElapsedMinutes = 0
+	batch := &changeBatch{}
+	change, err := uds.stageStoredValue(ctx, batch, userID, currentFocusSessionKey, stored)
+	if err != nil {
+		return err
+	}
+	if err := uds.commitChanges(ctx, batch, userID, change); err != nil {
+		return fmt.Errorf("failed to store focus session: %w", err)
+	}
+	return nil
+}
+
+// focusedMinutes returns the time focused in a session up to now: the time
+// since it started less the time spent paused
+func (session *ActiveFocusSession) focusedMinutes(now time.Time) float64 {
+	start, err := ParseTaskTime(session.StartTime)
+	if err != nil {
+		return 0
+	}
+	minutes := now.Sub(start).Minutes() - session.PausedMinutes
+	if pausedAt, err := ParseTaskTime(session.PausedAt); session.PausedAt != "" && err == nil {
+		minutes -= now.Sub(pausedAt).Minutes()
+	}
+	return math.Max(minutes, 0)
+}
+
+// GetActiveFocusSession returns the user's active focus session
+func (uds *UserDataService) GetActiveFocusSession(ctx context.Context, userID string) (*ActiveFocusSession, error) {
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	session, err := uds.getActiveFocusSession(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	session.ElapsedMinutes = session.focusedMinutes(time.Now())
+	return session, nil
+}
+
+// StartFocusSession starts a focus session on a task. A user has at most one
+// active session.
+func (uds *UserDataService) StartFocusSession(ctx context.Context, userID, taskID string) (*ActiveFocusSession, error) {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	if _, err := uds.getActiveFocusSession(ctx, userID); !errors.Is(err, ErrNoActiveFocusSession) {
+		if err == nil {
+			return nil, ErrFocusSessionActive
+		}
+		return nil, err
+	}
+	if _, err := uds.getTask(ctx, userID, taskID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &ActiveFocusSession{
+		FocusSession: FocusSession{
+			ID:        fmt.Sprintf("focus_%d_%s", now.UnixMilli(), uds.firebaseService.firestore.Collection(COLLECTION_NAME).NewDoc().ID[:9]),
+			TaskID:    taskID,
+			StartTime: formatTaskTime(now),
+		},
+		Status: FocusStatusRunning,
+	}
+	if err := uds.putActiveFocusSession(ctx, userID, session); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Started focus session %s on task %s for user %s", session.ID, taskID, userID)
+	return session, nil
+}
+
+// PauseFocusSession pauses the running focus session
+func (uds *UserDataService) PauseFocusSession(ctx context.Context, userID string) (*ActiveFocusSession, error) {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	session, err := uds.getActiveFocusSession(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != FocusStatusRunning {
+		return nil, fmt.Errorf("%w: session is already paused", ErrFocusSessionState)
+	}
+
+	now := time.Now()
+	session.Status = FocusStatusPaused
+	session.PausedAt = formatTaskTime(now)
+	if err := uds.putActiveFocusSession(ctx, userID, session); err != nil {
+		return nil, err
+	}
+
+	session.ElapsedMinutes = session.focusedMinutes(now)
+	return session, nil
+}
+
+// ResumeFocusSession resumes the paused focus session
+func (uds *UserDataService) ResumeFocusSession(ctx context.Context, userID string) (*ActiveFocusSession, error) {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	session, err := uds.getActiveFocusSession(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != FocusStatusPaused {
+		return nil, fmt.Errorf("%w: session is not paused", ErrFocusSessionState)
+	}
+
+	now := time.Now()
+	if pausedAt, err := ParseTaskTime(session.PausedAt); err == nil {
+		session.PausedMinutes += now.Sub(pausedAt).Minutes()
+	}
+	session.Status = FocusStatusRunning
+	session.PausedAt = ""
+	if err := uds.putActiveFocusSession(ctx, userID, session); err != nil {
+		return nil, err
+	}
+
+	session.ElapsedMinutes = session.focusedMinutes(now)
+	return session, nil
+}
+
+// StopFocusSession ends the active focus session. In one batch it clears
+// the session, adds it to the task's focus data with recomputed totals and
+// copies those totals onto the task. The batch commits only while the focus
+// data and the task are at the versions read, so a stop racing another
+// server instance is retried rather than overwriting its write.
+func (uds *UserDataService) StopFocusSession(ctx context.Context, userID string) (*FocusStopResult, error) {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := uds.stopFocusSession(ctx, userID)
+		if !errors.Is(err, errVersionChanged) {
+			return result, err
+		}
+		if attempt == maxCheckedApplyAttempts {
+			return nil, ErrSyncContention
+		}
+	}
+}
+
+// stopFocusSession makes one attempt at StopFocusSession. It fails with
+// errVersionChanged if the focus data or the task changes before the batch
+// commits.
+func (uds *UserDataService) stopFocusSession(ctx context.Context, userID string) (*FocusStopResult, error) {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	active, err := uds.getActiveFocusSession(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := active.FocusSession
+	session.EndTime = formatTaskTime(now)
+	// Whole minutes, as clients have always recorded them
+	session.TotalMinutes = math.Round(active.focusedMinutes(now))
+
+	// Versions are read before the documents, so a write in between fails
+	// the commit rather than being missed
+	focusCollection := getCollectionType(taskFocusDataKey)
+	checked, err := uds.withCurrentVersion(ctx, userID, focusCollection, taskFocusDataKey)
+	if err != nil {
+		return nil, err
+	}
+	checked, err = uds.withCurrentVersion(checked, userID, "tasks", session.TaskID)
+	if err != nil {
+		return nil, err
+	}
+
+	focusData := map[string]*TaskFocusData{}
+	if _, err := uds.readStoredValue(ctx, userID, taskFocusDataKey, &focusData); err != nil {
+		return nil, err
+	}
+	taskData := focusData[session.TaskID]
+	if taskData == nil {
+		taskData = &TaskFocusData{TaskID: session.TaskID}
+		focusData[session.TaskID] = taskData
+	}
+	taskData.Sessions = append(taskData.Sessions, session)
+	rollUpFocusData(taskData)
+
+	batch := &changeBatch{}
+	change, err := uds.stageStoredValue(ctx, batch, userID, taskFocusDataKey, focusData)
+	if err != nil {
+		return nil, err
+	}
+	changes := []documentChange{change}
+
+	batch.Delete(uds.storedValueRef(userID, currentFocusSessionKey))
+	changes = append(changes, documentChange{getCollectionType(currentFocusSessionKey), currentFocusSessionKey, ChangeOperationDelete})
+
+	// The task may have been deleted while the session ran
+	task, err := uds.getTask(ctx, userID, session.TaskID)
+	switch {
+	case err == nil:
+		task.TotalFocusTime = taskData.TotalFocusTime
+		task.AverageFocusTime = taskData.AverageFocusTime
+		task.TotalSessions = taskData.TotalSessions
+		task.FocusSessions = taskData.Sessions
+		task.UpdatedAt = formatTaskTime(now)
+		batch.Set(uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID)).Doc(task.ID), task)
+		changes = append(changes, documentChange{"tasks", task.ID, ChangeOperationUpsert})
+	case !errors.Is(err, ErrTaskNotFound):
+		return nil, err
+	}
+
+	if err := uds.commitChanges(checked, batch, userID, changes...); err != nil {
+		return nil, fmt.Errorf("failed to store focus session: %w", err)
+	}
+
+	log.Printf("Stopped focus session %s on task %s for user %s after %.0f minutes", session.ID, session.TaskID, userID, session.TotalMinutes)
+	return &FocusStopResult{Session: session, TaskFocusData: *taskData}, nil
+}
+
+// rollUpFocusData recomputes a task's focus totals from its sessions
+func rollUpFocusData(data *TaskFocusData) {
+	data.TotalSessions = len(data.Sessions)
+	data.TotalFocusTime = 0
+	for _, session := range data.Sessions {
+		data.TotalFocusTime += session.TotalMinutes
+	}
+	data.AverageFocusTime = 0
+	if data.TotalSessions > 0 {
+		data.AverageFocusTime = math.Round(data.TotalFocusTime / float64(data.TotalSessions))
+	}
+}
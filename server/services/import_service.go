@@ -0,0 +1,622 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Archive format constants
+const (
+	ArchiveFormat  = "tab-blaster-archive"
+	ArchiveVersion = 1
+)
+
+// Import sources
+const (
+	ImportSourceArchive       = "archive"
+	ImportSourceChromeStorage = "chrome-storage"
+)
+
+// ImportStrategy controls how imported items are applied to existing data
+type ImportStrategy string
+
+const (
+	// ImportStrategyReplace makes each imported collection match the import exactly
+	ImportStrategyReplace ImportStrategy = "replace"
+	// ImportStrategyMerge upserts imported items by ID and keeps everything else
+	ImportStrategyMerge ImportStrategy = "merge"
+	// ImportStrategyNewest only overwrites existing items when the import is newer
+	ImportStrategyNewest ImportStrategy = "newest"
+)
+
+// AccountArchive is the portable representation of a user's data
+type AccountArchive struct {
+	Format     string                 `json:"format"`
+	Version    int                    `json:"version"`
+	ExportedAt string                 `json:"exportedAt,omitempty"`
+	Sessions   []*Session             `json:"sessions,omitempty"`
+	SavedTabs  []*SavedTab            `json:"savedTabs,omitempty"`
+	Settings   map[string]interface{} `json:"settings,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"` // storage key -> value
+
+	Source      string   `json:"-"`
+	IgnoredKeys []string `json:"-"`
+}
+
+// ImportOptions configures an import run
+type ImportOptions struct {
+	Strategy ImportStrategy
+	DryRun   bool
+}
+
+// ImportCounts reports what happened to the items of one collection
+type ImportCounts struct {
+	Created int      `json:"created"`
+	Updated int      `json:"updated"`
+	Skipped int      `json:"skipped"`
+	Deleted int      `json:"deleted"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// ImportReport summarizes an import run per collection
+type ImportReport struct {
+	Strategy    ImportStrategy           `json:"strategy"`
+	DryRun      bool                     `json:"dryRun"`
+	Source      string                   `json:"source"`
+	IgnoredKeys []string                 `json:"ignoredKeys,omitempty"`
+	Collections map[string]*ImportCounts `json:"collections"`
+}
+
+// ParseImportStrategy validates a strategy name, defaulting to merge
+func ParseImportStrategy(name string) (ImportStrategy, error) {
+	switch ImportStrategy(name) {
+	case "":
+		return ImportStrategyMerge, nil
+	case ImportStrategyReplace, ImportStrategyMerge, ImportStrategyNewest:
+		return ImportStrategy(name), nil
+	default:
+		return "", fmt.Errorf("unknown import strategy %q (expected replace, merge or newest)", name)
+	}
+}
+
+// ParseImportPayload decodes either an account archive or a raw
+// chrome.storage dump from the extension
+func ParseImportPayload(payload []byte) (*AccountArchive, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("import payload must be a JSON object: %w", err)
+	}
+
+	if _, ok := raw["format"]; ok {
+		var archive AccountArchive
+		if err := json.Unmarshal(payload, &archive); err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		archive.Source = ImportSourceArchive
+		if err := archive.Validate(); err != nil {
+			return nil, err
+		}
+		return &archive, nil
+	}
+
+	// Anything without a format marker is treated as a chrome.storage dump,
+	// keyed by the extension's storage keys
+	archive := &AccountArchive{
+		Format:  ArchiveFormat,
+		Version: ArchiveVersion,
+		Source:  ImportSourceChromeStorage,
+		Data:    make(map[string]interface{}),
+	}
+	for key, value := range raw {
+		var err error
+		switch key {
+		case "sessions":
+			err = json.Unmarshal(value, &archive.Sessions)
+		case "savedTabs":
+			err = json.Unmarshal(value, &archive.SavedTabs)
+		case "settings":
+			err = json.Unmarshal(value, &archive.Settings)
+		default:
+			if _, known := STORAGE_KEY_TO_COLLECTION_TYPE[key]; !known {
+				archive.IgnoredKeys = append(archive.IgnoredKeys, key)
+				continue
+			}
+			var decoded interface{}
+			err = json.Unmarshal(value, &decoded)
+			archive.Data[key] = decoded
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for storage key %s: %w", key, err)
+		}
+	}
+
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Validate checks the archive structure. Individual items are validated
+// while importing so that one bad item does not reject the whole archive.
+func (a *AccountArchive) Validate() error {
+	if a.Format != ArchiveFormat {
+		return fmt.Errorf("unsupported archive format %q", a.Format)
+	}
+	if a.Version < 1 || a.Version > ArchiveVersion {
+		return fmt.Errorf("unsupported archive version %d", a.Version)
+	}
+
+	for key := range a.Data {
+		switch key {
+		case "sessions", "savedTabs", "settings":
+			return fmt.Errorf("storage key %s must be provided as a top-level collection", key)
+		}
+		if _, known := STORAGE_KEY_TO_COLLECTION_TYPE[key]; !known {
+			return fmt.Errorf("unknown storage key %s", key)
+		}
+	}
+
+	sessionIDs := make(map[string]bool)
+	for i, session := range a.Sessions {
+		if session == nil {
+			return fmt.Errorf("sessions[%d] is null", i)
+		}
+		if session.ID == "" {
+			continue
+		}
+		if sessionIDs[session.ID] {
+			return fmt.Errorf("duplicate session id %s", session.ID)
+		}
+		sessionIDs[session.ID] = true
+	}
+
+	tabIDs := make(map[int]bool)
+	for i, tab := range a.SavedTabs {
+		if tab == nil {
+			return fmt.Errorf("savedTabs[%d] is null", i)
+		}
+		if tab.ID == 0 {
+			continue
+		}
+		if tabIDs[tab.ID] {
+			return fmt.Errorf("duplicate saved tab id %d", tab.ID)
+		}
+		tabIDs[tab.ID] = true
+	}
+
+	return nil
+}
+
+// validateImportedSession checks a single session before it is written
+func validateImportedSession(session *Session) error {
+	if session.Name == "" {
+		return fmt.Errorf("session %s has no name", session.ID)
+	}
+	if session.LastModified != "" {
+		if _, ok := parseTimestamp(session.LastModified); !ok {
+			return fmt.Errorf("session %s has invalid lastModified %q", session.ID, session.LastModified)
+		}
+	}
+	return nil
+}
+
+// validateImportedSavedTab checks a single saved tab before it is written
+func validateImportedSavedTab(tab *SavedTab) error {
+	if tab.URL == "" {
+		return fmt.Errorf("saved tab %d has no url", tab.ID)
+	}
+	if tab.SavedAt != "" {
+		if _, ok := parseTimestamp(tab.SavedAt); !ok {
+			return fmt.Errorf("saved tab %d has invalid savedAt %q", tab.ID, tab.SavedAt)
+		}
+	}
+	return nil
+}
+
+// parseTimestamp parses the ISO timestamps produced by the extension
+func parseTimestamp(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// isNewer reports whether the incoming timestamp should replace the existing one.
+// Items without a usable incoming timestamp never win.
+func isNewer(incoming, existing string) bool {
+	incomingTime, ok := parseTimestamp(incoming)
+	if !ok {
+		return false
+	}
+	existingTime, ok := parseTimestamp(existing)
+	if !ok {
+		return true
+	}
+	return incomingTime.After(existingTime)
+}
+
+// ImportArchive applies an archive to the user's data using the given strategy
+func (uds *UserDataService) ImportArchive(ctx context.Context, userID string, archive *AccountArchive, opts ImportOptions) (*ImportReport, error) {
+	if opts.Strategy == "" {
+		opts.Strategy = ImportStrategyMerge
+	}
+
+	report := &ImportReport{
+		Strategy:    opts.Strategy,
+		DryRun:      opts.DryRun,
+		Source:      archive.Source,
+		IgnoredKeys: archive.IgnoredKeys,
+		Collections: make(map[string]*ImportCounts),
+	}
+
+	if archive.Sessions != nil {
+		counts, err := uds.importSessions(ctx, userID, archive.Sessions, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Collections["sessions"] = counts
+	}
+
+	if archive.SavedTabs != nil {
+		counts, err := uds.importSavedTabs(ctx, userID, archive.SavedTabs, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Collections["savedTabs"] = counts
+	}
+
+	if archive.Settings != nil {
+		counts, err := uds.importSettings(ctx, userID, archive.Settings, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Collections["settings"] = counts
+	}
+
+	for key, value := range archive.Data {
+		report.Collections[key] = uds.importDataValue(ctx, userID, key, value, opts)
+	}
+
+	log.Printf("Imported %s for user %s (strategy=%s, dryRun=%t)", archive.Source, userID, opts.Strategy, opts.DryRun)
+	return report, nil
+}
+
+func (uds *UserDataService) importSessions(ctx context.Context, userID string, sessions []*Session, opts ImportOptions) (*ImportCounts, error) {
+	existingSessions, err := uds.GetUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*Session, len(existingSessions))
+	for _, session := range existingSessions {
+		existing[session.ID] = session
+	}
+
+	counts := &ImportCounts{}
+	seen := make(map[string]bool)
+	for _, session := range sessions {
+		if err := validateImportedSession(session); err != nil {
+			counts.fail(err)
+			continue
+		}
+		seen[session.ID] = true
+
+		current, exists := existing[session.ID]
+		if exists && opts.Strategy == ImportStrategyNewest && !isNewer(session.LastModified, current.LastModified) {
+			counts.Skipped++
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := uds.StoreUserSession(ctx, userID, session); err != nil {
+				counts.fail(err)
+				continue
+			}
+		}
+		if exists {
+			counts.Updated++
+		} else {
+			counts.Created++
+		}
+	}
+
+	if opts.Strategy == ImportStrategyReplace {
+		for id := range existing {
+			if seen[id] {
+				continue
+			}
+			if !opts.DryRun {
+				if err := uds.DeleteUserSession(ctx, userID, id); err != nil {
+					counts.fail(err)
+					continue
+				}
+			}
+			counts.Deleted++
+		}
+	}
+
+	return counts, nil
+}
+
+func (uds *UserDataService) importSavedTabs(ctx context.Context, userID string, tabs []*SavedTab, opts ImportOptions) (*ImportCounts, error) {
+	existingTabs, err := uds.GetUserSavedTabs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[int]*SavedTab, len(existingTabs))
+	for _, tab := range existingTabs {
+		existing[tab.ID] = tab
+	}
+
+	counts := &ImportCounts{}
+	seen := make(map[int]bool)
+	var toStore []*SavedTab
+	var replacing []bool // Whether each tab to store replaces an existing one
+	for _, tab := range tabs {
+		if err := validateImportedSavedTab(tab); err != nil {
+			counts.fail(err)
+			continue
+		}
+		seen[tab.ID] = true
+
+		current, exists := existing[tab.ID]
+		if exists && opts.Strategy == ImportStrategyNewest && !isNewer(tab.SavedAt, current.SavedAt) {
+			counts.Skipped++
+			continue
+		}
+		toStore = append(toStore, tab)
+		replacing = append(replacing, exists)
+	}
+
+	// Saved tabs are stored in chunks; when one fails, the tabs before it
+	// are stored and the rest are not
+	stored := len(toStore)
+	var storeErr error
+	if len(toStore) > 0 && !opts.DryRun {
+		stored, storeErr = uds.storeSavedTabs(ctx, userID, toStore)
+		if storeErr != nil {
+			counts.Failed += len(toStore) - stored
+			counts.Errors = append(counts.Errors, storeErr.Error())
+		}
+	}
+	for _, exists := range replacing[:stored] {
+		if exists {
+			counts.Updated++
+		} else {
+			counts.Created++
+		}
+	}
+
+	// Replacing deletes the tabs missing from the import, but only once the
+	// import is fully stored
+	if opts.Strategy == ImportStrategyReplace && storeErr == nil {
+		for id := range existing {
+			if seen[id] {
+				continue
+			}
+			if !opts.DryRun {
+				if err := uds.DeleteSavedTab(ctx, userID, id); err != nil {
+					counts.fail(err)
+					continue
+				}
+			}
+			counts.Deleted++
+		}
+	}
+
+	return counts, nil
+}
+
+// importSettings applies settings key by key. Settings carry no timestamps,
+// so the newest strategy keeps existing values and only adds missing keys.
+func (uds *UserDataService) importSettings(ctx context.Context, userID string, settings map[string]interface{}, opts ImportOptions) (*ImportCounts, error) {
+	existing, err := uds.GetUserSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := &ImportCounts{}
+	result := make(map[string]interface{})
+	if opts.Strategy != ImportStrategyReplace {
+		for key, value := range existing {
+			result[key] = value
+		}
+	}
+
+	for key, value := range settings {
+		current, exists := existing[key]
+		switch {
+		case !exists:
+			counts.Created++
+		case opts.Strategy == ImportStrategyNewest || reflect.DeepEqual(current, value):
+			counts.Skipped++
+			continue
+		default:
+			counts.Updated++
+		}
+		result[key] = value
+	}
+
+	if opts.Strategy == ImportStrategyReplace {
+		for key := range existing {
+			if _, ok := settings[key]; !ok {
+				counts.Deleted++
+			}
+		}
+		for key, value := range settings {
+			result[key] = value
+		}
+	}
+
+	if !opts.DryRun && (counts.Created+counts.Updated+counts.Deleted) > 0 {
+		if err := uds.SaveUserSettings(ctx, userID, result); err != nil {
+			counts.Failed += counts.Created + counts.Updated + counts.Deleted
+			counts.Created, counts.Updated, counts.Deleted = 0, 0, 0
+			counts.Errors = append(counts.Errors, err.Error())
+		}
+	}
+
+	return counts, nil
+}
+
+// importDataValue applies a generic storage value. Lists of objects with an
+// "id" field are merged item by item; any other value is treated as one item.
+func (uds *UserDataService) importDataValue(ctx context.Context, userID, key string, value interface{}, opts ImportOptions) *ImportCounts {
+	counts := &ImportCounts{}
+
+	current, err := uds.GetUserData(ctx, userID, key)
+	exists := err == nil
+	if err != nil && status.Code(err) != codes.NotFound {
+		// Merging against data we could not read would drop it on write
+		counts.fail(err)
+		return counts
+	}
+
+	result := value
+	incomingItems, incomingOK := itemsByID(value)
+	currentItems, currentOK := itemsByID(current)
+	switch {
+	case incomingOK && (!exists || currentOK):
+		result = mergeItems(incomingItems, currentItems, value, current, opts.Strategy, counts)
+	case !exists:
+		counts.Created++
+	case reflect.DeepEqual(current, value):
+		counts.Skipped++
+	case opts.Strategy == ImportStrategyNewest && !isNewer(itemTimestamp(value), itemTimestamp(current)):
+		counts.Skipped++
+	default:
+		counts.Updated++
+	}
+
+	if opts.DryRun || counts.Created+counts.Updated+counts.Deleted == 0 {
+		return counts
+	}
+
+	if err := uds.SetUserData(ctx, userID, key, result); err != nil {
+		counts.Failed += counts.Created + counts.Updated + counts.Deleted
+		counts.Created, counts.Updated, counts.Deleted = 0, 0, 0
+		counts.Errors = append(counts.Errors, err.Error())
+	}
+	return counts
+}
+
+// mergeItems combines two lists of identified items and returns the list to store
+func mergeItems(incoming, current map[string]map[string]interface{}, incomingList, currentList interface{}, strategy ImportStrategy, counts *ImportCounts) interface{} {
+	var result []interface{}
+	if strategy != ImportStrategyReplace {
+		// Keep existing order and append new items after it
+		if list, ok := currentList.([]interface{}); ok {
+			result = append(result, list...)
+		}
+	}
+	index := make(map[string]int, len(result))
+	for i, item := range result {
+		if id, ok := itemID(item); ok {
+			index[id] = i
+		}
+	}
+
+	for _, item := range incomingList.([]interface{}) {
+		id, _ := itemID(item)
+		existingItem, exists := current[id]
+		switch {
+		case !exists:
+			counts.Created++
+		case reflect.DeepEqual(existingItem, incoming[id]):
+			counts.Skipped++
+			if strategy != ImportStrategyReplace {
+				continue
+			}
+		case strategy == ImportStrategyNewest && !isNewer(itemTimestamp(incoming[id]), itemTimestamp(existingItem)):
+			counts.Skipped++
+			continue
+		default:
+			counts.Updated++
+		}
+
+		if i, ok := index[id]; ok {
+			result[i] = item
+		} else {
+			index[id] = len(result)
+			result = append(result, item)
+		}
+	}
+
+	if strategy == ImportStrategyReplace {
+		for id := range current {
+			if _, ok := incoming[id]; !ok {
+				counts.Deleted++
+			}
+		}
+	}
+
+	if result == nil {
+		result = []interface{}{}
+	}
+	return result
+}
+
+// itemsByID indexes a list of JSON objects by their "id" field
+func itemsByID(value interface{}) (map[string]map[string]interface{}, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	items := make(map[string]map[string]interface{}, len(list))
+	for _, item := range list {
+		id, ok := itemID(item)
+		if !ok {
+			return nil, false
+		}
+		items[id] = item.(map[string]interface{})
+	}
+	return items, true
+}
+
+// itemID returns the string form of an object's "id" field
+func itemID(item interface{}) (string, bool) {
+	object, ok := item.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	switch id := object["id"].(type) {
+	case string:
+		return id, id != ""
+	case float64:
+		return fmt.Sprintf("%v", id), true
+	case int64:
+		return fmt.Sprintf("%d", id), true
+	default:
+		return "", false
+	}
+}
+
+// itemTimestamp returns the modification time recorded on an object, if any
+func itemTimestamp(item interface{}) string {
+	object, ok := item.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	for _, field := range []string{"lastModified", "updatedAt"} {
+		if value, ok := object[field].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (c *ImportCounts) fail(err error) {
+	c.Failed++
+	c.Errors = append(c.Errors, err.Error())
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+
+	"cloud.google.com/go/firestore"
+)
+
+// DefaultBlobSpillBytes is the encoded size above which sessions and stored
+// values move to the blob store. It leaves headroom under Firestore's 1 MiB
+// document limit, as Firestore counts sizes differently from JSON.
+const DefaultBlobSpillBytes = 900 << 10
+
+// DefaultCompressBytes is the JSON size above which sessions and stored
+// values are gzipped before they are stored
+const DefaultCompressBytes = 32 << 10
+
+// maxUnpackedBytes bounds a packed document once decompressed
+const maxUnpackedBytes = 64 << 20
+
+// Fields of documents whose data is stored packed rather than as fields
+const (
+	// blobRefField holds a BlobRef in place of a spilled document's data
+	blobRefField = "blobRef"
+	// packedField holds a compressed document's JSON
+	packedField = "packed"
+	// encodingField names the compression of packedField
+	encodingField = "encoding"
+)
+
+// gzipEncoding marks data compressed with gzip
+const gzipEncoding = "gzip"
+
+// BlobRef points from a Firestore document to its data in the blob store
+type BlobRef struct {
+	Key      string `json:"key" firestore:"key"`
+	Size     int    `json:"size" firestore:"size"`
+	Encoding string `json:"encoding,omitempty" firestore:"encoding,omitempty"`
+
+	// data is the blob's content until commitChanges uploads it
+	data []byte
+}
+
+// spillBlobKey returns a new blob key for a spilled document. Every write
+// gets its own key, so the blob a committed document points to is never
+// overwritten; commitChanges deletes it once a later write has replaced it.
+func spillBlobKey(userID, collection, documentID string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("spill/%s/%s/%s/%x.json", url.PathEscape(userID), collection, url.PathEscape(documentID), suffix), nil
+}
+
+// pack returns the fields storing v when its JSON encoding is large: gzipped
+// above the compress size and, if still over the spill size, bound for the
+// blob store with a reference left behind. nil means v is stored as is.
+// Spilled data is uploaded by commitChanges when the document is committed.
+func (uds *UserDataService) pack(ctx context.Context, userID, collection, documentID string, v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := ""
+	if uds.compressBytes > 0 && len(data) > uds.compressBytes {
+		compressed, err := gzipBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s %s: %w", collection, documentID, err)
+		}
+		// Already compressed data, such as inline images, can grow
+		if len(compressed) < len(data) {
+			data, encoding = compressed, gzipEncoding
+		}
+	}
+
+	if len(data) <= uds.spillBytes {
+		if encoding == "" {
+			return nil, nil
+		}
+		return map[string]interface{}{
+			packedField:   data,
+			encodingField: encoding,
+		}, nil
+	}
+
+	key, err := spillBlobKey(userID, collection, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to spill %s %s: %w", collection, documentID, err)
+	}
+	return map[string]interface{}{
+		blobRefField: &BlobRef{Key: key, Size: len(data), Encoding: encoding, data: data},
+	}, nil
+}
+
+// pendingBlob returns the blob a staged document spills to that has yet to
+// be uploaded, or nil
+func pendingBlob(data interface{}) *BlobRef {
+	document, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	ref, _ := document[blobRefField].(*BlobRef)
+	if ref == nil || ref.data == nil {
+		return nil
+	}
+	return ref
+}
+
+// putPendingBlobs uploads the spilled data of a batch's documents and
+// returns their keys. On failure the blobs already written are removed.
+func (uds *UserDataService) putPendingBlobs(ctx context.Context, batch *changeBatch) ([]string, error) {
+	var keys []string
+	for _, write := range batch.writes {
+		ref := pendingBlob(write.data)
+		if ref == nil {
+			continue
+		}
+		if err := uds.blobs.Put(ctx, ref.Key, ref.data); err != nil {
+			uds.deleteBlobs(ctx, keys)
+			return nil, fmt.Errorf("failed to spill %s: %w", write.ref.Path, err)
+		}
+		log.Printf("Spilled %d bytes of %s to the blob store", len(ref.data), write.ref.Path)
+		keys = append(keys, ref.Key)
+	}
+	return keys, nil
+}
+
+// deleteBlobs removes spilled blobs that no committed document points to
+func (uds *UserDataService) deleteBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := uds.blobs.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete spilled blob %s: %v", key, err)
+		}
+	}
+}
+
+// spilledRef returns the blob reference a document holds in place of its
+// data, or nil
+func spilledRef(doc *firestore.DocumentSnapshot) *BlobRef {
+	return spilledRefOf(doc.Data())
+}
+
+// spilledRefOf returns the blob reference among a document's fields, or nil
+func spilledRefOf(fields map[string]interface{}) *BlobRef {
+	ref, ok := fields[blobRefField].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	key, _ := ref["key"].(string)
+	if key == "" {
+		return nil
+	}
+	size, _ := ref["size"].(int64)
+	encoding, _ := ref["encoding"].(string)
+	return &BlobRef{Key: key, Size: int(size), Encoding: encoding}
+}
+
+// unpack decodes a packed document's data into v, reading spilled data from
+// the blob store. It reports false for documents stored as plain fields.
+func (uds *UserDataService) unpack(ctx context.Context, doc *firestore.DocumentSnapshot, v interface{}) (bool, error) {
+	return uds.unpackFields(ctx, doc.Ref.Path, doc.Data(), v)
+}
+
+// unpackFields is unpack for the fields of the document at path
+func (uds *UserDataService) unpackFields(ctx context.Context, path string, fields map[string]interface{}, v interface{}) (bool, error) {
+	var data []byte
+	var encoding string
+	if ref := spilledRefOf(fields); ref != nil {
+		blob, err := uds.blobs.Get(ctx, ref.Key)
+		if err != nil {
+			return false, err
+		}
+		data, encoding = blob, ref.Encoding
+	} else if packed, exists := fields[packedField]; exists {
+		if data, _ = packed.([]byte); data == nil {
+			return false, fmt.Errorf("invalid packed data in %s", path)
+		}
+		encoding, _ = fields[encodingField].(string)
+	} else {
+		return false, nil
+	}
+
+	data, err := decodePacked(data, encoding)
+	if err != nil {
+		return false, fmt.Errorf("failed to unpack %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse packed %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodePacked reverses a packed document's encoding
+func decodePacked(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case gzipEncoding:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxUnpackedBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > maxUnpackedBytes {
+			return nil, fmt.Errorf("decompressed data exceeds %d bytes", maxUnpackedBytes)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+// sessionDocument returns what to store for a session: the session itself,
+// or its identifying fields alongside the packed session
+func (uds *UserDataService) sessionDocument(ctx context.Context, userID string, session *Session) (interface{}, error) {
+	// Link statuses live in annotations, also when a session is packed
+	for i := range session.Tabs {
+		session.Tabs[i].LinkStatus = nil
+	}
+	document, err := uds.pack(ctx, userID, "sessions", session.ID, session)
+	if err != nil {
+		return nil, err
+	}
+	if document == nil {
+		return session, nil
+	}
+	document["id"] = session.ID
+	document["name"] = session.Name
+	document["lastModified"] = session.LastModified
+	return document, nil
+}
+
+// decodeSession decodes a session document, unpacking compressed and
+// spilled sessions
+func (uds *UserDataService) decodeSession(ctx context.Context, doc *firestore.DocumentSnapshot) (*Session, error) {
+	var session Session
+	packed, err := uds.unpack(ctx, doc, &session)
+	if err != nil {
+		return nil, err
+	}
+	if !packed {
+		if err := doc.DataTo(&session); err != nil {
+			return nil, err
+		}
+	}
+	return &session, nil
+}
+
+// stageSession returns a stagedWrite that stores a session, packing it if
+// needed
+func (uds *UserDataService) stageSession(ctx context.Context, userID string, session *Session) stagedWrite {
+	return func(batch *changeBatch) (documentChange, error) {
+		document, err := uds.sessionDocument(ctx, userID, session)
+		if err != nil {
+			return documentChange{}, err
+		}
+		batch.Set(uds.firebaseService.firestore.Collection(getSessionsCollectionPath(userID)).Doc(session.ID), document)
+		return documentChange{"sessions", session.ID, ChangeOperationUpsert}, nil
+	}
+}
+
+// storedValueDocument returns the document storing a generic storage key's
+// value: the value itself, or the packed value
+func (uds *UserDataService) storedValueDocument(ctx context.Context, userID, key string, value interface{}) (map[string]interface{}, error) {
+	document, err := uds.pack(ctx, userID, getCollectionType(key), key, value)
+	if err != nil {
+		return nil, err
+	}
+	if document == nil {
+		document = map[string]interface{}{"value": value}
+	}
+	document["timestamp"] = firestore.ServerTimestamp
+	return document, nil
+}
+
+// storedValue returns the value of a generic storage document, unpacking
+// compressed and spilled values. Documents written without the value
+// wrapper are returned whole.
+func (uds *UserDataService) storedValue(ctx context.Context, doc *firestore.DocumentSnapshot) (interface{}, error) {
+	var value interface{}
+	packed, err := uds.unpack(ctx, doc, &value)
+	if err != nil {
+		return nil, err
+	}
+	if packed {
+		return value, nil
+	}
+	data := doc.Data()
+	if value, exists := data["value"]; exists {
+		return value, nil
+	}
+	return data, nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+// storedFields returns a packed document as Firestore hands it back, with
+// the blob reference as a map of its fields
+func storedFields(document map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(document))
+	for name, value := range document {
+		if ref, ok := value.(*BlobRef); ok {
+			value = map[string]interface{}{"key": ref.Key, "size": int64(ref.Size), "encoding": ref.Encoding}
+		}
+		fields[name] = value
+	}
+	return fields
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	random := make([]byte, 6000)
+	rand.Read(random)
+	noise := base64.StdEncoding.EncodeToString(random)
+
+	tests := []struct {
+		name         string
+		value        string
+		limit        int // Spill and compress above this many bytes instead of the defaults
+		wantPacked   bool
+		wantSpilled  bool
+		wantEncoding string
+	}{
+		{name: "small value stored as is", value: "hello"},
+		{name: "compressed inline", value: strings.Repeat("tab ", 500), wantPacked: true, wantEncoding: gzipEncoding},
+		{name: "compressed and spilled", value: strings.Repeat("tab ", 500) + noise, wantSpilled: true, wantEncoding: gzipEncoding},
+		// gzip's header and trailer outweigh anything it saves on a short value
+		{name: "spilled uncompressed when gzip grows it", value: noise[:40], limit: 16, wantSpilled: true},
+	}
+
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uds := &UserDataService{blobs: blobs, spillBytes: 4096, compressBytes: 1024}
+			if tt.limit > 0 {
+				uds.spillBytes, uds.compressBytes = tt.limit, tt.limit
+			}
+			input := map[string]interface{}{"id": "s1", "notes": tt.value}
+			document, err := uds.pack(ctx, "user/1", "sessions", "s1", input)
+			if err != nil {
+				t.Fatalf("pack() error = %v", err)
+			}
+			if document == nil {
+				if tt.wantPacked || tt.wantSpilled {
+					t.Fatal("pack() left the value unpacked")
+				}
+				return
+			}
+
+			ref := pendingBlob(document)
+			if (ref != nil) != tt.wantSpilled {
+				t.Fatalf("pack() spilled = %v, want %v", ref != nil, tt.wantSpilled)
+			}
+			encoding, _ := document[encodingField].(string)
+			if ref != nil {
+				encoding = ref.Encoding
+				if !strings.HasPrefix(ref.Key, "spill/user%2F1/sessions/s1/") {
+					t.Errorf("spill key = %s", ref.Key)
+				}
+				if _, err := blobs.Get(ctx, ref.Key); !errors.Is(err, ErrBlobNotFound) {
+					t.Errorf("pack() uploaded the blob before commit: %v", err)
+				}
+			}
+			if encoding != tt.wantEncoding {
+				t.Errorf("encoding = %q, want %q", encoding, tt.wantEncoding)
+			}
+
+			batch := &changeBatch{}
+			batch.Set(&firestore.DocumentRef{ID: "s1", Path: "sessions/s1"}, document)
+			keys, err := uds.putPendingBlobs(ctx, batch)
+			if err != nil {
+				t.Fatalf("putPendingBlobs() error = %v", err)
+			}
+			wantKeys := 0
+			if tt.wantSpilled {
+				wantKeys = 1
+			}
+			if len(keys) != wantKeys {
+				t.Errorf("putPendingBlobs() uploaded %v", keys)
+			}
+
+			var output map[string]interface{}
+			packed, err := uds.unpackFields(ctx, "sessions/s1", storedFields(document), &output)
+			if err != nil || !packed {
+				t.Fatalf("unpackFields() = %v, %v", packed, err)
+			}
+			if !reflect.DeepEqual(output, input) {
+				t.Error("unpackFields() did not restore the packed value")
+			}
+
+			uds.deleteBlobs(ctx, keys)
+			if _, err := uds.unpackFields(ctx, "sessions/s1", storedFields(document), &output); tt.wantSpilled && !errors.Is(err, ErrBlobNotFound) {
+				t.Errorf("unpackFields() after deleteBlobs() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPackGivesEachWriteItsOwnBlob(t *testing.T) {
+	uds := &UserDataService{spillBytes: 16}
+	first, err := uds.pack(context.Background(), "u", "sessions", "s1", strings.Repeat("x", 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := uds.pack(context.Background(), "u", "sessions", "s1", strings.Repeat("x", 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pendingBlob(first).Key == pendingBlob(second).Key {
+		t.Error("two writes of one document share a blob key")
+	}
+}
+
+func TestDecodePackedLimits(t *testing.T) {
+	bomb, err := gzipBytes(make([]byte, maxUnpackedBytes+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		wantErr  bool
+	}{
+		{"plain", []byte("{}"), "", false},
+		{"unknown encoding", []byte("{}"), "br", true},
+		{"not gzip", []byte("{}"), gzipEncoding, true},
+		{"decompresses past the limit", bomb, gzipEncoding, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodePacked(tt.data, tt.encoding); (err != nil) != tt.wantErr {
+				t.Errorf("decodePacked() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// LINK_CHECKS_COLLECTION_NAME indexes the users whose links are checked by
+// when the checker next needs to look at them
+const LINK_CHECKS_COLLECTION_NAME = "tab-blaster-5k-link-checks"
+
+// Link checker defaults
+const (
+	DefaultLinkCheckInterval  = 15 * time.Minute
+	DefaultLinkRecheckAge     = 7 * 24 * time.Hour
+	DefaultLinkCheckHostDelay = time.Second
+	DefaultLinkCheckTimeout   = 15 * time.Second
+	// LinkCheckUserAgent identifies the checker to sites and robots.txt
+	LinkCheckUserAgent = "TabBlasterLinkChecker/1.0 (+https://github.com/glennmartinez/tab-blaster-5000)"
+	// linkCheckUserPeriod is how often a user's links are looked at, which
+	// bounds how long a new link waits for its first check
+	linkCheckUserPeriod = 24 * time.Hour
+	// maxLinksPerUserRun bounds the URLs checked for one user per run; the
+	// rest continue on the next run
+	maxLinksPerUserRun = 200
+	// linkCheckBatchSize is the number of users read per run
+	linkCheckBatchSize = 20
+	// linkCheckConcurrency is the number of URLs checked at once; requests
+	// to one host are still spaced out
+	linkCheckConcurrency = 4
+	// maxCrawlDelay caps the Crawl-delay honoured from robots.txt
+	maxCrawlDelay = time.Minute
+)
+
+// Link types in broken link results
+const (
+	LinkTypeSession  = "session"
+	LinkTypeSavedTab = "savedTab"
+	LinkTypeFavorite = "favorite"
+)
+
+// Errors recorded for links that were deliberately not requested
+const (
+	linkErrorRobots  = "not checked: disallowed by robots.txt"
+	linkErrorPrivate = "not checked: private address"
+	// maxLinkErrorBytes bounds a recorded request error
+	maxLinkErrorBytes = 200
+)
+
+// LinkStatus is the link checker's latest result for an item's URL
+type LinkStatus struct {
+	// URL is the URL that was checked; an item whose URL changed since is
+	// checked again
+	URL        string `json:"url" firestore:"url"`
+	StatusCode int    `json:"statusCode,omitempty" firestore:"statusCode,omitempty"`
+	FinalURL   string `json:"finalUrl,omitempty" firestore:"finalUrl,omitempty"`
+	Redirected bool   `json:"redirected,omitempty" firestore:"redirected,omitempty"`
+	Broken     bool   `json:"broken" firestore:"broken"`
+	Error      string `json:"error,omitempty" firestore:"error,omitempty"`
+	CheckedAt  string `json:"checkedAt" firestore:"checkedAt"`
+}
+
+// BrokenLink is an item whose link is broken or, on request, redirects
+type BrokenLink struct {
+	Type       string      `json:"type"`
+	ID         string      `json:"id"`
+	TabID      int         `json:"tabId,omitempty"` // Tab within a session
+	Title      string      `json:"title"`
+	URL        string      `json:"url"`
+	LinkStatus *LinkStatus `json:"linkStatus"`
+}
+
+// GetBrokenLinks returns the user's sessions tabs, saved tabs and favorites
+// whose last check found them broken, and with redirects those that now
+// redirect elsewhere
+func (uds *UserDataService) GetBrokenLinks(ctx context.Context, userID string, redirects bool) ([]*BrokenLink, error) {
+	uds.scheduleUserJob(ctx, LINK_CHECKS_COLLECTION_NAME, userID)
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	sessions, err := uds.loadSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	savedTabs, err := uds.loadSavedTabs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	favorites, err := uds.getFavorites(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	reported := func(item annotatedItem) *LinkStatus {
+		link := annotations.get(item).LinkStatus
+		if link != nil && (link.Broken || (redirects && link.Redirected)) {
+			return link
+		}
+		return nil
+	}
+	links := []*BrokenLink{}
+	for _, session := range sessions {
+		for i := range session.Tabs {
+			tab := &session.Tabs[i]
+			if link := reported(sessionTabItem(session, tab)); link != nil {
+				links = append(links, &BrokenLink{Type: LinkTypeSession, ID: session.ID, TabID: tab.ID, Title: tab.Title, URL: tab.URL, LinkStatus: link})
+			}
+		}
+	}
+	for _, tab := range savedTabs {
+		if link := reported(savedTabItem(tab)); link != nil {
+			links = append(links, &BrokenLink{Type: LinkTypeSavedTab, ID: strconv.Itoa(tab.ID), Title: tab.Title, URL: tab.URL, LinkStatus: link})
+		}
+	}
+	for _, favorite := range favorites {
+		if link := reported(favoriteItem(favorite)); link != nil {
+			links = append(links, &BrokenLink{Type: LinkTypeFavorite, ID: favorite.ID, Title: favorite.Title, URL: favorite.URL, LinkStatus: link})
+		}
+	}
+
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].LinkStatus.CheckedAt > links[j].LinkStatus.CheckedAt
+	})
+	return links, nil
+}
+
+// LinkProber checks URLs politely: robots.txt is honoured and requests to
+// one host are spaced out
+type LinkProber struct {
+	client *politeClient
+}
+
+// NewLinkProber creates a link prober. allowPrivate lets it reach private
+// and loopback addresses, which is only meant for tests and trusted setups.
+func NewLinkProber(timeout, hostDelay time.Duration, allowPrivate bool) *LinkProber {
+	return &LinkProber{client: newPoliteClient(timeout, hostDelay, allowPrivate, LinkCheckUserAgent)}
+}
+
+// Check checks one http(s) URL with HEAD, falling back to GET for servers
+// that reject or mishandle HEAD. It returns nil when ctx ends first.
+func (lp *LinkProber) Check(ctx context.Context, rawURL string) *LinkStatus {
+	result := &LinkStatus{URL: rawURL, CheckedAt: formatTaskTime(time.Now())}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !isHTTPURL(rawURL) || parsed.Host == "" {
+		result.Broken = true
+		result.Error = "invalid URL"
+		return result
+	}
+
+	allowed, delay := lp.client.allowed(ctx, parsed)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if !allowed {
+		result.Error = linkErrorRobots
+		return result
+	}
+
+	resp, err := lp.fetch(ctx, http.MethodHead, parsed, delay)
+	if err == nil && headUnreliable(resp.StatusCode) {
+		resp, err = lp.fetch(ctx, http.MethodGet, parsed, delay)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		if errors.Is(err, ErrPrivateAddress) {
+			result.Error = linkErrorPrivate
+			return result
+		}
+		result.Broken = true
+		result.Error = truncateString(err.Error(), maxLinkErrorBytes)
+		return result
+	}
+
+	result.StatusCode = resp.StatusCode
+	result.FinalURL = resp.Request.URL.String()
+	result.Redirected = result.FinalURL != parsed.String()
+	// 429 says nothing about the link itself
+	result.Broken = resp.StatusCode >= 400 && resp.StatusCode != http.StatusTooManyRequests
+	return result
+}
+
+// fetch makes one request and discards the body
+func (lp *LinkProber) fetch(ctx context.Context, method string, target *url.URL, delay time.Duration) (*http.Response, error) {
+	resp, err := lp.client.do(ctx, method, target, delay, nil)
+	if err != nil {
+		return nil, err
+	}
+	io.CopyN(io.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// headUnreliable reports whether a HEAD status is worth confirming with GET:
+// any error except the definitive 404 and 410
+func headUnreliable(statusCode int) bool {
+	return statusCode >= 400 && statusCode != http.StatusNotFound && statusCode != http.StatusGone
+}
+
+// truncateString shortens s to at most n bytes without splitting a rune
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// LinkChecker periodically checks the links in users' sessions, saved tabs
+// and favorites
+type LinkChecker struct {
+	userDataService *UserDataService
+	prober          *LinkProber
+	interval        time.Duration
+	recheckAge      time.Duration
+}
+
+var (
+	linkChecker     *LinkChecker
+	linkCheckerOnce sync.Once
+	linkCheckerErr  error
+)
+
+// NewLinkChecker returns the link checker. LINK_CHECK_INTERVAL sets how
+// often it runs (0 disables it), LINK_RECHECK_AGE how old a result gets
+// before the link is checked again, LINK_CHECK_HOST_DELAY the minimum gap
+// between requests to one host, LINK_CHECK_TIMEOUT the per-request timeout
+// and LINK_CHECK_ALLOW_PRIVATE=true lets it reach private addresses.
+func NewLinkChecker() (*LinkChecker, error) {
+	linkCheckerOnce.Do(func() {
+		userDataService, err := NewUserDataService()
+		if err != nil {
+			linkCheckerErr = err
+			return
+		}
+
+		durations := map[string]time.Duration{
+			"LINK_CHECK_INTERVAL":   DefaultLinkCheckInterval,
+			"LINK_RECHECK_AGE":      DefaultLinkRecheckAge,
+			"LINK_CHECK_HOST_DELAY": DefaultLinkCheckHostDelay,
+			"LINK_CHECK_TIMEOUT":    DefaultLinkCheckTimeout,
+		}
+		for name, fallback := range durations {
+			value, err := time.ParseDuration(getEnvOrDefault(name, fallback.String()))
+			if err != nil || value < 0 {
+				linkCheckerErr = fmt.Errorf("invalid %s: %v", name, err)
+				return
+			}
+			durations[name] = value
+		}
+
+		linkChecker = &LinkChecker{
+			userDataService: userDataService,
+			prober: NewLinkProber(
+				durations["LINK_CHECK_TIMEOUT"],
+				durations["LINK_CHECK_HOST_DELAY"],
+				getEnvOrDefault("LINK_CHECK_ALLOW_PRIVATE", "false") == "true",
+			),
+			interval:   durations["LINK_CHECK_INTERVAL"],
+			recheckAge: durations["LINK_RECHECK_AGE"],
+		}
+	})
+
+	return linkChecker, linkCheckerErr
+}
+
+// Run runs the checker until ctx is done
+func (lc *LinkChecker) Run(ctx context.Context) {
+	if lc.interval == 0 {
+		log.Printf("Link checker disabled")
+		return
+	}
+
+	ticker := time.NewTicker(lc.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := lc.RunOnce(ctx); err != nil {
+			log.Printf("Link checker run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce checks the links of the users that are due
+func (lc *LinkChecker) RunOnce(ctx context.Context) error {
+	return lc.userDataService.runDueUserJobs(ctx, LINK_CHECKS_COLLECTION_NAME, linkCheckBatchSize, lc.checkUser)
+}
+
+// checkUser checks the user's links that were never checked, changed or
+// were last checked over recheckAge ago, stores the results and returns when
+// to look again. Annotations of items that are gone are pruned.
+func (lc *LinkChecker) checkUser(ctx context.Context, entry *userJobEntry) (time.Time, error) {
+	uds := lc.userDataService
+	userID := entry.UserID
+	started := time.Now()
+	cutoff := formatTaskTime(started.Add(-lc.recheckAge))
+
+	uds.mu.RLock()
+	sessions, err := uds.loadSessions(ctx, userID)
+	var savedTabs []*SavedTab
+	var favorites []*FavoriteTab
+	if err == nil {
+		savedTabs, err = uds.loadSavedTabs(ctx, userID)
+	}
+	if err == nil {
+		favorites, err = uds.getFavorites(ctx, userID)
+	}
+	uds.mu.RUnlock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	items := annotatedItems(sessions, savedTabs, favorites)
+	if err := uds.pruneAnnotations(ctx, userID, annotations, items, started); err != nil {
+		return time.Time{}, err
+	}
+
+	var due []string
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if !isHTTPURL(item.url) || seen[item.url] {
+			continue
+		}
+		if link := annotations.get(item).LinkStatus; link != nil && link.CheckedAt > cutoff {
+			continue
+		}
+		seen[item.url] = true
+		due = append(due, item.url)
+	}
+
+	nextRun := time.Now().Add(linkCheckUserPeriod)
+	if len(due) > maxLinksPerUserRun {
+		due = due[:maxLinksPerUserRun]
+		nextRun = time.Now()
+	}
+	if len(due) == 0 {
+		return nextRun, nil
+	}
+
+	results := lc.checkLinks(ctx, due)
+	if ctx.Err() != nil {
+		return time.Time{}, ctx.Err()
+	}
+	if err := uds.storeLinkStatuses(ctx, userID, items, results); err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Checked %d links for user %s", len(results), userID)
+	return nextRun, nil
+}
+
+// checkLinks checks urls a few at a time
+func (lc *LinkChecker) checkLinks(ctx context.Context, urls []string) map[string]*LinkStatus {
+	results := make(map[string]*LinkStatus)
+	var mu sync.Mutex
+	forEachConcurrently(urls, linkCheckConcurrency, func(rawURL string) {
+		if result := lc.prober.Check(ctx, rawURL); result != nil {
+			mu.Lock()
+			results[rawURL] = result
+			mu.Unlock()
+		}
+	})
+	return results
+}
+
+// storeLinkStatuses records link results in the annotations of every item
+// with a checked URL. An item edited during the check keeps its annotation
+// for the old URL until it is pruned.
+func (uds *UserDataService) storeLinkStatuses(ctx context.Context, userID string, items []annotatedItem, results map[string]*LinkStatus) error {
+	var updates []annotationUpdate
+	for _, item := range items {
+		if result := results[item.url]; result != nil {
+			updates = append(updates, annotationUpdate{item, map[string]interface{}{"linkStatus": result}})
+		}
+	}
+	if err := uds.writeAnnotations(ctx, userID, updates); err != nil {
+		return fmt.Errorf("failed to store link statuses: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// linkSite is a test site that records the requests it gets
+type linkSite struct {
+	mu       sync.Mutex
+	requests []string
+	times    []time.Time
+}
+
+func (ls *linkSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ls.mu.Lock()
+	ls.requests = append(ls.requests, r.Method+" "+r.URL.Path)
+	ls.times = append(ls.times, time.Now())
+	ls.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/robots.txt":
+		w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	case "/ok":
+	case "/no-head":
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case "/moved":
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	case "/loop":
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	case "/busy":
+		w.WriteHeader(http.StatusTooManyRequests)
+	case "/error":
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// requestsTo returns the methods of the requests made for path
+func (ls *linkSite) requestsTo(path string) []string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	var methods []string
+	for _, request := range ls.requests {
+		if method, requested, _ := strings.Cut(request, " "); requested == path {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+func TestLinkProberCheck(t *testing.T) {
+	site := &linkSite{}
+	server := httptest.NewServer(site)
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		wantStatus     int
+		wantBroken     bool
+		wantRedirected bool
+		wantError      string
+		wantMethods    []string
+	}{
+		{name: "ok with HEAD", path: "/ok", wantStatus: 200, wantMethods: []string{"HEAD"}},
+		{name: "HEAD rejected falls back to GET", path: "/no-head", wantStatus: 200, wantMethods: []string{"HEAD", "GET"}},
+		{name: "not found is not retried with GET", path: "/missing", wantStatus: 404, wantBroken: true, wantMethods: []string{"HEAD"}},
+		{name: "server error confirmed with GET", path: "/error", wantStatus: 500, wantBroken: true, wantMethods: []string{"HEAD", "GET"}},
+		{name: "rate limited is not broken", path: "/busy", wantStatus: 429, wantMethods: []string{"HEAD", "GET"}},
+		{name: "redirect followed", path: "/moved", wantStatus: 200, wantRedirected: true, wantMethods: []string{"HEAD"}},
+		{name: "redirect loop", path: "/loop", wantBroken: true, wantError: "stopped after 10 redirects"},
+		{name: "disallowed by robots.txt", path: "/private/page", wantError: linkErrorRobots},
+	}
+
+	prober := NewLinkProber(5*time.Second, 0, true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prober.Check(context.Background(), server.URL+tt.path)
+			if got == nil {
+				t.Fatal("Check() = nil")
+			}
+			if got.StatusCode != tt.wantStatus || got.Broken != tt.wantBroken || got.Redirected != tt.wantRedirected {
+				t.Errorf("Check() = status %d broken %v redirected %v, want %d %v %v",
+					got.StatusCode, got.Broken, got.Redirected, tt.wantStatus, tt.wantBroken, tt.wantRedirected)
+			}
+			if tt.wantError != "" && !strings.Contains(got.Error, tt.wantError) {
+				t.Errorf("Check() error = %q, want %q", got.Error, tt.wantError)
+			}
+			if tt.wantError == "" && got.Error != "" {
+				t.Errorf("Check() error = %q", got.Error)
+			}
+			if tt.wantRedirected && got.FinalURL != server.URL+"/ok" {
+				t.Errorf("Check() finalUrl = %s", got.FinalURL)
+			}
+			if tt.wantMethods != nil && !slices.Equal(site.requestsTo(tt.path), tt.wantMethods) {
+				t.Errorf("requests to %s = %v, want %v", tt.path, site.requestsTo(tt.path), tt.wantMethods)
+			}
+		})
+	}
+
+	if got := site.requestsTo("/private/page"); len(got) != 0 {
+		t.Errorf("disallowed page was requested: %v", got)
+	}
+	if got := site.requestsTo("/robots.txt"); len(got) != 1 {
+		t.Errorf("robots.txt requested %d times, want once", len(got))
+	}
+}
+
+func TestLinkProberCheckRefusesPrivateAddresses(t *testing.T) {
+	site := &linkSite{}
+	server := httptest.NewServer(site)
+	defer server.Close()
+
+	got := NewLinkProber(5*time.Second, 0, false).Check(context.Background(), server.URL+"/ok")
+	if got == nil || got.Error != linkErrorPrivate || got.Broken {
+		t.Errorf("Check() = %+v, want an unbroken private address result", got)
+	}
+	if len(site.requestsTo("/ok")) != 0 {
+		t.Error("Check() reached a private address")
+	}
+}
+
+func TestLinkProberSpacesRequestsToAHost(t *testing.T) {
+	site := &linkSite{}
+	server := httptest.NewServer(site)
+	defer server.Close()
+
+	const delay = 100 * time.Millisecond
+	prober := NewLinkProber(5*time.Second, delay, true)
+	for _, path := range []string{"/ok", "/no-head", "/ok"} {
+		if got := prober.Check(context.Background(), server.URL+path); got == nil || got.Broken {
+			t.Fatalf("Check(%s) = %+v", path, got)
+		}
+	}
+
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	// robots.txt, HEAD /ok, HEAD and GET /no-head, HEAD /ok
+	if len(site.times) != 5 {
+		t.Fatalf("got requests %v", site.requests)
+	}
+	// Requests leave on their slots but arrive after connecting, so only
+	// the span is exact
+	if span := site.times[4].Sub(site.times[0]); span < 4*delay-20*time.Millisecond {
+		t.Errorf("five requests took %v, want at least %v", span, 4*delay)
+	}
+}
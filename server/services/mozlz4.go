@@ -0,0 +1,104 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// mozLz4Magic prefixes Firefox's jsonlz4 files (sessionstore.jsonlz4, recovery.jsonlz4)
+const mozLz4Magic = "mozLz40\x00"
+
+// maxMozLz4Size caps the decompressed size of a jsonlz4 file
+const maxMozLz4Size = 64 << 20
+
+// isMozLz4 reports whether the payload is a Firefox jsonlz4 file
+func isMozLz4(data []byte) bool {
+	return len(data) >= len(mozLz4Magic) && string(data[:len(mozLz4Magic)]) == mozLz4Magic
+}
+
+// decodeMozLz4 decompresses a Firefox jsonlz4 file: the magic header, a
+// little-endian uint32 decompressed size, then a single raw LZ4 block
+func decodeMozLz4(data []byte) ([]byte, error) {
+	if !isMozLz4(data) || len(data) < len(mozLz4Magic)+4 {
+		return nil, fmt.Errorf("not a mozLz4 file")
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[len(mozLz4Magic):]))
+	if size > maxMozLz4Size {
+		return nil, fmt.Errorf("mozLz4 content too large: %d bytes", size)
+	}
+
+	return decodeLz4Block(data[len(mozLz4Magic)+4:], size)
+}
+
+// decodeLz4Block decodes one LZ4 block into exactly size bytes
+func decodeLz4Block(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+	i := 0
+
+	readLength := func(length int) (int, error) {
+		if length != 15 {
+			return length, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, fmt.Errorf("lz4: truncated length")
+			}
+			b := src[i]
+			i++
+			length += int(b)
+			if b != 255 {
+				return length, nil
+			}
+		}
+	}
+
+	for i < len(src) {
+		token := src[i]
+		i++
+
+		literalLen, err := readLength(int(token >> 4))
+		if err != nil {
+			return nil, err
+		}
+		if i+literalLen > len(src) || len(dst)+literalLen > size {
+			return nil, fmt.Errorf("lz4: literal run out of range")
+		}
+		dst = append(dst, src[i:i+literalLen]...)
+		i += literalLen
+
+		// The last sequence carries literals only
+		if i >= len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("lz4: truncated match offset")
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, fmt.Errorf("lz4: invalid match offset %d", offset)
+		}
+
+		matchLen, err := readLength(int(token & 0x0f))
+		if err != nil {
+			return nil, err
+		}
+		matchLen += 4
+		if len(dst)+matchLen > size {
+			return nil, fmt.Errorf("lz4: match out of range")
+		}
+
+		// Matches may overlap their own output, so copy byte by byte
+		start := len(dst) - offset
+		for k := 0; k < matchLen; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+
+	if len(dst) != size {
+		return nil, fmt.Errorf("lz4: decoded %d bytes, expected %d", len(dst), size)
+	}
+	return dst, nil
+}
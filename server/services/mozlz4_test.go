@@ -0,0 +1,142 @@
+package services
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// mozLz4File wraps an LZ4 block in the jsonlz4 header
+func mozLz4File(block []byte, size int) []byte {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, uint32(size))
+	return append(append([]byte(mozLz4Magic), header...), block...)
+}
+
+func TestDecodeLz4Block(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   []byte
+		size    int
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "literals only",
+			block: append([]byte{0x50}, "hello"...),
+			size:  5,
+			want:  "hello",
+		},
+		{
+			name: "match copies earlier output",
+			// "abcd", then a 4 byte match at offset 4, then literal "!"
+			block: append(append([]byte{0x40}, "abcd"...), 0x04, 0x00, 0x10, '!'),
+			size:  9,
+			want:  "abcdabcd!",
+		},
+		{
+			name: "overlapping match repeats a byte",
+			// "a", then a 9 byte match at offset 1, then literal "b"
+			block: []byte{0x15, 'a', 0x01, 0x00, 0x10, 'b'},
+			size:  11,
+			want:  "aaaaaaaaaab",
+		},
+		{
+			name: "extended literal length",
+			// 15 + 5 literals
+			block: append([]byte{0xf0, 0x05}, strings.Repeat("x", 20)...),
+			size:  20,
+			want:  strings.Repeat("x", 20),
+		},
+		{
+			name: "extended match length",
+			// "ab", then 4 + 15 + 255 + 1 bytes at offset 2, then literal "c"
+			block: []byte{0x2f, 'a', 'b', 0x02, 0x00, 0xff, 0x01, 0x10, 'c'},
+			size:  2 + 4 + 15 + 255 + 1 + 1,
+			want:  strings.Repeat("ab", 138) + "ac",
+		},
+		{
+			name:    "zero offset",
+			block:   []byte{0x10, 'a', 0x00, 0x00, 0x10, 'b'},
+			size:    6,
+			wantErr: "invalid match offset",
+		},
+		{
+			name:    "offset before start",
+			block:   []byte{0x10, 'a', 0x02, 0x00, 0x10, 'b'},
+			size:    6,
+			wantErr: "invalid match offset",
+		},
+		{
+			name:    "truncated offset",
+			block:   []byte{0x10, 'a', 0x01},
+			size:    5,
+			wantErr: "truncated match offset",
+		},
+		{
+			name:    "truncated length",
+			block:   []byte{0xf0},
+			size:    20,
+			wantErr: "truncated length",
+		},
+		{
+			name:    "literals past end of input",
+			block:   []byte{0x50, 'a', 'b'},
+			size:    5,
+			wantErr: "literal run out of range",
+		},
+		{
+			name:    "output larger than declared",
+			block:   []byte{0x15, 'a', 0x01, 0x00, 0x10, 'b'},
+			size:    5,
+			wantErr: "match out of range",
+		},
+		{
+			name:    "output smaller than declared",
+			block:   append([]byte{0x50}, "hello"...),
+			size:    6,
+			wantErr: "decoded 5 bytes, expected 6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeLz4Block(tt.block, tt.size)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeLz4Block() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeLz4Block() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decodeLz4Block() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMozLz4(t *testing.T) {
+	payload := `{"windows":[]}`
+	block := append([]byte{byte(len(payload)) << 4}, payload...)
+
+	got, err := decodeMozLz4(mozLz4File(block, len(payload)))
+	if err != nil {
+		t.Fatalf("decodeMozLz4() error = %v", err)
+	}
+	if string(got) != payload {
+		t.Errorf("decodeMozLz4() = %q, want %q", got, payload)
+	}
+
+	if _, err := decodeMozLz4([]byte(payload)); err == nil {
+		t.Error("decodeMozLz4() accepted a payload without the magic header")
+	}
+	if _, err := decodeMozLz4(mozLz4File(nil, maxMozLz4Size+1)); err == nil {
+		t.Error("decodeMozLz4() accepted an oversized declared size")
+	}
+	if !isMozLz4(mozLz4File(nil, 0)) {
+		t.Error("isMozLz4() rejected a jsonlz4 header")
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxOutboundRedirects bounds the redirects followed for user-supplied URLs
+const maxOutboundRedirects = 10
+
+// ErrPrivateAddress is returned for outbound requests to loopback, private
+// or link-local addresses, which user-supplied URLs must not reach
+var ErrPrivateAddress = errors.New("refusing to connect to a private address")
+
+// newOutboundClient returns an HTTP client for fetching user-supplied URLs.
+// Unless allowPrivate is set, connections to non-public addresses are
+// refused after DNS resolution, redirects included.
+func newOutboundClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = timeout
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxOutboundRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxOutboundRedirects)
+			}
+			if !isHTTPURL(req.URL.String()) {
+				return fmt.Errorf("refusing redirect to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// isPublicIP reports whether ip is a global unicast address outside the
+// private ranges
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// newOutboundRequest builds a request for a user-supplied http(s) URL
+func newOutboundRequest(ctx context.Context, method, rawURL, userAgent string) (*http.Request, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("not an http(s) URL: %q", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// politeClient makes outbound requests that honour robots.txt and keep
+// requests to one host at least hostDelay (or its Crawl-delay) apart
+type politeClient struct {
+	client    *http.Client
+	agent     string
+	robots    *robotsCache
+	hostDelay time.Duration
+
+	mu          sync.Mutex
+	nextRequest map[string]time.Time
+}
+
+func newPoliteClient(timeout, hostDelay time.Duration, allowPrivate bool, agent string) *politeClient {
+	client := newOutboundClient(timeout, allowPrivate)
+	return &politeClient{
+		client:      client,
+		agent:       agent,
+		robots:      newRobotsCache(client, agent),
+		hostDelay:   hostDelay,
+		nextRequest: make(map[string]time.Time),
+	}
+}
+
+// allowed reports whether robots.txt allows fetching target, and the delay
+// to keep between requests to its host
+func (pc *politeClient) allowed(ctx context.Context, target *url.URL) (bool, time.Duration) {
+	rules := pc.robots.rules(ctx, target.Scheme+"://"+target.Host, func() error {
+		return pc.wait(ctx, target.Host, pc.hostDelay)
+	})
+	return rules.Allowed(target.RequestURI()), max(pc.hostDelay, min(rules.crawlDelay, maxCrawlDelay))
+}
+
+// do makes one request once the host's slot comes up; header adds request
+// headers. Callers close the body.
+func (pc *politeClient) do(ctx context.Context, method string, target *url.URL, delay time.Duration, header http.Header) (*http.Response, error) {
+	if err := pc.wait(ctx, target.Host, delay); err != nil {
+		return nil, err
+	}
+	req, err := newOutboundRequest(ctx, method, target.String(), pc.agent)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return pc.client.Do(req)
+}
+
+// wait blocks until the next request to host may be made
+func (pc *politeClient) wait(ctx context.Context, host string, delay time.Duration) error {
+	pc.mu.Lock()
+	now := time.Now()
+	if len(pc.nextRequest) > 1000 {
+		for name, slot := range pc.nextRequest {
+			if slot.Before(now) {
+				delete(pc.nextRequest, name)
+			}
+		}
+	}
+	slot := pc.nextRequest[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	pc.nextRequest[host] = slot.Add(delay)
+	pc.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxArchiveTextBytes bounds the readable text kept from an archived page
+const maxArchiveTextBytes = 1 << 20
+
+// PageSnapshot is an archived copy of a page
+type PageSnapshot struct {
+	Title string
+	// HTML is the sanitized document: no scripts, frames, plugins, event
+	// handlers or non-http(s) links, with URLs made absolute
+	HTML []byte
+	// Text is the page's readable text, a paragraph per line
+	Text string
+}
+
+// archiveDroppedElements are removed from snapshots along with their content
+var archiveDroppedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Iframe:   true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Applet:   true,
+	atom.Base:     true,
+}
+
+// archiveURLAttributes hold URLs, which snapshots resolve or drop
+var archiveURLAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"poster":     true,
+	"background": true,
+	"cite":       true,
+	"longdesc":   true,
+	"data":       true,
+}
+
+// archiveDroppedAttributes would run code, submit forms or phone home
+var archiveDroppedAttributes = map[string]bool{
+	"srcdoc":     true,
+	"action":     true,
+	"formaction": true,
+	"ping":       true,
+	"nonce":      true,
+	"integrity":  true,
+}
+
+// SnapshotPage parses an HTML document and returns its sanitized copy and
+// readable text. Relative URLs resolve against base, or the document's
+// <base href>.
+func SnapshotPage(r io.Reader, base *url.URL) (*PageSnapshot, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+	if href := findBaseHref(doc); href != "" {
+		if parsed, err := base.Parse(href); err == nil {
+			base = parsed
+		}
+	}
+
+	snapshot := &PageSnapshot{}
+	sanitizeArchiveNode(doc, base, snapshot)
+	snapshot.Text = extractReadableText(doc)
+
+	// The snapshot is rendered as UTF-8 whatever the page declared
+	if head := findElement(doc, atom.Head); head != nil {
+		head.InsertBefore(&html.Node{
+			Type:     html.ElementNode,
+			Data:     "meta",
+			DataAtom: atom.Meta,
+			Attr:     []html.Attribute{{Key: "charset", Val: "utf-8"}},
+		}, head.FirstChild)
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, fmt.Errorf("failed to render page: %w", err)
+	}
+	snapshot.HTML = buf.Bytes()
+	return snapshot, nil
+}
+
+// findBaseHref returns the first <base href>, if any
+func findBaseHref(node *html.Node) string {
+	if node.Type == html.ElementNode && node.DataAtom == atom.Base {
+		for _, attr := range node.Attr {
+			if attr.Key == "href" && strings.TrimSpace(attr.Val) != "" {
+				return strings.TrimSpace(attr.Val)
+			}
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if href := findBaseHref(child); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+// findElement returns the first element of a kind, depth first
+func findElement(node *html.Node, kind atom.Atom) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == kind {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, kind); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// sanitizeArchiveNode strips active content below node and records the title
+func sanitizeArchiveNode(node *html.Node, base *url.URL, snapshot *PageSnapshot) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		switch child.Type {
+		case html.CommentNode:
+			node.RemoveChild(child)
+		case html.ElementNode:
+			if !keepArchiveElement(child) {
+				node.RemoveChild(child)
+				break
+			}
+			if child.DataAtom == atom.Title && snapshot.Title == "" && child.FirstChild != nil {
+				snapshot.Title = cleanMetadataText(child.FirstChild.Data)
+			}
+			child.Attr = sanitizeArchiveAttributes(child, base)
+			sanitizeArchiveNode(child, base, snapshot)
+		}
+		child = next
+	}
+}
+
+// keepArchiveElement reports whether an element belongs in a snapshot.
+// Meta tags are kept for their content only, and links only as stylesheets.
+func keepArchiveElement(node *html.Node) bool {
+	if archiveDroppedElements[node.DataAtom] {
+		return false
+	}
+	switch node.DataAtom {
+	case atom.Meta:
+		for _, attr := range node.Attr {
+			if attr.Key == "http-equiv" || attr.Key == "charset" {
+				return false
+			}
+		}
+	case atom.Link:
+		for _, attr := range node.Attr {
+			if attr.Key == "rel" {
+				for _, rel := range strings.Fields(strings.ToLower(attr.Val)) {
+					if rel == "stylesheet" {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// sanitizeArchiveAttributes drops event handlers and active attributes and
+// makes URLs absolute, dropping those that are not http(s) or inline images
+func sanitizeArchiveAttributes(node *html.Node, base *url.URL) []html.Attribute {
+	attributes := node.Attr[:0]
+	for _, attr := range node.Attr {
+		key := strings.ToLower(attr.Key)
+		if strings.HasPrefix(key, "on") || archiveDroppedAttributes[key] {
+			continue
+		}
+		// Links get their own rel below
+		if node.DataAtom == atom.A && key == "rel" {
+			continue
+		}
+		switch {
+		case archiveURLAttributes[key]:
+			value, ok := resolveArchiveURL(attr.Val, base, key != "href")
+			if !ok {
+				continue
+			}
+			attr.Val = value
+		case key == "srcset":
+			attr.Val = resolveArchiveSrcset(attr.Val, base)
+			if attr.Val == "" {
+				continue
+			}
+		}
+		attributes = append(attributes, attr)
+	}
+	if node.DataAtom == atom.A {
+		attributes = append(attributes, html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+	}
+	return attributes
+}
+
+// resolveArchiveURL makes a URL absolute, keeping in-page fragments and,
+// when allowed, inline images
+func resolveArchiveURL(raw string, base *url.URL, allowDataImage bool) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "#") {
+		return raw, true
+	}
+	if allowDataImage && strings.HasPrefix(strings.ToLower(raw), "data:image/") {
+		return raw, true
+	}
+	target, err := base.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return "", false
+	}
+	return target.String(), true
+}
+
+// resolveArchiveSrcset resolves every candidate of a srcset
+func resolveArchiveSrcset(srcset string, base *url.URL) string {
+	var candidates []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		if resolved, ok := resolveArchiveURL(fields[0], base, false); ok {
+			fields[0] = resolved
+			candidates = append(candidates, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// archiveSkippedText are elements whose text is not part of the page's
+// content
+var archiveSkippedText = map[atom.Atom]bool{
+	atom.Head:   true,
+	atom.Style:  true,
+	atom.Svg:    true,
+	atom.Canvas: true,
+	atom.Nav:    true,
+	atom.Aside:  true,
+	atom.Footer: true,
+	atom.Form:   true,
+	atom.Button: true,
+	atom.Select: true,
+}
+
+// archiveBlockElements end a paragraph of extracted text
+var archiveBlockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Header: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Ul: true, atom.Ol: true, atom.Li: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Blockquote: true, atom.Pre: true,
+	atom.Table: true, atom.Tr: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Br: true, atom.Hr: true,
+}
+
+// extractReadableText returns the text of the page's <article>, or <main>,
+// or <body>, leaving out navigation and other page furniture
+func extractReadableText(doc *html.Node) string {
+	root := findElement(doc, atom.Article)
+	if root == nil {
+		root = findElement(doc, atom.Main)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	var paragraphs []string
+	var current []string
+	size := 0
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		paragraph := strings.Join(strings.Fields(strings.Join(current, " ")), " ")
+		current = current[:0]
+		if paragraph != "" && size < maxArchiveTextBytes {
+			paragraphs = append(paragraphs, paragraph)
+			size += len(paragraph) + 1
+		}
+	}
+
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		switch node.Type {
+		case html.TextNode:
+			current = append(current, node.Data)
+			return
+		case html.ElementNode:
+			if archiveSkippedText[node.DataAtom] {
+				return
+			}
+		}
+		block := node.Type == html.ElementNode && archiveBlockElements[node.DataAtom]
+		if block {
+			flush()
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if block {
+			flush()
+		}
+	}
+	walk(root)
+	flush()
+	return truncateString(strings.Join(paragraphs, "\n"), maxArchiveTextBytes)
+}
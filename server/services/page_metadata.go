@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Metadata fetcher defaults
+const (
+	DefaultMetadataFetchTimeout = 10 * time.Second
+	DefaultMetadataMaxBytes     = 1 << 20
+	DefaultMetadataCacheTTL     = 24 * time.Hour
+	// MetadataUserAgent identifies the fetcher to sites and robots.txt
+	MetadataUserAgent = "TabBlasterMetadata/1.0 (+https://github.com/glennmartinez/tab-blaster-5000)"
+	// metadataHostDelay is the minimum gap between requests to one host
+	metadataHostDelay = time.Second
+	// maxMetadataCacheEntries bounds the page and favicon caches
+	maxMetadataCacheEntries = 10000
+	// maxMetadataTextLength bounds extracted titles and descriptions
+	maxMetadataTextLength = 1000
+)
+
+// Metadata errors
+var (
+	ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+	ErrNotHTML          = errors.New("not an HTML page")
+)
+
+// PageMetadata is what a page says about itself
+type PageMetadata struct {
+	// URL is the page's address after redirects
+	URL          string `json:"url" firestore:"url"`
+	Title        string `json:"title,omitempty" firestore:"title,omitempty"`
+	Description  string `json:"description,omitempty" firestore:"description,omitempty"`
+	CanonicalURL string `json:"canonicalUrl,omitempty" firestore:"canonicalUrl,omitempty"`
+	Image        string `json:"image,omitempty" firestore:"image,omitempty"`
+	Favicon      string `json:"favicon,omitempty" firestore:"favicon,omitempty"`
+}
+
+// ParsePageMetadata extracts metadata from the head of an HTML document.
+// Relative URLs resolve against base, or the document's <base href>. The
+// <title> wins over og:title and the meta description over og:description;
+// the canonical link wins over og:url.
+func ParsePageMetadata(r io.Reader, base *url.URL) *PageMetadata {
+	metadata := &PageMetadata{URL: base.String()}
+	var ogTitle, ogDescription, ogURL, touchIcon string
+	resolve := func(ref string) string {
+		target, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			return ""
+		}
+		target.Fragment = ""
+		return target.String()
+	}
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return finishPageMetadata(metadata, ogTitle, ogDescription, ogURL, touchIcon)
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return finishPageMetadata(metadata, ogTitle, ogDescription, ogURL, touchIcon)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttributes := tokenizer.TagName()
+			attributes := map[string]string{}
+			for hasAttributes {
+				var key, value []byte
+				key, value, hasAttributes = tokenizer.TagAttr()
+				attributes[string(key)] = string(value)
+			}
+
+			switch string(name) {
+			case "body":
+				return finishPageMetadata(metadata, ogTitle, ogDescription, ogURL, touchIcon)
+			case "base":
+				if href, err := base.Parse(strings.TrimSpace(attributes["href"])); err == nil && attributes["href"] != "" {
+					base = href
+				}
+			case "title":
+				if tokenizer.Next() == html.TextToken && metadata.Title == "" {
+					metadata.Title = cleanMetadataText(string(tokenizer.Text()))
+				}
+			case "meta":
+				content := cleanMetadataText(attributes["content"])
+				key := strings.ToLower(attributes["property"])
+				if key == "" {
+					key = strings.ToLower(attributes["name"])
+				}
+				switch key {
+				case "description":
+					metadata.Description = content
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "og:url":
+					ogURL = resolve(content)
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if metadata.Image == "" {
+						metadata.Image = resolve(content)
+					}
+				}
+			case "link":
+				href := resolve(attributes["href"])
+				if href == "" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.ToLower(attributes["rel"])) {
+					switch rel {
+					case "canonical":
+						if metadata.CanonicalURL == "" {
+							metadata.CanonicalURL = href
+						}
+					case "icon":
+						if metadata.Favicon == "" {
+							metadata.Favicon = href
+						}
+					case "apple-touch-icon":
+						if touchIcon == "" {
+							touchIcon = href
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func finishPageMetadata(metadata *PageMetadata, ogTitle, ogDescription, ogURL, touchIcon string) *PageMetadata {
+	if metadata.Title == "" {
+		metadata.Title = ogTitle
+	}
+	if metadata.Description == "" {
+		metadata.Description = ogDescription
+	}
+	if metadata.CanonicalURL == "" {
+		metadata.CanonicalURL = ogURL
+	}
+	if metadata.Favicon == "" {
+		metadata.Favicon = touchIcon
+	}
+	return metadata
+}
+
+// cleanMetadataText collapses whitespace and bounds the length
+func cleanMetadataText(text string) string {
+	return truncateString(strings.Join(strings.Fields(text), " "), maxMetadataTextLength)
+}
+
+// MetadataFetcher fetches page metadata politely, caching results per
+// canonical URL
+type MetadataFetcher struct {
+	client   *politeClient
+	maxBytes int64
+	cacheTTL time.Duration
+
+	mu       sync.Mutex
+	pages    map[string]metadataCacheEntry // by canonical URL
+	aliases  map[string]string             // requested URL to canonical URL
+	favicons map[string]metadataCacheEntry // by favicon URL; metadata unused
+}
+
+type metadataCacheEntry struct {
+	metadata *PageMetadata
+	err      error
+	expires  time.Time
+}
+
+// NewMetadataFetcher creates a metadata fetcher that reads at most maxBytes
+// of a page. allowPrivate lets it reach private and loopback addresses,
+// which is only meant for tests and trusted setups.
+func NewMetadataFetcher(timeout time.Duration, maxBytes int64, cacheTTL time.Duration, allowPrivate bool) *MetadataFetcher {
+	return &MetadataFetcher{
+		client:   newPoliteClient(timeout, metadataHostDelay, allowPrivate, MetadataUserAgent),
+		maxBytes: maxBytes,
+		cacheTTL: cacheTTL,
+		pages:    make(map[string]metadataCacheEntry),
+		aliases:  make(map[string]string),
+		favicons: make(map[string]metadataCacheEntry),
+	}
+}
+
+// Fetch returns a page's metadata. Without a declared icon, the site's
+// /favicon.ico is used when it serves an image. Failures are cached too, so
+// a dead page is not requested again until the cache expires.
+func (mf *MetadataFetcher) Fetch(ctx context.Context, rawURL string) (*PageMetadata, error) {
+	now := time.Now()
+	mf.mu.Lock()
+	key := rawURL
+	if canonical, ok := mf.aliases[rawURL]; ok {
+		key = canonical
+	}
+	entry, ok := mf.pages[key]
+	mf.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.metadata, entry.err
+	}
+
+	metadata, err := mf.fetch(ctx, rawURL)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if len(mf.pages) >= maxMetadataCacheEntries {
+		pruneMetadataCache(mf.pages, now)
+		for alias, canonical := range mf.aliases {
+			if _, ok := mf.pages[canonical]; !ok {
+				delete(mf.aliases, alias)
+			}
+		}
+	}
+	key = rawURL
+	if metadata != nil && metadata.CanonicalURL != "" {
+		key = metadata.CanonicalURL
+		mf.aliases[rawURL] = key
+	}
+	mf.pages[key] = metadataCacheEntry{metadata: metadata, err: err, expires: now.Add(mf.cacheTTL)}
+	return metadata, err
+}
+
+func (mf *MetadataFetcher) fetch(ctx context.Context, rawURL string) (*PageMetadata, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || !isHTTPURL(rawURL) || target.Host == "" {
+		return nil, fmt.Errorf("not an http(s) URL: %q", rawURL)
+	}
+	allowed, delay := mf.client.allowed(ctx, target)
+	if !allowed {
+		return nil, ErrRobotsDisallowed
+	}
+
+	resp, err := mf.client.do(ctx, http.MethodGet, target, delay, http.Header{
+		"Accept": {"text/html,application/xhtml+xml;q=0.9,*/*;q=0.1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("page returned status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("%w: %s", ErrNotHTML, mediaType)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, mf.maxBytes), contentType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode page: %w", err)
+	}
+	metadata := ParsePageMetadata(body, resp.Request.URL)
+
+	if metadata.Favicon == "" {
+		fallback := &url.URL{Scheme: resp.Request.URL.Scheme, Host: resp.Request.URL.Host, Path: "/favicon.ico"}
+		if mf.FaviconOK(ctx, fallback.String()) {
+			metadata.Favicon = fallback.String()
+		}
+	}
+	return metadata, nil
+}
+
+// FaviconOK reports whether rawURL serves an image; results are cached
+func (mf *MetadataFetcher) FaviconOK(ctx context.Context, rawURL string) bool {
+	now := time.Now()
+	mf.mu.Lock()
+	entry, ok := mf.favicons[rawURL]
+	mf.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.err == nil
+	}
+
+	err := mf.checkFavicon(ctx, rawURL)
+	if ctx.Err() != nil {
+		return false
+	}
+
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if len(mf.favicons) >= maxMetadataCacheEntries {
+		pruneMetadataCache(mf.favicons, now)
+	}
+	mf.favicons[rawURL] = metadataCacheEntry{err: err, expires: now.Add(mf.cacheTTL)}
+	return err == nil
+}
+
+func (mf *MetadataFetcher) checkFavicon(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil || !isHTTPURL(rawURL) || target.Host == "" {
+		return fmt.Errorf("not an http(s) URL: %q", rawURL)
+	}
+	allowed, delay := mf.client.allowed(ctx, target)
+	if !allowed {
+		return ErrRobotsDisallowed
+	}
+	resp, err := mf.client.do(ctx, http.MethodGet, target, delay, http.Header{"Accept": {"image/*"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("favicon returned status %d", resp.StatusCode)
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(resp.Body, head)
+	if !isImageContent(resp.Header.Get("Content-Type"), head[:n]) {
+		return fmt.Errorf("favicon is not an image")
+	}
+	return nil
+}
+
+// isImageContent reports whether a response is an image, going by its
+// declared type and, when that is vague, its first bytes
+func isImageContent(contentType string, head []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "image/") {
+		return true
+	}
+	if mediaType != "" && mediaType != "application/octet-stream" {
+		return false
+	}
+	return strings.HasPrefix(http.DetectContentType(head), "image/")
+}
+
+// pruneMetadataCache drops expired entries, or everything if none expired
+func pruneMetadataCache(cache map[string]metadataCacheEntry, now time.Time) {
+	for key, entry := range cache {
+		if now.After(entry.expires) {
+			delete(cache, key)
+		}
+	}
+	if len(cache) >= maxMetadataCacheEntries {
+		clear(cache)
+	}
+}
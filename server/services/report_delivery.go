@@ -0,0 +1,303 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// REPORT_SUBSCRIPTIONS_COLLECTION_NAME indexes the users who receive weekly
+// reports by when their next report is due
+const REPORT_SUBSCRIPTIONS_COLLECTION_NAME = "tab-blaster-5k-report-subscriptions"
+
+// Report scheduler defaults
+const (
+	DefaultReportSchedulerInterval = 15 * time.Minute
+	// reportDeliveryHour is the local hour on Monday the previous week's
+	// report is delivered
+	reportDeliveryHour = 8
+	// reportBatchSize is the number of subscriptions read per run
+	reportBatchSize = 100
+)
+
+// ErrReportSubscriptionNotFound is returned when the user has no report
+// subscription
+var ErrReportSubscriptionNotFound = errors.New("report subscription not found")
+
+// ReportSubscription schedules a user's weekly report
+type ReportSubscription struct {
+	UserID   string    `json:"-" firestore:"userId"`
+	NextRun  time.Time `json:"nextRun" firestore:"nextRun"`
+	LastWeek string    `json:"lastWeek,omitempty" firestore:"lastWeek,omitempty"`
+}
+
+// ReportSender delivers a rendered weekly report
+type ReportSender interface {
+	SendReport(ctx context.Context, userID string, report *WeeklyReport) error
+}
+
+// LogReportSender writes reports to the server log
+type LogReportSender struct {
+	Format string
+}
+
+// SendReport logs the rendered report
+func (s *LogReportSender) SendReport(ctx context.Context, userID string, report *WeeklyReport) error {
+	var buf bytes.Buffer
+	if err := RenderWeeklyReport(&buf, s.Format, report); err != nil {
+		return err
+	}
+	log.Printf("Weekly report %s for user %s:\n%s", report.Week, userID, buf.String())
+	return nil
+}
+
+// FileReportSender writes reports to Dir/{userID}/{week}.{extension}
+type FileReportSender struct {
+	Dir    string
+	Format string
+}
+
+// SendReport writes the rendered report, replacing any earlier copy
+func (s *FileReportSender) SendReport(ctx context.Context, userID string, report *WeeklyReport) error {
+	_, extension, err := ReportContentType(s.Format)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := RenderWeeklyReport(&buf, s.Format, report); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.Dir, url.PathEscape(userID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	path := filepath.Join(dir, report.Week+"."+extension)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	log.Printf("Wrote weekly report %s for user %s to %s", report.Week, userID, path)
+	return nil
+}
+
+// NewReportSender returns the sender named by kind: log or file
+func NewReportSender(kind, dir, format string) (ReportSender, error) {
+	if _, _, err := ReportContentType(format); err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "log":
+		return &LogReportSender{Format: format}, nil
+	case "file":
+		return &FileReportSender{Dir: dir, Format: format}, nil
+	default:
+		return nil, fmt.Errorf("unsupported report sender %q (expected log or file)", kind)
+	}
+}
+
+// reportSubscriptionRef returns the scheduler index document for a user
+func reportSubscriptionRef(client *firestore.Client, userID string) *firestore.DocumentRef {
+	return client.Collection(REPORT_SUBSCRIPTIONS_COLLECTION_NAME).Doc(url.PathEscape(userID))
+}
+
+// nextReportRun returns the first Monday delivery time after now in location
+func nextReportRun(now time.Time, location *time.Location) time.Time {
+	monday := weekStart(now.In(location))
+	run := time.Date(monday.Year(), monday.Month(), monday.Day(), reportDeliveryHour, 0, 0, 0, location)
+	if !run.After(now) {
+		run = run.AddDate(0, 0, 7)
+	}
+	return run
+}
+
+// GetReportSubscription returns the user's weekly report subscription
+func (uds *UserDataService) GetReportSubscription(ctx context.Context, userID string) (*ReportSubscription, error) {
+	doc, err := reportSubscriptionRef(uds.firebaseService.firestore, userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrReportSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report subscription: %w", err)
+	}
+
+	var subscription ReportSubscription
+	if err := doc.DataTo(&subscription); err != nil {
+		return nil, fmt.Errorf("failed to parse report subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+// SubscribeWeeklyReport schedules the user's weekly report for the next
+// Monday morning in their time zone; subscribing again keeps the schedule
+func (uds *UserDataService) SubscribeWeeklyReport(ctx context.Context, userID string) (*ReportSubscription, error) {
+	if subscription, err := uds.GetReportSubscription(ctx, userID); !errors.Is(err, ErrReportSubscriptionNotFound) {
+		return subscription, err
+	}
+
+	subscription := &ReportSubscription{
+		UserID:  userID,
+		NextRun: nextReportRun(time.Now(), uds.GetUserLocation(ctx, userID)),
+	}
+	if _, err := reportSubscriptionRef(uds.firebaseService.firestore, userID).Set(ctx, subscription); err != nil {
+		return nil, fmt.Errorf("failed to store report subscription: %w", err)
+	}
+
+	log.Printf("Subscribed user %s to weekly reports from %s", userID, subscription.NextRun.Format(time.RFC3339))
+	return subscription, nil
+}
+
+// UnsubscribeWeeklyReport stops the user's weekly report
+func (uds *UserDataService) UnsubscribeWeeklyReport(ctx context.Context, userID string) error {
+	if _, err := reportSubscriptionRef(uds.firebaseService.firestore, userID).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete report subscription: %w", err)
+	}
+	return nil
+}
+
+// ReportScheduler delivers weekly reports to subscribed users
+type ReportScheduler struct {
+	userDataService *UserDataService
+	sender          ReportSender
+	interval        time.Duration
+}
+
+var (
+	reportScheduler     *ReportScheduler
+	reportSchedulerOnce sync.Once
+	reportSchedulerErr  error
+)
+
+// NewReportScheduler returns the report scheduler. REPORT_SCHEDULER_INTERVAL
+// sets how often it checks for due reports (0 disables it), REPORT_SENDER
+// picks the sender (log or file), REPORT_DIR the file sender's directory and
+// REPORT_FORMAT the rendering (markdown, html or json).
+func NewReportScheduler() (*ReportScheduler, error) {
+	reportSchedulerOnce.Do(func() {
+		userDataService, err := NewUserDataService()
+		if err != nil {
+			reportSchedulerErr = err
+			return
+		}
+
+		interval, err := time.ParseDuration(getEnvOrDefault("REPORT_SCHEDULER_INTERVAL", DefaultReportSchedulerInterval.String()))
+		if err != nil || interval < 0 {
+			reportSchedulerErr = fmt.Errorf("invalid REPORT_SCHEDULER_INTERVAL: %v", err)
+			return
+		}
+		sender, err := NewReportSender(
+			getEnvOrDefault("REPORT_SENDER", "log"),
+			getEnvOrDefault("REPORT_DIR", "reports"),
+			getEnvOrDefault("REPORT_FORMAT", ReportFormatMarkdown),
+		)
+		if err != nil {
+			reportSchedulerErr = err
+			return
+		}
+
+		reportScheduler = &ReportScheduler{
+			userDataService: userDataService,
+			sender:          sender,
+			interval:        interval,
+		}
+	})
+
+	return reportScheduler, reportSchedulerErr
+}
+
+// SetSender replaces the sender reports are delivered through; call it
+// before Run
+func (rs *ReportScheduler) SetSender(sender ReportSender) {
+	rs.sender = sender
+}
+
+// Run runs the scheduler until ctx is done
+func (rs *ReportScheduler) Run(ctx context.Context) {
+	if rs.interval == 0 {
+		log.Printf("Report scheduler disabled")
+		return
+	}
+
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := rs.RunOnce(ctx); err != nil {
+			log.Printf("Report scheduler run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce delivers the reports that are due
+func (rs *ReportScheduler) RunOnce(ctx context.Context) error {
+	now := time.Now()
+	iter := rs.userDataService.firebaseService.firestore.Collection(REPORT_SUBSCRIPTIONS_COLLECTION_NAME).
+		Where("nextRun", "<=", now).
+		OrderBy("nextRun", firestore.Asc).
+		Limit(reportBatchSize).
+		Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query report subscriptions: %w", err)
+		}
+
+		var subscription ReportSubscription
+		if err := doc.DataTo(&subscription); err != nil {
+			log.Printf("Failed to parse report subscription %s: %v", doc.Ref.ID, err)
+			continue
+		}
+
+		// One user failing does not hold up the rest
+		if err := rs.deliver(ctx, doc.Ref, &subscription, now); err != nil {
+			log.Printf("Failed to deliver weekly report for user %s: %v", subscription.UserID, err)
+		}
+	}
+}
+
+// deliver sends the report for the week before now, unless it was already
+// sent, and schedules the next one. A failed send is retried on the next
+// run.
+func (rs *ReportScheduler) deliver(ctx context.Context, ref *firestore.DocumentRef, subscription *ReportSubscription, now time.Time) error {
+	location := rs.userDataService.GetUserLocation(ctx, subscription.UserID)
+	monday := weekStart(now.In(location)).AddDate(0, 0, -7)
+
+	if week := reportWeekName(monday); week != subscription.LastWeek {
+		report, err := rs.userDataService.buildWeeklyReport(ctx, subscription.UserID, monday)
+		if err != nil {
+			return err
+		}
+		if err := rs.sender.SendReport(ctx, subscription.UserID, report); err != nil {
+			return err
+		}
+		subscription.LastWeek = week
+	}
+
+	subscription.NextRun = nextReportRun(now, location)
+	if _, err := ref.Set(ctx, subscription); err != nil {
+		return fmt.Errorf("failed to schedule next report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,479 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats
+const (
+	ReportFormatJSON     = "json"
+	ReportFormatHTML     = "html"
+	ReportFormatMarkdown = "markdown"
+)
+
+// reportFormats maps each report format to its content type and file extension
+var reportFormats = map[string]struct {
+	ContentType string
+	Extension   string
+}{
+	ReportFormatJSON:     {"application/json", "json"},
+	ReportFormatHTML:     {"text/html; charset=utf-8", "html"},
+	ReportFormatMarkdown: {"text/markdown; charset=utf-8", "md"},
+}
+
+// topSavedTabsLimit is the number of saved tabs a weekly report lists
+const topSavedTabsLimit = 10
+
+// ErrInvalidReportWeek is returned for a week that is not YYYY-Www or YYYY-MM-DD
+var ErrInvalidReportWeek = errors.New("invalid report week")
+
+// WeeklyReport summarizes a week, Monday to Sunday in the user's time zone
+type WeeklyReport struct {
+	Week           string             `json:"week"`
+	From           string             `json:"from"`
+	To             string             `json:"to"`
+	TimeZone       string             `json:"timeZone"`
+	GeneratedAt    string             `json:"generatedAt"`
+	Focus          ReportFocus        `json:"focus"`
+	CompletedTasks []ReportTask       `json:"completedTasks"`
+	Disruptions    *DisruptionSummary `json:"disruptions"`
+	TopSavedTabs   []ReportSavedTab   `json:"topSavedTabs"`
+}
+
+// ReportFocus is the week's focus time in total, per day and per task
+type ReportFocus struct {
+	FocusedMinutes   float64           `json:"focusedMinutes"`
+	DisruptedMinutes float64           `json:"disruptedMinutes"`
+	Sessions         int               `json:"sessions"`
+	Days             []ReportDay       `json:"days"`
+	Tasks            []ReportTaskFocus `json:"tasks"`
+}
+
+// ReportDay is one day's focused and disrupted minutes
+type ReportDay struct {
+	Date             string  `json:"date"`
+	FocusedMinutes   float64 `json:"focusedMinutes"`
+	DisruptedMinutes float64 `json:"disruptedMinutes"`
+}
+
+// ReportTaskFocus is the focus time spent on one task during the week
+type ReportTaskFocus struct {
+	TaskID   string  `json:"taskId"`
+	Title    string  `json:"title"`
+	Category string  `json:"category"`
+	Minutes  float64 `json:"minutes"`
+	Sessions int     `json:"sessions"`
+}
+
+// ReportTask is a task completed during the week
+type ReportTask struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Category    string `json:"category"`
+	Priority    string `json:"priority"`
+	CompletedAt string `json:"completedAt"`
+}
+
+// ReportSavedTab is a saved tab opened during the week
+type ReportSavedTab struct {
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	URL        string `json:"url"`
+	VisitCount int    `json:"visitCount"`
+	LastAccess string `json:"lastAccess"`
+}
+
+// ReportContentType returns the content type and file extension for a format
+func ReportContentType(format string) (string, string, error) {
+	info, ok := reportFormats[format]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported report format %q (expected json, html or markdown)", format)
+	}
+	return info.ContentType, info.Extension, nil
+}
+
+// ParseReportWeek returns the Monday starting week, an ISO week (2026-W11)
+// or any date in it (YYYY-MM-DD), in location. An empty week is the current
+// one.
+func ParseReportWeek(week string, location *time.Location) (time.Time, error) {
+	if week == "" {
+		return weekStart(time.Now().In(location)), nil
+	}
+
+	if date, err := time.ParseInLocation(disruptionDateFormat, week, location); err == nil {
+		return weekStart(date), nil
+	}
+
+	year, number, ok := strings.Cut(week, "-W")
+	if !ok || len(year) != 4 || len(number) != 2 {
+		return time.Time{}, fmt.Errorf("%w: %q is not YYYY-Www or YYYY-MM-DD", ErrInvalidReportWeek, week)
+	}
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q is not YYYY-Www or YYYY-MM-DD", ErrInvalidReportWeek, week)
+	}
+	w, err := strconv.Atoi(number)
+	if err != nil || w < 1 || w > 53 {
+		return time.Time{}, fmt.Errorf("%w: %q has no week %s", ErrInvalidReportWeek, week, number)
+	}
+
+	// 4 January always falls in week 1
+	monday := weekStart(time.Date(y, time.January, 4, 0, 0, 0, 0, location)).AddDate(0, 0, (w-1)*7)
+	if isoYear, isoWeek := monday.ISOWeek(); isoYear != y || isoWeek != w {
+		return time.Time{}, fmt.Errorf("%w: %d has no week %d", ErrInvalidReportWeek, y, w)
+	}
+	return monday, nil
+}
+
+// weekStart returns midnight on the Monday of t's week, in t's location
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+// reportWeekName formats the ISO week starting on monday
+func reportWeekName(monday time.Time) string {
+	year, week := monday.ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// GetWeeklyReport builds the report for week (see ParseReportWeek) in
+// location, or the user's time zone when location is nil
+func (uds *UserDataService) GetWeeklyReport(ctx context.Context, userID, week string, location *time.Location) (*WeeklyReport, error) {
+	if location == nil {
+		location = uds.GetUserLocation(ctx, userID)
+	}
+	monday, err := ParseReportWeek(week, location)
+	if err != nil {
+		return nil, err
+	}
+	return uds.buildWeeklyReport(ctx, userID, monday)
+}
+
+// buildWeeklyReport builds the report for the week starting monday
+func (uds *UserDataService) buildWeeklyReport(ctx context.Context, userID string, monday time.Time) (*WeeklyReport, error) {
+	sunday := monday.AddDate(0, 0, 6)
+	weekEnd := monday.AddDate(0, 0, 7)
+	from, to := monday.Format(disruptionDateFormat), sunday.Format(disruptionDateFormat)
+
+	report := &WeeklyReport{
+		Week:           reportWeekName(monday),
+		From:           from,
+		To:             to,
+		TimeZone:       monday.Location().String(),
+		GeneratedAt:    formatTaskTime(time.Now()),
+		CompletedTasks: []ReportTask{},
+		TopSavedTabs:   []ReportSavedTab{},
+	}
+
+	timeline, err := uds.GetTimeline(ctx, userID, from, to, monday.Location())
+	if err != nil {
+		return nil, err
+	}
+	report.Focus = summarizeReportFocus(timeline)
+
+	tasks, err := uds.ListTasks(ctx, userID, TaskFilter{Statuses: []string{TaskStatusDone}})
+	if err != nil {
+		return nil, err
+	}
+	report.CompletedTasks = completedReportTasks(tasks, monday, weekEnd)
+
+	if report.Disruptions, err = uds.GetDisruptionSummary(ctx, userID, DisruptionFilter{From: from, To: to}); err != nil {
+		return nil, err
+	}
+
+	savedTabs, err := uds.GetUserSavedTabs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	report.TopSavedTabs = topReportSavedTabs(savedTabs, monday, weekEnd)
+
+	return report, nil
+}
+
+// summarizeReportFocus totals the timeline's focus sessions per day and per
+// task; sessions spanning midnight count once per task
+func summarizeReportFocus(timeline *Timeline) ReportFocus {
+	focus := ReportFocus{
+		FocusedMinutes:   timeline.FocusedMinutes,
+		DisruptedMinutes: timeline.DisruptedMinutes,
+		Days:             make([]ReportDay, 0, len(timeline.Days)),
+		Tasks:            []ReportTaskFocus{},
+	}
+
+	seen := make(map[string]bool)
+	byTask := make(map[string]*ReportTaskFocus)
+	for _, day := range timeline.Days {
+		focus.Days = append(focus.Days, ReportDay{
+			Date:             day.Date,
+			FocusedMinutes:   day.FocusedMinutes,
+			DisruptedMinutes: day.DisruptedMinutes,
+		})
+
+		for _, entry := range day.Entries {
+			if entry.Type != TimelineTypeTask || seen[entry.ID] {
+				continue
+			}
+			seen[entry.ID] = true
+			focus.Sessions++
+
+			task := byTask[entry.TaskID]
+			if task == nil {
+				task = &ReportTaskFocus{TaskID: entry.TaskID, Title: entry.Title, Category: entry.Category}
+				byTask[entry.TaskID] = task
+			}
+			task.Minutes += entry.Duration
+			task.Sessions++
+		}
+	}
+
+	for _, task := range byTask {
+		task.Minutes = roundMinutes(task.Minutes)
+		focus.Tasks = append(focus.Tasks, *task)
+	}
+	sort.Slice(focus.Tasks, func(i, j int) bool {
+		if focus.Tasks[i].Minutes != focus.Tasks[j].Minutes {
+			return focus.Tasks[i].Minutes > focus.Tasks[j].Minutes
+		}
+		return focus.Tasks[i].Title < focus.Tasks[j].Title
+	})
+	return focus
+}
+
+// completedReportTasks returns the tasks completed in [from, to), oldest
+// first. Tasks without a completion time count from their last update.
+func completedReportTasks(tasks []*Task, from, to time.Time) []ReportTask {
+	completed := []ReportTask{}
+	for _, task := range tasks {
+		if task.Status != TaskStatusDone {
+			continue
+		}
+		completedAt := task.CompletedAt
+		if completedAt == "" {
+			completedAt = task.UpdatedAt
+		}
+		t, err := ParseTaskTime(completedAt)
+		if err != nil || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		completed = append(completed, ReportTask{
+			ID:          task.ID,
+			Title:       task.Title,
+			Category:    task.Category,
+			Priority:    task.Priority,
+			CompletedAt: formatTaskTime(t),
+		})
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].CompletedAt < completed[j].CompletedAt
+	})
+	return completed
+}
+
+// topReportSavedTabs returns the saved tabs last opened in [from, to), most
+// visited first
+func topReportSavedTabs(tabs []*SavedTab, from, to time.Time) []ReportSavedTab {
+	top := []ReportSavedTab{}
+	for _, tab := range tabs {
+		if tab.Usage == nil || tab.Usage.VisitCount == 0 {
+			continue
+		}
+		lastAccess, ok := parseTimestamp(tab.Usage.LastAccess)
+		if !ok || lastAccess.Before(from) || !lastAccess.Before(to) {
+			continue
+		}
+		top = append(top, ReportSavedTab{
+			ID:         tab.ID,
+			Title:      tab.Title,
+			URL:        tab.URL,
+			VisitCount: tab.Usage.VisitCount,
+			LastAccess: formatTaskTime(lastAccess),
+		})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].VisitCount != top[j].VisitCount {
+			return top[i].VisitCount > top[j].VisitCount
+		}
+		return top[i].LastAccess > top[j].LastAccess
+	})
+	if len(top) > topSavedTabsLimit {
+		top = top[:topSavedTabsLimit]
+	}
+	return top
+}
+
+// RenderWeeklyReport writes the report in the requested format
+func RenderWeeklyReport(w io.Writer, format string, report *WeeklyReport) error {
+	switch format {
+	case ReportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case ReportFormatHTML:
+		return renderReportHTML(w, report)
+	case ReportFormatMarkdown:
+		return renderReportMarkdown(w, report)
+	default:
+		_, _, err := ReportContentType(format)
+		return err
+	}
+}
+
+// renderReportHTML writes the report as a standalone HTML page
+func renderReportHTML(w io.Writer, report *WeeklyReport) error {
+	var b strings.Builder
+	title := html.EscapeString(reportTitle(report))
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n</head>\n<body>\n<h1>%s</h1>\n", title, title)
+
+	fmt.Fprintf(&b, "<h2>Focus</h2>\n<p>%s focused over %d sessions, %s disrupted.</p>\n",
+		formatReportMinutes(report.Focus.FocusedMinutes), report.Focus.Sessions, formatReportMinutes(report.Focus.DisruptedMinutes))
+	b.WriteString("<table>\n<tr><th>Day</th><th>Focused</th><th>Disrupted</th></tr>\n")
+	for _, day := range report.Focus.Days {
+		fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			reportDayName(day.Date), formatReportMinutes(day.FocusedMinutes), formatReportMinutes(day.DisruptedMinutes))
+	}
+	b.WriteString("</table>\n")
+	if len(report.Focus.Tasks) > 0 {
+		b.WriteString("<ul>\n")
+		for _, task := range report.Focus.Tasks {
+			fmt.Fprintf(&b, "<li>%s: %s (%d sessions)</li>\n", html.EscapeString(task.Title), formatReportMinutes(task.Minutes), task.Sessions)
+		}
+		b.WriteString("</ul>\n")
+	}
+
+	fmt.Fprintf(&b, "<h2>Completed tasks (%d)</h2>\n", len(report.CompletedTasks))
+	if len(report.CompletedTasks) > 0 {
+		b.WriteString("<ul>\n")
+		for _, task := range report.CompletedTasks {
+			fmt.Fprintf(&b, "<li>%s <small>%s</small></li>\n", html.EscapeString(task.Title), html.EscapeString(task.Category))
+		}
+		b.WriteString("</ul>\n")
+	}
+
+	summary := report.Disruptions
+	fmt.Fprintf(&b, "<h2>Disruptions (%d)</h2>\n", summary.TotalDisruptions)
+	if summary.TotalDisruptions > 0 {
+		b.WriteString("<table>\n<tr><th>Category</th><th>Count</th><th>Time</th></tr>\n")
+		for _, category := range disruptionCategoriesByTime(summary) {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%d</td><td>%s</td></tr>\n", html.EscapeString(category),
+				summary.DisruptionsByCategory[category], formatReportMinutes(summary.DisruptionTimeByCategory[category]))
+		}
+		b.WriteString("</table>\n")
+	}
+
+	b.WriteString("<h2>Most used saved tabs</h2>\n")
+	if len(report.TopSavedTabs) > 0 {
+		b.WriteString("<ol>\n")
+		for _, tab := range report.TopSavedTabs {
+			title := html.EscapeString(reportTabTitle(tab))
+			if isHTTPURL(tab.URL) {
+				title = fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(tab.URL), title)
+			}
+			fmt.Fprintf(&b, "<li>%s (%d visits)</li>\n", title, tab.VisitCount)
+		}
+		b.WriteString("</ol>\n")
+	}
+
+	b.WriteString("</body>\n</html>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// renderReportMarkdown writes the report as Markdown with a table per section
+func renderReportMarkdown(w io.Writer, report *WeeklyReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", escapeMarkdownText(reportTitle(report)))
+
+	fmt.Fprintf(&b, "## Focus\n\n%s focused over %d sessions, %s disrupted.\n\n",
+		formatReportMinutes(report.Focus.FocusedMinutes), report.Focus.Sessions, formatReportMinutes(report.Focus.DisruptedMinutes))
+	b.WriteString("| Day | Focused | Disrupted |\n| --- | --- | --- |\n")
+	for _, day := range report.Focus.Days {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n",
+			reportDayName(day.Date), formatReportMinutes(day.FocusedMinutes), formatReportMinutes(day.DisruptedMinutes))
+	}
+	if len(report.Focus.Tasks) > 0 {
+		b.WriteString("\n")
+		for _, task := range report.Focus.Tasks {
+			fmt.Fprintf(&b, "- %s: %s (%d sessions)\n", escapeMarkdownText(task.Title), formatReportMinutes(task.Minutes), task.Sessions)
+		}
+	}
+
+	fmt.Fprintf(&b, "\n## Completed tasks (%d)\n\n", len(report.CompletedTasks))
+	for _, task := range report.CompletedTasks {
+		fmt.Fprintf(&b, "- %s (%s)\n", escapeMarkdownText(task.Title), escapeMarkdownText(task.Category))
+	}
+
+	summary := report.Disruptions
+	fmt.Fprintf(&b, "\n## Disruptions (%d)\n\n", summary.TotalDisruptions)
+	if summary.TotalDisruptions > 0 {
+		b.WriteString("| Category | Count | Time |\n| --- | --- | --- |\n")
+		for _, category := range disruptionCategoriesByTime(summary) {
+			fmt.Fprintf(&b, "| %s | %d | %s |\n", strings.ReplaceAll(escapeMarkdownText(category), "|", `\|`),
+				summary.DisruptionsByCategory[category], formatReportMinutes(summary.DisruptionTimeByCategory[category]))
+		}
+	}
+
+	b.WriteString("\n## Most used saved tabs\n\n")
+	for i, tab := range report.TopSavedTabs {
+		fmt.Fprintf(&b, "%d. [%s](%s) (%d visits)\n", i+1, escapeMarkdownText(reportTabTitle(tab)), escapeMarkdownURL(tab.URL), tab.VisitCount)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func reportTitle(report *WeeklyReport) string {
+	return fmt.Sprintf("Weekly report %s (%s to %s)", report.Week, report.From, report.To)
+}
+
+func reportTabTitle(tab ReportSavedTab) string {
+	if title := strings.TrimSpace(tab.Title); title != "" {
+		return title
+	}
+	return tab.URL
+}
+
+// reportDayName formats a YYYY-MM-DD date as "Mon 2006-01-02"
+func reportDayName(date string) string {
+	t, err := time.Parse(disruptionDateFormat, date)
+	if err != nil {
+		return date
+	}
+	return t.Format("Mon 2006-01-02")
+}
+
+// formatReportMinutes formats minutes as hours and minutes, e.g. 2h 05m
+func formatReportMinutes(minutes float64) string {
+	total := int(math.Round(minutes))
+	if total < 60 {
+		return fmt.Sprintf("%dm", total)
+	}
+	return fmt.Sprintf("%dh %02dm", total/60, total%60)
+}
+
+// disruptionCategoriesByTime returns a summary's categories, most time first
+func disruptionCategoriesByTime(summary *DisruptionSummary) []string {
+	categories := make([]string, 0, len(summary.DisruptionsByCategory))
+	for category := range summary.DisruptionsByCategory {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := summary.DisruptionTimeByCategory[categories[i]], summary.DisruptionTimeByCategory[categories[j]]
+		if a != b {
+			return a > b
+		}
+		return categories[i] < categories[j]
+	})
+	return categories
+}
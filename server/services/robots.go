@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Robots defaults
+const (
+	robotsCacheTTL = 24 * time.Hour
+	// maxRobotsSize is how much of a robots.txt is read, as RFC 9309 allows
+	maxRobotsSize = 500 << 10
+)
+
+// robotsRule is one Allow or Disallow line
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// robotsRules are the rules of the robots.txt group that applies to us
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	disallowed bool // the whole site, e.g. while robots.txt errors
+}
+
+// parseRobots picks the group for agent (the most specific user-agent line
+// contained in it, else *) from a robots.txt
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	type group struct {
+		agents []string
+		rules  []robotsRule
+		delay  time.Duration
+	}
+	var groups []*group
+	var current *group
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			if current != nil && value != "" {
+				current.rules = append(current.rules, robotsRule{pattern: value, allow: key == "allow"})
+			}
+		case "crawl-delay":
+			inAgents = false
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && current != nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	agent = strings.ToLower(agent)
+	var best *group
+	bestLength := -1
+	for _, g := range groups {
+		for _, name := range g.agents {
+			length := -1
+			if name == "*" {
+				length = 0
+			} else if name != "" && strings.Contains(agent, name) {
+				length = len(name)
+			}
+			if length > bestLength {
+				best, bestLength = g, length
+			}
+		}
+	}
+	if best == nil {
+		return &robotsRules{}
+	}
+	return &robotsRules{rules: best.rules, crawlDelay: best.delay}
+}
+
+// Allowed reports whether path (with any query) may be fetched: the longest
+// matching rule wins, Allow on a tie
+func (rr *robotsRules) Allowed(path string) bool {
+	if rr.disallowed {
+		return false
+	}
+	if path == "" {
+		path = "/"
+	}
+	allowed, longest := true, -1
+	for _, rule := range rr.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allowed, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches a robots.txt path pattern, where * is any run of
+// characters and a trailing $ anchors the end
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		index := strings.Index(rest, part)
+		if index < 0 {
+			return false
+		}
+		rest = rest[index+len(part):]
+	}
+	return !anchored || rest == ""
+}
+
+// robotsCache fetches and caches robots.txt per scheme and host
+type robotsCache struct {
+	client *http.Client
+	agent  string
+	mu     sync.Mutex
+	hosts  map[string]robotsCacheEntry
+}
+
+type robotsCacheEntry struct {
+	rules   *robotsRules
+	expires time.Time
+}
+
+func newRobotsCache(client *http.Client, agent string) *robotsCache {
+	return &robotsCache{client: client, agent: agent, hosts: make(map[string]robotsCacheEntry)}
+}
+
+// rules returns the rules for origin (scheme://host), calling beforeFetch
+// before requesting robots.txt so the caller can space out requests. A
+// missing robots.txt allows everything and a failing one (5xx) disallows
+// everything until it is fetched again; an unreachable host is left for the
+// caller's own request to report.
+func (rc *robotsCache) rules(ctx context.Context, origin string, beforeFetch func() error) *robotsRules {
+	rc.mu.Lock()
+	entry, ok := rc.hosts[origin]
+	rc.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.rules
+	}
+
+	if err := beforeFetch(); err != nil {
+		return &robotsRules{disallowed: true}
+	}
+	rules := &robotsRules{}
+	if req, err := newOutboundRequest(ctx, http.MethodGet, origin+"/robots.txt", rc.agent); err == nil {
+		if resp, err := rc.client.Do(req); err == nil {
+			switch {
+			case resp.StatusCode >= 500:
+				rules.disallowed = true
+			case resp.StatusCode < 300:
+				rules = parseRobots(io.LimitReader(resp.Body, maxRobotsSize), rc.agent)
+			}
+			resp.Body.Close()
+		}
+	}
+
+	rc.mu.Lock()
+	rc.hosts[origin] = robotsCacheEntry{rules: rules, expires: time.Now().Add(robotsCacheTTL)}
+	rc.mu.Unlock()
+	return rules
+}
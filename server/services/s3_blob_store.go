@@ -0,0 +1,228 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3RequestTimeout bounds one S3 request
+const s3RequestTimeout = time.Minute
+
+// S3BlobStore keeps blobs as objects in an S3-compatible bucket, such as
+// AWS S3 or MinIO. Requests are signed with AWS Signature Version 4.
+type S3BlobStore struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	// pathStyle addresses objects as endpoint/bucket/key, as MinIO expects,
+	// instead of bucket.endpoint/key
+	pathStyle bool
+}
+
+// NewS3BlobStore creates a blob store for a bucket. An empty endpoint means
+// AWS S3 in region.
+func NewS3BlobStore(endpoint, bucket, region, accessKey, secretKey string, pathStyle bool) (*S3BlobStore, error) {
+	if bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3 blob store needs a bucket and credentials")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	parsed, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return &S3BlobStore{
+		client:    &http.Client{Timeout: s3RequestTimeout},
+		endpoint:  parsed,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		pathStyle: pathStyle,
+	}, nil
+}
+
+// Put stores data under key
+func (ss *S3BlobStore) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := ss.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to write blob %s: %s", key, s3ErrorMessage(resp))
+	}
+	return nil
+}
+
+// Get reads the blob stored under key
+func (ss *S3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := ss.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	default:
+		return nil, fmt.Errorf("failed to read blob %s: %s", key, s3ErrorMessage(resp))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Delete removes the blob stored under key; S3 does not mind missing keys
+func (ss *S3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := ss.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete blob %s: %s", key, s3ErrorMessage(resp))
+	}
+	return nil
+}
+
+// do sends a signed request for an object
+func (ss *S3BlobStore) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return nil, fmt.Errorf("invalid blob key %q", key)
+	}
+
+	target := *ss.endpoint
+	path := "/" + key
+	if ss.pathStyle {
+		path = "/" + ss.bucket + path
+	} else {
+		target.Host = ss.bucket + "." + target.Host
+	}
+	target.Path = strings.TrimSuffix(ss.endpoint.Path, "/") + path
+	target.RawPath = s3Escape(target.Path, true)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	ss.sign(req, body, time.Now().UTC())
+	return ss.client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (ss *S3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalRequest := s3CanonicalRequest(req, payloadHash, amzDate)
+	scope := date + "/" + ss.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+ss.secretKey), date)
+	for _, part := range []string{ss.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		ss.accessKey, scope, s3SignedHeaders, signature))
+}
+
+// s3SignedHeaders are the headers covered by request signatures
+const s3SignedHeaders = "host;x-amz-content-sha256;x-amz-date"
+
+// s3CanonicalRequest returns the canonical form of req that is signed
+func s3CanonicalRequest(req *http.Request, payloadHash, amzDate string) string {
+	return strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		s3SignedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+// s3CanonicalQuery sorts and encodes query parameters for signing
+func s3CanonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters and, in
+// paths, slashes, as Signature Version 4 requires
+func s3Escape(value string, path bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', path && b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// s3ErrorMessage describes a failed response, including S3's error code
+func s3ErrorMessage(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	code := ""
+	if start := bytes.Index(body, []byte("<Code>")); start >= 0 {
+		if end := bytes.Index(body[start:], []byte("</Code>")); end >= 0 {
+			code = " " + string(body[start+len("<Code>"):start+end])
+		}
+	}
+	return fmt.Sprintf("status %d%s", resp.StatusCode, code)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Session export formats
+const (
+	ExportFormatHTML     = "html"
+	ExportFormatMarkdown = "markdown"
+	ExportFormatOPML     = "opml"
+	ExportFormatCSV      = "csv"
+	ExportFormatURLs     = "urls"
+)
+
+// sessionExportFormats maps each export format to its content type and file extension
+var sessionExportFormats = map[string]struct {
+	ContentType string
+	Extension   string
+}{
+	ExportFormatHTML:     {"text/html; charset=utf-8", "html"},
+	ExportFormatMarkdown: {"text/markdown; charset=utf-8", "md"},
+	ExportFormatOPML:     {"text/x-opml; charset=utf-8", "opml"},
+	ExportFormatCSV:      {"text/csv; charset=utf-8", "csv"},
+	ExportFormatURLs:     {"text/plain; charset=utf-8", "txt"},
+}
+
+// exportWindow is a window's tabs in display order
+type exportWindow struct {
+	Number int
+	Tabs   []Tab
+}
+
+// SessionExportContentType returns the content type and file extension for a format
+func SessionExportContentType(format string) (string, string, error) {
+	info, ok := sessionExportFormats[format]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported export format %q (expected html, markdown, opml, csv or urls)", format)
+	}
+	return info.ContentType, info.Extension, nil
+}
+
+// SessionExportFilename builds a download filename for the exported sessions
+func SessionExportFilename(sessions []*Session, format string) string {
+	_, extension, _ := SessionExportContentType(format)
+	name := "sessions"
+	if len(sessions) == 1 {
+		name = slugify(sessions[0].Name)
+	}
+	if name == "" {
+		name = "session"
+	}
+	return name + "." + extension
+}
+
+// RenderSessions writes the sessions in the requested format. The title is
+// used for the document heading when more than one session is exported.
+func RenderSessions(w io.Writer, format, title string, sessions []*Session) error {
+	switch format {
+	case ExportFormatHTML:
+		return renderSessionsHTML(w, title, sessions)
+	case ExportFormatMarkdown:
+		return renderSessionsMarkdown(w, title, sessions)
+	case ExportFormatOPML:
+		return renderSessionsOPML(w, title, sessions)
+	case ExportFormatCSV:
+		return renderSessionsCSV(w, sessions)
+	case ExportFormatURLs:
+		return renderSessionsURLs(w, sessions)
+	default:
+		_, _, err := SessionExportContentType(format)
+		return err
+	}
+}
+
+// renderSessionsHTML writes a Netscape bookmark file with one folder per
+// session and a subfolder per window when a session spans several windows
+func renderSessionsHTML(w io.Writer, title string, sessions []*Session) error {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE NETSCAPE-Bookmark-file-1>\n")
+	b.WriteString("<META HTTP-EQUIV=\"Content-Type\" CONTENT=\"text/html; charset=UTF-8\">\n")
+	fmt.Fprintf(&b, "<TITLE>%s</TITLE>\n<H1>%s</H1>\n<DL><p>\n", html.EscapeString(title), html.EscapeString(title))
+
+	for _, session := range sessions {
+		added := exportUnixTime(session.CreatedAt)
+		fmt.Fprintf(&b, "    <DT><H3 ADD_DATE=\"%d\"", added)
+		if modified := exportUnixTime(session.LastModified); modified > 0 {
+			fmt.Fprintf(&b, " LAST_MODIFIED=\"%d\"", modified)
+		}
+		fmt.Fprintf(&b, ">%s</H3>\n", html.EscapeString(session.Name))
+		if session.Description != "" {
+			fmt.Fprintf(&b, "    <DD>%s\n", html.EscapeString(session.Description))
+		}
+		b.WriteString("    <DL><p>\n")
+
+		windows := groupTabsByWindow(session.Tabs)
+		for _, window := range windows {
+			indent := "        "
+			if len(windows) > 1 {
+				fmt.Fprintf(&b, "        <DT><H3>Window %d</H3>\n        <DL><p>\n", window.Number)
+				indent = "            "
+			}
+			for _, tab := range window.Tabs {
+				fmt.Fprintf(&b, "%s<DT><A HREF=\"%s\" ADD_DATE=\"%d\"", indent, html.EscapeString(tab.URL), added)
+				if tags := strings.Join(session.Tags, ","); tags != "" {
+					fmt.Fprintf(&b, " TAGS=\"%s\"", html.EscapeString(tags))
+				}
+				if isHTTPURL(tab.FavIconUrl) {
+					fmt.Fprintf(&b, " ICON_URI=\"%s\"", html.EscapeString(tab.FavIconUrl))
+				}
+				fmt.Fprintf(&b, ">%s</A>\n", html.EscapeString(exportTabTitle(tab)))
+			}
+			if len(windows) > 1 {
+				b.WriteString("        </DL><p>\n")
+			}
+		}
+		b.WriteString("    </DL><p>\n")
+	}
+
+	b.WriteString("</DL><p>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// renderSessionsMarkdown writes link lists grouped by window
+func renderSessionsMarkdown(w io.Writer, title string, sessions []*Session) error {
+	var b strings.Builder
+	heading := "#"
+	if len(sessions) > 1 {
+		fmt.Fprintf(&b, "# %s\n\n", escapeMarkdownText(title))
+		heading = "##"
+	}
+
+	for i, session := range sessions {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s %s\n\n", heading, escapeMarkdownText(session.Name))
+		if session.Description != "" {
+			fmt.Fprintf(&b, "%s\n\n", escapeMarkdownText(session.Description))
+		}
+		if len(session.Tags) > 0 {
+			tags := make([]string, len(session.Tags))
+			for j, tag := range session.Tags {
+				tags[j] = "`" + strings.ReplaceAll(tag, "`", "'") + "`"
+			}
+			fmt.Fprintf(&b, "Tags: %s\n\n", strings.Join(tags, " "))
+		}
+
+		windows := groupTabsByWindow(session.Tabs)
+		for j, window := range windows {
+			if len(windows) > 1 {
+				if j > 0 {
+					b.WriteString("\n")
+				}
+				fmt.Fprintf(&b, "%s# Window %d\n\n", heading, window.Number)
+			}
+			for _, tab := range window.Tabs {
+				fmt.Fprintf(&b, "- [%s](%s)\n", escapeMarkdownText(exportTabTitle(tab)), escapeMarkdownURL(tab.URL))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// OPML document structure
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Head    opmlHead      `xml:"head"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title        string `xml:"title"`
+	DateCreated  string `xml:"dateCreated,omitempty"`
+	DateModified string `xml:"dateModified,omitempty"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	URL      string        `xml:"url,attr,omitempty"`
+	Category string        `xml:"category,attr,omitempty"`
+	Created  string        `xml:"created,attr,omitempty"`
+	Children []opmlOutline `xml:"outline"`
+}
+
+// renderSessionsOPML writes an OPML 2.0 outline: session > window > link
+func renderSessionsOPML(w io.Writer, title string, sessions []*Session) error {
+	doc := opmlDocument{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	if len(sessions) == 1 {
+		doc.Head.DateCreated = exportRFC1123(sessions[0].CreatedAt)
+		doc.Head.DateModified = exportRFC1123(sessions[0].LastModified)
+	}
+
+	for _, session := range sessions {
+		outline := opmlOutline{
+			Text:     session.Name,
+			Title:    session.Description,
+			Category: strings.Join(session.Tags, ","),
+			Created:  exportRFC1123(session.CreatedAt),
+		}
+		windows := groupTabsByWindow(session.Tabs)
+		for _, window := range windows {
+			links := make([]opmlOutline, 0, len(window.Tabs))
+			for _, tab := range window.Tabs {
+				links = append(links, opmlOutline{Text: exportTabTitle(tab), Type: "link", URL: tab.URL})
+			}
+			if len(windows) > 1 {
+				outline.Children = append(outline.Children, opmlOutline{
+					Text:     fmt.Sprintf("Window %d", window.Number),
+					Children: links,
+				})
+			} else {
+				outline.Children = append(outline.Children, links...)
+			}
+		}
+		doc.Body = append(doc.Body, outline)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// renderSessionsCSV writes one row per tab
+func renderSessionsCSV(w io.Writer, sessions []*Session) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"session_id", "session_name", "window", "index", "title", "url", "pinned", "tags"}); err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		for _, window := range groupTabsByWindow(session.Tabs) {
+			for i, tab := range window.Tabs {
+				record := []string{
+					session.ID,
+					escapeCSVCell(session.Name),
+					fmt.Sprintf("%d", window.Number),
+					fmt.Sprintf("%d", i),
+					escapeCSVCell(exportTabTitle(tab)),
+					escapeCSVCell(tab.URL),
+					fmt.Sprintf("%t", tab.Pinned),
+					escapeCSVCell(strings.Join(session.Tags, ";")),
+				}
+				if err := writer.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// renderSessionsURLs writes one URL per line with a blank line between sessions
+func renderSessionsURLs(w io.Writer, sessions []*Session) error {
+	var b strings.Builder
+	for i, session := range sessions {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for _, window := range groupTabsByWindow(session.Tabs) {
+			for _, tab := range window.Tabs {
+				b.WriteString(tab.URL)
+				b.WriteString("\n")
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// groupTabsByWindow orders tabs by window then index, numbering windows from 1
+// and dropping tabs without a URL
+func groupTabsByWindow(tabs []Tab) []exportWindow {
+	byWindow := make(map[int][]Tab)
+	var windowIDs []int
+	for _, tab := range tabs {
+		if tab.URL == "" {
+			continue
+		}
+		if _, ok := byWindow[tab.WindowId]; !ok {
+			windowIDs = append(windowIDs, tab.WindowId)
+		}
+		byWindow[tab.WindowId] = append(byWindow[tab.WindowId], tab)
+	}
+	sort.Ints(windowIDs)
+
+	windows := make([]exportWindow, 0, len(windowIDs))
+	for i, id := range windowIDs {
+		windowTabs := byWindow[id]
+		sort.SliceStable(windowTabs, func(a, b int) bool { return windowTabs[a].Index < windowTabs[b].Index })
+		windows = append(windows, exportWindow{Number: i + 1, Tabs: windowTabs})
+	}
+	return windows
+}
+
+// exportTabTitle falls back to the URL for untitled tabs
+func exportTabTitle(tab Tab) string {
+	if title := strings.TrimSpace(tab.Title); title != "" {
+		return title
+	}
+	return tab.URL
+}
+
+// escapeMarkdownText escapes characters that would change link or heading rendering
+func escapeMarkdownText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	replacer := strings.NewReplacer(
+		`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`, ">", `\>`, "#", `\#`,
+	)
+	return replacer.Replace(text)
+}
+
+// escapeMarkdownURL percent-encodes characters that would terminate a link target
+func escapeMarkdownURL(url string) string {
+	replacer := strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+	return replacer.Replace(url)
+}
+
+// escapeCSVCell neutralizes values that spreadsheets would evaluate as formulas
+func escapeCSVCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// exportUnixTime converts an ISO timestamp to Unix seconds, or 0 if unset
+func exportUnixTime(value string) int64 {
+	if t, ok := parseTimestamp(value); ok {
+		return t.Unix()
+	}
+	return 0
+}
+
+// exportRFC1123 converts an ISO timestamp to the RFC 822 style dates OPML uses
+func exportRFC1123(value string) string {
+	if t, ok := parseTimestamp(value); ok {
+		return t.UTC().Format(time.RFC1123Z)
+	}
+	return ""
+}
+
+// isHTTPURL reports whether a URL is safe to embed as a remote reference
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+// slugify turns a name into a filename-safe slug
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case b.Len() > 0 && !dash:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
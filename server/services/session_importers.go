@@ -0,0 +1,600 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Third-party import formats
+const (
+	ImportFormatOneTab       = "onetab"
+	ImportFormatSessionBuddy = "sessionbuddy"
+	ImportFormatToby         = "toby"
+	ImportFormatBookmarks    = "bookmarks"
+	ImportFormatFirefox      = "firefox"
+)
+
+// SessionImportFormats lists the supported third-party formats
+var SessionImportFormats = []string{
+	ImportFormatOneTab,
+	ImportFormatSessionBuddy,
+	ImportFormatToby,
+	ImportFormatBookmarks,
+	ImportFormatFirefox,
+}
+
+// importedLink is the common shape every importer reduces a tab to
+type importedLink struct {
+	URL        string
+	Title      string
+	FavIconUrl string
+	Pinned     bool
+	Active     bool
+}
+
+// importedWindow groups links the way the source grouped them
+type importedWindow struct {
+	Links []importedLink
+}
+
+// DetectSessionImportFormat guesses the format of a third-party export
+func DetectSessionImportFormat(payload []byte) string {
+	if isMozLz4(payload) {
+		return ImportFormatFirefox
+	}
+
+	trimmed := bytes.TrimSpace(payload)
+	upper := strings.ToUpper(string(trimmed[:min(len(trimmed), 512)]))
+	if strings.HasPrefix(upper, "<!DOCTYPE NETSCAPE-BOOKMARK") || strings.Contains(upper, "<DL>") {
+		return ImportFormatBookmarks
+	}
+
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &probe); err == nil {
+			switch {
+			case probe["windows"] != nil:
+				return ImportFormatFirefox
+			case probe["collections"] != nil, probe["sessions"] != nil:
+				return ImportFormatSessionBuddy
+			case probe["lists"] != nil, probe["groups"] != nil:
+				return ImportFormatToby
+			}
+		}
+		return ""
+	}
+
+	return ImportFormatOneTab
+}
+
+// ParseSessionImport converts a third-party export into an archive that can
+// be applied with ImportArchive. An empty format is detected from the payload.
+func ParseSessionImport(format string, payload []byte) (*AccountArchive, error) {
+	if format == "" {
+		format = DetectSessionImportFormat(payload)
+		if format == "" {
+			return nil, fmt.Errorf("could not detect import format")
+		}
+	}
+
+	archive := &AccountArchive{
+		Format:  ArchiveFormat,
+		Version: ArchiveVersion,
+		Source:  format,
+	}
+
+	var err error
+	switch format {
+	case ImportFormatOneTab:
+		archive.Sessions, err = parseOneTab(payload)
+	case ImportFormatSessionBuddy:
+		archive.Sessions, err = parseSessionBuddy(payload)
+	case ImportFormatToby:
+		archive.Sessions, err = parseToby(payload)
+	case ImportFormatBookmarks:
+		archive.SavedTabs, err = parseNetscapeBookmarks(payload)
+	case ImportFormatFirefox:
+		archive.Sessions, err = parseFirefoxSessionStore(payload)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s export: %w", format, err)
+	}
+	uniqueImportedSessionIDs(archive.Sessions)
+
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// parseOneTab parses OneTab's text export: one "url | title" per line, with
+// blank lines separating tab groups
+func parseOneTab(payload []byte) ([]*Session, error) {
+	var groups [][]importedLink
+	var current []importedLink
+
+	scanner := bufio.NewScanner(bytes.NewReader(payload))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			if len(current) > 0 {
+				groups = append(groups, current)
+				current = nil
+			}
+			continue
+		}
+
+		url, title, _ := strings.Cut(line, " | ")
+		url = strings.TrimSpace(url)
+		if !strings.Contains(url, "://") {
+			continue
+		}
+		current = append(current, importedLink{URL: url, Title: strings.TrimSpace(title)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		groups = append(groups, current)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no links found")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	sessions := make([]*Session, 0, len(groups))
+	for i, links := range groups {
+		name := fmt.Sprintf("OneTab group %d", i+1)
+		sessions = append(sessions, buildImportedSession(ImportFormatOneTab, name, now, now,
+			[]importedWindow{{Links: links}}, []string{"onetab"}))
+	}
+	return sessions, nil
+}
+
+// Session Buddy exports: v3 uses sessions/windows/tabs, v4 uses collections/folders/links
+type sessionBuddyExport struct {
+	Sessions []struct {
+		Name     string `json:"name"`
+		Created  int64  `json:"created"`
+		Modified int64  `json:"modified"`
+		Windows  []struct {
+			Tabs []sessionBuddyLink `json:"tabs"`
+		} `json:"windows"`
+		Tags []string `json:"tags"`
+	} `json:"sessions"`
+	Collections []struct {
+		Title   string `json:"title"`
+		Created int64  `json:"created"`
+		Updated int64  `json:"updated"`
+		Folders []struct {
+			Title string             `json:"title"`
+			Links []sessionBuddyLink `json:"links"`
+		} `json:"folders"`
+	} `json:"collections"`
+}
+
+type sessionBuddyLink struct {
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	FavIconUrl string `json:"favIconUrl"`
+	Pinned     bool   `json:"pinned"`
+	Active     bool   `json:"active"`
+}
+
+func (l sessionBuddyLink) toLink() importedLink {
+	return importedLink{URL: l.URL, Title: l.Title, FavIconUrl: l.FavIconUrl, Pinned: l.Pinned, Active: l.Active}
+}
+
+// parseSessionBuddy parses Session Buddy JSON exports
+func parseSessionBuddy(payload []byte) ([]*Session, error) {
+	var export sessionBuddyExport
+	if err := json.Unmarshal(payload, &export); err != nil {
+		return nil, err
+	}
+
+	var sessions []*Session
+	for i, sbSession := range export.Sessions {
+		var windows []importedWindow
+		for _, window := range sbSession.Windows {
+			var links []importedLink
+			for _, tab := range window.Tabs {
+				links = append(links, tab.toLink())
+			}
+			windows = append(windows, importedWindow{Links: links})
+		}
+
+		name := sbSession.Name
+		if name == "" {
+			name = fmt.Sprintf("Session Buddy session %d", i+1)
+		}
+		tags := append([]string{"session-buddy"}, sbSession.Tags...)
+		sessions = append(sessions, buildImportedSession(ImportFormatSessionBuddy, name,
+			formatEpochMillis(sbSession.Created), formatEpochMillis(sbSession.Modified), windows, tags))
+	}
+
+	for i, collection := range export.Collections {
+		var windows []importedWindow
+		tags := []string{"session-buddy"}
+		for _, folder := range collection.Folders {
+			var links []importedLink
+			for _, link := range folder.Links {
+				links = append(links, link.toLink())
+			}
+			windows = append(windows, importedWindow{Links: links})
+			tags = append(tags, folder.Title)
+		}
+
+		name := collection.Title
+		if name == "" {
+			name = fmt.Sprintf("Session Buddy collection %d", i+1)
+		}
+		sessions = append(sessions, buildImportedSession(ImportFormatSessionBuddy, name,
+			formatEpochMillis(collection.Created), formatEpochMillis(collection.Updated), windows, tags))
+	}
+
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no sessions or collections found")
+	}
+	return sessions, nil
+}
+
+// Toby exports are lists of cards, optionally grouped
+type tobyList struct {
+	Title string `json:"title"`
+	Cards []struct {
+		URL         string `json:"url"`
+		Title       string `json:"title"`
+		CustomTitle string `json:"customTitle"`
+		FavIconUrl  string `json:"favIconUrl"`
+	} `json:"cards"`
+	Labels []string `json:"labels"`
+}
+
+type tobyExport struct {
+	Lists  []tobyList `json:"lists"`
+	Groups []struct {
+		Name  string     `json:"name"`
+		Lists []tobyList `json:"lists"`
+	} `json:"groups"`
+}
+
+// parseToby parses Toby JSON exports; each list becomes a session tagged
+// with the list and group names
+func parseToby(payload []byte) ([]*Session, error) {
+	var export tobyExport
+	if err := json.Unmarshal(payload, &export); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var sessions []*Session
+	addList := func(group string, list tobyList) {
+		var links []importedLink
+		for _, card := range list.Cards {
+			title := card.CustomTitle
+			if title == "" {
+				title = card.Title
+			}
+			links = append(links, importedLink{URL: card.URL, Title: title, FavIconUrl: card.FavIconUrl})
+		}
+
+		name := list.Title
+		if name == "" {
+			name = fmt.Sprintf("Toby list %d", len(sessions)+1)
+		}
+		tags := append([]string{"toby", group, list.Title}, list.Labels...)
+		sessions = append(sessions, buildImportedSession(ImportFormatToby, name, now, now,
+			[]importedWindow{{Links: links}}, tags))
+	}
+
+	for _, list := range export.Lists {
+		addList("", list)
+	}
+	for _, group := range export.Groups {
+		for _, list := range group.Lists {
+			addList(group.Name, list)
+		}
+	}
+
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no lists found")
+	}
+	return sessions, nil
+}
+
+// parseNetscapeBookmarks parses the Netscape bookmark HTML format exported by
+// every major browser. Each bookmark becomes a saved tab tagged with its
+// folder path, e.g. "work/infra".
+func parseNetscapeBookmarks(payload []byte) ([]*SavedTab, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(payload))
+
+	var folders []string
+	var pendingFolder string
+	var inFolderTitle, inLink bool
+	var text strings.Builder
+	var link *SavedTab
+	var tabs []*SavedTab
+	usedIDs := make(map[int]bool)
+
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, err
+			}
+			if len(tabs) == 0 {
+				return nil, fmt.Errorf("no bookmarks found")
+			}
+			return tabs, nil
+
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "h3":
+				inFolderTitle = true
+				text.Reset()
+			case "dl":
+				folders = append(folders, pendingFolder)
+				pendingFolder = ""
+			case "a":
+				inLink = true
+				text.Reset()
+				link = &SavedTab{Index: len(tabs)}
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "href":
+						link.URL = attr.Val
+					case "add_date":
+						if seconds, err := strconv.ParseInt(attr.Val, 10, 64); err == nil {
+							link.SavedAt = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+						}
+					case "icon_uri":
+						link.FavIconUrl = attr.Val
+					case "icon":
+						if link.FavIconUrl == "" {
+							link.FavIconUrl = attr.Val
+						}
+					case "tags":
+						for _, tag := range strings.Split(attr.Val, ",") {
+							link.Tags = appendTag(link.Tags, tag)
+						}
+					}
+				}
+			}
+
+		case html.TextToken:
+			if inFolderTitle || inLink {
+				text.Write(tokenizer.Text())
+			}
+
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "h3":
+				inFolderTitle = false
+				pendingFolder = strings.TrimSpace(text.String())
+			case "dl":
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case "a":
+				inLink = false
+				if link == nil || !strings.Contains(link.URL, "://") {
+					continue
+				}
+				link.Title = strings.TrimSpace(text.String())
+				if path := bookmarkFolderPath(folders); path != "" {
+					link.Tags = appendTag(link.Tags, path)
+				}
+				if link.SavedAt == "" {
+					link.SavedAt = time.Now().UTC().Format(time.RFC3339)
+				}
+				// The same URL is often bookmarked twice in a folder; later
+				// copies mix their occurrence into the ID
+				link.ID = importedTabID(ImportFormatBookmarks, link.URL, bookmarkFolderPath(folders))
+				for occurrence := 2; usedIDs[link.ID]; occurrence++ {
+					link.ID = importedTabID(ImportFormatBookmarks, link.URL, bookmarkFolderPath(folders), strconv.Itoa(occurrence))
+				}
+				usedIDs[link.ID] = true
+				tabs = append(tabs, link)
+				link = nil
+			}
+		}
+	}
+}
+
+// bookmarkFolderPath joins the open folder names, skipping the unnamed root
+func bookmarkFolderPath(folders []string) string {
+	var parts []string
+	for _, folder := range folders {
+		if folder != "" {
+			parts = append(parts, strings.ReplaceAll(folder, "/", "-"))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Firefox sessionstore JSON: windows of tabs, each tab a history of entries
+type firefoxSessionStore struct {
+	Windows []struct {
+		Tabs []struct {
+			Entries []struct {
+				URL   string `json:"url"`
+				Title string `json:"title"`
+			} `json:"entries"`
+			Index  int    `json:"index"` // 1-based current entry
+			Pinned bool   `json:"pinned"`
+			Image  string `json:"image"`
+			Hidden bool   `json:"hidden"`
+		} `json:"tabs"`
+		Selected int `json:"selected"` // 1-based selected tab
+	} `json:"windows"`
+	Session struct {
+		LastUpdate int64 `json:"lastUpdate"`
+		StartTime  int64 `json:"startTime"`
+	} `json:"session"`
+}
+
+// parseFirefoxSessionStore parses sessionstore.js / recovery.jsonlz4
+func parseFirefoxSessionStore(payload []byte) ([]*Session, error) {
+	if isMozLz4(payload) {
+		decoded, err := decodeMozLz4(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = decoded
+	}
+
+	var store firefoxSessionStore
+	if err := json.Unmarshal(payload, &store); err != nil {
+		return nil, err
+	}
+
+	var windows []importedWindow
+	for _, window := range store.Windows {
+		var links []importedLink
+		for i, tab := range window.Tabs {
+			if len(tab.Entries) == 0 || tab.Hidden {
+				continue
+			}
+			current := tab.Index - 1
+			if current < 0 || current >= len(tab.Entries) {
+				current = len(tab.Entries) - 1
+			}
+			entry := tab.Entries[current]
+			links = append(links, importedLink{
+				URL:        entry.URL,
+				Title:      entry.Title,
+				FavIconUrl: tab.Image,
+				Pinned:     tab.Pinned,
+				Active:     i+1 == window.Selected,
+			})
+		}
+		windows = append(windows, importedWindow{Links: links})
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no windows found")
+	}
+
+	created := formatEpochMillis(store.Session.StartTime)
+	modified := formatEpochMillis(store.Session.LastUpdate)
+	name := "Firefox session"
+	if t, ok := parseTimestamp(modified); ok {
+		name = fmt.Sprintf("Firefox session %s", t.Format("2006-01-02 15:04"))
+	}
+	return []*Session{buildImportedSession(ImportFormatFirefox, name, created, modified, windows, []string{"firefox"})}, nil
+}
+
+// buildImportedSession assembles a Session with a deterministic ID so that
+// importing the same export twice updates rather than duplicates it
+func buildImportedSession(format, name, createdAt, lastModified string, windows []importedWindow, tags []string) *Session {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if createdAt == "" {
+		createdAt = now
+	}
+	if lastModified == "" {
+		lastModified = createdAt
+	}
+
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\n%s\n", format, name)
+
+	session := &Session{
+		Name:         name,
+		CreatedAt:    createdAt,
+		LastModified: lastModified,
+		Tabs:         []Tab{},
+		Metadata:     map[string]string{"importedFrom": format},
+	}
+	for _, tag := range tags {
+		session.Tags = appendTag(session.Tags, tag)
+	}
+
+	for w, window := range windows {
+		index := 0
+		for _, link := range window.Links {
+			if link.URL == "" {
+				continue
+			}
+			fmt.Fprintf(hash, "%d %s\n", w, link.URL)
+			session.Tabs = append(session.Tabs, Tab{
+				ID:         len(session.Tabs) + 1,
+				URL:        link.URL,
+				Title:      link.Title,
+				WindowId:   w + 1,
+				Index:      index,
+				Active:     link.Active,
+				Pinned:     link.Pinned,
+				FavIconUrl: link.FavIconUrl,
+			})
+			index++
+		}
+	}
+
+	session.ID = format + "-" + hex.EncodeToString(hash.Sum(nil))[:16]
+	session.TabCount = len(session.Tabs)
+	session.WindowCount = len(windows)
+	return session
+}
+
+// uniqueImportedSessionIDs numbers sessions that would otherwise share an
+// ID, such as identical groups in one export. The first keeps its ID so that
+// re-importing the export still updates it.
+func uniqueImportedSessionIDs(sessions []*Session) {
+	used := make(map[string]bool, len(sessions))
+	for _, session := range sessions {
+		id := session.ID
+		for occurrence := 2; used[id]; occurrence++ {
+			id = fmt.Sprintf("%s-%d", session.ID, occurrence)
+		}
+		session.ID = id
+		used[id] = true
+	}
+}
+
+// importedTabID derives a stable positive integer ID for an imported saved tab
+func importedTabID(parts ...string) int {
+	hash := fnv.New32a()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return int(hash.Sum32()&0x7fffffff) + 1
+}
+
+// appendTag adds a trimmed, non-empty tag if it is not already present
+func appendTag(tags []string, tag string) []string {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return tags
+	}
+	for _, existing := range tags {
+		if existing == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
+// formatEpochMillis converts a JavaScript timestamp to an ISO string
+func formatEpochMillis(ms int64) string {
+	if ms <= 0 {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
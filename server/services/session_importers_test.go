@@ -0,0 +1,97 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSessionImportDetectsFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"onetab", "https://a.example | A\nhttps://b.example | B\n", ImportFormatOneTab},
+		{"bookmarks", "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n<DL><p><DT><A HREF=\"https://a.example\">A</A></DL>", ImportFormatBookmarks},
+		{"firefox", `{"windows":[{"tabs":[{"entries":[{"url":"https://a.example"}],"index":1}]}]}`, ImportFormatFirefox},
+		{"session buddy", `{"sessions":[{"name":"S","windows":[{"tabs":[{"url":"https://a.example"}]}]}]}`, ImportFormatSessionBuddy},
+		{"toby", `{"lists":[{"title":"T","cards":[{"url":"https://a.example"}]}]}`, ImportFormatToby},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := ParseSessionImport("", []byte(tt.payload))
+			if err != nil {
+				t.Fatalf("ParseSessionImport() error = %v", err)
+			}
+			if archive.Source != tt.want {
+				t.Errorf("ParseSessionImport() source = %s, want %s", archive.Source, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetscapeBookmarksDuplicates(t *testing.T) {
+	payload := `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<DL><p>
+	<DT><H3>Work</H3>
+	<DL><p>
+		<DT><A HREF="https://a.example/">A</A>
+		<DT><A HREF="https://a.example/">A again</A>
+		<DT><A HREF="https://a.example/">A once more</A>
+	</DL><p>
+	<DT><A HREF="https://a.example/">A at the root</A>
+</DL>`
+
+	archive, err := ParseSessionImport(ImportFormatBookmarks, []byte(payload))
+	if err != nil {
+		t.Fatalf("ParseSessionImport() error = %v", err)
+	}
+	if len(archive.SavedTabs) != 4 {
+		t.Fatalf("got %d saved tabs, want 4", len(archive.SavedTabs))
+	}
+
+	ids := make(map[int]bool)
+	for _, tab := range archive.SavedTabs {
+		if ids[tab.ID] {
+			t.Errorf("duplicate saved tab id %d", tab.ID)
+		}
+		ids[tab.ID] = true
+	}
+
+	// The first copy keeps the ID earlier imports gave it
+	first := importedTabID(ImportFormatBookmarks, "https://a.example/", "Work")
+	if archive.SavedTabs[0].ID != first {
+		t.Errorf("first bookmark id = %d, want %d", archive.SavedTabs[0].ID, first)
+	}
+	if got := archive.SavedTabs[0].Tags; len(got) != 1 || got[0] != "Work" {
+		t.Errorf("first bookmark tags = %v, want [Work]", got)
+	}
+
+	again, err := ParseSessionImport(ImportFormatBookmarks, []byte(payload))
+	if err != nil {
+		t.Fatalf("ParseSessionImport() error = %v", err)
+	}
+	for i := range again.SavedTabs {
+		if again.SavedTabs[i].ID != archive.SavedTabs[i].ID {
+			t.Errorf("bookmark %d id changed between imports", i)
+		}
+	}
+}
+
+func TestParseSessionImportIdenticalSessions(t *testing.T) {
+	session := `{"name":"Reading","windows":[{"tabs":[{"url":"https://a.example"}]}]}`
+	payload := `{"sessions":[` + strings.Join([]string{session, session, session}, ",") + `]}`
+
+	archive, err := ParseSessionImport(ImportFormatSessionBuddy, []byte(payload))
+	if err != nil {
+		t.Fatalf("ParseSessionImport() error = %v", err)
+	}
+	if len(archive.Sessions) != 3 {
+		t.Fatalf("got %d sessions, want 3", len(archive.Sessions))
+	}
+	first := archive.Sessions[0].ID
+	if archive.Sessions[1].ID != first+"-2" || archive.Sessions[2].ID != first+"-3" {
+		t.Errorf("session ids = %s, %s, %s", first, archive.Sessions[1].ID, archive.Sessions[2].ID)
+	}
+}
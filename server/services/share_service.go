@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Shares live in their own top-level collection so a token can be resolved
+// without knowing the owner
+const SHARES_COLLECTION_NAME = "tab-blaster-5k-shares"
+
+// Share modes
+const (
+	// ShareModeSnapshot freezes the session as it was when the share was created
+	ShareModeSnapshot = "snapshot"
+	// ShareModeLive always renders the owner's current version of the session
+	ShareModeLive = "live"
+)
+
+// Share resolution errors
+var (
+	ErrShareNotFound         = errors.New("share not found")
+	ErrShareExpired          = errors.New("share has expired")
+	ErrSharePasswordRequired = errors.New("share is password protected")
+	ErrSharePasswordInvalid  = errors.New("invalid share password")
+)
+
+// SessionShare is a public, read-only link to a session
+type SessionShare struct {
+	Token        string   `json:"token" firestore:"token"`
+	OwnerID      string   `json:"ownerId" firestore:"ownerId"`
+	SessionID    string   `json:"sessionId" firestore:"sessionId"`
+	SessionName  string   `json:"sessionName" firestore:"sessionName"`
+	Mode         string   `json:"mode" firestore:"mode"`
+	Snapshot     *Session `json:"-" firestore:"snapshot,omitempty"`
+	PasswordHash string   `json:"-" firestore:"passwordHash,omitempty"`
+	HasPassword  bool     `json:"hasPassword" firestore:"hasPassword"`
+	CreatedAt    string   `json:"createdAt" firestore:"createdAt"`
+	ExpiresAt    string   `json:"expiresAt,omitempty" firestore:"expiresAt,omitempty"`
+	ViewCount    int      `json:"viewCount" firestore:"viewCount"`
+	LastViewedAt string   `json:"lastViewedAt,omitempty" firestore:"lastViewedAt,omitempty"`
+}
+
+// ShareOptions configures a new share
+type ShareOptions struct {
+	Mode      string `json:"mode"`      // snapshot (default) or live
+	ExpiresIn string `json:"expiresIn"` // Go duration, e.g. "72h"
+	ExpiresAt string `json:"expiresAt"` // ISO timestamp; takes precedence over expiresIn
+	Password  string `json:"password"`
+}
+
+// ShareService manages public share links for sessions
+type ShareService struct {
+	firebaseService *FirebaseService
+	userDataService *UserDataService
+	mu              sync.RWMutex
+}
+
+var (
+	shareService *ShareService
+	shareOnce    sync.Once
+	shareErr     error
+)
+
+// NewShareService creates a new share service instance
+func NewShareService() (*ShareService, error) {
+	shareOnce.Do(func() {
+		shareService, shareErr = initializeShareService()
+	})
+	return shareService, shareErr
+}
+
+// initializeShareService initializes the share service
+func initializeShareService() (*ShareService, error) {
+	firebaseService, err := NewFirebaseService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Firebase service: %w", err)
+	}
+
+	userDataService, err := NewUserDataService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize user data service: %w", err)
+	}
+
+	service := &ShareService{
+		firebaseService: firebaseService,
+		userDataService: userDataService,
+	}
+
+	log.Println("Share service initialized successfully")
+	return service, nil
+}
+
+// generateShareToken returns a 256-bit URL-safe random token
+func generateShareToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreateShare creates a share link for one of the user's sessions
+func (ss *ShareService) CreateShare(ctx context.Context, userID, sessionID string, opts ShareOptions) (*SessionShare, error) {
+	session, err := ss.userDataService.GetUserSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	share := &SessionShare{
+		OwnerID:     userID,
+		SessionID:   sessionID,
+		SessionName: session.Name,
+		Mode:        opts.Mode,
+		CreatedAt:   now.Format(time.RFC3339),
+	}
+
+	switch opts.Mode {
+	case "", ShareModeSnapshot:
+		share.Mode = ShareModeSnapshot
+		share.Snapshot = session
+	case ShareModeLive:
+	default:
+		return nil, fmt.Errorf("unknown share mode %q (expected snapshot or live)", opts.Mode)
+	}
+
+	switch {
+	case opts.ExpiresAt != "":
+		expiresAt, ok := parseTimestamp(opts.ExpiresAt)
+		if !ok {
+			return nil, fmt.Errorf("invalid expiresAt %q", opts.ExpiresAt)
+		}
+		if !expiresAt.After(now) {
+			return nil, fmt.Errorf("expiresAt must be in the future")
+		}
+		share.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	case opts.ExpiresIn != "":
+		duration, err := time.ParseDuration(opts.ExpiresIn)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid expiresIn %q", opts.ExpiresIn)
+		}
+		share.ExpiresAt = now.Add(duration).Format(time.RFC3339)
+	}
+
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash share password: %w", err)
+		}
+		share.PasswordHash = string(hash)
+		share.HasPassword = true
+	}
+
+	share.Token, err = generateShareToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	docRef := ss.firebaseService.firestore.Collection(SHARES_COLLECTION_NAME).Doc(share.Token)
+	if _, err := docRef.Create(ctx, share); err != nil {
+		return nil, fmt.Errorf("failed to store share: %w", err)
+	}
+
+	log.Printf("Created %s share for session %s of user %s", share.Mode, sessionID, userID)
+	return share, nil
+}
+
+// ListShares returns the user's shares, optionally limited to one session
+func (ss *ShareService) ListShares(ctx context.Context, userID, sessionID string) ([]*SessionShare, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	query := ss.firebaseService.firestore.Collection(SHARES_COLLECTION_NAME).Where("ownerId", "==", userID)
+	if sessionID != "" {
+		query = query.Where("sessionId", "==", sessionID)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	shares := []*SessionShare{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate shares: %w", err)
+		}
+
+		var share SessionShare
+		if err := doc.DataTo(&share); err != nil {
+			log.Printf("Failed to parse share %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		shares = append(shares, &share)
+	}
+
+	return shares, nil
+}
+
+// RevokeShare deletes one of the user's shares
+func (ss *ShareService) RevokeShare(ctx context.Context, userID, token string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	docRef := ss.firebaseService.firestore.Collection(SHARES_COLLECTION_NAME).Doc(token)
+	share, err := getShare(ctx, docRef)
+	if err != nil {
+		return err
+	}
+	// Don't reveal other users' tokens
+	if share.OwnerID != userID {
+		return ErrShareNotFound
+	}
+
+	if _, err := docRef.Delete(ctx); err != nil {
+		return fmt.Errorf("failed to revoke share: %w", err)
+	}
+
+	log.Printf("Revoked share for session %s of user %s", share.SessionID, userID)
+	return nil
+}
+
+// ResolveShare returns the shared session for a token, enforcing expiry and password
+func (ss *ShareService) ResolveShare(ctx context.Context, token, password string) (*SessionShare, *Session, error) {
+	docRef := ss.firebaseService.firestore.Collection(SHARES_COLLECTION_NAME).Doc(token)
+
+	ss.mu.RLock()
+	share, err := getShare(ctx, docRef)
+	ss.mu.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if share.ExpiresAt != "" {
+		if expiresAt, ok := parseTimestamp(share.ExpiresAt); ok && time.Now().After(expiresAt) {
+			return nil, nil, ErrShareExpired
+		}
+	}
+
+	if share.PasswordHash != "" {
+		if password == "" {
+			return share, nil, ErrSharePasswordRequired
+		}
+		if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			return share, nil, ErrSharePasswordInvalid
+		}
+	}
+
+	session := share.Snapshot
+	if share.Mode == ShareModeLive {
+		session, err = ss.userDataService.GetUserSession(ctx, share.OwnerID, share.SessionID)
+		if err != nil {
+			return nil, nil, ErrShareNotFound
+		}
+	}
+	if session == nil {
+		return nil, nil, ErrShareNotFound
+	}
+
+	// View tracking is best effort
+	_, err = docRef.Update(ctx, []firestore.Update{
+		{Path: "viewCount", Value: firestore.Increment(1)},
+		{Path: "lastViewedAt", Value: time.Now().UTC().Format(time.RFC3339)},
+	})
+	if err != nil {
+		log.Printf("Failed to record view of share for session %s: %v", share.SessionID, err)
+	}
+
+	return share, session, nil
+}
+
+// getShare loads a share document, mapping a missing document to ErrShareNotFound
+func getShare(ctx context.Context, docRef *firestore.DocumentRef) (*SessionShare, error) {
+	doc, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share: %w", err)
+	}
+
+	var share SessionShare
+	if err := doc.DataTo(&share); err != nil {
+		return nil, fmt.Errorf("failed to parse share: %w", err)
+	}
+	return &share, nil
+}
+
+// sharedSessionPage is the minimal read-only page for a shared session
+var sharedSessionPage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{if .Session}}{{.Session.Name}}{{else}}Shared session{{end}} · Tab Blaster 5000</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #1f2937; }
+h1 { font-size: 1.5rem; } h2 { font-size: 1rem; color: #6b7280; margin-top: 1.5rem; }
+ul { padding-left: 1.25rem; } li { margin: 0.25rem 0; } a { color: #2563eb; word-break: break-word; }
+.meta, .error { color: #6b7280; font-size: 0.875rem; } .error { color: #b91c1c; }
+</style>
+</head>
+<body>
+{{if .Session}}
+<h1>{{.Session.Name}}</h1>
+{{if .Session.Description}}<p>{{.Session.Description}}</p>{{end}}
+<p class="meta">{{.TabCount}} tabs{{if .Session.LastModified}} · updated {{.Session.LastModified}}{{end}}</p>
+{{range .Windows}}
+{{if gt (len $.Windows) 1}}<h2>Window {{.Number}}</h2>{{end}}
+<ul>
+{{range .Tabs}}<li><a href="{{.URL}}" rel="noopener noreferrer nofollow">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></li>
+{{end}}</ul>
+{{end}}
+{{else}}
+<h1>Shared session</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .PasswordForm}}
+<form method="post">
+<label>Password <input type="password" name="password" autofocus required></label>
+<button type="submit">View</button>
+</form>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// RenderSharedSessionHTML writes the read-only page for a shared session.
+// With a nil session it renders the error and, if requested, a password form.
+func RenderSharedSessionHTML(w io.Writer, session *Session, errorMessage string, passwordForm bool) error {
+	data := struct {
+		Session      *Session
+		Windows      []exportWindow
+		TabCount     int
+		Error        string
+		PasswordForm bool
+	}{
+		Session:      session,
+		Error:        errorMessage,
+		PasswordForm: passwordForm,
+	}
+	if session != nil {
+		data.Windows = groupTabsByWindow(session.Tabs)
+		for _, window := range data.Windows {
+			data.TabCount += len(window.Tabs)
+		}
+	}
+	return sharedSessionPage.Execute(w, data)
+}
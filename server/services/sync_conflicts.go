@@ -0,0 +1,469 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Conflict statuses
+const (
+	ConflictStatusOpen     = "open"
+	ConflictStatusResolved = "resolved"
+)
+
+// Conflict resolutions
+const (
+	// ConflictResolutionCurrent keeps the merged document as stored
+	ConflictResolutionCurrent = "current"
+	// ConflictResolutionClient re-applies the client's change as submitted
+	ConflictResolutionClient = "client"
+	// ConflictResolutionCustom stores data supplied with the resolution
+	ConflictResolutionCustom = "custom"
+)
+
+// Conflict errors
+var (
+	ErrConflictNotFound      = errors.New("conflict not found")
+	ErrConflictResolved      = errors.New("conflict already resolved")
+	ErrInvalidResolution     = errors.New("resolution must be current, client or custom")
+	ErrResolutionDataMissing = errors.New("custom resolution requires data")
+	ErrSyncContention        = errors.New("document kept changing while the change was merged; retry")
+
+	// errVersionChanged aborts a checked write whose document changed after
+	// it was read
+	errVersionChanged = errors.New("document version changed")
+)
+
+// SyncResult describes how a submitted change was applied
+type SyncResult struct {
+	// Merged is set when the change raced another edit and was merged
+	Merged bool `json:"merged,omitempty"`
+	// ConflictID identifies the recorded conflict when fields could not be merged
+	ConflictID string `json:"conflictId,omitempty"`
+}
+
+// SyncConflict is a concurrent edit the server could not fully merge. The
+// stored document keeps the server's value for each listed field.
+type SyncConflict struct {
+	ID            string          `json:"id" firestore:"id"`
+	Collection    string          `json:"collection" firestore:"collection"`
+	DocumentID    string          `json:"documentId" firestore:"documentId"`
+	Operation     string          `json:"operation" firestore:"operation"`
+	BaseVersion   int64           `json:"baseVersion" firestore:"baseVersion"`
+	ServerVersion int64           `json:"serverVersion" firestore:"serverVersion"`
+	Fields        []string        `json:"fields,omitempty" firestore:"fields,omitempty"`
+	Reason        string          `json:"reason" firestore:"reason"`
+	Status        string          `json:"status" firestore:"status"`
+	Resolution    string          `json:"resolution,omitempty" firestore:"resolution,omitempty"`
+	CreatedAt     string          `json:"createdAt" firestore:"createdAt"`
+	ResolvedAt    string          `json:"resolvedAt,omitempty" firestore:"resolvedAt,omitempty"`
+	ClientData    json.RawMessage `json:"clientData,omitempty" firestore:"-"`
+	ServerData    json.RawMessage `json:"serverData,omitempty" firestore:"-"`
+
+	// JSON is stored as strings; Firestore cannot hold nested arrays
+	StoredClientData string `json:"-" firestore:"clientData,omitempty"`
+	StoredServerData string `json:"-" firestore:"serverData,omitempty"`
+}
+
+// getConflictsCollectionPath returns the path for the conflicts collection
+func getConflictsCollectionPath(userID string) string {
+	return fmt.Sprintf("%s/%s/conflicts", COLLECTION_NAME, userID)
+}
+
+// maxCheckedApplyAttempts bounds how often a checked change is merged again
+// after losing a race with another write to its document
+const maxCheckedApplyAttempts = 3
+
+// ApplyChange applies one client change. Changes carrying a baseVersion are
+// checked against the document's current version; when the document changed
+// since, the client's base, the stored version and the client's version are
+// merged with the collection's merge strategy and fields that cannot be
+// merged are recorded as a conflict. The write only commits if the document
+// is still at the version checked, so a racing write from another request
+// or server instance causes the change to be merged again.
+func (uds *UserDataService) ApplyChange(ctx context.Context, userID string, change SyncChange) (*SyncResult, error) {
+	if change.BaseVersion == 0 {
+		return &SyncResult{}, uds.applyChange(ctx, userID, change)
+	}
+	if change.Operation == ChangeOperationUpsert && len(change.Base) == 0 {
+		return nil, fmt.Errorf("%w: base is required with baseVersion", ErrInvalidSyncChange)
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := uds.applyCheckedChange(ctx, userID, change)
+		if !errors.Is(err, errVersionChanged) {
+			return result, err
+		}
+		if attempt == maxCheckedApplyAttempts {
+			return nil, ErrSyncContention
+		}
+	}
+}
+
+// applyCheckedChange makes one attempt at ApplyChange for a change with a
+// baseVersion. It fails with errVersionChanged if the document changes
+// before the write commits.
+func (uds *UserDataService) applyCheckedChange(ctx context.Context, userID string, change SyncChange) (*SyncResult, error) {
+	collection := CollectionForStorageKey(change.Collection)
+	documentID := syncChangeDocumentID(collection, change)
+	if documentID == "" {
+		// A new document cannot conflict
+		return &SyncResult{}, uds.applyChange(ctx, userID, change)
+	}
+
+	entry, err := uds.getSyncLogEntry(ctx, userID, collection, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return &SyncResult{}, uds.applyChange(withExpectedVersion(ctx, collection, documentID, 0), userID, change)
+	}
+	checked := withExpectedVersion(ctx, collection, documentID, entry.Version)
+	if entry.Version <= change.BaseVersion {
+		return &SyncResult{}, uds.applyChange(checked, userID, change)
+	}
+
+	if entry.Operation == ChangeOperationDelete {
+		if change.Operation == ChangeOperationDelete {
+			return &SyncResult{}, nil
+		}
+		// Edits win over deletions so offline work is never discarded
+		return &SyncResult{Merged: true}, uds.applyChange(checked, userID, change)
+	}
+
+	serverData, err := uds.getSyncDocumentValue(ctx, userID, collection, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	conflict := &SyncConflict{
+		Collection:    collection,
+		DocumentID:    documentID,
+		Operation:     change.Operation,
+		BaseVersion:   change.BaseVersion,
+		ServerVersion: entry.Version,
+		ClientData:    change.Data,
+	}
+
+	if change.Operation == ChangeOperationDelete {
+		conflict.Reason = "deleted on the client but modified on the server"
+		if err := uds.recordConflict(ctx, userID, conflict, serverData); err != nil {
+			return nil, err
+		}
+		return &SyncResult{ConflictID: conflict.ID}, nil
+	}
+
+	var baseData, clientData interface{}
+	if err := json.Unmarshal(change.Base, &baseData); err != nil {
+		return nil, fmt.Errorf("%w: invalid base: %v", ErrInvalidSyncChange, err)
+	}
+	if err := json.Unmarshal(change.Data, &clientData); err != nil {
+		return nil, fmt.Errorf("%w: invalid data: %v", ErrInvalidSyncChange, err)
+	}
+
+	merged, unresolved := mergeDocuments(collection, baseData, serverData, clientData)
+	mergedData, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode merged document: %w", err)
+	}
+
+	mergedChange := change
+	mergedChange.Data = mergedData
+	mergedChange.Base = nil
+	mergedChange.BaseVersion = 0
+	if err := uds.applyChange(checked, userID, mergedChange); err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{Merged: true}
+	if len(unresolved) > 0 {
+		conflict.Fields = unresolved
+		conflict.Reason = "fields changed on both the client and the server"
+		if err := uds.recordConflict(ctx, userID, conflict, serverData); err != nil {
+			return nil, err
+		}
+		result.ConflictID = conflict.ID
+	}
+
+	log.Printf("Merged concurrent %s %s for user %s (%d unresolved fields)", collection, documentID, userID, len(unresolved))
+	return result, nil
+}
+
+// expectedVersionKey carries a checked change's expected versions to
+// commitChanges
+type expectedVersionKey struct{}
+
+// expectedVersion is the sync log version a document must still have for a
+// write to it to commit; 0 means it must have no entry
+type expectedVersion struct {
+	collection string
+	documentID string
+	version    int64
+}
+
+// expectedVersions are the expected versions of every checked document
+type expectedVersions []expectedVersion
+
+// withExpectedVersion returns a context whose writes to the document commit
+// only while its sync log entry is at version. Expectations already on ctx
+// are kept, so a write can be checked against several documents.
+func withExpectedVersion(ctx context.Context, collection, documentID string, version int64) context.Context {
+	expected, _ := ctx.Value(expectedVersionKey{}).(expectedVersions)
+	expected = append(expected[:len(expected):len(expected)], expectedVersion{collection, documentID, version})
+	return context.WithValue(ctx, expectedVersionKey{}, expected)
+}
+
+// withCurrentVersion returns a context whose writes to the document commit
+// only while its sync log entry is at the version it has now
+func (uds *UserDataService) withCurrentVersion(ctx context.Context, userID, collection, documentID string) (context.Context, error) {
+	entry, err := uds.getSyncLogEntry(ctx, userID, collection, documentID)
+	if err != nil {
+		return nil, err
+	}
+	var version int64
+	if entry != nil {
+		version = entry.Version
+	}
+	return withExpectedVersion(ctx, collection, documentID, version), nil
+}
+
+// check fails with errVersionChanged if change is to an expected document
+// and its sync log entry, read in the commit transaction, has moved on
+func (e expectedVersions) check(change documentChange, entry *firestore.DocumentSnapshot) error {
+	for _, expected := range e {
+		if change.collection != expected.collection || change.documentID != expected.documentID {
+			continue
+		}
+		var current syncLogEntry
+		if entry.Exists() {
+			if err := entry.DataTo(&current); err != nil {
+				return fmt.Errorf("failed to parse sync log entry: %w", err)
+			}
+		}
+		if current.Version != expected.version {
+			return errVersionChanged
+		}
+	}
+	return nil
+}
+
+// syncChangeDocumentID returns the document a change addresses, or "" when
+// it creates a new document
+func syncChangeDocumentID(collection string, change SyncChange) string {
+	switch collection {
+	case "sessions", "saved-tabs", "tasks", "disruptions":
+		if change.DocumentID != "" {
+			return change.DocumentID
+		}
+		var record struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if json.Unmarshal(change.Data, &record) != nil {
+			return ""
+		}
+		switch id := strings.Trim(string(record.ID), `"`); id {
+		case "", "0", "null":
+			return ""
+		default:
+			return id
+		}
+	case "settings":
+		return "settings"
+	default:
+		return StorageKeyForCollection(collection)
+	}
+}
+
+// getSyncLogEntry returns a document's sync log entry, or nil if it has none
+func (uds *UserDataService) getSyncLogEntry(ctx context.Context, userID, collection, documentID string) (*syncLogEntry, error) {
+	doc, err := uds.firebaseService.firestore.Collection(getSyncLogCollectionPath(userID)).Doc(syncLogDocID(collection, documentID)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read sync log: %w", err)
+	}
+
+	var entry syncLogEntry
+	if err := doc.DataTo(&entry); err != nil {
+		return nil, fmt.Errorf("failed to parse sync log entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// getSyncDocumentValue returns a document in its generic JSON shape, or nil
+// if it does not exist
+func (uds *UserDataService) getSyncDocumentValue(ctx context.Context, userID, collection, documentID string) (interface{}, error) {
+	doc, err := uds.syncDocumentRef(userID, collection, documentID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s %s: %w", collection, documentID, err)
+	}
+
+	data, err := uds.syncDocumentData(ctx, collection, doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s %s: %w", collection, documentID, err)
+	}
+	return toJSONValue(data)
+}
+
+// recordConflict stores an open conflict, assigning its ID
+func (uds *UserDataService) recordConflict(ctx context.Context, userID string, conflict *SyncConflict, serverData interface{}) error {
+	serverJSON, err := json.Marshal(serverData)
+	if err != nil {
+		return fmt.Errorf("failed to encode server document: %w", err)
+	}
+
+	docRef := uds.firebaseService.firestore.Collection(getConflictsCollectionPath(userID)).NewDoc()
+	conflict.ID = docRef.ID
+	conflict.Status = ConflictStatusOpen
+	conflict.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	conflict.ServerData = serverJSON
+	conflict.StoredServerData = string(serverJSON)
+	conflict.StoredClientData = string(conflict.ClientData)
+
+	if _, err := docRef.Set(ctx, conflict); err != nil {
+		return fmt.Errorf("failed to record conflict: %w", err)
+	}
+
+	log.Printf("Recorded conflict %s on %s %s for user %s", conflict.ID, conflict.Collection, conflict.DocumentID, userID)
+	return nil
+}
+
+// ListConflicts returns the user's conflicts with the given status (all
+// when empty), oldest first
+func (uds *UserDataService) ListConflicts(ctx context.Context, userID, conflictStatus string) ([]*SyncConflict, error) {
+	query := uds.firebaseService.firestore.Collection(getConflictsCollectionPath(userID)).Query
+	if conflictStatus != "" {
+		query = query.Where("status", "==", conflictStatus)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	conflicts := []*SyncConflict{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate conflicts: %w", err)
+		}
+
+		var conflict SyncConflict
+		if err := doc.DataTo(&conflict); err != nil {
+			log.Printf("Failed to parse conflict %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		conflict.restoreData()
+		conflicts = append(conflicts, &conflict)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].CreatedAt < conflicts[j].CreatedAt
+	})
+	return conflicts, nil
+}
+
+// GetConflict returns one conflict
+func (uds *UserDataService) GetConflict(ctx context.Context, userID, conflictID string) (*SyncConflict, error) {
+	doc, err := uds.firebaseService.firestore.Collection(getConflictsCollectionPath(userID)).Doc(conflictID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrConflictNotFound
+		}
+		return nil, fmt.Errorf("failed to get conflict: %w", err)
+	}
+
+	var conflict SyncConflict
+	if err := doc.DataTo(&conflict); err != nil {
+		return nil, fmt.Errorf("failed to parse conflict: %w", err)
+	}
+	conflict.restoreData()
+	return &conflict, nil
+}
+
+// ResolveConflict settles an open conflict by keeping the stored document,
+// re-applying the client's change, or storing custom data
+func (uds *UserDataService) ResolveConflict(ctx context.Context, userID, conflictID, resolution string, data json.RawMessage) (*SyncConflict, error) {
+	conflict, err := uds.GetConflict(ctx, userID, conflictID)
+	if err != nil {
+		return nil, err
+	}
+	if conflict.Status != ConflictStatusOpen {
+		return nil, ErrConflictResolved
+	}
+
+	switch resolution {
+	case ConflictResolutionCurrent:
+	case ConflictResolutionClient:
+		change := SyncChange{
+			Collection: conflict.Collection,
+			Operation:  conflict.Operation,
+			DocumentID: conflict.DocumentID,
+			Data:       conflict.ClientData,
+		}
+		if err := uds.applyChange(ctx, userID, change); err != nil {
+			return nil, err
+		}
+	case ConflictResolutionCustom:
+		if len(data) == 0 {
+			return nil, ErrResolutionDataMissing
+		}
+		change := SyncChange{
+			Collection: conflict.Collection,
+			Operation:  ChangeOperationUpsert,
+			DocumentID: conflict.DocumentID,
+			Data:       data,
+		}
+		if err := uds.applyChange(ctx, userID, change); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidResolution
+	}
+
+	conflict.Status = ConflictStatusResolved
+	conflict.Resolution = resolution
+	conflict.ResolvedAt = time.Now().UTC().Format(time.RFC3339)
+	if _, err := uds.firebaseService.firestore.Collection(getConflictsCollectionPath(userID)).Doc(conflictID).Set(ctx, conflict); err != nil {
+		return nil, fmt.Errorf("failed to update conflict: %w", err)
+	}
+
+	log.Printf("Resolved conflict %s for user %s with %s", conflictID, userID, resolution)
+	return conflict, nil
+}
+
+// DeleteConflict discards a conflict record
+func (uds *UserDataService) DeleteConflict(ctx context.Context, userID, conflictID string) error {
+	if _, err := uds.GetConflict(ctx, userID, conflictID); err != nil {
+		return err
+	}
+	if _, err := uds.firebaseService.firestore.Collection(getConflictsCollectionPath(userID)).Doc(conflictID).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete conflict: %w", err)
+	}
+	return nil
+}
+
+// restoreData exposes the stored JSON strings as raw JSON
+func (c *SyncConflict) restoreData() {
+	if c.StoredClientData != "" {
+		c.ClientData = json.RawMessage(c.StoredClientData)
+	}
+	if c.StoredServerData != "" {
+		c.ServerData = json.RawMessage(c.StoredServerData)
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestExpectedVersionsCheck(t *testing.T) {
+	ctx := withExpectedVersion(context.Background(), "task-focus-data", "taskFocusData", 0)
+	ctx = withExpectedVersion(ctx, "tasks", "t1", 5)
+	expected, _ := ctx.Value(expectedVersionKey{}).(expectedVersions)
+
+	tests := []struct {
+		name    string
+		change  documentChange
+		wantErr error
+	}{
+		{name: "document without an entry as expected", change: documentChange{"task-focus-data", "taskFocusData", ChangeOperationUpsert}},
+		{name: "second expected document changed", change: documentChange{"tasks", "t1", ChangeOperationUpsert}, wantErr: errVersionChanged},
+		{name: "unchecked document", change: documentChange{"tasks", "t2", ChangeOperationUpsert}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An empty snapshot is a document without a sync log entry
+			if err := expected.check(tt.change, &firestore.DocumentSnapshot{}); !errors.Is(err, tt.wantErr) {
+				t.Errorf("check() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
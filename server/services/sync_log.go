@@ -0,0 +1,662 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Delta sync limits
+const (
+	DefaultSyncPageSize = 500
+	MaxSyncPageSize     = 1000
+	// firestoreBatchLimit is the maximum number of writes in one batch or
+	// transaction
+	firestoreBatchLimit = 500
+	// changeWriteLimit is the number of writes, sync log entries included,
+	// one commitChanges call may make; the version counter takes the last
+	changeWriteLimit = firestoreBatchLimit - 1
+)
+
+// Sync errors
+var (
+	ErrInvalidSyncToken  = errors.New("invalid sync token")
+	ErrInvalidSyncChange = errors.New("invalid sync change")
+)
+
+// syncLogEntry records the latest change to one document for delta sync.
+// Entries of deleted documents are kept as tombstones.
+type syncLogEntry struct {
+	Collection     string `firestore:"collection"`
+	DocumentID     string `firestore:"documentId"`
+	Operation      string `firestore:"operation"`
+	Version        int64  `firestore:"version"`
+	CreatedVersion int64  `firestore:"createdVersion"`
+	UpdatedAt      string `firestore:"updatedAt"`
+}
+
+// documentChange is one document written by a UserDataService method
+type documentChange struct {
+	collection string
+	documentID string
+	operation  string
+}
+
+// SyncItem is a created or updated document in a delta
+type SyncItem struct {
+	Collection string      `json:"collection"`
+	DocumentID string      `json:"id"`
+	Version    int64       `json:"version"`
+	UpdatedAt  string      `json:"updatedAt"`
+	Data       interface{} `json:"data"`
+}
+
+// SyncTombstone is a deleted document in a delta
+type SyncTombstone struct {
+	Collection string `json:"collection"`
+	DocumentID string `json:"id"`
+	Version    int64  `json:"version"`
+	DeletedAt  string `json:"deletedAt"`
+}
+
+// SyncDelta is the set of changes since a sync token
+type SyncDelta struct {
+	Created    []SyncItem      `json:"created"`
+	Updated    []SyncItem      `json:"updated"`
+	Tombstones []SyncTombstone `json:"tombstones"`
+	// NextToken is passed as since on the next request
+	NextToken string `json:"nextToken"`
+	// HasMore is set when the page was full; request again with NextToken
+	HasMore bool `json:"hasMore"`
+}
+
+// SyncChange is a client change submitted for sync
+type SyncChange struct {
+	Collection string          `json:"collection"`
+	Operation  string          `json:"operation"`
+	DocumentID string          `json:"id,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	// BaseVersion is the document version the client edited; when set, the
+	// change is checked for conflicts with edits made since
+	BaseVersion int64 `json:"baseVersion,omitempty"`
+	// Base is the document as of BaseVersion, required for upserts with a
+	// BaseVersion so that merges can tell the client's deletions from the
+	// server's additions
+	Base json.RawMessage `json:"base,omitempty"`
+}
+
+// getSyncLogCollectionPath returns the path for the sync-log collection
+func getSyncLogCollectionPath(userID string) string {
+	return fmt.Sprintf("%s/%s/sync-log", COLLECTION_NAME, userID)
+}
+
+// syncLogDocID keys log entries by collection and document; document IDs are
+// escaped as they may contain slashes
+func syncLogDocID(collection, documentID string) string {
+	return collection + ":" + url.PathEscape(documentID)
+}
+
+// ParseSyncToken parses a sync token; an empty token means no prior sync
+func ParseSyncToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	version, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || version < 0 {
+		return 0, ErrInvalidSyncToken
+	}
+	return version, nil
+}
+
+// formatVersion renders a change version as a timestamp
+func formatVersion(version int64) string {
+	return time.UnixMicro(version).UTC().Format(time.RFC3339Nano)
+}
+
+// syncCounter holds the newest change version allocated for a user
+type syncCounter struct {
+	Version int64 `firestore:"version"`
+}
+
+// syncCounterRef returns the document holding the user's version counter
+func (uds *UserDataService) syncCounterRef(userID string) *firestore.DocumentRef {
+	return uds.firebaseService.firestore.Collection(fmt.Sprintf("%s/%s/sync-state", COLLECTION_NAME, userID)).Doc("version")
+}
+
+// latestVersion returns the newest change version committed for the user,
+// or 0 before the first
+func (uds *UserDataService) latestVersion(ctx context.Context, userID string) (int64, error) {
+	doc, err := uds.syncCounterRef(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read sync version: %w", err)
+	}
+	var counter syncCounter
+	if err := doc.DataTo(&counter); err != nil {
+		return 0, fmt.Errorf("failed to parse sync version: %w", err)
+	}
+	return counter.Version, nil
+}
+
+// changeBatch collects the document writes commitChanges applies in one
+// transaction with their sync log entries
+type changeBatch struct {
+	writes []batchWrite
+}
+
+// batchWrite is one staged document write; nil data deletes the document
+type batchWrite struct {
+	ref    *firestore.DocumentRef
+	data   interface{}
+	create bool
+}
+
+// Set stages writing data to ref
+func (b *changeBatch) Set(ref *firestore.DocumentRef, data interface{}) {
+	b.writes = append(b.writes, batchWrite{ref: ref, data: data})
+}
+
+// Create stages creating ref, failing the commit with AlreadyExists if it
+// exists
+func (b *changeBatch) Create(ref *firestore.DocumentRef, data interface{}) {
+	b.writes = append(b.writes, batchWrite{ref: ref, data: data, create: true})
+}
+
+// Delete stages deleting ref
+func (b *changeBatch) Delete(ref *firestore.DocumentRef) {
+	b.writes = append(b.writes, batchWrite{ref: ref})
+}
+
+// targets returns the documents the batch writes, each once
+func (b *changeBatch) targets() []*firestore.DocumentRef {
+	refs := make([]*firestore.DocumentRef, 0, len(b.writes))
+	seen := make(map[string]bool, len(b.writes))
+	for _, write := range b.writes {
+		if !seen[write.ref.Path] {
+			seen[write.ref.Path] = true
+			refs = append(refs, write.ref)
+		}
+	}
+	return refs
+}
+
+// apply adds the staged writes to a transaction
+func (b *changeBatch) apply(tx *firestore.Transaction) error {
+	for _, write := range b.writes {
+		var err error
+		switch {
+		case write.data == nil:
+			err = tx.Delete(write.ref)
+		case write.create:
+			err = tx.Create(write.ref, write.data)
+		default:
+			err = tx.Set(write.ref, write.data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitChanges commits batch in a transaction together with a sync log
+// entry for each change, then publishes the changes to the change feed with
+// the same versions. Versions are allocated from the user's counter in the
+// same transaction, so they increase in commit order even across server
+// instances; they track the commit time in microseconds where they can. A
+// context from withExpectedVersion makes the commit fail with
+// errVersionChanged if an expected document has changed.
+//
+// Spilled data of the batch's documents is uploaded before the transaction
+// and removed again if it fails; blobs of the documents it overwrites or
+// deletes are removed only once it has committed.
+func (uds *UserDataService) commitChanges(ctx context.Context, batch *changeBatch, userID string, changes ...documentChange) error {
+	client := uds.firebaseService.firestore
+	logCollection := client.Collection(getSyncLogCollectionPath(userID))
+	counterRef := uds.syncCounterRef(userID)
+	refs := make([]*firestore.DocumentRef, len(changes))
+	for i, change := range changes {
+		refs[i] = logCollection.Doc(syncLogDocID(change.collection, change.documentID))
+	}
+	targets := batch.targets()
+
+	newBlobs, err := uds.putPendingBlobs(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	expected, _ := ctx.Value(expectedVersionKey{}).(expectedVersions)
+	versions := make([]int64, len(changes))
+	var staleBlobs []string
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.GetAll(append(append([]*firestore.DocumentRef{counterRef}, refs...), targets...))
+		if err != nil {
+			return fmt.Errorf("failed to read sync log: %w", err)
+		}
+		var counter syncCounter
+		if docs[0].Exists() {
+			if err := docs[0].DataTo(&counter); err != nil {
+				return fmt.Errorf("failed to parse sync version: %w", err)
+			}
+		}
+		previous := docs[1 : len(refs)+1]
+
+		// The blobs of documents about to be replaced or deleted
+		staleBlobs = nil
+		for _, doc := range docs[len(refs)+1:] {
+			if ref := spilledRef(doc); ref != nil {
+				staleBlobs = append(staleBlobs, ref.Key)
+			}
+		}
+
+		next := max(time.Now().UnixMicro(), counter.Version+1)
+		for i, change := range changes {
+			if err := expected.check(change, previous[i]); err != nil {
+				return err
+			}
+			version := next + int64(i)
+			versions[i] = version
+
+			// A document keeps its creation version until it is deleted
+			createdVersion := version
+			var prev syncLogEntry
+			if previous[i].Exists() && previous[i].DataTo(&prev) == nil && prev.Operation != ChangeOperationDelete {
+				createdVersion = prev.CreatedVersion
+			}
+
+			err := tx.Set(refs[i], syncLogEntry{
+				Collection:     change.collection,
+				DocumentID:     change.documentID,
+				Operation:      change.operation,
+				Version:        version,
+				CreatedVersion: createdVersion,
+				UpdatedAt:      formatVersion(version),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := batch.apply(tx); err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return tx.Set(counterRef, syncCounter{Version: versions[len(versions)-1]})
+	})
+	if err != nil {
+		uds.deleteBlobs(ctx, newBlobs)
+		return err
+	}
+	uds.deleteBlobs(ctx, staleBlobs)
+
+	for i, change := range changes {
+		uds.changeFeed.publishContext(ctx, userID, change.collection, change.documentID, change.operation, versions[i])
+		uds.scheduleItemJobs(ctx, userID, change.collection)
+	}
+	return nil
+}
+
+// GetChangesSince returns up to limit documents changed after the since
+// token. Without a token it returns a snapshot of all of the user's data.
+func (uds *UserDataService) GetChangesSince(ctx context.Context, userID, since string, limit int) (*SyncDelta, error) {
+	sinceVersion, err := ParseSyncToken(since)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > MaxSyncPageSize {
+		limit = DefaultSyncPageSize
+	}
+
+	if sinceVersion == 0 {
+		return uds.syncSnapshot(ctx, userID)
+	}
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	iter := uds.firebaseService.firestore.Collection(getSyncLogCollectionPath(userID)).
+		Where("version", ">", sinceVersion).
+		OrderBy("version", firestore.Asc).
+		Limit(limit).
+		Documents(ctx)
+	defer iter.Stop()
+
+	delta := &SyncDelta{
+		Created:    []SyncItem{},
+		Updated:    []SyncItem{},
+		Tombstones: []SyncTombstone{},
+		NextToken:  strconv.FormatInt(sinceVersion, 10),
+	}
+
+	var entries []syncLogEntry
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query sync log: %w", err)
+		}
+
+		var entry syncLogEntry
+		if err := doc.DataTo(&entry); err != nil {
+			log.Printf("Failed to parse sync log entry %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		entries = append(entries, entry)
+		delta.NextToken = strconv.FormatInt(entry.Version, 10)
+	}
+	delta.HasMore = len(entries) == limit
+
+	// Fetch the current contents of upserted documents in one round trip
+	var upserts []syncLogEntry
+	var refs []*firestore.DocumentRef
+	for _, entry := range entries {
+		if entry.Operation == ChangeOperationDelete {
+			delta.Tombstones = append(delta.Tombstones, SyncTombstone{
+				Collection: entry.Collection,
+				DocumentID: entry.DocumentID,
+				Version:    entry.Version,
+				DeletedAt:  entry.UpdatedAt,
+			})
+			continue
+		}
+		upserts = append(upserts, entry)
+		refs = append(refs, uds.syncDocumentRef(userID, entry.Collection, entry.DocumentID))
+	}
+	if len(refs) == 0 {
+		return delta, nil
+	}
+
+	docs, err := uds.firebaseService.firestore.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch changed documents: %w", err)
+	}
+
+	for i, entry := range upserts {
+		if !docs[i].Exists() {
+			continue
+		}
+		data, err := uds.syncDocumentData(ctx, entry.Collection, docs[i])
+		if err != nil {
+			log.Printf("Failed to parse %s %s: %v", entry.Collection, entry.DocumentID, err)
+			continue
+		}
+
+		item := SyncItem{
+			Collection: entry.Collection,
+			DocumentID: entry.DocumentID,
+			Version:    entry.Version,
+			UpdatedAt:  entry.UpdatedAt,
+			Data:       data,
+		}
+		if entry.CreatedVersion > sinceVersion {
+			delta.Created = append(delta.Created, item)
+		} else {
+			delta.Updated = append(delta.Updated, item)
+		}
+	}
+
+	log.Printf("Retrieved sync delta for user %s: %d created, %d updated, %d deleted", userID, len(delta.Created), len(delta.Updated), len(delta.Tombstones))
+	return delta, nil
+}
+
+// syncSnapshot returns every document as created, with a token taken before
+// reading so that writes racing the snapshot are delivered again next time
+func (uds *UserDataService) syncSnapshot(ctx context.Context, userID string) (*SyncDelta, error) {
+	token, err := uds.latestVersion(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// A zero token would ask for another snapshot
+	token = max(token, 1)
+	delta := &SyncDelta{
+		Created:    []SyncItem{},
+		Updated:    []SyncItem{},
+		Tombstones: []SyncTombstone{},
+		NextToken:  strconv.FormatInt(token, 10),
+	}
+
+	// Items as stored, like deltas return them, without annotations
+	uds.mu.RLock()
+	sessions, err := uds.loadSessions(ctx, userID)
+	var tabs []*SavedTab
+	if err == nil {
+		tabs, err = uds.loadSavedTabs(ctx, userID)
+	}
+	uds.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		delta.Created = append(delta.Created, SyncItem{Collection: "sessions", DocumentID: session.ID, Data: session})
+	}
+
+	for _, tab := range tabs {
+		delta.Created = append(delta.Created, SyncItem{Collection: "saved-tabs", DocumentID: strconv.Itoa(tab.ID), Data: tab})
+	}
+
+	settings, err := uds.GetUserSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) > 0 {
+		delta.Created = append(delta.Created, SyncItem{Collection: "settings", DocumentID: "settings", Data: settings})
+	}
+
+	tasks, err := uds.ListTasks(ctx, userID, TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		delta.Created = append(delta.Created, SyncItem{Collection: "tasks", DocumentID: task.ID, Data: task})
+	}
+
+	disruptions, err := uds.ListDisruptions(ctx, userID, DisruptionFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, disruption := range disruptions {
+		delta.Created = append(delta.Created, SyncItem{Collection: "disruptions", DocumentID: disruption.ID, Data: disruption})
+	}
+
+	for key, collection := range STORAGE_KEY_TO_COLLECTION_TYPE {
+		switch collection {
+		case "sessions", "saved-tabs", "settings", "tasks", "disruptions", "disruption-ids":
+			continue
+		}
+		value, err := uds.GetUserData(ctx, userID, key)
+		if err != nil {
+			// Keys the user never stored
+			continue
+		}
+		delta.Created = append(delta.Created, SyncItem{Collection: collection, DocumentID: key, Data: value})
+	}
+
+	log.Printf("Retrieved sync snapshot for user %s: %d documents", userID, len(delta.Created))
+	return delta, nil
+}
+
+// syncDocumentRef locates the document a sync log entry refers to
+func (uds *UserDataService) syncDocumentRef(userID, collection, documentID string) *firestore.DocumentRef {
+	return uds.firebaseService.firestore.Collection(fmt.Sprintf("%s/%s/%s", COLLECTION_NAME, userID, collection)).Doc(documentID)
+}
+
+// syncDocumentData decodes a document for a delta: typed records for
+// sessions and saved tabs, the stored value for generic storage keys
+func (uds *UserDataService) syncDocumentData(ctx context.Context, collection string, doc *firestore.DocumentSnapshot) (interface{}, error) {
+	switch collection {
+	case "sessions":
+		return uds.decodeSession(ctx, doc)
+	case "saved-tabs":
+		var tab SavedTab
+		if err := doc.DataTo(&tab); err != nil {
+			return nil, err
+		}
+		return &tab, nil
+	case "settings":
+		return doc.Data(), nil
+	case "tasks":
+		if doc.Ref.ID == legacyTasksDocID {
+			return doc.Data()["value"], nil
+		}
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			return nil, err
+		}
+		return &task, nil
+	case "disruptions":
+		if doc.Ref.ID == legacyDisruptionsDocID {
+			return doc.Data()["value"], nil
+		}
+		var disruption Disruption
+		if err := doc.DataTo(&disruption); err != nil {
+			return nil, err
+		}
+		return &disruption, nil
+	default:
+		return uds.storedValue(ctx, doc)
+	}
+}
+
+// StorageKeyForCollection maps a change collection back to its storage key
+func StorageKeyForCollection(collection string) string {
+	for key, mapped := range STORAGE_KEY_TO_COLLECTION_TYPE {
+		if mapped == collection {
+			return key
+		}
+	}
+	return collection
+}
+
+// CollectionForStorageKey maps a storage key (e.g. taskFocusData) to the
+// collection used in changes (task-focus-data)
+func CollectionForStorageKey(key string) string {
+	return getCollectionType(key)
+}
+
+// applyChange applies one client change, addressed by collection
+func (uds *UserDataService) applyChange(ctx context.Context, userID string, change SyncChange) error {
+	collection := CollectionForStorageKey(change.Collection)
+	deleting := change.Operation == ChangeOperationDelete
+	if !deleting && change.Operation != ChangeOperationUpsert {
+		return fmt.Errorf("%w: operation must be %q or %q", ErrInvalidSyncChange, ChangeOperationUpsert, ChangeOperationDelete)
+	}
+
+	switch collection {
+	case "":
+		return fmt.Errorf("%w: collection is required", ErrInvalidSyncChange)
+
+	case "sessions":
+		if deleting {
+			if change.DocumentID == "" {
+				return fmt.Errorf("%w: id is required", ErrInvalidSyncChange)
+			}
+			return uds.DeleteUserSession(ctx, userID, change.DocumentID)
+		}
+		var session Session
+		if err := json.Unmarshal(change.Data, &session); err != nil {
+			return fmt.Errorf("%w: invalid session: %v", ErrInvalidSyncChange, err)
+		}
+		if change.DocumentID != "" {
+			session.ID = change.DocumentID
+		}
+		return uds.StoreUserSession(ctx, userID, &session)
+
+	case "saved-tabs":
+		if deleting {
+			tabID, err := strconv.Atoi(change.DocumentID)
+			if err != nil {
+				return fmt.Errorf("%w: id must be a saved tab ID", ErrInvalidSyncChange)
+			}
+			return uds.DeleteSavedTab(ctx, userID, tabID)
+		}
+		var tab SavedTab
+		if err := json.Unmarshal(change.Data, &tab); err != nil {
+			return fmt.Errorf("%w: invalid saved tab: %v", ErrInvalidSyncChange, err)
+		}
+		return uds.StoreSavedTabs(ctx, userID, []*SavedTab{&tab})
+
+	case "settings":
+		if deleting {
+			return fmt.Errorf("%w: settings cannot be deleted", ErrInvalidSyncChange)
+		}
+		var settings map[string]interface{}
+		if err := json.Unmarshal(change.Data, &settings); err != nil {
+			return fmt.Errorf("%w: invalid settings: %v", ErrInvalidSyncChange, err)
+		}
+		return uds.SaveUserSettings(ctx, userID, settings)
+
+	case "tasks":
+		// The legacy id addresses the whole list
+		if change.DocumentID == legacyTasksDocID {
+			break
+		}
+		if deleting {
+			if change.DocumentID == "" {
+				return fmt.Errorf("%w: id is required", ErrInvalidSyncChange)
+			}
+			err := uds.DeleteTask(ctx, userID, change.DocumentID)
+			if errors.Is(err, ErrTaskNotFound) {
+				return nil
+			}
+			return err
+		}
+		var task Task
+		if err := json.Unmarshal(change.Data, &task); err != nil {
+			return fmt.Errorf("%w: invalid task: %v", ErrInvalidSyncChange, err)
+		}
+		if change.DocumentID != "" {
+			task.ID = change.DocumentID
+		}
+		return uds.upsertTask(ctx, userID, &task)
+
+	case "disruptions":
+		if change.DocumentID == legacyDisruptionsDocID {
+			break
+		}
+		if deleting {
+			if change.DocumentID == "" {
+				return fmt.Errorf("%w: id is required", ErrInvalidSyncChange)
+			}
+			err := uds.DeleteDisruption(ctx, userID, change.DocumentID)
+			if errors.Is(err, ErrDisruptionNotFound) {
+				return nil
+			}
+			return err
+		}
+		var disruption Disruption
+		if err := json.Unmarshal(change.Data, &disruption); err != nil {
+			return fmt.Errorf("%w: invalid disruption: %v", ErrInvalidSyncChange, err)
+		}
+		if change.DocumentID != "" {
+			disruption.ID = change.DocumentID
+		}
+		return uds.upsertDisruption(ctx, userID, &disruption)
+	}
+
+	// Generic storage keys, and the task and disruption lists as a whole
+	key := StorageKeyForCollection(collection)
+	if deleting {
+		return uds.DeleteUserData(ctx, userID, key)
+	}
+	var value interface{}
+	if err := json.Unmarshal(change.Data, &value); err != nil {
+		return fmt.Errorf("%w: invalid value: %v", ErrInvalidSyncChange, err)
+	}
+	return uds.SetUserData(ctx, userID, key, value)
+}
@@ -0,0 +1,695 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// tagsStorageKey is the generic storage key the extension keeps tag
+// definitions (name and color) under
+const tagsStorageKey = "tags"
+
+// Tagged entity types
+const (
+	TagEntitySessions  = "sessions"
+	TagEntitySavedTabs = "savedTabs"
+	TagEntityFavorites = "favorites"
+	TagEntityTasks     = "tasks"
+)
+
+// TagEntityTypes are the entity types that carry tags
+var TagEntityTypes = []string{TagEntitySessions, TagEntitySavedTabs, TagEntityFavorites, TagEntityTasks}
+
+// TagSeparator separates the levels of a hierarchical tag (work/infra)
+const TagSeparator = "/"
+
+// maxTagLength bounds a tag name, separators included
+const maxTagLength = 100
+
+// tagColors are the colors new tags are given when none is chosen, the
+// extension's palette
+var tagColors = []string{"#ef4444", "#f97316", "#eab308", "#22c55e", "#06b6d4", "#3b82f6", "#8b5cf6", "#ec4899"}
+
+var tagColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Tag errors
+var (
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("tag already exists")
+	ErrInvalidTag  = errors.New("invalid tag")
+)
+
+// Tag is a tag definition, as stored by the extension
+type Tag struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+	Count int    `json:"count"`
+}
+
+// TagInfo is a tag with its usage. Count is the number of items tagged with
+// exactly this tag; TotalCount adds the items of its descendants.
+type TagInfo struct {
+	Tag
+	Parent     string         `json:"parent,omitempty"`
+	Counts     map[string]int `json:"counts"`
+	TotalCount int            `json:"totalCount"`
+}
+
+// TagUpdate changes a tag's name or color; nil fields are left alone
+type TagUpdate struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// TaggedItems are the items carrying a tag, by entity type
+type TaggedItems struct {
+	Sessions  []*Session     `json:"sessions"`
+	SavedTabs []*SavedTab    `json:"savedTabs"`
+	Favorites []*FavoriteTab `json:"favorites"`
+	Tasks     []*Task        `json:"tasks"`
+}
+
+// TagQuery selects tagged items. Descendant tags match unless Exact is set;
+// empty Types means every entity type.
+type TagQuery struct {
+	Tag   string
+	Types []string
+	Exact bool
+}
+
+// NormalizeTagName trims a tag and each of its levels, rejecting empty
+// levels and overlong names
+func NormalizeTagName(name string) (string, error) {
+	levels := strings.Split(name, TagSeparator)
+	for i, level := range levels {
+		levels[i] = strings.TrimSpace(level)
+		if levels[i] == "" {
+			return "", fmt.Errorf("%w: %q has an empty level", ErrInvalidTag, name)
+		}
+	}
+	normalized := strings.Join(levels, TagSeparator)
+	if len(normalized) > maxTagLength {
+		return "", fmt.Errorf("%w: tags are at most %d characters", ErrInvalidTag, maxTagLength)
+	}
+	return normalized, nil
+}
+
+// tagKey is the case-insensitive identity of a tag name
+func tagKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// tagParent returns the tag one level up, or "" for a top-level tag
+func tagParent(name string) string {
+	if i := strings.LastIndex(name, TagSeparator); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// tagWithin reports whether tag is ancestor or one of its descendants
+func tagWithin(tag, ancestor string) bool {
+	tag, ancestor = tagKey(tag), tagKey(ancestor)
+	return tag == ancestor || strings.HasPrefix(tag, ancestor+TagSeparator)
+}
+
+// defaultTagColor picks a palette color from the tag's name, so a tag
+// keeps its color until one is chosen
+func defaultTagColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(tagKey(name)))
+	return tagColors[h.Sum32()%uint32(len(tagColors))]
+}
+
+// taggedData is everything that carries tags, loaded together so tag
+// changes see a consistent set
+type taggedData struct {
+	sessions    []*Session
+	savedTabs   []*SavedTab
+	favorites   []*FavoriteTab
+	tasks       []*Task
+	definitions []*Tag
+}
+
+// loadTaggedData reads every tagged item and the tag definitions; callers
+// hold uds.mu and have migrated legacy tasks
+func (uds *UserDataService) loadTaggedData(ctx context.Context, userID string) (*taggedData, error) {
+	data := &taggedData{definitions: []*Tag{}}
+	var err error
+	if data.sessions, err = uds.loadSessions(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.savedTabs, err = uds.loadSavedTabs(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.favorites, err = uds.getFavorites(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.tasks, err = uds.loadTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+	if _, err := uds.readStoredValue(ctx, userID, tagsStorageKey, &data.definitions); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// tagLists returns each entity type's tag lists
+func (data *taggedData) tagLists() map[string][][]string {
+	lists := make(map[string][][]string, len(TagEntityTypes))
+	for _, session := range data.sessions {
+		lists[TagEntitySessions] = append(lists[TagEntitySessions], session.Tags)
+	}
+	for _, tab := range data.savedTabs {
+		lists[TagEntitySavedTabs] = append(lists[TagEntitySavedTabs], tab.Tags)
+	}
+	for _, favorite := range data.favorites {
+		lists[TagEntityFavorites] = append(lists[TagEntityFavorites], favorite.Tags)
+	}
+	for _, task := range data.tasks {
+		lists[TagEntityTasks] = append(lists[TagEntityTasks], task.Tags)
+	}
+	return lists
+}
+
+// definition returns the definition of a tag, if any
+func (data *taggedData) definition(name string) *Tag {
+	for _, tag := range data.definitions {
+		if tagKey(tag.Name) == tagKey(name) {
+			return tag
+		}
+	}
+	return nil
+}
+
+// tagInfos counts every defined or used tag per entity type. Ancestors of
+// used tags are listed even when nothing carries them directly.
+func (data *taggedData) tagInfos() []*TagInfo {
+	infos := make(map[string]*TagInfo)
+	add := func(name string) *TagInfo {
+		key := tagKey(name)
+		if info, exists := infos[key]; exists {
+			return info
+		}
+		info := &TagInfo{Tag: Tag{Name: name}, Parent: tagParent(name), Counts: make(map[string]int)}
+		for _, entity := range TagEntityTypes {
+			info.Counts[entity] = 0
+		}
+		infos[key] = info
+		return info
+	}
+
+	for _, tag := range data.definitions {
+		info := add(tag.Name)
+		info.ID, info.Name, info.Color = tag.ID, tag.Name, tag.Color
+	}
+	for entity, lists := range data.tagLists() {
+		for _, tags := range lists {
+			seen := make(map[string]bool)
+			for _, name := range tags {
+				name = strings.TrimSpace(name)
+				if name == "" || seen[tagKey(name)] {
+					continue
+				}
+				seen[tagKey(name)] = true
+				info := add(name)
+				info.Count++
+				info.Counts[entity]++
+			}
+		}
+	}
+
+	// Ancestors, then totals including descendants
+	for _, info := range infos {
+		for parent := info.Parent; parent != ""; parent = tagParent(parent) {
+			add(parent)
+		}
+	}
+	for _, info := range infos {
+		for _, other := range infos {
+			if tagWithin(other.Name, info.Name) {
+				info.TotalCount += other.Count
+			}
+		}
+		if info.Color == "" {
+			info.Color = defaultTagColor(info.Name)
+		}
+	}
+
+	result := make([]*TagInfo, 0, len(infos))
+	for _, info := range infos {
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return tagKey(result[i].Name) < tagKey(result[j].Name)
+	})
+	return result
+}
+
+// ListTags returns every tag in use or defined, with counts per entity type
+func (uds *UserDataService) ListTags(ctx context.Context, userID string) ([]*TagInfo, error) {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	data, err := uds.loadTaggedData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return data.tagInfos(), nil
+}
+
+// GetTag returns one tag with its counts
+func (uds *UserDataService) GetTag(ctx context.Context, userID, name string) (*TagInfo, error) {
+	tags, err := uds.ListTags(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		if tagKey(tag.Name) == tagKey(name) {
+			return tag, nil
+		}
+	}
+	return nil, ErrTagNotFound
+}
+
+// CreateTag defines a tag, giving it a palette color when it has none. Tags
+// already in use on items can be defined to fix their color.
+func (uds *UserDataService) CreateTag(ctx context.Context, userID string, tag *Tag) error {
+	name, err := NormalizeTagName(tag.Name)
+	if err != nil {
+		return err
+	}
+	tag.Name = name
+	if tag.Color == "" {
+		tag.Color = defaultTagColor(name)
+	} else if !tagColorPattern.MatchString(tag.Color) {
+		return fmt.Errorf("%w: color must be #rgb or #rrggbb", ErrInvalidTag)
+	}
+
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	data, err := uds.loadTaggedData(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if data.definition(name) != nil {
+		return ErrTagExists
+	}
+
+	tag.ID = fmt.Sprintf("tag_%d_%s", time.Now().UnixMilli(), strings.ToLower(uds.firebaseService.firestore.Collection(COLLECTION_NAME).NewDoc().ID[:9]))
+	data.definitions = append(data.definitions, tag)
+	if err := uds.writeTaggedData(ctx, userID, data, nil); err != nil {
+		return err
+	}
+
+	log.Printf("Created tag %q for user %s", name, userID)
+	return nil
+}
+
+// UpdateTag recolors or renames a tag. Renaming moves its descendants with
+// it (work/infra becomes job/infra) and retags every item in one change;
+// renaming onto an existing tag is a merge and must use MergeTags.
+func (uds *UserDataService) UpdateTag(ctx context.Context, userID, name string, update TagUpdate) (*TagInfo, error) {
+	newName := name
+	if update.Name != nil {
+		var err error
+		if newName, err = NormalizeTagName(*update.Name); err != nil {
+			return nil, err
+		}
+	}
+	if update.Color != nil && !tagColorPattern.MatchString(*update.Color) {
+		return nil, fmt.Errorf("%w: color must be #rgb or #rrggbb", ErrInvalidTag)
+	}
+
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	data, err := uds.loadTaggedData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	current := findTagInfo(data.tagInfos(), name)
+	if current == nil {
+		return nil, ErrTagNotFound
+	}
+
+	renamed := tagKey(newName) != tagKey(current.Name)
+	if renamed {
+		if tagWithin(newName, current.Name) {
+			return nil, fmt.Errorf("%w: a tag cannot be moved under itself", ErrInvalidTag)
+		}
+		if findTagInfo(data.tagInfos(), newName) != nil {
+			return nil, fmt.Errorf("%w: %q (merge the tags instead)", ErrTagExists, newName)
+		}
+	}
+
+	definition := data.definition(current.Name)
+	if definition == nil {
+		definition = &Tag{ID: current.ID, Name: current.Name, Color: current.Color}
+		if definition.ID == "" {
+			definition.ID = fmt.Sprintf("tag_%d_%s", time.Now().UnixMilli(), strings.ToLower(uds.firebaseService.firestore.Collection(COLLECTION_NAME).NewDoc().ID[:9]))
+		}
+		data.definitions = append(data.definitions, definition)
+	}
+	if update.Color != nil {
+		definition.Color = *update.Color
+	}
+	// Case-only renames change the definition but not the items' identity
+	definition.Name = newName
+
+	var retag func(string) (string, bool)
+	if renamed || newName != current.Name {
+		from := current.Name
+		retag = func(tag string) (string, bool) {
+			return moveTag(tag, from, newName)
+		}
+	}
+	if err := uds.writeTaggedData(ctx, userID, data, retag); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated tag %q to %q for user %s", current.Name, newName, userID)
+	return findTagInfo(data.tagInfos(), newName), nil
+}
+
+// MergeTags replaces the sources, and their descendants, with target on
+// every item in one change. The sources' definitions are removed; target
+// keeps or gains its own.
+func (uds *UserDataService) MergeTags(ctx context.Context, userID, target string, sources []string) (*TagInfo, error) {
+	target, err := NormalizeTagName(target)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w: sources are required", ErrInvalidTag)
+	}
+	for i, source := range sources {
+		if sources[i], err = NormalizeTagName(source); err != nil {
+			return nil, err
+		}
+		if tagWithin(target, sources[i]) {
+			return nil, fmt.Errorf("%w: cannot merge %q into itself or its descendant %q", ErrInvalidTag, sources[i], target)
+		}
+	}
+
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	data, err := uds.loadTaggedData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	infos := data.tagInfos()
+	for _, source := range sources {
+		if findTagInfo(infos, source) == nil {
+			return nil, fmt.Errorf("%w: %q", ErrTagNotFound, source)
+		}
+	}
+	if existing := findTagInfo(infos, target); existing != nil {
+		target = existing.Name
+	}
+
+	retag := func(tag string) (string, bool) {
+		for _, source := range sources {
+			if moved, ok := moveTag(tag, source, target); ok {
+				return moved, true
+			}
+		}
+		return tag, false
+	}
+	if err := uds.writeTaggedData(ctx, userID, data, retag); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Merged tags %q into %q for user %s", sources, target, userID)
+	return findTagInfo(data.tagInfos(), target), nil
+}
+
+// DeleteTag removes a tag and its descendants from every item, along with
+// their definitions
+func (uds *UserDataService) DeleteTag(ctx context.Context, userID, name string) error {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	data, err := uds.loadTaggedData(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if findTagInfo(data.tagInfos(), name) == nil {
+		return ErrTagNotFound
+	}
+
+	err = uds.writeTaggedData(ctx, userID, data, func(tag string) (string, bool) {
+		if tagWithin(tag, name) {
+			return "", true
+		}
+		return tag, false
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted tag %q for user %s", name, userID)
+	return nil
+}
+
+// GetTaggedItems returns the items carrying the queried tag
+func (uds *UserDataService) GetTaggedItems(ctx context.Context, userID string, query TagQuery) (*TaggedItems, error) {
+	types := make(map[string]bool)
+	for _, entity := range query.Types {
+		if !containsString(TagEntityTypes, entity) {
+			return nil, fmt.Errorf("%w: unknown type %q (expected %s)", ErrInvalidTag, entity, strings.Join(TagEntityTypes, ", "))
+		}
+		types[entity] = true
+	}
+	wanted := func(entity string) bool { return len(types) == 0 || types[entity] }
+	matches := func(tags []string) bool {
+		for _, tag := range tags {
+			if tagKey(tag) == tagKey(query.Tag) || (!query.Exact && tagWithin(tag, query.Tag)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	data, err := uds.loadTaggedData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := &TaggedItems{Sessions: []*Session{}, SavedTabs: []*SavedTab{}, Favorites: []*FavoriteTab{}, Tasks: []*Task{}}
+	if wanted(TagEntitySessions) {
+		for _, session := range data.sessions {
+			if matches(session.Tags) {
+				items.Sessions = append(items.Sessions, session)
+			}
+		}
+	}
+	if wanted(TagEntitySavedTabs) {
+		for _, tab := range data.savedTabs {
+			if matches(tab.Tags) {
+				items.SavedTabs = append(items.SavedTabs, tab)
+			}
+		}
+	}
+	if wanted(TagEntityFavorites) {
+		scoreFavorites(data.favorites, time.Now())
+		for _, favorite := range data.favorites {
+			if matches(favorite.Tags) {
+				items.Favorites = append(items.Favorites, favorite)
+			}
+		}
+	}
+	if wanted(TagEntityTasks) {
+		for _, task := range data.tasks {
+			if matches(task.Tags) {
+				items.Tasks = append(items.Tasks, task)
+			}
+		}
+	}
+	return items, nil
+}
+
+// writeTaggedData applies retag to every item's tags and to the tag
+// definitions, then stores what changed. retag returns a tag's new name, or
+// "" to remove it; nil only stores the definitions. Everything is written
+// in one transaction, so a change that would rewrite more documents than
+// one takes is rejected.
+func (uds *UserDataService) writeTaggedData(ctx context.Context, userID string, data *taggedData, retag func(string) (string, bool)) error {
+	client := uds.firebaseService.firestore
+	now := time.Now()
+	var writes []stagedWrite
+	set := func(ref *firestore.DocumentRef, value interface{}, change documentChange) {
+		writes = append(writes, stageSet(ref, value, change))
+	}
+
+	if retag != nil {
+		for _, session := range data.sessions {
+			if tags, changed := retagList(session.Tags, retag); changed {
+				session.Tags = tags
+				session.LastModified = formatTaskTime(now)
+				writes = append(writes, uds.stageSession(ctx, userID, session))
+			}
+		}
+		for _, tab := range data.savedTabs {
+			if tags, changed := retagList(tab.Tags, retag); changed {
+				tab.Tags = tags
+				tabID := fmt.Sprintf("%d", tab.ID)
+				set(client.Collection(getSavedTabsCollectionPath(userID)).Doc(tabID), tab,
+					documentChange{"saved-tabs", tabID, ChangeOperationUpsert})
+			}
+		}
+		for _, task := range data.tasks {
+			if tags, changed := retagList(task.Tags, retag); changed {
+				task.Tags = tags
+				task.UpdatedAt = formatTaskTime(now)
+				set(client.Collection(getTasksCollectionPath(userID)).Doc(task.ID), task,
+					documentChange{"tasks", task.ID, ChangeOperationUpsert})
+			}
+		}
+
+		favoritesChanged := false
+		for _, favorite := range data.favorites {
+			if tags, changed := retagList(favorite.Tags, retag); changed {
+				favorite.Tags = tags
+				favoritesChanged = true
+			}
+		}
+		if favoritesChanged {
+			scoreFavorites(data.favorites, now)
+			favorites := data.favorites
+			writes = append(writes, func(batch *changeBatch) (documentChange, error) {
+				return uds.stageStoredValue(ctx, batch, userID, favoritesStorageKey, favorites)
+			})
+		}
+
+		// Definitions follow their tags; merged definitions collapse into
+		// the first one
+		definitions := []*Tag{}
+		seen := make(map[string]bool)
+		for _, tag := range data.definitions {
+			name, changed := retag(tag.Name)
+			if !changed {
+				name = tag.Name
+			}
+			if name == "" || seen[tagKey(name)] {
+				continue
+			}
+			seen[tagKey(name)] = true
+			tag.Name = name
+			definitions = append(definitions, tag)
+		}
+		data.definitions = definitions
+	}
+
+	// Stored counts match the extension's: items tagged with exactly the tag
+	counts := make(map[string]int)
+	for _, info := range data.tagInfos() {
+		counts[tagKey(info.Name)] = info.Count
+	}
+	for _, tag := range data.definitions {
+		tag.Count = counts[tagKey(tag.Name)]
+	}
+	definitions := data.definitions
+	writes = append(writes, func(batch *changeBatch) (documentChange, error) {
+		return uds.stageStoredValue(ctx, batch, userID, tagsStorageKey, definitions)
+	})
+
+	if len(writes) > maxStagedWrites {
+		return fmt.Errorf("%w: the change would rewrite %d sessions, saved tabs, tasks and lists; at most %d can change at once",
+			ErrInvalidTag, len(writes), maxStagedWrites)
+	}
+	if err := uds.commitStagedWrites(ctx, userID, writes); err != nil {
+		return fmt.Errorf("failed to store tags: %w", err)
+	}
+	return nil
+}
+
+// retagList applies retag to a tag list, dropping removed and duplicate
+// tags, and reports whether anything changed
+func retagList(tags []string, retag func(string) (string, bool)) ([]string, bool) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	changed := false
+	for _, tag := range tags {
+		name, renamed := retag(tag)
+		if !renamed {
+			name = tag
+		}
+		changed = changed || renamed
+		if name == "" || seen[tagKey(name)] {
+			changed = true
+			continue
+		}
+		seen[tagKey(name)] = true
+		result = append(result, name)
+	}
+	return result, changed
+}
+
+// moveTag replaces the from prefix of tag with to, when tag is from or one
+// of its descendants
+func moveTag(tag, from, to string) (string, bool) {
+	tag = strings.TrimSpace(tag)
+	if !tagWithin(tag, from) {
+		return tag, false
+	}
+	return to + tag[len(strings.TrimSpace(from)):], true
+}
+
+// findTagInfo returns the tag named name, ignoring case, or nil
+func findTagInfo(tags []*TagInfo, name string) *TagInfo {
+	for _, tag := range tags {
+		if tagKey(tag.Name) == tagKey(name) {
+			return tag
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
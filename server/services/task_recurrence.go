@@ -0,0 +1,361 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Missed occurrence policies
+const (
+	// RecurrenceMissedSkip drops occurrences from days that passed before
+	// they could be created
+	RecurrenceMissedSkip = "skip"
+	// RecurrenceMissedCatchUp creates them, oldest first
+	RecurrenceMissedCatchUp = "catch-up"
+)
+
+// recurrenceDateFormat is the format of occurrence dates
+const recurrenceDateFormat = "2006-01-02"
+
+// TaskRecurrence repeats a task. The task it is set on is the template:
+// the scheduler creates an inbox task for each occurrence, due at the
+// template's schedule slot in the user's time zone.
+type TaskRecurrence struct {
+	// Rule is an RFC 5545 RRULE using FREQ (DAILY, WEEKLY, MONTHLY or
+	// YEARLY), INTERVAL, BYDAY, BYMONTHDAY, BYMONTH, COUNT, UNTIL and WKST
+	Rule string `json:"rule" firestore:"rule"`
+	// Start is the first possible occurrence date (YYYY-MM-DD); it
+	// defaults to the day the template was created
+	Start string `json:"start,omitempty" firestore:"start,omitempty"`
+	// Missed is skip (the default) or catch-up
+	Missed string `json:"missed,omitempty" firestore:"missed,omitempty"`
+	// LastOccurrence is the last occurrence created or skipped, and
+	// NextOccurrence the next one due; both are maintained by the server
+	LastOccurrence string `json:"lastOccurrence,omitempty" firestore:"lastOccurrence,omitempty"`
+	NextOccurrence string `json:"nextOccurrence,omitempty" firestore:"nextOccurrence,omitempty"`
+}
+
+// recurrenceRule is a parsed RRULE. Dates are calendar days, represented
+// as midnight UTC.
+type recurrenceRule struct {
+	freq       string
+	interval   int
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []int
+	count      int
+	until      time.Time
+	weekStart  time.Weekday
+	hasUntil   bool
+}
+
+// weekdayNum is a BYDAY value such as MO or -1FR
+type weekdayNum struct {
+	weekday time.Weekday
+	n       int
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// validateRecurrence checks a task's recurrence and fills its defaults
+func validateRecurrence(recurrence *TaskRecurrence) error {
+	recurrence.Rule = strings.TrimPrefix(strings.TrimSpace(recurrence.Rule), "RRULE:")
+	if _, err := parseRecurrenceRule(recurrence.Rule); err != nil {
+		return fmt.Errorf("%w: recurrence rule: %v", ErrInvalidTask, err)
+	}
+	if recurrence.Start != "" {
+		if _, err := time.Parse(recurrenceDateFormat, recurrence.Start); err != nil {
+			return fmt.Errorf("%w: recurrence start must be YYYY-MM-DD", ErrInvalidTask)
+		}
+	}
+	switch recurrence.Missed {
+	case "":
+		recurrence.Missed = RecurrenceMissedSkip
+	case RecurrenceMissedSkip, RecurrenceMissedCatchUp:
+	default:
+		return fmt.Errorf("%w: recurrence missed must be skip or catch-up", ErrInvalidTask)
+	}
+	return nil
+}
+
+// parseRecurrenceRule parses the supported RRULE subset
+func parseRecurrenceRule(rule string) (*recurrenceRule, error) {
+	r := &recurrenceRule{interval: 1, weekStart: time.Monday}
+	if rule == "" {
+		return nil, fmt.Errorf("rule is required")
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(rule, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.freq = value
+			default:
+				return nil, fmt.Errorf("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY")
+			}
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+			if err != nil || r.interval < 1 || r.interval > 1000 {
+				return nil, fmt.Errorf("INTERVAL must be between 1 and 1000")
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(value)
+			if err != nil || r.count < 1 {
+				return nil, fmt.Errorf("COUNT must be a positive number")
+			}
+		case "UNTIL":
+			r.until, err = parseRRuleUntil(value)
+			if err != nil {
+				return nil, err
+			}
+			r.hasUntil = true
+		case "WKST":
+			weekday, ok := rruleWeekdays[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST %q", value)
+			}
+			r.weekStart = weekday
+		case "BYDAY":
+			for _, item := range strings.Split(value, ",") {
+				day, err := parseWeekdayNum(item)
+				if err != nil {
+					return nil, err
+				}
+				r.byDay = append(r.byDay, day)
+			}
+		case "BYMONTHDAY":
+			r.byMonthDay, err = parseRRuleInts(name, value, -31, 31)
+			if err != nil {
+				return nil, err
+			}
+		case "BYMONTH":
+			r.byMonth, err = parseRRuleInts(name, value, 1, 12)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%s is not supported", name)
+		}
+	}
+
+	switch {
+	case r.freq == "":
+		return nil, fmt.Errorf("FREQ is required")
+	case r.count > 0 && r.hasUntil:
+		return nil, fmt.Errorf("COUNT and UNTIL cannot both be given")
+	case len(r.byMonthDay) > 0 && r.freq != "MONTHLY" && r.freq != "YEARLY":
+		return nil, fmt.Errorf("BYMONTHDAY requires FREQ=MONTHLY or YEARLY")
+	case len(r.byMonth) > 0 && r.freq != "YEARLY":
+		return nil, fmt.Errorf("BYMONTH requires FREQ=YEARLY")
+	case len(r.byDay) > 0 && r.freq == "YEARLY":
+		return nil, fmt.Errorf("BYDAY is not supported with FREQ=YEARLY")
+	}
+	for _, day := range r.byDay {
+		if day.n != 0 && r.freq != "MONTHLY" {
+			return nil, fmt.Errorf("numbered BYDAY values require FREQ=MONTHLY")
+		}
+	}
+	return r, nil
+}
+
+// parseRRuleUntil parses an UNTIL date or UTC date-time as a date
+func parseRRuleUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return civilDate(t), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("UNTIL must be YYYYMMDD or YYYYMMDDTHHMMSSZ")
+}
+
+// parseWeekdayNum parses a BYDAY value
+func parseWeekdayNum(value string) (weekdayNum, error) {
+	if len(value) < 2 {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	weekday, ok := rruleWeekdays[value[len(value)-2:]]
+	if !ok {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+	}
+	day := weekdayNum{weekday: weekday}
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", value)
+		}
+		day.n = n
+	}
+	return day, nil
+}
+
+// parseRRuleInts parses a comma-separated list of non-zero numbers
+func parseRRuleInts(name, value string, low, high int) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n == 0 || n < low || n > high {
+			return nil, fmt.Errorf("invalid %s %q", name, item)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+// civilDate returns the calendar day of t as midnight UTC
+func civilDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// occurrences calls fn with each occurrence date on or after start, in
+// order, until fn returns false or the rule ends. start is the first
+// occurrence's anchor (DTSTART); COUNT includes every occurrence from it.
+func (r *recurrenceRule) occurrences(start time.Time, fn func(date time.Time) bool) {
+	start = civilDate(start)
+	emitted := 0
+	// A rule whose periods never contain a date (e.g. February 30th) ends
+	// after this many empty periods
+	const maxEmptyPeriods = 100
+
+	empty := 0
+	for period := 0; ; period += r.interval {
+		dates := r.periodDates(start, period)
+		if len(dates) == 0 {
+			if empty++; empty > maxEmptyPeriods {
+				return
+			}
+			continue
+		}
+		empty = 0
+
+		for _, date := range dates {
+			if date.Before(start) {
+				continue
+			}
+			if r.hasUntil && date.After(r.until) {
+				return
+			}
+			if !fn(date) {
+				return
+			}
+			if emitted++; r.count > 0 && emitted >= r.count {
+				return
+			}
+		}
+	}
+}
+
+// periodDates returns the sorted candidate dates in the period that is
+// offset periods after the one containing start
+func (r *recurrenceRule) periodDates(start time.Time, offset int) []time.Time {
+	var dates []time.Time
+	switch r.freq {
+	case "DAILY":
+		date := start.AddDate(0, 0, offset)
+		if len(r.byDay) == 0 || r.hasWeekday(date.Weekday()) {
+			dates = append(dates, date)
+		}
+
+	case "WEEKLY":
+		weekStart := start.AddDate(0, 0, -int((start.Weekday()-r.weekStart+7)%7)).AddDate(0, 0, 7*offset)
+		for i := 0; i < 7; i++ {
+			date := weekStart.AddDate(0, 0, i)
+			if (len(r.byDay) == 0 && date.Weekday() == start.Weekday()) || r.hasWeekday(date.Weekday()) {
+				dates = append(dates, date)
+			}
+		}
+
+	case "MONTHLY":
+		month := time.Date(start.Year(), start.Month()+time.Month(offset), 1, 0, 0, 0, 0, time.UTC)
+		dates = r.monthDates(month, start.Day())
+
+	case "YEARLY":
+		months := r.byMonth
+		if len(months) == 0 {
+			months = []int{int(start.Month())}
+		}
+		for _, m := range months {
+			month := time.Date(start.Year()+offset, time.Month(m), 1, 0, 0, 0, 0, time.UTC)
+			dates = append(dates, r.monthDates(month, start.Day())...)
+		}
+	}
+
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates
+}
+
+// monthDates returns the dates in month selected by BYMONTHDAY and BYDAY
+// (both must match when both are given), defaulting to defaultDay
+func (r *recurrenceRule) monthDates(month time.Time, defaultDay int) []time.Time {
+	daysInMonth := month.AddDate(0, 1, -1).Day()
+	byMonthDay := r.byMonthDay
+	if len(byMonthDay) == 0 && len(r.byDay) == 0 {
+		byMonthDay = []int{defaultDay}
+	}
+
+	var dates []time.Time
+	for day := 1; day <= daysInMonth; day++ {
+		date := month.AddDate(0, 0, day-1)
+		if len(byMonthDay) > 0 && !containsMonthDay(byMonthDay, day, daysInMonth) {
+			continue
+		}
+		if len(r.byDay) > 0 && !r.matchesMonthWeekday(date, daysInMonth) {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+// containsMonthDay reports whether day matches a BYMONTHDAY list, where
+// negative values count from the end of the month
+func containsMonthDay(monthDays []int, day, daysInMonth int) bool {
+	for _, n := range monthDays {
+		if n == day || n < 0 && daysInMonth+n+1 == day {
+			return true
+		}
+	}
+	return false
+}
+
+// hasWeekday reports whether BYDAY includes weekday
+func (r *recurrenceRule) hasWeekday(weekday time.Weekday) bool {
+	for _, day := range r.byDay {
+		if day.weekday == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthWeekday reports whether date matches BYDAY within its month,
+// where numbered values select the nth (or nth last) such weekday
+func (r *recurrenceRule) matchesMonthWeekday(date time.Time, daysInMonth int) bool {
+	nth := (date.Day()-1)/7 + 1
+	nthLast := -((daysInMonth-date.Day())/7 + 1)
+	for _, day := range r.byDay {
+		if day.weekday == date.Weekday() && (day.n == 0 || day.n == nth || day.n == nthLast) {
+			return true
+		}
+	}
+	return false
+}
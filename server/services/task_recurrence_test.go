@@ -0,0 +1,126 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr string
+	}{
+		{rule: "FREQ=WEEKLY;BYDAY=MO,FR"},
+		{rule: "freq=monthly;byday=-1fr"},
+		{rule: "FREQ=YEARLY;BYMONTH=1,7;BYMONTHDAY=1;UNTIL=20301231T000000Z"},
+		{rule: "", wantErr: "rule is required"},
+		{rule: "INTERVAL=2", wantErr: "FREQ is required"},
+		{rule: "FREQ=HOURLY", wantErr: "FREQ must be"},
+		{rule: "FREQ=DAILY;FREQ=WEEKLY", wantErr: "FREQ given more than once"},
+		{rule: "FREQ=DAILY;INTERVAL=0", wantErr: "INTERVAL must be"},
+		{rule: "FREQ=DAILY;COUNT=2;UNTIL=20240101", wantErr: "COUNT and UNTIL"},
+		{rule: "FREQ=DAILY;UNTIL=tomorrow", wantErr: "UNTIL must be"},
+		{rule: "FREQ=DAILY;BYMONTHDAY=1", wantErr: "BYMONTHDAY requires"},
+		{rule: "FREQ=MONTHLY;BYMONTH=2", wantErr: "BYMONTH requires"},
+		{rule: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: "invalid BYMONTHDAY"},
+		{rule: "FREQ=WEEKLY;BYDAY=1MO", wantErr: "numbered BYDAY"},
+		{rule: "FREQ=MONTHLY;BYDAY=6MO", wantErr: "invalid BYDAY"},
+		{rule: "FREQ=YEARLY;BYDAY=MO", wantErr: "BYDAY is not supported"},
+		{rule: "FREQ=DAILY;BYSETPOS=1", wantErr: "BYSETPOS is not supported"},
+		{rule: "FREQ=DAILY;WKST=XX", wantErr: "invalid WKST"},
+		{rule: "FREQ=DAILY;COUNT", wantErr: "invalid part"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := parseRecurrenceRule(tt.rule)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("parseRecurrenceRule() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseRecurrenceRule() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecurrenceOccurrences(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		start string
+		want  []string
+	}{
+		{"daily count", "FREQ=DAILY;COUNT=3", "2024-01-31", []string{"2024-01-31", "2024-02-01", "2024-02-02"}},
+		{"daily until", "FREQ=DAILY;UNTIL=20240103", "2024-01-01", []string{"2024-01-01", "2024-01-02", "2024-01-03"}},
+		{"daily on weekends", "FREQ=DAILY;BYDAY=SA,SU;COUNT=3", "2024-01-01", []string{"2024-01-06", "2024-01-07", "2024-01-13"}},
+		{"weekly on days", "FREQ=WEEKLY;BYDAY=MO,FR", "2024-01-03", []string{"2024-01-05", "2024-01-08", "2024-01-12", "2024-01-15"}},
+		{"every other week", "FREQ=WEEKLY;INTERVAL=2", "2024-01-03", []string{"2024-01-03", "2024-01-17", "2024-01-31", "2024-02-14"}},
+		{"week starting Monday", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU", "2024-01-01", []string{"2024-01-01", "2024-01-07", "2024-01-15", "2024-01-21"}},
+		{"week starting Sunday", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU;WKST=SU", "2024-01-01", []string{"2024-01-01", "2024-01-14", "2024-01-15", "2024-01-28"}},
+		{"monthly skips short months", "FREQ=MONTHLY", "2024-01-31", []string{"2024-01-31", "2024-03-31", "2024-05-31", "2024-07-31"}},
+		{"last day of the month", "FREQ=MONTHLY;BYMONTHDAY=-1", "2024-01-15", []string{"2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30"}},
+		{"second Tuesday", "FREQ=MONTHLY;BYDAY=2TU", "2024-01-01", []string{"2024-01-09", "2024-02-13", "2024-03-12", "2024-04-09"}},
+		{"last Friday", "FREQ=MONTHLY;BYDAY=-1FR", "2024-01-01", []string{"2024-01-26", "2024-02-23", "2024-03-29", "2024-04-26"}},
+		{"Friday the 13th", "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", "2024-01-01", []string{"2024-09-13", "2024-12-13", "2025-06-13", "2026-02-13"}},
+		{"leap day", "FREQ=YEARLY", "2024-02-29", []string{"2024-02-29", "2028-02-29", "2032-02-29", "2036-02-29"}},
+		{"yearly in months", "FREQ=YEARLY;BYMONTH=1,7;BYMONTHDAY=1", "2024-03-10", []string{"2024-07-01", "2025-01-01", "2025-07-01", "2026-01-01"}},
+		{"date that never comes", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", "2024-01-01", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRecurrenceRule(tt.rule)
+			if err != nil {
+				t.Fatalf("parseRecurrenceRule() error = %v", err)
+			}
+			start, _ := time.Parse(recurrenceDateFormat, tt.start)
+
+			var got []string
+			rule.occurrences(start, func(date time.Time) bool {
+				got = append(got, date.Format(recurrenceDateFormat))
+				return len(got) < 4
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("occurrences = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRecurrence(t *testing.T) {
+	tests := []struct {
+		name       string
+		recurrence TaskRecurrence
+		wantRule   string
+		wantMissed string
+		wantErr    bool
+	}{
+		{name: "defaults", recurrence: TaskRecurrence{Rule: " RRULE:FREQ=DAILY "}, wantRule: "FREQ=DAILY", wantMissed: RecurrenceMissedSkip},
+		{name: "catch-up", recurrence: TaskRecurrence{Rule: "FREQ=DAILY", Start: "2024-01-01", Missed: RecurrenceMissedCatchUp}, wantRule: "FREQ=DAILY", wantMissed: RecurrenceMissedCatchUp},
+		{name: "bad start", recurrence: TaskRecurrence{Rule: "FREQ=DAILY", Start: "01/01/2024"}, wantErr: true},
+		{name: "bad missed", recurrence: TaskRecurrence{Rule: "FREQ=DAILY", Missed: "later"}, wantErr: true},
+		{name: "bad rule", recurrence: TaskRecurrence{Rule: "FREQ=NEVER"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recurrence := tt.recurrence
+			err := validateRecurrence(&recurrence)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateRecurrence() error = %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if recurrence.Rule != tt.wantRule || recurrence.Missed != tt.wantMissed {
+				t.Errorf("recurrence = %+v, want rule %q missed %q", recurrence, tt.wantRule, tt.wantMissed)
+			}
+		})
+	}
+}
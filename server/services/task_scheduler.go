@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// RECURRENCES_COLLECTION_NAME indexes recurring tasks across users by when
+// the scheduler next needs to look at them
+const RECURRENCES_COLLECTION_NAME = "tab-blaster-5k-recurrences"
+
+// Task scheduler defaults
+const (
+	DefaultTaskSchedulerInterval = time.Minute
+	// maxOccurrencesPerRun bounds the tasks one template creates per run;
+	// a longer catch-up continues on the next run
+	maxOccurrencesPerRun = 100
+	// recurrenceBatchSize is the number of templates read per run
+	recurrenceBatchSize = 200
+)
+
+// scheduleSlotTimes are the local times, as hours and minutes, tasks in a
+// schedule slot fall due. Unscheduled occurrences are due at the start of
+// their day.
+var scheduleSlotTimes = map[string][2]int{
+	"morning": {9, 0},
+	"midday":  {12, 0},
+	"evening": {18, 0},
+}
+
+// recurrenceEntry is a recurring task's entry in the scheduler index
+type recurrenceEntry struct {
+	UserID  string    `firestore:"userId"`
+	TaskID  string    `firestore:"taskId"`
+	NextRun time.Time `firestore:"nextRun"`
+}
+
+// recurrenceEntryRef returns the scheduler index document for a task
+func recurrenceEntryRef(client *firestore.Client, userID, taskID string) *firestore.DocumentRef {
+	return client.Collection(RECURRENCES_COLLECTION_NAME).Doc(url.PathEscape(userID) + ":" + url.PathEscape(taskID))
+}
+
+// stageRecurrence adds the write that keeps a task's scheduler entry in step
+// with the task: recurring tasks are due for a run straight away, others
+// have no entry
+func (uds *UserDataService) stageRecurrence(batch *changeBatch, userID string, task *Task) {
+	ref := recurrenceEntryRef(uds.firebaseService.firestore, userID, task.ID)
+	if task.Recurrence == nil || task.RecurringTaskID != "" {
+		batch.Delete(ref)
+		return
+	}
+	batch.Set(ref, recurrenceEntry{UserID: userID, TaskID: task.ID, NextRun: time.Now()})
+}
+
+// TaskScheduler creates the tasks due from recurring task templates
+type TaskScheduler struct {
+	userDataService *UserDataService
+	interval        time.Duration
+	// lookahead creates occurrences this long before their day starts
+	lookahead time.Duration
+}
+
+var (
+	taskScheduler     *TaskScheduler
+	taskSchedulerOnce sync.Once
+	taskSchedulerErr  error
+)
+
+// NewTaskScheduler returns the task scheduler. TASK_SCHEDULER_INTERVAL sets
+// how often it runs (0 disables it) and TASK_RECURRENCE_LOOKAHEAD how far
+// ahead of their day occurrences are created.
+func NewTaskScheduler() (*TaskScheduler, error) {
+	taskSchedulerOnce.Do(func() {
+		userDataService, err := NewUserDataService()
+		if err != nil {
+			taskSchedulerErr = err
+			return
+		}
+
+		interval, err := time.ParseDuration(getEnvOrDefault("TASK_SCHEDULER_INTERVAL", DefaultTaskSchedulerInterval.String()))
+		if err != nil || interval < 0 {
+			taskSchedulerErr = fmt.Errorf("invalid TASK_SCHEDULER_INTERVAL: %v", err)
+			return
+		}
+		lookahead, err := time.ParseDuration(getEnvOrDefault("TASK_RECURRENCE_LOOKAHEAD", "0s"))
+		if err != nil || lookahead < 0 {
+			taskSchedulerErr = fmt.Errorf("invalid TASK_RECURRENCE_LOOKAHEAD: %v", err)
+			return
+		}
+
+		taskScheduler = &TaskScheduler{
+			userDataService: userDataService,
+			interval:        interval,
+			lookahead:       lookahead,
+		}
+	})
+
+	return taskScheduler, taskSchedulerErr
+}
+
+// Run runs the scheduler until ctx is done
+func (ts *TaskScheduler) Run(ctx context.Context) {
+	if ts.interval == 0 {
+		log.Printf("Task scheduler disabled")
+		return
+	}
+
+	ticker := time.NewTicker(ts.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := ts.RunOnce(ctx); err != nil {
+			log.Printf("Task scheduler run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce processes the recurring tasks that are due
+func (ts *TaskScheduler) RunOnce(ctx context.Context) error {
+	now := time.Now()
+	iter := ts.userDataService.firebaseService.firestore.Collection(RECURRENCES_COLLECTION_NAME).
+		Where("nextRun", "<=", now).
+		OrderBy("nextRun", firestore.Asc).
+		Limit(recurrenceBatchSize).
+		Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query recurring tasks: %w", err)
+		}
+
+		var entry recurrenceEntry
+		if err := doc.DataTo(&entry); err != nil {
+			log.Printf("Failed to parse recurring task entry %s: %v", doc.Ref.ID, err)
+			continue
+		}
+
+		// One template failing does not hold up the rest
+		if err := ts.userDataService.materializeOccurrences(ctx, entry.UserID, entry.TaskID, now, ts.lookahead); err != nil {
+			log.Printf("Failed to create occurrences of task %s for user %s: %v", entry.TaskID, entry.UserID, err)
+		}
+	}
+}
+
+// occurrenceTaskID derives an occurrence's task ID from its template and
+// date, so an occurrence is never created twice
+func occurrenceTaskID(templateID string, date time.Time) string {
+	return templateID + "-" + date.Format("20060102")
+}
+
+// occurrenceDueTime returns when an occurrence is due: its schedule slot on
+// its date in location
+func occurrenceDueTime(date time.Time, schedule string, location *time.Location) time.Time {
+	slot := scheduleSlotTimes[schedule]
+	return time.Date(date.Year(), date.Month(), date.Day(), slot[0], slot[1], 0, 0, location)
+}
+
+// materializeOccurrences creates a template's occurrences whose day starts
+// within lookahead of now, in the user's time zone. Occurrences from days
+// before today that were never created are skipped or caught up according
+// to the template's missed policy; either way the template records the
+// last date handled, so each occurrence is considered exactly once.
+func (uds *UserDataService) materializeOccurrences(ctx context.Context, userID, templateID string, now time.Time, lookahead time.Duration) error {
+	location := uds.GetUserLocation(ctx, userID)
+	client := uds.firebaseService.firestore
+	entryRef := recurrenceEntryRef(client, userID, templateID)
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	template, err := uds.getTask(ctx, userID, templateID)
+	if errors.Is(err, ErrTaskNotFound) || (err == nil && (template.Recurrence == nil || template.RecurringTaskID != "")) {
+		_, err = entryRef.Delete(ctx)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	recurrence := template.Recurrence
+	rule, err := parseRecurrenceRule(recurrence.Rule)
+	if err != nil {
+		// Stored rules were validated; drop the entry rather than retry
+		log.Printf("Invalid recurrence rule on task %s for user %s: %v", templateID, userID, err)
+		_, err = entryRef.Delete(ctx)
+		return err
+	}
+
+	if recurrence.Start == "" {
+		created, err := ParseTaskTime(template.CreatedAt)
+		if err != nil {
+			created = now
+		}
+		recurrence.Start = created.In(location).Format(recurrenceDateFormat)
+	}
+	start, err := time.Parse(recurrenceDateFormat, recurrence.Start)
+	if err != nil {
+		return fmt.Errorf("invalid recurrence start: %w", err)
+	}
+	var last time.Time
+	if recurrence.LastOccurrence != "" {
+		if last, err = time.Parse(recurrenceDateFormat, recurrence.LastOccurrence); err != nil {
+			return fmt.Errorf("invalid last occurrence: %w", err)
+		}
+	}
+
+	localNow := now.In(location)
+	today := civilDate(localNow)
+	horizon := civilDate(localNow.Add(lookahead))
+
+	var due []time.Time
+	var next time.Time
+	skipped := 0
+	rule.occurrences(start, func(date time.Time) bool {
+		switch {
+		case !last.IsZero() && !date.After(last):
+			return true
+		case date.After(horizon) || len(due) == maxOccurrencesPerRun:
+			next = date
+			return false
+		case date.Before(today) && recurrence.Missed != RecurrenceMissedCatchUp:
+			skipped++
+			last = date
+			return true
+		default:
+			due = append(due, date)
+			last = date
+			return true
+		}
+	})
+
+	// Occurrences created by an earlier run that failed to record them are
+	// left alone
+	tasksCollection := client.Collection(getTasksCollectionPath(userID))
+	refs := make([]*firestore.DocumentRef, len(due))
+	for i, date := range due {
+		refs[i] = tasksCollection.Doc(occurrenceTaskID(templateID, date))
+	}
+	var existing []*firestore.DocumentSnapshot
+	if len(refs) > 0 {
+		if existing, err = client.GetAll(ctx, refs); err != nil {
+			return fmt.Errorf("failed to check occurrences: %w", err)
+		}
+	}
+
+	batch := &changeBatch{}
+	var changes []documentChange
+	created := formatTaskTime(now)
+	for i, date := range due {
+		if existing[i].Exists() {
+			continue
+		}
+		occurrence := &Task{
+			ID:              refs[i].ID,
+			Title:           template.Title,
+			Description:     template.Description,
+			Category:        template.Category,
+			Size:            template.Size,
+			Priority:        template.Priority,
+			Status:          TaskStatusInbox,
+			DueDate:         formatTaskTime(occurrenceDueTime(date, template.Schedule, location)),
+			Schedule:        template.Schedule,
+			CreatedAt:       created,
+			UpdatedAt:       created,
+			Tags:            append([]string(nil), template.Tags...),
+			RecurringTaskID: templateID,
+			OccurrenceDate:  date.Format(recurrenceDateFormat),
+		}
+		batch.Set(refs[i], occurrence)
+		changes = append(changes, documentChange{"tasks", occurrence.ID, ChangeOperationUpsert})
+	}
+
+	if !last.IsZero() {
+		recurrence.LastOccurrence = last.Format(recurrenceDateFormat)
+	}
+	recurrence.NextOccurrence = ""
+	if !next.IsZero() {
+		recurrence.NextOccurrence = next.Format(recurrenceDateFormat)
+	}
+	batch.Set(tasksCollection.Doc(templateID), template)
+	changes = append(changes, documentChange{"tasks", templateID, ChangeOperationUpsert})
+
+	switch {
+	case next.IsZero():
+		// The series has ended
+		batch.Delete(entryRef)
+	case len(due) == maxOccurrencesPerRun:
+		batch.Set(entryRef, recurrenceEntry{UserID: userID, TaskID: templateID, NextRun: now})
+	default:
+		nextDay := time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, location)
+		batch.Set(entryRef, recurrenceEntry{UserID: userID, TaskID: templateID, NextRun: nextDay.Add(-lookahead)})
+	}
+
+	if err := uds.commitChanges(ctx, batch, userID, changes...); err != nil {
+		return fmt.Errorf("failed to store occurrences: %w", err)
+	}
+
+	if len(changes) > 1 || skipped > 0 {
+		log.Printf("Recurring task %s for user %s: created %d occurrences, skipped %d missed, next %q",
+			templateID, userID, len(changes)-1, skipped, recurrence.NextOccurrence)
+	}
+	return nil
+}
@@ -0,0 +1,725 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Task field values
+const (
+	TaskStatusInbox = "inbox"
+	TaskStatusDone  = "done"
+
+	// tasksStorageKey is the generic storage key tasks were kept under as a
+	// single value; it now addresses the per-task documents as a list
+	tasksStorageKey = "tasks"
+	// legacyTasksDocID is the document that held that value
+	legacyTasksDocID = "tasks"
+	// taskTimeFormat matches JavaScript's toISOString so stored timestamps
+	// sort correctly as strings
+	taskTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+var (
+	taskCategories = map[string]bool{"development": true, "design": true, "research": true, "meeting": true, "other": true}
+	taskSizes      = map[string]bool{"S": true, "M": true, "L": true, "XL": true}
+	taskPriorities = map[string]bool{"low": true, "medium": true, "high": true}
+	taskStatuses   = map[string]bool{TaskStatusInbox: true, TaskStatusDone: true}
+	taskSchedules  = map[string]bool{"morning": true, "midday": true, "evening": true}
+)
+
+// Task errors
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrInvalidTask  = errors.New("invalid task")
+)
+
+// FocusSession is one period of focused work on a task
+type FocusSession struct {
+	ID           string  `json:"id" firestore:"id"`
+	TaskID       string  `json:"taskId" firestore:"taskId"`
+	StartTime    string  `json:"startTime" firestore:"startTime"`
+	EndTime      string  `json:"endTime,omitempty" firestore:"endTime,omitempty"`
+	TotalMinutes float64 `json:"totalMinutes" firestore:"totalMinutes"`
+}
+
+// Task is a to-do item with its focus statistics
+type Task struct {
+	ID               string         `json:"id" firestore:"id"`
+	Title            string         `json:"title" firestore:"title"`
+	Description      string         `json:"description,omitempty" firestore:"description,omitempty"`
+	Category         string         `json:"category" firestore:"category"`
+	Size             string         `json:"size" firestore:"size"`
+	Priority         string         `json:"priority" firestore:"priority"`
+	Status           string         `json:"status" firestore:"status"`
+	DueDate          string         `json:"dueDate,omitempty" firestore:"dueDate,omitempty"`
+	Schedule         string         `json:"schedule,omitempty" firestore:"schedule,omitempty"`
+	CreatedAt        string         `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt        string         `json:"updatedAt" firestore:"updatedAt"`
+	CompletedAt      string         `json:"completedAt,omitempty" firestore:"completedAt,omitempty"`
+	Tags             []string       `json:"tags,omitempty" firestore:"tags,omitempty"`
+	TotalFocusTime   float64        `json:"totalFocusTime,omitempty" firestore:"totalFocusTime,omitempty"`
+	AverageFocusTime float64        `json:"averageFocusTime,omitempty" firestore:"averageFocusTime,omitempty"`
+	TotalSessions    int            `json:"totalSessions,omitempty" firestore:"totalSessions,omitempty"`
+	FocusSessions    []FocusSession `json:"focusSessions,omitempty" firestore:"focusSessions,omitempty"`
+
+	// Recurrence makes the task a template for repeating tasks
+	Recurrence *TaskRecurrence `json:"recurrence,omitempty" firestore:"recurrence,omitempty"`
+	// RecurringTaskID and OccurrenceDate identify a task created from a
+	// template
+	RecurringTaskID string `json:"recurringTaskId,omitempty" firestore:"recurringTaskId,omitempty"`
+	OccurrenceDate  string `json:"occurrenceDate,omitempty" firestore:"occurrenceDate,omitempty"`
+}
+
+// TaskFilter selects tasks. Empty fields match everything; list fields
+// match any of their values.
+type TaskFilter struct {
+	Statuses   []string
+	Categories []string
+	Priorities []string
+	Sizes      []string
+	Tag        string
+	// DueFrom and DueTo bound the due date (inclusive); tasks without a
+	// due date are excluded when either is set
+	DueFrom time.Time
+	DueTo   time.Time
+}
+
+// getTasksCollectionPath returns the path for the tasks collection
+func getTasksCollectionPath(userID string) string {
+	return getCollectionPath(userID, tasksStorageKey)
+}
+
+// formatTaskTime renders a time in the stored task format
+func formatTaskTime(t time.Time) string {
+	return t.UTC().Format(taskTimeFormat)
+}
+
+// ParseTaskTime parses an RFC 3339 timestamp or a YYYY-MM-DD date (midnight
+// UTC)
+func ParseTaskTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// normalizeTask validates a task, fills defaults and normalizes timestamps;
+// date-only due dates are kept as dates
+func normalizeTask(task *Task) error {
+	task.Title = strings.TrimSpace(task.Title)
+	if task.Title == "" {
+		return fmt.Errorf("%w: title is required", ErrInvalidTask)
+	}
+
+	if task.Category == "" {
+		task.Category = "other"
+	}
+	if task.Size == "" {
+		task.Size = "M"
+	}
+	if task.Priority == "" {
+		task.Priority = "medium"
+	}
+	if task.Status == "" {
+		task.Status = TaskStatusInbox
+	}
+
+	switch {
+	case !taskCategories[task.Category]:
+		return fmt.Errorf("%w: category must be development, design, research, meeting or other", ErrInvalidTask)
+	case !taskSizes[task.Size]:
+		return fmt.Errorf("%w: size must be S, M, L or XL", ErrInvalidTask)
+	case !taskPriorities[task.Priority]:
+		return fmt.Errorf("%w: priority must be low, medium or high", ErrInvalidTask)
+	case !taskStatuses[task.Status]:
+		return fmt.Errorf("%w: status must be inbox or done", ErrInvalidTask)
+	case task.Schedule != "" && !taskSchedules[task.Schedule]:
+		return fmt.Errorf("%w: schedule must be morning, midday or evening", ErrInvalidTask)
+	}
+
+	for _, field := range []*string{&task.DueDate, &task.CreatedAt, &task.UpdatedAt, &task.CompletedAt} {
+		if *field == "" {
+			continue
+		}
+		if field == &task.DueDate {
+			// A due date without a time is a day, not midnight UTC
+			if _, err := time.Parse(recurrenceDateFormat, *field); err == nil {
+				continue
+			}
+		}
+		t, err := ParseTaskTime(*field)
+		if err != nil {
+			return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidTask, *field)
+		}
+		*field = formatTaskTime(t)
+	}
+
+	if task.Recurrence != nil {
+		if err := validateRecurrence(task.Recurrence); err != nil {
+			return err
+		}
+	}
+
+	var tags []string
+	for _, tag := range task.Tags {
+		tags = appendTag(tags, strings.TrimSpace(tag))
+	}
+	task.Tags = tags
+	return nil
+}
+
+// trackCompletion maintains CompletedAt: done tasks keep the time they were
+// first marked done (previous is the stored version, if any), falling back
+// to completedAt when the client did not send one; open tasks have none
+func trackCompletion(task, previous *Task, completedAt string) {
+	if task.Status != TaskStatusDone {
+		task.CompletedAt = ""
+		return
+	}
+	if task.CompletedAt != "" {
+		return
+	}
+	if previous != nil && previous.Status == TaskStatusDone && previous.CompletedAt != "" {
+		task.CompletedAt = previous.CompletedAt
+		return
+	}
+	task.CompletedAt = completedAt
+}
+
+// keepRecurrenceState carries the scheduler's progress over from the stored
+// task (previous, if any) so client writes cannot rewind it
+func keepRecurrenceState(task, previous *Task) {
+	if task.Recurrence == nil {
+		return
+	}
+	task.Recurrence.LastOccurrence = ""
+	task.Recurrence.NextOccurrence = ""
+	if previous != nil && previous.Recurrence != nil {
+		task.Recurrence.LastOccurrence = previous.Recurrence.LastOccurrence
+		task.Recurrence.NextOccurrence = previous.Recurrence.NextOccurrence
+	}
+}
+
+// matches reports whether a task passes the filter
+func (f TaskFilter) matches(task *Task) bool {
+	oneOf := func(values []string, value string) bool {
+		if len(values) == 0 {
+			return true
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !oneOf(f.Statuses, task.Status) || !oneOf(f.Categories, task.Category) ||
+		!oneOf(f.Priorities, task.Priority) || !oneOf(f.Sizes, task.Size) {
+		return false
+	}
+	if f.Tag != "" && !oneOf(task.Tags, f.Tag) {
+		return false
+	}
+
+	if !f.DueFrom.IsZero() || !f.DueTo.IsZero() {
+		due, err := ParseTaskTime(task.DueDate)
+		if err != nil {
+			return false
+		}
+		if !f.DueFrom.IsZero() && due.Before(f.DueFrom) {
+			return false
+		}
+		if !f.DueTo.IsZero() && due.After(f.DueTo) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListTasks returns the user's tasks matching filter, newest first
+func (uds *UserDataService) ListTasks(ctx context.Context, userID string, filter TaskFilter) ([]*Task, error) {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	all, err := uds.loadTasks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := []*Task{}
+	for _, task := range all {
+		if filter.matches(task) {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt > tasks[j].CreatedAt
+	})
+
+	log.Printf("Retrieved %d of %d tasks for user %s", len(tasks), len(all), userID)
+	return tasks, nil
+}
+
+// GetTask returns one task
+func (uds *UserDataService) GetTask(ctx context.Context, userID, taskID string) (*Task, error) {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	return uds.getTask(ctx, userID, taskID)
+}
+
+// CreateTask stores a new task, assigning its ID and timestamps
+func (uds *UserDataService) CreateTask(ctx context.Context, userID string, task *Task) error {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return err
+	}
+
+	now := formatTaskTime(time.Now())
+	task.CreatedAt = now
+	task.UpdatedAt = now
+	trackCompletion(task, nil, now)
+	keepRecurrenceState(task, nil)
+	if err := normalizeTask(task); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collection := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID))
+	var docRef *firestore.DocumentRef
+	if task.ID != "" && task.ID != legacyTasksDocID {
+		docRef = collection.Doc(task.ID)
+	} else {
+		docRef = collection.NewDoc()
+		task.ID = docRef.ID
+	}
+
+	batch := &changeBatch{}
+	batch.Create(docRef, task)
+	uds.stageRecurrence(batch, userID, task)
+	if err := uds.commitChanges(ctx, batch, userID, documentChange{"tasks", task.ID, ChangeOperationUpsert}); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return fmt.Errorf("%w: task %s already exists", ErrInvalidTask, task.ID)
+		}
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+
+	log.Printf("Created task %s for user %s", task.ID, userID)
+	return nil
+}
+
+// UpdateTask replaces an existing task, keeping its creation time
+func (uds *UserDataService) UpdateTask(ctx context.Context, userID string, task *Task) error {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	existing, err := uds.getTask(ctx, userID, task.ID)
+	if err != nil {
+		return err
+	}
+
+	task.CreatedAt = existing.CreatedAt
+	task.UpdatedAt = formatTaskTime(time.Now())
+	trackCompletion(task, existing, task.UpdatedAt)
+	keepRecurrenceState(task, existing)
+	if err := normalizeTask(task); err != nil {
+		return err
+	}
+
+	return uds.putTasksLocked(ctx, userID, []*Task{task})
+}
+
+// DeleteTask removes a task
+func (uds *UserDataService) DeleteTask(ctx context.Context, userID, taskID string) error {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	if _, err := uds.getTask(ctx, userID, taskID); err != nil {
+		return err
+	}
+
+	docRef := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID)).Doc(taskID)
+	batch := &changeBatch{}
+	batch.Delete(docRef)
+	batch.Delete(recurrenceEntryRef(uds.firebaseService.firestore, userID, taskID))
+	if err := uds.commitChanges(ctx, batch, userID, documentChange{"tasks", taskID, ChangeOperationDelete}); err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
+	}
+
+	log.Printf("Deleted task %s for user %s", taskID, userID)
+	return nil
+}
+
+// upsertTask stores a task as received from sync, keeping the client's
+// timestamps so newest-wins merging stays meaningful
+func (uds *UserDataService) upsertTask(ctx context.Context, userID string, task *Task) error {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return err
+	}
+	if task.ID == "" || task.ID == legacyTasksDocID {
+		return uds.CreateTask(ctx, userID, task)
+	}
+
+	now := formatTaskTime(time.Now())
+	if task.CreatedAt == "" {
+		task.CreatedAt = now
+	}
+	if task.UpdatedAt == "" {
+		task.UpdatedAt = now
+	}
+	if err := normalizeTask(task); err != nil {
+		return err
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	previous, err := uds.getTask(ctx, userID, task.ID)
+	if err != nil && !errors.Is(err, ErrTaskNotFound) {
+		return err
+	}
+	trackCompletion(task, previous, task.UpdatedAt)
+	keepRecurrenceState(task, previous)
+	return uds.putTasksLocked(ctx, userID, []*Task{task})
+}
+
+// getTask reads one task; callers hold uds.mu
+func (uds *UserDataService) getTask(ctx context.Context, userID, taskID string) (*Task, error) {
+	if taskID == "" || taskID == legacyTasksDocID {
+		return nil, ErrTaskNotFound
+	}
+
+	doc, err := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID)).Doc(taskID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrTaskNotFound
+		}
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	var task Task
+	if err := doc.DataTo(&task); err != nil {
+		return nil, fmt.Errorf("failed to parse task: %w", err)
+	}
+	return &task, nil
+}
+
+// loadTasks reads all of the user's tasks; callers hold uds.mu
+func (uds *UserDataService) loadTasks(ctx context.Context, userID string) ([]*Task, error) {
+	iter := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID)).Documents(ctx)
+	defer iter.Stop()
+
+	var tasks []*Task
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate tasks: %w", err)
+		}
+		if doc.Ref.ID == legacyTasksDocID {
+			continue
+		}
+
+		var task Task
+		if err := doc.DataTo(&task); err != nil {
+			log.Printf("Failed to parse task %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+
+// putTasksLocked writes tasks in batches with their sync log entries;
+// callers hold uds.mu
+func (uds *UserDataService) putTasksLocked(ctx context.Context, userID string, tasks []*Task) error {
+	collection := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID))
+
+	// Each task takes three writes: the task, its scheduler entry and its
+	// sync log entry
+	const chunkSize = changeWriteLimit / 3
+	for start := 0; start < len(tasks); start += chunkSize {
+		batch := &changeBatch{}
+		var changes []documentChange
+		for _, task := range tasks[start:min(start+chunkSize, len(tasks))] {
+			batch.Set(collection.Doc(task.ID), task)
+			uds.stageRecurrence(batch, userID, task)
+			changes = append(changes, documentChange{"tasks", task.ID, ChangeOperationUpsert})
+		}
+		if err := uds.commitChanges(ctx, batch, userID, changes...); err != nil {
+			return fmt.Errorf("failed to store tasks: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteTasksLocked deletes tasks in batches; callers hold uds.mu
+func (uds *UserDataService) deleteTasksLocked(ctx context.Context, userID string, taskIDs []string) error {
+	collection := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID))
+
+	const chunkSize = changeWriteLimit / 3
+	for start := 0; start < len(taskIDs); start += chunkSize {
+		batch := &changeBatch{}
+		var changes []documentChange
+		for _, taskID := range taskIDs[start:min(start+chunkSize, len(taskIDs))] {
+			batch.Delete(collection.Doc(taskID))
+			batch.Delete(recurrenceEntryRef(uds.firebaseService.firestore, userID, taskID))
+			changes = append(changes, documentChange{"tasks", taskID, ChangeOperationDelete})
+		}
+		if err := uds.commitChanges(ctx, batch, userID, changes...); err != nil {
+			return fmt.Errorf("failed to delete tasks: %w", err)
+		}
+	}
+	return nil
+}
+
+// decodeTaskList converts a stored or submitted tasks value to tasks
+func decodeTaskList(value interface{}) ([]*Task, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, fmt.Errorf("%w: tasks must be a list of tasks: %v", ErrInvalidTask, err)
+	}
+	return tasks, nil
+}
+
+// getTasksValue returns all tasks as a generic list, for the "tasks"
+// storage key
+func (uds *UserDataService) getTasksValue(ctx context.Context, userID string) (interface{}, error) {
+	tasks, err := uds.ListTasks(ctx, userID, TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return toJSONValue(tasks)
+}
+
+// replaceTasks stores a whole task list submitted under the "tasks" storage
+// key: changed tasks are written, missing ones deleted and unchanged ones
+// left alone, so clients still using the list do not rewrite every task
+func (uds *UserDataService) replaceTasks(ctx context.Context, userID string, value interface{}) error {
+	if err := uds.migrateLegacyTasks(ctx, userID); err != nil {
+		return err
+	}
+
+	tasks, err := decodeTaskList(value)
+	if err != nil {
+		return err
+	}
+	now := formatTaskTime(time.Now())
+	for _, task := range tasks {
+		if task.CreatedAt == "" {
+			task.CreatedAt = now
+		}
+		if task.UpdatedAt == "" {
+			task.UpdatedAt = now
+		}
+		if err := normalizeTask(task); err != nil {
+			return err
+		}
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	existing, err := uds.loadTasks(ctx, userID)
+	if err != nil {
+		return err
+	}
+	existingByID := make(map[string]*Task, len(existing))
+	for _, task := range existing {
+		existingByID[task.ID] = task
+	}
+
+	collection := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID))
+	var changed []*Task
+	keep := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		if task.ID == "" || task.ID == legacyTasksDocID {
+			task.ID = collection.NewDoc().ID
+		}
+		keep[task.ID] = true
+		current, exists := existingByID[task.ID]
+		trackCompletion(task, current, task.UpdatedAt)
+		keepRecurrenceState(task, current)
+		if !exists || !reflect.DeepEqual(current, task) {
+			changed = append(changed, task)
+		}
+	}
+	var removed []string
+	for _, task := range existing {
+		if !keep[task.ID] {
+			removed = append(removed, task.ID)
+		}
+	}
+
+	if err := uds.putTasksLocked(ctx, userID, changed); err != nil {
+		return err
+	}
+	if err := uds.deleteTasksLocked(ctx, userID, removed); err != nil {
+		return err
+	}
+
+	log.Printf("Replaced tasks for user %s: %d written, %d deleted, %d unchanged", userID, len(changed), len(removed), len(tasks)-len(changed))
+	return nil
+}
+
+// deleteAllTasks removes every task, for deleting the "tasks" storage key
+func (uds *UserDataService) deleteAllTasks(ctx context.Context, userID string) error {
+	return uds.replaceTasks(ctx, userID, []interface{}{})
+}
+
+// repairLegacyTask resets the fields of a legacy task that normalizeTask
+// would reject to their defaults. The replaced values are appended to the
+// description so nothing is lost; the repairs made are returned.
+func repairLegacyTask(task *Task, now string) []string {
+	var repairs []string
+	replace := func(field *string, name, value string) {
+		repairs = append(repairs, fmt.Sprintf("%s %q", name, *field))
+		*field = value
+	}
+
+	if strings.TrimSpace(task.Title) == "" {
+		replace(&task.Title, "title", "Untitled task")
+	}
+	if task.Category != "" && !taskCategories[task.Category] {
+		replace(&task.Category, "category", "other")
+	}
+	if task.Size != "" && !taskSizes[task.Size] {
+		replace(&task.Size, "size", "M")
+	}
+	if task.Priority != "" && !taskPriorities[task.Priority] {
+		replace(&task.Priority, "priority", "medium")
+	}
+	if task.Status != "" && !taskStatuses[task.Status] {
+		replace(&task.Status, "status", TaskStatusInbox)
+	}
+	if task.Schedule != "" && !taskSchedules[task.Schedule] {
+		replace(&task.Schedule, "schedule", "")
+	}
+	for _, field := range []struct {
+		value *string
+		name  string
+	}{{&task.DueDate, "dueDate"}, {&task.CreatedAt, "createdAt"}, {&task.UpdatedAt, "updatedAt"}, {&task.CompletedAt, "completedAt"}} {
+		if *field.value == "" {
+			continue
+		}
+		if _, err := ParseTaskTime(*field.value); err != nil {
+			value := now
+			if field.value == &task.DueDate || field.value == &task.CompletedAt {
+				value = ""
+			}
+			replace(field.value, field.name, value)
+		}
+	}
+	if task.Recurrence != nil {
+		if err := validateRecurrence(task.Recurrence); err != nil {
+			repairs = append(repairs, fmt.Sprintf("recurrence %q", task.Recurrence.Rule))
+			task.Recurrence = nil
+		}
+	}
+
+	if len(repairs) > 0 {
+		note := "Replaced when migrating: " + strings.Join(repairs, ", ")
+		if task.Description != "" {
+			note = task.Description + "\n\n" + note
+		}
+		task.Description = note
+	}
+	return repairs
+}
+
+// migrateLegacyTasks moves tasks stored as a single value into one document
+// per task. Each user is checked once per process.
+func (uds *UserDataService) migrateLegacyTasks(ctx context.Context, userID string) error {
+	if _, done := uds.tasksMigrated.Load(userID); done {
+		return nil
+	}
+
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collection := uds.firebaseService.firestore.Collection(getTasksCollectionPath(userID))
+	doc, err := collection.Doc(legacyTasksDocID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			uds.tasksMigrated.Store(userID, true)
+			return nil
+		}
+		return fmt.Errorf("failed to check for legacy tasks: %w", err)
+	}
+
+	tasks, err := decodeTaskList(doc.Data()["value"])
+	if err != nil {
+		return fmt.Errorf("failed to migrate legacy tasks: %w", err)
+	}
+
+	now := formatTaskTime(time.Now())
+	for _, task := range tasks {
+		if repairs := repairLegacyTask(task, now); len(repairs) > 0 {
+			log.Printf("Repaired legacy task %s for user %s: %s", task.ID, userID, strings.Join(repairs, "; "))
+		}
+		if task.CreatedAt == "" {
+			task.CreatedAt = now
+		}
+		if task.UpdatedAt == "" {
+			task.UpdatedAt = now
+		}
+		trackCompletion(task, nil, task.UpdatedAt)
+		// The legacy document is only deleted once every task moved
+		if err := normalizeTask(task); err != nil {
+			return fmt.Errorf("failed to migrate legacy task %s: %w", task.ID, err)
+		}
+		if task.ID == "" || task.ID == legacyTasksDocID {
+			task.ID = collection.NewDoc().ID
+		}
+	}
+
+	if err := uds.putTasksLocked(ctx, userID, tasks); err != nil {
+		return err
+	}
+	batch := &changeBatch{}
+	batch.Delete(collection.Doc(legacyTasksDocID))
+	if err := uds.commitChanges(ctx, batch, userID, documentChange{"tasks", legacyTasksDocID, ChangeOperationDelete}); err != nil {
+		return fmt.Errorf("failed to remove legacy tasks: %w", err)
+	}
+
+	uds.tasksMigrated.Store(userID, true)
+	log.Printf("Migrated %d legacy tasks to documents for user %s", len(tasks), userID)
+	return nil
+}
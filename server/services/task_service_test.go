@@ -0,0 +1,88 @@
+package services
+
+import "testing"
+
+func TestRepairLegacyTask(t *testing.T) {
+	const now = "2024-05-01T12:00:00.000Z"
+	tests := []struct {
+		name     string
+		task     Task
+		want     Task
+		wantNote string
+	}{
+		{
+			name: "valid task untouched",
+			task: Task{Title: "Write", Category: "design", DueDate: "2024-05-02", Description: "Draft"},
+			want: Task{Title: "Write", Category: "design", DueDate: "2024-05-02", Description: "Draft"},
+		},
+		{
+			name:     "bad enums reset",
+			task:     Task{Title: "Write", Category: "chores", Size: "XXL", Priority: "urgent", Status: "doing", Schedule: "night"},
+			want:     Task{Title: "Write", Category: "other", Size: "M", Priority: "medium", Status: TaskStatusInbox},
+			wantNote: `Replaced when migrating: category "chores", size "XXL", priority "urgent", status "doing", schedule "night"`,
+		},
+		{
+			name:     "blank title and bad timestamps",
+			task:     Task{Title: " ", DueDate: "tomorrow", CreatedAt: "yesterday", Description: "Keep me"},
+			want:     Task{Title: "Untitled task", CreatedAt: now},
+			wantNote: "Keep me\n\nReplaced when migrating: title \" \", dueDate \"tomorrow\", createdAt \"yesterday\"",
+		},
+		{
+			name:     "bad recurrence dropped",
+			task:     Task{Title: "Water plants", Recurrence: &TaskRecurrence{Rule: "FREQ=SOMETIMES"}},
+			want:     Task{Title: "Water plants"},
+			wantNote: `Replaced when migrating: recurrence "FREQ=SOMETIMES"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := tt.task
+			repairs := repairLegacyTask(&task, now)
+			if (len(repairs) > 0) != (tt.wantNote != "") {
+				t.Errorf("repairs = %v", repairs)
+			}
+			if tt.wantNote != "" {
+				tt.want.Description = tt.wantNote
+			}
+			if task.Title != tt.want.Title || task.Category != tt.want.Category || task.Size != tt.want.Size ||
+				task.Priority != tt.want.Priority || task.Status != tt.want.Status || task.Schedule != tt.want.Schedule ||
+				task.DueDate != tt.want.DueDate || task.CreatedAt != tt.want.CreatedAt || (task.Recurrence == nil) != (tt.want.Recurrence == nil) {
+				t.Errorf("task = %+v, want %+v", task, tt.want)
+			}
+			if task.Description != tt.want.Description {
+				t.Errorf("description = %q, want %q", task.Description, tt.want.Description)
+			}
+			if err := normalizeTask(&task); err != nil {
+				t.Errorf("normalizeTask() after repair = %v", err)
+			}
+		})
+	}
+}
+
+func TestNormalizeTaskDueDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		dueDate string
+		want    string
+		wantErr bool
+	}{
+		{name: "date kept", dueDate: "2024-05-02", want: "2024-05-02"},
+		{name: "timestamp canonicalized", dueDate: "2024-05-02T09:00:00+02:00", want: "2024-05-02T07:00:00.000Z"},
+		{name: "none", dueDate: "", want: ""},
+		{name: "invalid", dueDate: "2024-13-40", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := Task{Title: "Write", DueDate: tt.dueDate}
+			err := normalizeTask(&task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeTask() error = %v", err)
+			}
+			if !tt.wantErr && task.DueDate != tt.want {
+				t.Errorf("dueDate = %q, want %q", task.DueDate, tt.want)
+			}
+		})
+	}
+}
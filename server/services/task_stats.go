@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// Task statistics defaults
+var DefaultTaskStatsWindows = []int{7, 30, 90}
+
+const DefaultTaskStatsTrendDays = 14
+
+// TaskStatsOptions configures GetTaskStats
+type TaskStatsOptions struct {
+	// Location is the time zone for day boundaries; the user's timeZone
+	// setting is used when nil
+	Location *time.Location
+	// Windows are the trailing periods, in days, summarized in Windows
+	Windows []int
+	// TrendDays is the number of days in the daily completion series
+	TrendDays int
+}
+
+// TaskBreakdown counts tasks in one category, size or priority
+type TaskBreakdown struct {
+	Total int `json:"total"`
+	Inbox int `json:"inbox"`
+	Done  int `json:"done"`
+}
+
+// TaskCompletionWindow summarizes task throughput over a trailing period
+type TaskCompletionWindow struct {
+	Days      int `json:"days"`
+	Created   int `json:"created"`
+	Completed int `json:"completed"`
+	// CompletionRate is the share of tasks created in the window that are done
+	CompletionRate float64 `json:"completionRate"`
+}
+
+// TaskDailyCompletion counts tasks created and completed on one day
+type TaskDailyCompletion struct {
+	Date      string `json:"date"`
+	Created   int    `json:"created"`
+	Completed int    `json:"completed"`
+}
+
+// TaskStats summarizes a user's tasks. Due-date counts cover open (inbox)
+// tasks only.
+type TaskStats struct {
+	Total       int    `json:"total"`
+	Inbox       int    `json:"inbox"`
+	Done        int    `json:"done"`
+	Overdue     int    `json:"overdue"`
+	DueToday    int    `json:"dueToday"`
+	DueThisWeek int    `json:"dueThisWeek"`
+	TimeZone    string `json:"timeZone"`
+
+	ByCategory map[string]*TaskBreakdown `json:"byCategory"`
+	BySize     map[string]*TaskBreakdown `json:"bySize"`
+	ByPriority map[string]*TaskBreakdown `json:"byPriority"`
+
+	Windows []TaskCompletionWindow `json:"windows"`
+	Trend   []TaskDailyCompletion  `json:"trend"`
+}
+
+// GetTaskStats computes task statistics with day boundaries in the user's
+// time zone
+func (uds *UserDataService) GetTaskStats(ctx context.Context, userID string, opts TaskStatsOptions) (*TaskStats, error) {
+	location := opts.Location
+	if location == nil {
+		location = uds.GetUserLocation(ctx, userID)
+	}
+	if len(opts.Windows) == 0 {
+		opts.Windows = DefaultTaskStatsWindows
+	}
+	if opts.TrendDays <= 0 {
+		opts.TrendDays = DefaultTaskStatsTrendDays
+	}
+
+	tasks, err := uds.ListTasks(ctx, userID, TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	return computeTaskStats(tasks, time.Now().In(location), opts), nil
+}
+
+// computeTaskStats computes statistics as of now, whose location sets the
+// day boundaries
+func computeTaskStats(tasks []*Task, now time.Time, opts TaskStatsOptions) *TaskStats {
+	location := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	tomorrow := today.AddDate(0, 0, 1)
+	weekEnd := today.AddDate(0, 0, 7)
+
+	stats := &TaskStats{
+		Total:      len(tasks),
+		TimeZone:   location.String(),
+		ByCategory: make(map[string]*TaskBreakdown),
+		BySize:     make(map[string]*TaskBreakdown),
+		ByPriority: make(map[string]*TaskBreakdown),
+		Windows:    make([]TaskCompletionWindow, len(opts.Windows)),
+		Trend:      make([]TaskDailyCompletion, opts.TrendDays),
+	}
+
+	// Trend days are indexed by local date
+	trendDays := make(map[string]int, opts.TrendDays)
+	trendStart := today.AddDate(0, 0, 1-opts.TrendDays)
+	for i := range stats.Trend {
+		date := trendStart.AddDate(0, 0, i).Format("2006-01-02")
+		stats.Trend[i].Date = date
+		trendDays[date] = i
+	}
+	trendIndex := func(t time.Time) (int, bool) {
+		i, ok := trendDays[t.In(location).Format("2006-01-02")]
+		return i, ok
+	}
+
+	windowStarts := make([]time.Time, len(opts.Windows))
+	createdDone := make([]int, len(opts.Windows))
+	for i, days := range opts.Windows {
+		stats.Windows[i].Days = days
+		windowStarts[i] = tomorrow.AddDate(0, 0, -days)
+	}
+
+	for _, task := range tasks {
+		done := task.Status == TaskStatusDone
+		countBreakdown(stats.ByCategory, task.Category, done)
+		countBreakdown(stats.BySize, task.Size, done)
+		countBreakdown(stats.ByPriority, task.Priority, done)
+
+		if done {
+			stats.Done++
+		} else {
+			stats.Inbox++
+			if due, ok := parseDueDate(task.DueDate, location); ok {
+				switch {
+				case due.Before(today):
+					stats.Overdue++
+				case due.Before(tomorrow):
+					stats.DueToday++
+				case !due.After(weekEnd):
+					stats.DueThisWeek++
+				}
+			}
+		}
+
+		created, createdOK := parseTimestamp(task.CreatedAt)
+		completedAt := task.CompletedAt
+		if completedAt == "" {
+			// Tasks completed before completion times were recorded
+			completedAt = task.UpdatedAt
+		}
+		completed, completedOK := parseTimestamp(completedAt)
+		completedOK = completedOK && done
+
+		for i, start := range windowStarts {
+			if createdOK && !created.Before(start) {
+				stats.Windows[i].Created++
+				if done {
+					createdDone[i]++
+				}
+			}
+			if completedOK && !completed.Before(start) {
+				stats.Windows[i].Completed++
+			}
+		}
+		if i, ok := trendIndex(created); createdOK && ok {
+			stats.Trend[i].Created++
+		}
+		if i, ok := trendIndex(completed); completedOK && ok {
+			stats.Trend[i].Completed++
+		}
+	}
+
+	for i := range stats.Windows {
+		if stats.Windows[i].Created > 0 {
+			stats.Windows[i].CompletionRate = float64(createdDone[i]) / float64(stats.Windows[i].Created)
+		}
+	}
+
+	return stats
+}
+
+// countBreakdown counts a task under key
+func countBreakdown(breakdowns map[string]*TaskBreakdown, key string, done bool) {
+	breakdown := breakdowns[key]
+	if breakdown == nil {
+		breakdown = &TaskBreakdown{}
+		breakdowns[key] = breakdown
+	}
+	breakdown.Total++
+	if done {
+		breakdown.Done++
+	} else {
+		breakdown.Inbox++
+	}
+}
+
+// parseDueDate parses a task due date; a plain date is a day in location
+func parseDueDate(value string, location *time.Location) (time.Time, bool) {
+	if due, err := time.ParseInLocation("2006-01-02", value, location); err == nil {
+		return due, true
+	}
+	due, err := ParseTaskTime(value)
+	return due, err == nil
+}
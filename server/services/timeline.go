@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Timeline entry types
+const (
+	TimelineTypeTask       = "task"
+	TimelineTypeDisruption = "disruption"
+)
+
+// MaxTimelineDays bounds the days one timeline request covers
+const MaxTimelineDays = 31
+
+// TimelineEntry is a focus session or disruption on the timeline
+type TimelineEntry struct {
+	ID        string  `json:"id"`
+	Type      string  `json:"type"`
+	Title     string  `json:"title"`
+	StartTime string  `json:"startTime"`
+	EndTime   string  `json:"endTime,omitempty"`
+	Duration  float64 `json:"duration"`
+	Category  string  `json:"category"`
+	TaskID    string  `json:"taskId,omitempty"`
+	IsActive  bool    `json:"isActive,omitempty"`
+}
+
+// TimelineOverlap is a period where a disruption interrupted a focus session
+type TimelineOverlap struct {
+	FocusSessionID string  `json:"focusSessionId"`
+	DisruptionID   string  `json:"disruptionId"`
+	StartTime      string  `json:"startTime"`
+	EndTime        string  `json:"endTime"`
+	Minutes        float64 `json:"minutes"`
+}
+
+// TimelineDay is one day of the timeline. Focused minutes exclude time
+// covered by disruptions and time paused; entries spanning midnight count on
+// each day.
+type TimelineDay struct {
+	Date             string            `json:"date"`
+	Entries          []TimelineEntry   `json:"entries"`
+	Overlaps         []TimelineOverlap `json:"overlaps"`
+	FocusedMinutes   float64           `json:"focusedMinutes"`
+	DisruptedMinutes float64           `json:"disruptedMinutes"`
+	OverlapMinutes   float64           `json:"overlapMinutes"`
+}
+
+// Timeline is the focus sessions and disruptions over a range of days
+type Timeline struct {
+	From             string        `json:"from"`
+	To               string        `json:"to"`
+	TimeZone         string        `json:"timeZone"`
+	Days             []TimelineDay `json:"days"`
+	FocusedMinutes   float64       `json:"focusedMinutes"`
+	DisruptedMinutes float64       `json:"disruptedMinutes"`
+}
+
+// timelineSpan is an entry's period
+type timelineSpan struct {
+	entry      TimelineEntry
+	start, end time.Time
+	// countedEnd ends the time counted toward the totals when it is before
+	// end. Pauses are not recorded, so a focus session counts its focused
+	// minutes from its start.
+	countedEnd time.Time
+}
+
+// counted returns the end of the span's counted time
+func (span timelineSpan) counted() time.Time {
+	if span.countedEnd.IsZero() {
+		return span.end
+	}
+	return minTime(span.countedEnd, span.end)
+}
+
+// GetTimeline merges focus sessions, including the active one, and
+// disruptions from the days from to to (YYYY-MM-DD, inclusive; today when
+// both are empty) in location, or the user's time zone when location is nil
+func (uds *UserDataService) GetTimeline(ctx context.Context, userID, from, to string, location *time.Location) (*Timeline, error) {
+	if location == nil {
+		location = uds.GetUserLocation(ctx, userID)
+	}
+	if from == "" && to == "" {
+		from = time.Now().In(location).Format(disruptionDateFormat)
+		to = from
+	}
+	fromDate, err := time.ParseInLocation(disruptionDateFormat, from, location)
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := time.ParseInLocation(disruptionDateFormat, to, location)
+	if err != nil {
+		return nil, err
+	}
+	windowEnd := toDate.AddDate(0, 0, 1)
+
+	tasks, err := uds.ListTasks(ctx, userID, TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+	tasksByID := make(map[string]*Task, len(tasks))
+	for _, task := range tasks {
+		tasksByID[task.ID] = task
+	}
+
+	// Disruption dates may have been assigned in another time zone
+	disruptions, err := uds.ListDisruptions(ctx, userID, DisruptionFilter{
+		From: fromDate.AddDate(0, 0, -1).Format(disruptionDateFormat),
+		To:   windowEnd.Format(disruptionDateFormat),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, active, err := uds.timelineFocusSessions(ctx, userID, tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var focus, disrupted []timelineSpan
+	for _, session := range sessions {
+		span, ok := focusSessionSpan(session, tasksByID[session.TaskID], now)
+		if ok {
+			focus = append(focus, span)
+		}
+	}
+	if active != nil {
+		span, ok := focusSessionSpan(active.FocusSession, tasksByID[active.TaskID], now)
+		if ok {
+			span.entry.IsActive = true
+			focused := active.focusedMinutes(now)
+			span.entry.Duration = roundMinutes(focused)
+			span.countedEnd = span.start.Add(time.Duration(focused * float64(time.Minute)))
+			focus = append(focus, span)
+		}
+	}
+	for _, disruption := range disruptions {
+		if span, ok := disruptionSpan(disruption, now); ok {
+			disrupted = append(disrupted, span)
+		}
+	}
+
+	timeline := &Timeline{From: from, To: to, TimeZone: location.String()}
+	for day := fromDate; day.Before(windowEnd); day = day.AddDate(0, 0, 1) {
+		timelineDay := buildTimelineDay(day, day.AddDate(0, 0, 1), focus, disrupted)
+		timeline.FocusedMinutes += timelineDay.FocusedMinutes
+		timeline.DisruptedMinutes += timelineDay.DisruptedMinutes
+		timeline.Days = append(timeline.Days, timelineDay)
+	}
+	timeline.FocusedMinutes = roundMinutes(timeline.FocusedMinutes)
+	timeline.DisruptedMinutes = roundMinutes(timeline.DisruptedMinutes)
+	return timeline, nil
+}
+
+// timelineFocusSessions returns the completed focus sessions from the
+// focus data and tasks, without duplicates, and the active session if any
+func (uds *UserDataService) timelineFocusSessions(ctx context.Context, userID string, tasks []*Task) ([]FocusSession, *ActiveFocusSession, error) {
+	uds.mu.RLock()
+	defer uds.mu.RUnlock()
+
+	focusData := map[string]*TaskFocusData{}
+	if _, err := uds.readStoredValue(ctx, userID, taskFocusDataKey, &focusData); err != nil {
+		return nil, nil, err
+	}
+	active, err := uds.getActiveFocusSession(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNoActiveFocusSession) {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool)
+	var sessions []FocusSession
+	add := func(session FocusSession) {
+		if session.EndTime == "" || seen[session.ID] || (active != nil && session.ID == active.ID) {
+			return
+		}
+		seen[session.ID] = true
+		sessions = append(sessions, session)
+	}
+	for _, data := range focusData {
+		if data == nil {
+			continue
+		}
+		for _, session := range data.Sessions {
+			add(session)
+		}
+	}
+	for _, task := range tasks {
+		for _, session := range task.FocusSessions {
+			add(session)
+		}
+	}
+	return sessions, active, nil
+}
+
+// focusSessionSpan places a focus session on the timeline; sessions still
+// running end now
+func focusSessionSpan(session FocusSession, task *Task, now time.Time) (timelineSpan, bool) {
+	start, err := ParseTaskTime(session.StartTime)
+	if err != nil {
+		return timelineSpan{}, false
+	}
+	end := now
+	if session.EndTime != "" {
+		if end, err = ParseTaskTime(session.EndTime); err != nil {
+			return timelineSpan{}, false
+		}
+	}
+
+	entry := TimelineEntry{
+		ID:        session.ID,
+		Type:      TimelineTypeTask,
+		Title:     "Deleted task",
+		StartTime: session.StartTime,
+		EndTime:   session.EndTime,
+		Duration:  session.TotalMinutes,
+		Category:  "other",
+		TaskID:    session.TaskID,
+	}
+	if task != nil {
+		entry.Title = task.Title
+		entry.Category = task.Category
+	}
+	span := timelineSpan{entry: entry, start: start, end: end}
+	if session.EndTime != "" {
+		span.countedEnd = start.Add(time.Duration(session.TotalMinutes * float64(time.Minute)))
+	}
+	return span, !end.Before(start)
+}
+
+// disruptionSpan places a disruption on the timeline; disruptions still in
+// progress end now
+func disruptionSpan(disruption *Disruption, now time.Time) (timelineSpan, bool) {
+	start, err := ParseTaskTime(disruption.StartTime)
+	if err != nil {
+		return timelineSpan{}, false
+	}
+	end := now
+	active := disruption.EndTime == ""
+	if !active {
+		if end, err = ParseTaskTime(disruption.EndTime); err != nil {
+			return timelineSpan{}, false
+		}
+	}
+
+	entry := TimelineEntry{
+		ID:        disruption.ID,
+		Type:      TimelineTypeDisruption,
+		Title:     disruption.Title,
+		StartTime: disruption.StartTime,
+		EndTime:   disruption.EndTime,
+		Duration:  disruption.Duration,
+		Category:  disruption.Category,
+		TaskID:    disruption.TaskID,
+		IsActive:  active,
+	}
+	if active {
+		entry.Duration = roundMinutes(now.Sub(start).Minutes())
+	}
+	return timelineSpan{entry: entry, start: start, end: end}, !end.Before(start)
+}
+
+// buildTimelineDay collects the entries overlapping [dayStart, dayEnd) and
+// totals the day's focused and disrupted time
+func buildTimelineDay(dayStart, dayEnd time.Time, focus, disrupted []timelineSpan) TimelineDay {
+	day := TimelineDay{
+		Date:     dayStart.Format(disruptionDateFormat),
+		Entries:  []TimelineEntry{},
+		Overlaps: []TimelineOverlap{},
+	}
+
+	// clip limits the counted time of spans to the day, dropping spans
+	// outside it
+	var daySpans []timelineSpan
+	clip := func(spans []timelineSpan) []timelineSpan {
+		var clipped []timelineSpan
+		for _, span := range spans {
+			start, end := maxTime(span.start, dayStart), minTime(span.end, dayEnd)
+			if end.After(start) || (span.start.Equal(span.end) && !start.Before(dayStart) && start.Before(dayEnd)) {
+				daySpans = append(daySpans, span)
+				clipped = append(clipped, timelineSpan{entry: span.entry, start: start, end: minTime(span.counted(), dayEnd)})
+			}
+		}
+		return clipped
+	}
+	dayFocus, dayDisrupted := clip(focus), clip(disrupted)
+
+	// Start times may be in any offset, so sort by the parsed times
+	sort.SliceStable(daySpans, func(i, j int) bool {
+		return daySpans[i].start.Before(daySpans[j].start)
+	})
+	for _, span := range daySpans {
+		day.Entries = append(day.Entries, span.entry)
+	}
+
+	for _, f := range dayFocus {
+		for _, d := range dayDisrupted {
+			start, end := maxTime(f.start, d.start), minTime(f.end, d.end)
+			if !end.After(start) {
+				continue
+			}
+			day.Overlaps = append(day.Overlaps, TimelineOverlap{
+				FocusSessionID: f.entry.ID,
+				DisruptionID:   d.entry.ID,
+				StartTime:      formatTaskTime(start),
+				EndTime:        formatTaskTime(end),
+				Minutes:        roundMinutes(end.Sub(start).Minutes()),
+			})
+		}
+	}
+	sort.SliceStable(day.Overlaps, func(i, j int) bool {
+		return day.Overlaps[i].StartTime < day.Overlaps[j].StartTime
+	})
+
+	focusTime := unionDuration(dayFocus)
+	disruptedTime := unionDuration(dayDisrupted)
+	overlapTime := focusTime + disruptedTime - unionDuration(append(append([]timelineSpan{}, dayFocus...), dayDisrupted...))
+	day.FocusedMinutes = roundMinutes((focusTime - overlapTime).Minutes())
+	day.DisruptedMinutes = roundMinutes(disruptedTime.Minutes())
+	day.OverlapMinutes = roundMinutes(overlapTime.Minutes())
+	return day
+}
+
+// unionDuration returns the time covered by at least one span
+func unionDuration(spans []timelineSpan) time.Duration {
+	sorted := append([]timelineSpan{}, spans...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start.Before(sorted[j].start) })
+
+	var total time.Duration
+	var coveredUntil time.Time
+	for _, span := range sorted {
+		start := maxTime(span.start, coveredUntil)
+		if span.end.After(start) {
+			total += span.end.Sub(start)
+			coveredUntil = span.end
+		}
+	}
+	return total
+}
+
+// roundMinutes rounds minutes to one decimal place
+func roundMinutes(minutes float64) float64 {
+	return math.Round(minutes*10) / 10
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBuildTimelineDay(t *testing.T) {
+	dayStart := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := dayStart.AddDate(0, 0, 2)
+	session := func(id, start, end string, minutes float64) FocusSession {
+		return FocusSession{ID: id, TaskID: "t1", StartTime: start, EndTime: end, TotalMinutes: minutes}
+	}
+	disruption := func(id, start, end string) *Disruption {
+		return &Disruption{ID: id, Title: id, StartTime: start, EndTime: end}
+	}
+
+	tests := []struct {
+		name          string
+		sessions      []FocusSession
+		disruptions   []*Disruption
+		wantEntries   []string
+		wantFocused   float64
+		wantDisrupted float64
+		wantOverlap   float64
+	}{
+		{
+			name:        "unpaused session",
+			sessions:    []FocusSession{session("f1", "2024-05-01T09:00:00.000Z", "2024-05-01T10:00:00.000Z", 60)},
+			wantEntries: []string{"f1"},
+			wantFocused: 60,
+		},
+		{
+			name:        "paused time is not focused",
+			sessions:    []FocusSession{session("f1", "2024-05-01T09:00:00.000Z", "2024-05-01T10:00:00.000Z", 40)},
+			wantEntries: []string{"f1"},
+			wantFocused: 40,
+		},
+		{
+			name:          "disruption inside the focused time",
+			sessions:      []FocusSession{session("f1", "2024-05-01T09:00:00.000Z", "2024-05-01T10:00:00.000Z", 40)},
+			disruptions:   []*Disruption{disruption("d1", "2024-05-01T09:10:00.000Z", "2024-05-01T09:20:00.000Z")},
+			wantEntries:   []string{"f1", "d1"},
+			wantFocused:   30,
+			wantDisrupted: 10,
+			wantOverlap:   10,
+		},
+		{
+			name:          "disruption during the pause",
+			sessions:      []FocusSession{session("f1", "2024-05-01T09:00:00.000Z", "2024-05-01T10:00:00.000Z", 40)},
+			disruptions:   []*Disruption{disruption("d1", "2024-05-01T09:45:00.000Z", "2024-05-01T09:55:00.000Z")},
+			wantEntries:   []string{"f1", "d1"},
+			wantFocused:   40,
+			wantDisrupted: 10,
+		},
+		{
+			name:        "session from the previous day counts its focused time there",
+			sessions:    []FocusSession{session("f1", "2024-04-30T23:00:00.000Z", "2024-05-01T01:00:00.000Z", 30)},
+			wantEntries: []string{"f1"},
+		},
+		{
+			name: "entries sorted by time, not by string",
+			sessions: []FocusSession{
+				session("f1", "2024-05-01T09:00:00.000Z", "2024-05-01T09:30:00.000Z", 30),
+				session("f2", "2024-05-01T10:00:00+02:00", "2024-05-01T08:30:00.000Z", 30),
+			},
+			wantEntries: []string{"f2", "f1"},
+			wantFocused: 60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var focus, disrupted []timelineSpan
+			for _, s := range tt.sessions {
+				if span, ok := focusSessionSpan(s, nil, now); ok {
+					focus = append(focus, span)
+				}
+			}
+			for _, d := range tt.disruptions {
+				if span, ok := disruptionSpan(d, now); ok {
+					disrupted = append(disrupted, span)
+				}
+			}
+
+			day := buildTimelineDay(dayStart, dayStart.AddDate(0, 0, 1), focus, disrupted)
+			var entries []string
+			for _, entry := range day.Entries {
+				entries = append(entries, entry.ID)
+			}
+			if !slices.Equal(entries, tt.wantEntries) {
+				t.Errorf("entries = %v, want %v", entries, tt.wantEntries)
+			}
+			if day.FocusedMinutes != tt.wantFocused || day.DisruptedMinutes != tt.wantDisrupted || day.OverlapMinutes != tt.wantOverlap {
+				t.Errorf("focused %v disrupted %v overlap %v, want %v %v %v", day.FocusedMinutes, day.DisruptedMinutes,
+					day.OverlapMinutes, tt.wantFocused, tt.wantDisrupted, tt.wantOverlap)
+			}
+		})
+	}
+}
@@ -2,12 +2,17 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Collection structure constants - NEW optimized pattern
@@ -26,6 +31,8 @@ var STORAGE_KEY_TO_COLLECTION_TYPE = map[string]string{
 	"sessions":            "sessions",
 	"savedTabs":           "saved-tabs",
 	"favorites":           "favorites",
+	"tags":                "tags",
+	"smartCollections":    "smart-collections",
 	"settings":            "settings",
 	"metrics":             "metrics",
 	"sessionAnalytics":    "session-analytics",
@@ -47,15 +54,16 @@ type Session struct {
 
 // Tab represents a browser tab
 type Tab struct {
-	ID         int    `json:"id" firestore:"id"`
-	URL        string `json:"url,omitempty" firestore:"url,omitempty"`
-	Title      string `json:"title,omitempty" firestore:"title,omitempty"`
-	WindowId   int    `json:"windowId" firestore:"windowId"`
-	Index      int    `json:"index" firestore:"index"`
-	Active     bool   `json:"active,omitempty" firestore:"active,omitempty"`
-	Pinned     bool   `json:"pinned,omitempty" firestore:"pinned,omitempty"`
-	FavIconUrl string `json:"favIconUrl,omitempty" firestore:"favIconUrl,omitempty"`
-	Usage      *Usage `json:"usage,omitempty" firestore:"usage,omitempty"`
+	ID         int         `json:"id" firestore:"id"`
+	URL        string      `json:"url,omitempty" firestore:"url,omitempty"`
+	Title      string      `json:"title,omitempty" firestore:"title,omitempty"`
+	WindowId   int         `json:"windowId" firestore:"windowId"`
+	Index      int         `json:"index" firestore:"index"`
+	Active     bool        `json:"active,omitempty" firestore:"active,omitempty"`
+	Pinned     bool        `json:"pinned,omitempty" firestore:"pinned,omitempty"`
+	FavIconUrl string      `json:"favIconUrl,omitempty" firestore:"favIconUrl,omitempty"`
+	Usage      *Usage      `json:"usage,omitempty" firestore:"usage,omitempty"`
+	LinkStatus *LinkStatus `json:"linkStatus,omitempty" firestore:"-"` // From annotations, never stored
 }
 
 // Usage represents tab usage statistics
@@ -76,12 +84,23 @@ type SavedTab struct {
 	Tags       []string          `json:"tags,omitempty" firestore:"tags,omitempty"`
 	Notes      string            `json:"notes,omitempty" firestore:"notes,omitempty"`
 	Metadata   map[string]string `json:"metadata,omitempty" firestore:"metadata,omitempty"`
+	Usage      *Usage            `json:"usage,omitempty" firestore:"usage,omitempty"`
+	LinkStatus *LinkStatus       `json:"linkStatus,omitempty" firestore:"-"` // From annotations, never stored
+	Archive    *PageArchive      `json:"archive,omitempty" firestore:"-"`    // From annotations, never stored
 }
 
 // UserDataService handles user data operations
 type UserDataService struct {
 	firebaseService *FirebaseService
+	changeFeed      *ChangeFeed
+	blobs           BlobStore
+	spillBytes      int
+	compressBytes   int
 	mu              sync.RWMutex
+	tasksMigrated   sync.Map
+
+	disruptionsMigrated sync.Map
+	userJobsScheduled   sync.Map
 }
 
 var (
@@ -132,8 +151,25 @@ func initializeUserDataService() (*UserDataService, error) {
 		return nil, fmt.Errorf("failed to initialize Firebase service: %w", err)
 	}
 
+	blobs, err := NewBlobStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize blob store: %w", err)
+	}
+	spillBytes, err := strconv.Atoi(getEnvOrDefault("BLOB_SPILL_BYTES", strconv.Itoa(DefaultBlobSpillBytes)))
+	if err != nil || spillBytes <= 0 {
+		return nil, fmt.Errorf("invalid BLOB_SPILL_BYTES: %v", err)
+	}
+	compressBytes, err := strconv.Atoi(getEnvOrDefault("STORAGE_COMPRESS_BYTES", strconv.Itoa(DefaultCompressBytes)))
+	if err != nil || compressBytes < 0 {
+		return nil, fmt.Errorf("invalid STORAGE_COMPRESS_BYTES: %v", err)
+	}
+
 	service := &UserDataService{
 		firebaseService: firebaseService,
+		changeFeed:      newChangeFeedFromEnv(),
+		blobs:           blobs,
+		spillBytes:      spillBytes,
+		compressBytes:   compressBytes,
 	}
 
 	log.Println("User data service initialized successfully")
@@ -146,6 +182,22 @@ func (uds *UserDataService) GetUserSessions(ctx context.Context, userID string)
 	uds.mu.RLock()
 	defer uds.mu.RUnlock()
 
+	sessions, err := uds.loadSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations.annotateSessions(sessions)
+
+	log.Printf("Retrieved %d sessions for user %s", len(sessions), userID)
+	return sessions, nil
+}
+
+// loadSessions reads all of the user's sessions; callers hold uds.mu
+func (uds *UserDataService) loadSessions(ctx context.Context, userID string) ([]*Session, error) {
 	// NEW: Use optimized collection structure
 	collectionPath := getSessionsCollectionPath(userID)
 	collection := uds.firebaseService.firestore.Collection(collectionPath)
@@ -162,16 +214,14 @@ func (uds *UserDataService) GetUserSessions(ctx context.Context, userID string)
 			return nil, fmt.Errorf("failed to iterate sessions: %w", err)
 		}
 
-		var session Session
-		if err := doc.DataTo(&session); err != nil {
+		session, err := uds.decodeSession(ctx, doc)
+		if err != nil {
 			log.Printf("Failed to parse session %s: %v", doc.Ref.ID, err)
 			continue
 		}
 
-		sessions = append(sessions, &session)
+		sessions = append(sessions, session)
 	}
-
-	log.Printf("Retrieved %d sessions for user %s", len(sessions), userID)
 	return sessions, nil
 }
 
@@ -191,7 +241,15 @@ func (uds *UserDataService) StoreUserSession(ctx context.Context, userID string,
 		session.ID = docRef.ID
 	}
 
-	_, err := docRef.Set(ctx, session)
+	// Sessions too large for a document are kept in the blob store
+	document, err := uds.sessionDocument(ctx, userID, session)
+	if err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+
+	batch := &changeBatch{}
+	batch.Set(docRef, document)
+	err = uds.commitChanges(ctx, batch, userID, documentChange{"sessions", session.ID, ChangeOperationUpsert})
 	if err != nil {
 		return fmt.Errorf("failed to store session: %w", err)
 	}
@@ -207,7 +265,9 @@ func (uds *UserDataService) DeleteUserSession(ctx context.Context, userID string
 	// NEW: Use optimized collection structure
 	collectionPath := getSessionsCollectionPath(userID)
 	docRef := uds.firebaseService.firestore.Collection(collectionPath).Doc(sessionID)
-	_, err := docRef.Delete(ctx)
+	batch := &changeBatch{}
+	batch.Delete(docRef)
+	err := uds.commitChanges(ctx, batch, userID, documentChange{"sessions", sessionID, ChangeOperationDelete})
 	if err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
@@ -232,12 +292,17 @@ func (uds *UserDataService) GetUserSession(ctx context.Context, userID string, s
 		return nil, fmt.Errorf("session not found")
 	}
 
-	var session Session
-	if err := doc.DataTo(&session); err != nil {
+	session, err := uds.decodeSession(ctx, doc)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse session: %w", err)
 	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations.annotateSessions([]*Session{session})
 
-	return &session, nil
+	return session, nil
 }
 
 // Saved Tabs Management Methods
@@ -246,6 +311,22 @@ func (uds *UserDataService) GetUserSavedTabs(ctx context.Context, userID string)
 	uds.mu.RLock()
 	defer uds.mu.RUnlock()
 
+	tabs, err := uds.loadSavedTabs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := uds.loadAnnotations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	annotations.annotateSavedTabs(tabs)
+
+	log.Printf("Retrieved %d saved tabs for user %s (NEW structure)", len(tabs), userID)
+	return tabs, nil
+}
+
+// loadSavedTabs reads all of the user's saved tabs; callers hold uds.mu
+func (uds *UserDataService) loadSavedTabs(ctx context.Context, userID string) ([]*SavedTab, error) {
 	// NEW: Use optimized collection structure
 	collectionPath := getSavedTabsCollectionPath(userID)
 	collection := uds.firebaseService.firestore.Collection(collectionPath)
@@ -270,20 +351,41 @@ func (uds *UserDataService) GetUserSavedTabs(ctx context.Context, userID string)
 
 		tabs = append(tabs, &tab)
 	}
-
-	log.Printf("Retrieved %d saved tabs for user %s (NEW structure)", len(tabs), userID)
 	return tabs, nil
 }
 
 func (uds *UserDataService) StoreSavedTabs(ctx context.Context, userID string, tabs []*SavedTab) error {
+	_, err := uds.storeSavedTabs(ctx, userID, tabs)
+	return err
+}
+
+// storeSavedTabs stores tabs in chunks, in order, and returns how many were
+// stored; when a chunk fails, the tabs before it stay stored
+func (uds *UserDataService) storeSavedTabs(ctx context.Context, userID string, tabs []*SavedTab) (int, error) {
 	uds.mu.Lock()
 	defer uds.mu.Unlock()
 
-	batch := uds.firebaseService.firestore.Batch()
 	// NEW: Use optimized collection structure
 	collectionPath := getSavedTabsCollectionPath(userID)
 	collection := uds.firebaseService.firestore.Collection(collectionPath)
 
+	// Each tab takes two writes (the tab and its sync log entry)
+	const chunkSize = changeWriteLimit / 2
+	for start := 0; start < len(tabs); start += chunkSize {
+		chunk := tabs[start:min(start+chunkSize, len(tabs))]
+		if err := uds.storeSavedTabsChunk(ctx, userID, collection, chunk); err != nil {
+			return start, fmt.Errorf("failed to store saved tabs: %w", err)
+		}
+	}
+
+	log.Printf("Stored %d saved tabs for user %s (NEW structure)", len(tabs), userID)
+	return len(tabs), nil
+}
+
+// storeSavedTabsChunk writes up to half a batch of saved tabs
+func (uds *UserDataService) storeSavedTabsChunk(ctx context.Context, userID string, collection *firestore.CollectionRef, tabs []*SavedTab) error {
+	batch := &changeBatch{}
+	changes := make([]documentChange, 0, len(tabs))
 	for _, tab := range tabs {
 		var docRef *firestore.DocumentRef
 		// Use tab ID as string for document ID, or generate new one
@@ -306,14 +408,32 @@ func (uds *UserDataService) StoreSavedTabs(ctx context.Context, userID string, t
 			}
 		}
 		batch.Set(docRef, tab)
+		changes = append(changes, documentChange{"saved-tabs", fmt.Sprintf("%d", tab.ID), ChangeOperationUpsert})
 	}
 
-	_, err := batch.Commit(ctx)
+	return uds.commitChanges(ctx, batch, userID, changes...)
+}
+
+func (uds *UserDataService) DeleteSavedTab(ctx context.Context, userID string, tabID int) error {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	collectionPath := getSavedTabsCollectionPath(userID)
+	docRef := uds.firebaseService.firestore.Collection(collectionPath).Doc(fmt.Sprintf("%d", tabID))
+	batch := &changeBatch{}
+	batch.Delete(docRef)
+	err := uds.commitChanges(ctx, batch, userID, documentChange{"saved-tabs", fmt.Sprintf("%d", tabID), ChangeOperationDelete})
 	if err != nil {
-		return fmt.Errorf("failed to store saved tabs: %w", err)
+		return fmt.Errorf("failed to delete saved tab: %w", err)
+	}
+	if err := uds.deleteArchive(ctx, userID, tabID); err != nil {
+		log.Printf("Failed to delete archive of saved tab %d for user %s: %v", tabID, userID, err)
+	}
+	if err := uds.deleteItemAnnotations(ctx, userID, LinkTypeSavedTab, strconv.Itoa(tabID)); err != nil {
+		log.Printf("Failed to delete annotations of saved tab %d for user %s: %v", tabID, userID, err)
 	}
 
-	log.Printf("Stored %d saved tabs for user %s (NEW structure)", len(tabs), userID)
+	log.Printf("Deleted saved tab %d for user %s (NEW structure)", tabID, userID)
 	return nil
 }
 
@@ -344,7 +464,9 @@ func (uds *UserDataService) SaveUserSettings(ctx context.Context, userID string,
 	// NEW: Use optimized collection structure
 	collectionPath := getCollectionPath(userID, "settings")
 	docRef := uds.firebaseService.firestore.Collection(collectionPath).Doc("settings")
-	_, err := docRef.Set(ctx, settings)
+	batch := &changeBatch{}
+	batch.Set(docRef, settings)
+	err := uds.commitChanges(ctx, batch, userID, documentChange{"settings", "settings", ChangeOperationUpsert})
 	if err != nil {
 		return fmt.Errorf("failed to save settings: %w", err)
 	}
@@ -353,9 +475,38 @@ func (uds *UserDataService) SaveUserSettings(ctx context.Context, userID string,
 	return nil
 }
 
+// GetUserLocation returns the user's time zone from the timeZone setting (an
+// IANA name such as Europe/London), or UTC when unset or invalid
+func (uds *UserDataService) GetUserLocation(ctx context.Context, userID string) *time.Location {
+	settings, err := uds.GetUserSettings(ctx, userID)
+	if err != nil {
+		return time.UTC
+	}
+	name, _ := settings["timeZone"].(string)
+	if name == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Invalid time zone %q for user %s: %v", name, userID, err)
+		return time.UTC
+	}
+	return location
+}
+
 // Generic Storage Methods
 
 func (uds *UserDataService) GetUserData(ctx context.Context, userID string, key string) (interface{}, error) {
+	// Tasks and disruptions are stored one document each
+	switch key {
+	case tasksStorageKey:
+		return uds.getTasksValue(ctx, userID)
+	case disruptionsStorageKey:
+		return uds.getDisruptionsValue(ctx, userID)
+	case disruptionIDsStorageKey:
+		return uds.getDisruptionIDsValue(ctx, userID)
+	}
+
 	uds.mu.RLock()
 	defer uds.mu.RUnlock()
 
@@ -367,25 +518,34 @@ func (uds *UserDataService) GetUserData(ctx context.Context, userID string, key
 		return nil, fmt.Errorf("failed to get data for key %s: %w", key, err)
 	}
 
-	data := doc.Data()
-	if value, exists := data["value"]; exists {
-		return value, nil
-	}
-
-	return data, nil
+	return uds.storedValue(ctx, doc)
 }
 
 func (uds *UserDataService) SetUserData(ctx context.Context, userID string, key string, value interface{}) error {
+	switch key {
+	case tasksStorageKey:
+		return uds.replaceTasks(ctx, userID, value)
+	case disruptionsStorageKey:
+		return uds.replaceDisruptions(ctx, userID, value)
+	case disruptionIDsStorageKey:
+		// Derived from the disruptions
+		return nil
+	}
+
 	uds.mu.Lock()
 	defer uds.mu.Unlock()
 
 	// NEW: Use optimized collection structure
 	collectionPath := getCollectionPath(userID, key)
 	docRef := uds.firebaseService.firestore.Collection(collectionPath).Doc(key)
-	_, err := docRef.Set(ctx, map[string]interface{}{
-		"value":     value,
-		"timestamp": firestore.ServerTimestamp,
-	})
+	// Values too large for a document are kept in the blob store
+	document, err := uds.storedValueDocument(ctx, userID, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to set data for key %s: %w", key, err)
+	}
+	batch := &changeBatch{}
+	batch.Set(docRef, document)
+	err = uds.commitChanges(ctx, batch, userID, documentChange{getCollectionType(key), key, ChangeOperationUpsert})
 	if err != nil {
 		return fmt.Errorf("failed to set data for key %s: %w", key, err)
 	}
@@ -395,13 +555,24 @@ func (uds *UserDataService) SetUserData(ctx context.Context, userID string, key
 }
 
 func (uds *UserDataService) DeleteUserData(ctx context.Context, userID string, key string) error {
+	switch key {
+	case tasksStorageKey:
+		return uds.deleteAllTasks(ctx, userID)
+	case disruptionsStorageKey:
+		return uds.deleteAllDisruptions(ctx, userID)
+	case disruptionIDsStorageKey:
+		return nil
+	}
+
 	uds.mu.Lock()
 	defer uds.mu.Unlock()
 
 	// NEW: Use optimized collection structure
 	collectionPath := getCollectionPath(userID, key)
 	docRef := uds.firebaseService.firestore.Collection(collectionPath).Doc(key)
-	_, err := docRef.Delete(ctx)
+	batch := &changeBatch{}
+	batch.Delete(docRef)
+	err := uds.commitChanges(ctx, batch, userID, documentChange{getCollectionType(key), key, ChangeOperationDelete})
 	if err != nil {
 		return fmt.Errorf("failed to delete data for key %s: %w", key, err)
 	}
@@ -410,6 +581,60 @@ func (uds *UserDataService) DeleteUserData(ctx context.Context, userID string, k
 	return nil
 }
 
+// storedValueRef returns the document holding a generic storage key's value
+func (uds *UserDataService) storedValueRef(userID, key string) *firestore.DocumentRef {
+	return uds.firebaseService.firestore.Collection(getCollectionPath(userID, key)).Doc(key)
+}
+
+// readStoredValue decodes a generic storage key's value into v, reporting
+// whether it exists; callers hold uds.mu
+func (uds *UserDataService) readStoredValue(ctx context.Context, userID, key string, v interface{}) (bool, error) {
+	doc, err := uds.storedValueRef(userID, key).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+
+	if packed, err := uds.unpack(ctx, doc, v); err != nil || packed {
+		return packed, err
+	}
+	value, exists := doc.Data()["value"]
+	if !exists || value == nil {
+		return false, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// stageStoredValue adds the write storing v under a generic storage key, in
+// the same shape as SetUserData
+func (uds *UserDataService) stageStoredValue(ctx context.Context, batch *changeBatch, userID, key string, v interface{}) (documentChange, error) {
+	value, err := toJSONValue(v)
+	if err != nil {
+		return documentChange{}, err
+	}
+	document, err := uds.storedValueDocument(ctx, userID, key, value)
+	if err != nil {
+		return documentChange{}, err
+	}
+	batch.Set(uds.storedValueRef(userID, key), document)
+	return documentChange{getCollectionType(key), key, ChangeOperationUpsert}, nil
+}
+
+// SubscribeChanges subscribes subscriberID to the user's change events,
+// replaying buffered events newer than lastVersion
+func (uds *UserDataService) SubscribeChanges(userID, subscriberID string, lastVersion int64) *ChangeSubscription {
+	return uds.changeFeed.Subscribe(userID, subscriberID, lastVersion)
+}
+
 // Close cleans up resources
 func (uds *UserDataService) Close() error {
 	uds.mu.Lock()
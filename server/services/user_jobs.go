@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userJobEntry is a user's entry in a background job index, such as the
+// link checker's
+type userJobEntry struct {
+	UserID  string    `firestore:"userId"`
+	NextRun time.Time `firestore:"nextRun"`
+}
+
+// userJobRef returns a user's document in a background job index
+func userJobRef(client *firestore.Client, collectionName, userID string) *firestore.DocumentRef {
+	return client.Collection(collectionName).Doc(url.PathEscape(userID))
+}
+
+// scheduleUserJob adds the user to a background job index, once per process;
+// users already indexed keep their schedule
+func (uds *UserDataService) scheduleUserJob(ctx context.Context, collectionName, userID string) {
+	key := collectionName + "/" + userID
+	if _, done := uds.userJobsScheduled.Load(key); done {
+		return
+	}
+	ref := userJobRef(uds.firebaseService.firestore, collectionName, userID)
+	_, err := ref.Create(ctx, userJobEntry{UserID: userID, NextRun: time.Now()})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		log.Printf("Failed to add user %s to %s: %v", userID, collectionName, err)
+		return
+	}
+	uds.userJobsScheduled.Store(key, true)
+}
+
+// requestUserJob makes a user due in a background job index, so the job
+// runs for them on the worker's next run
+func (uds *UserDataService) requestUserJob(ctx context.Context, collectionName, userID string) error {
+	ref := userJobRef(uds.firebaseService.firestore, collectionName, userID)
+	if _, err := ref.Set(ctx, userJobEntry{UserID: userID, NextRun: time.Now()}); err != nil {
+		return fmt.Errorf("failed to add user to %s: %w", collectionName, err)
+	}
+	uds.userJobsScheduled.Store(collectionName+"/"+userID, true)
+	return nil
+}
+
+// scheduleItemJobs indexes the user for the jobs that work on a collection's
+// items
+func (uds *UserDataService) scheduleItemJobs(ctx context.Context, userID, collection string) {
+	switch collection {
+	case "sessions", "saved-tabs":
+		uds.scheduleUserJob(ctx, LINK_CHECKS_COLLECTION_NAME, userID)
+		uds.scheduleUserJob(ctx, ENRICHMENT_COLLECTION_NAME, userID)
+	case "favorites":
+		uds.scheduleUserJob(ctx, LINK_CHECKS_COLLECTION_NAME, userID)
+	}
+}
+
+// runDueUserJobs calls run for up to limit users whose entry in a job index
+// is due. run schedules the user's next run; one user failing does not hold
+// up the rest.
+func (uds *UserDataService) runDueUserJobs(ctx context.Context, collectionName string, limit int, run func(ctx context.Context, entry *userJobEntry) (time.Time, error)) error {
+	iter := uds.firebaseService.firestore.Collection(collectionName).
+		Where("nextRun", "<=", time.Now()).
+		OrderBy("nextRun", firestore.Asc).
+		Limit(limit).
+		Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %w", collectionName, err)
+		}
+
+		var entry userJobEntry
+		if err := doc.DataTo(&entry); err != nil {
+			log.Printf("Failed to parse %s entry %s: %v", collectionName, doc.Ref.ID, err)
+			continue
+		}
+
+		nextRun, err := run(ctx, &entry)
+		if err != nil {
+			log.Printf("Failed to run %s for user %s: %v", collectionName, entry.UserID, err)
+			continue
+		}
+		entry.NextRun = nextRun
+		if _, err := doc.Ref.Set(ctx, entry); err != nil {
+			log.Printf("Failed to schedule %s for user %s: %v", collectionName, entry.UserID, err)
+		}
+	}
+}
+
+// stagedWrite stages one document write on a batch and returns its change
+type stagedWrite func(*changeBatch) (documentChange, error)
+
+// stageSet returns a stagedWrite that sets a document
+func stageSet(ref *firestore.DocumentRef, value interface{}, change documentChange) stagedWrite {
+	return func(batch *changeBatch) (documentChange, error) {
+		batch.Set(ref, value)
+		return change, nil
+	}
+}
+
+// maxStagedWrites is the most writes commitStagedWrites takes: each takes
+// two slots of the transaction, the document and its sync log entry
+const maxStagedWrites = changeWriteLimit / 2
+
+// commitStagedWrites commits writes in one transaction, so they apply all
+// or none; callers keep to maxStagedWrites
+func (uds *UserDataService) commitStagedWrites(ctx context.Context, userID string, writes []stagedWrite) error {
+	if len(writes) > maxStagedWrites {
+		return fmt.Errorf("%d writes exceed the %d one transaction takes", len(writes), maxStagedWrites)
+	}
+	batch := &changeBatch{}
+	changes := make([]documentChange, 0, len(writes))
+	for _, write := range writes {
+		change, err := write(batch)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change)
+	}
+	return uds.commitChanges(ctx, batch, userID, changes...)
+}
+
+// forEachConcurrently calls fn for each item, at most concurrency at a time,
+// and returns when all calls have
+func forEachConcurrently(items []string, concurrency int, fn func(string)) {
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for range min(concurrency, len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,738 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Workspace metadata and invitations live in their own top-level collections;
+// workspace data lives under COLLECTION_NAME/workspace:{id}/...
+const (
+	WORKSPACES_COLLECTION_NAME            = "tab-blaster-5k-workspaces"
+	WORKSPACE_INVITATIONS_COLLECTION_NAME = "tab-blaster-5k-workspace-invitations"
+
+	workspaceNamespacePrefix = "workspace:"
+	workspaceInvitationTTL   = 14 * 24 * time.Hour
+)
+
+// WorkspaceStorageKeys are the generic storage keys that can be shared in a
+// workspace; everything else (settings, focus data, ...) stays personal
+var WorkspaceStorageKeys = map[string]bool{
+	"favorites": true,
+}
+
+// WorkspaceRole is a member's role in a workspace
+type WorkspaceRole string
+
+const (
+	WorkspaceRoleOwner  WorkspaceRole = "owner"
+	WorkspaceRoleEditor WorkspaceRole = "editor"
+	WorkspaceRoleViewer WorkspaceRole = "viewer"
+)
+
+// Workspace errors
+var (
+	ErrWorkspaceNotFound    = errors.New("workspace not found")
+	ErrWorkspaceForbidden   = errors.New("insufficient workspace permissions")
+	ErrInvitationNotFound   = errors.New("invitation not found")
+	ErrInvitationExpired    = errors.New("invitation has expired")
+	ErrInvitationEmail      = errors.New("invitation was sent to a different email address")
+	ErrEmailNotVerified     = errors.New("account email address is not verified")
+	ErrLastWorkspaceOwner   = errors.New("a workspace must keep at least one owner")
+	ErrInvalidWorkspaceRole = errors.New("invalid workspace role (expected owner, editor or viewer)")
+)
+
+// Workspace is a shared namespace for sessions, saved tabs and favorites
+type Workspace struct {
+	ID        string                   `json:"id" firestore:"id"`
+	Name      string                   `json:"name" firestore:"name"`
+	OwnerID   string                   `json:"ownerId" firestore:"ownerId"` // creator
+	CreatedAt string                   `json:"createdAt" firestore:"createdAt"`
+	Members   map[string]WorkspaceRole `json:"members" firestore:"members"`  // user ID -> role
+	MemberIDs []string                 `json:"-" firestore:"memberIds"`      // for array-contains queries
+	Role      WorkspaceRole            `json:"role,omitempty" firestore:"-"` // caller's role
+}
+
+// WorkspaceInvitation invites an email address to join a workspace
+type WorkspaceInvitation struct {
+	Token         string        `json:"token,omitempty" firestore:"token"`
+	WorkspaceID   string        `json:"workspaceId" firestore:"workspaceId"`
+	WorkspaceName string        `json:"workspaceName" firestore:"workspaceName"`
+	Email         string        `json:"email" firestore:"email"`
+	Role          WorkspaceRole `json:"role" firestore:"role"`
+	InvitedBy     string        `json:"invitedBy" firestore:"invitedBy"`
+	CreatedAt     string        `json:"createdAt" firestore:"createdAt"`
+	ExpiresAt     string        `json:"expiresAt" firestore:"expiresAt"`
+}
+
+// WorkspaceService manages workspaces, membership and access checks
+type WorkspaceService struct {
+	firebaseService *FirebaseService
+	mu              sync.RWMutex
+}
+
+var (
+	workspaceService *WorkspaceService
+	workspaceOnce    sync.Once
+	workspaceErr     error
+)
+
+// NewWorkspaceService creates a new workspace service instance
+func NewWorkspaceService() (*WorkspaceService, error) {
+	workspaceOnce.Do(func() {
+		workspaceService, workspaceErr = initializeWorkspaceService()
+	})
+	return workspaceService, workspaceErr
+}
+
+// initializeWorkspaceService initializes the workspace service
+func initializeWorkspaceService() (*WorkspaceService, error) {
+	firebaseService, err := NewFirebaseService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Firebase service: %w", err)
+	}
+
+	service := &WorkspaceService{
+		firebaseService: firebaseService,
+	}
+
+	log.Println("Workspace service initialized successfully")
+	return service, nil
+}
+
+// WorkspaceNamespace returns the owner key under which a workspace's data is
+// stored. It is passed wherever UserDataService expects a user ID.
+func WorkspaceNamespace(workspaceID string) string {
+	return workspaceNamespacePrefix + workspaceID
+}
+
+// IsWorkspaceNamespace reports whether an owner key refers to a workspace
+func IsWorkspaceNamespace(ownerID string) bool {
+	return strings.HasPrefix(ownerID, workspaceNamespacePrefix)
+}
+
+// ParseWorkspaceRole validates a role name
+func ParseWorkspaceRole(role string) (WorkspaceRole, error) {
+	switch WorkspaceRole(role) {
+	case WorkspaceRoleOwner, WorkspaceRoleEditor, WorkspaceRoleViewer:
+		return WorkspaceRole(role), nil
+	default:
+		return "", ErrInvalidWorkspaceRole
+	}
+}
+
+// CanWrite reports whether the role may modify workspace data
+func (r WorkspaceRole) CanWrite() bool {
+	return r == WorkspaceRoleOwner || r == WorkspaceRoleEditor
+}
+
+func (ws *WorkspaceService) workspaces() *firestore.CollectionRef {
+	return ws.firebaseService.firestore.Collection(WORKSPACES_COLLECTION_NAME)
+}
+
+func (ws *WorkspaceService) invitations() *firestore.CollectionRef {
+	return ws.firebaseService.firestore.Collection(WORKSPACE_INVITATIONS_COLLECTION_NAME)
+}
+
+// CreateWorkspace creates a workspace owned by the user
+func (ws *WorkspaceService) CreateWorkspace(ctx context.Context, userID, name string) (*Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("workspace name is required")
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	docRef := ws.workspaces().NewDoc()
+	workspace := &Workspace{
+		ID:        docRef.ID,
+		Name:      name,
+		OwnerID:   userID,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Members:   map[string]WorkspaceRole{userID: WorkspaceRoleOwner},
+		MemberIDs: []string{userID},
+	}
+	if _, err := docRef.Create(ctx, workspace); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	workspace.Role = WorkspaceRoleOwner
+	log.Printf("Created workspace %s for user %s", workspace.ID, userID)
+	return workspace, nil
+}
+
+// ListWorkspaces returns the workspaces the user is a member of
+func (ws *WorkspaceService) ListWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	iter := ws.workspaces().Where("memberIds", "array-contains", userID).Documents(ctx)
+	defer iter.Stop()
+
+	workspaces := []*Workspace{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate workspaces: %w", err)
+		}
+
+		var workspace Workspace
+		if err := doc.DataTo(&workspace); err != nil {
+			log.Printf("Failed to parse workspace %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		workspace.Role = workspace.Members[userID]
+		workspaces = append(workspaces, &workspace)
+	}
+
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].Name < workspaces[j].Name })
+	return workspaces, nil
+}
+
+// GetWorkspace returns a workspace the user is a member of
+func (ws *WorkspaceService) GetWorkspace(ctx context.Context, userID, workspaceID string) (*Workspace, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	return ws.getMemberWorkspace(ctx, userID, workspaceID)
+}
+
+// getMemberWorkspace loads a workspace, hiding it from non-members
+func (ws *WorkspaceService) getMemberWorkspace(ctx context.Context, userID, workspaceID string) (*Workspace, error) {
+	workspace, err := loadWorkspace(ws.workspaces().Doc(workspaceID).Get(ctx))
+	if err != nil {
+		return nil, err
+	}
+	role, ok := workspace.Members[userID]
+	if !ok {
+		return nil, ErrWorkspaceNotFound
+	}
+	workspace.Role = role
+	return workspace, nil
+}
+
+// loadWorkspace decodes a workspace document snapshot
+func loadWorkspace(doc *firestore.DocumentSnapshot, err error) (*Workspace, error) {
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace: %w", err)
+	}
+
+	var workspace Workspace
+	if err := doc.DataTo(&workspace); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace: %w", err)
+	}
+	return &workspace, nil
+}
+
+// AuthorizeWorkspace checks that the user may read (or write) the workspace
+// and returns the namespace its data is stored under
+func (ws *WorkspaceService) AuthorizeWorkspace(ctx context.Context, userID, workspaceID string, write bool) (string, error) {
+	workspace, err := ws.GetWorkspace(ctx, userID, workspaceID)
+	if err != nil {
+		return "", err
+	}
+	if write && !workspace.Role.CanWrite() {
+		return "", ErrWorkspaceForbidden
+	}
+	return WorkspaceNamespace(workspace.ID), nil
+}
+
+// RenameWorkspace changes a workspace's name (owners only)
+func (ws *WorkspaceService) RenameWorkspace(ctx context.Context, userID, workspaceID, name string) (*Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("workspace name is required")
+	}
+
+	return ws.updateWorkspace(ctx, userID, workspaceID, func(workspace *Workspace) error {
+		if workspace.Members[userID] != WorkspaceRoleOwner {
+			return ErrWorkspaceForbidden
+		}
+		workspace.Name = name
+		return nil
+	})
+}
+
+// DeleteWorkspace deletes a workspace and its shared data (owners only)
+func (ws *WorkspaceService) DeleteWorkspace(ctx context.Context, userID, workspaceID string) error {
+	workspace, err := ws.GetWorkspace(ctx, userID, workspaceID)
+	if err != nil {
+		return err
+	}
+	if workspace.Role != WorkspaceRoleOwner {
+		return ErrWorkspaceForbidden
+	}
+
+	userDataService, err := NewUserDataService()
+	if err != nil {
+		return err
+	}
+	namespace := WorkspaceNamespace(workspaceID)
+	// The workspace stays until its data is gone, so a failed delete can be
+	// retried
+	if err := userDataService.deleteNamespace(ctx, namespace); err != nil {
+		return fmt.Errorf("failed to delete workspace data: %w", err)
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, err := ws.workspaces().Doc(workspaceID).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete workspace: %w", err)
+	}
+	// Members following the workspace's changes are disconnected and can no
+	// longer resubscribe
+	userDataService.changeFeed.CloseUser(namespace)
+
+	log.Printf("Deleted workspace %s by user %s", workspaceID, userID)
+	return nil
+}
+
+// unsyncedCollections are the collections under a namespace that hold sync
+// and worker bookkeeping rather than data
+var unsyncedCollections = map[string]bool{
+	"sync-log":    true,
+	"conflicts":   true,
+	"sync-state":  true,
+	"annotations": true,
+}
+
+// deleteNamespace deletes everything stored under a namespace: its data
+// collections, with their spilled blobs, saved tab archives and deletions
+// published to subscribers, then its sync log, conflicts, version counter and
+// annotations, and its entries in the background job indexes
+func (uds *UserDataService) deleteNamespace(ctx context.Context, namespace string) error {
+	uds.mu.Lock()
+	defer uds.mu.Unlock()
+
+	client := uds.firebaseService.firestore
+	collections, err := client.Collection(COLLECTION_NAME).Doc(namespace).Collections(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+	for _, collection := range collections {
+		if !unsyncedCollections[collection.ID] {
+			if err := uds.deleteSyncedCollection(ctx, namespace, collection); err != nil {
+				return err
+			}
+		}
+	}
+	// Deleting the data wrote sync log entries, so bookkeeping goes last
+	for _, collection := range collections {
+		if unsyncedCollections[collection.ID] {
+			if err := deleteCollection(ctx, client, collection); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, jobs := range []string{LINK_CHECKS_COLLECTION_NAME, ENRICHMENT_COLLECTION_NAME, ARCHIVES_COLLECTION_NAME} {
+		if _, err := userJobRef(client, jobs, namespace).Delete(ctx); err != nil {
+			return fmt.Errorf("failed to remove from %s: %w", jobs, err)
+		}
+		uds.userJobsScheduled.Delete(jobs + "/" + namespace)
+	}
+	return nil
+}
+
+// deleteSyncedCollection deletes a data collection through the sync log,
+// which also removes the documents' spilled blobs; callers hold uds.mu
+func (uds *UserDataService) deleteSyncedCollection(ctx context.Context, namespace string, collection *firestore.CollectionRef) error {
+	// Each document takes two writes (the document and its sync log entry)
+	const chunkSize = changeWriteLimit / 2
+	for {
+		docs, err := collection.Limit(chunkSize).Documents(ctx).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", collection.ID, err)
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		batch := &changeBatch{}
+		changes := make([]documentChange, 0, len(docs))
+		for _, doc := range docs {
+			batch.Delete(doc.Ref)
+			changes = append(changes, documentChange{collection.ID, doc.Ref.ID, ChangeOperationDelete})
+		}
+		if err := uds.commitChanges(ctx, batch, namespace, changes...); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", collection.ID, err)
+		}
+
+		if collection.ID == getCollectionType("savedTabs") {
+			for _, doc := range docs {
+				tabID, err := strconv.Atoi(doc.Ref.ID)
+				if err != nil {
+					continue
+				}
+				if err := uds.deleteArchive(ctx, namespace, tabID); err != nil {
+					log.Printf("Failed to delete archive of saved tab %d in %s: %v", tabID, namespace, err)
+				}
+			}
+		}
+	}
+}
+
+// deleteCollection removes every document in a collection in batches
+func deleteCollection(ctx context.Context, client *firestore.Client, collection *firestore.CollectionRef) error {
+	for {
+		docs, err := collection.Limit(firestoreBatchLimit).Documents(ctx).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", collection.ID, err)
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		batch := client.Batch()
+		for _, doc := range docs {
+			batch.Delete(doc.Ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", collection.ID, err)
+		}
+	}
+}
+
+// InviteMember invites an email address to the workspace (owners only)
+func (ws *WorkspaceService) InviteMember(ctx context.Context, userID, workspaceID, email string, role WorkspaceRole) (*WorkspaceInvitation, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("a valid email address is required")
+	}
+	if _, err := ParseWorkspaceRole(string(role)); err != nil {
+		return nil, err
+	}
+
+	workspace, err := ws.GetWorkspace(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if workspace.Role != WorkspaceRoleOwner {
+		return nil, ErrWorkspaceForbidden
+	}
+
+	token, err := generateShareToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	invitation := &WorkspaceInvitation{
+		Token:         token,
+		WorkspaceID:   workspaceID,
+		WorkspaceName: workspace.Name,
+		Email:         email,
+		Role:          role,
+		InvitedBy:     userID,
+		CreatedAt:     now.Format(time.RFC3339),
+		ExpiresAt:     now.Add(workspaceInvitationTTL).Format(time.RFC3339),
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, err := ws.invitations().Doc(token).Create(ctx, invitation); err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	log.Printf("Invited %s to workspace %s as %s", email, workspaceID, role)
+	return invitation, nil
+}
+
+// ListWorkspaceInvitations returns pending invitations for a workspace (owners only)
+func (ws *WorkspaceService) ListWorkspaceInvitations(ctx context.Context, userID, workspaceID string) ([]*WorkspaceInvitation, error) {
+	workspace, err := ws.GetWorkspace(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if workspace.Role != WorkspaceRoleOwner {
+		return nil, ErrWorkspaceForbidden
+	}
+
+	return ws.queryInvitations(ctx, ws.invitations().Where("workspaceId", "==", workspaceID))
+}
+
+// ListUserInvitations returns pending invitations addressed to the user's
+// email. Their tokens are left out: the invitee gets the token from whoever
+// invited them, so owning the address alone is not enough to join.
+func (ws *WorkspaceService) ListUserInvitations(ctx context.Context, userID string) ([]*WorkspaceInvitation, error) {
+	email, err := ws.userEmail(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := ws.queryInvitations(ctx, ws.invitations().Where("email", "==", email))
+	if err != nil {
+		return nil, err
+	}
+	for _, invitation := range invitations {
+		invitation.Token = ""
+	}
+	return invitations, nil
+}
+
+func (ws *WorkspaceService) queryInvitations(ctx context.Context, query firestore.Query) ([]*WorkspaceInvitation, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	now := time.Now()
+	invitations := []*WorkspaceInvitation{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate invitations: %w", err)
+		}
+
+		var invitation WorkspaceInvitation
+		if err := doc.DataTo(&invitation); err != nil {
+			log.Printf("Failed to parse invitation %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if expiresAt, ok := parseTimestamp(invitation.ExpiresAt); ok && now.After(expiresAt) {
+			continue
+		}
+		invitations = append(invitations, &invitation)
+	}
+
+	return invitations, nil
+}
+
+// AcceptInvitation adds the user to the invited workspace. The invitation
+// must have been sent to the user's account email.
+func (ws *WorkspaceService) AcceptInvitation(ctx context.Context, userID, token string) (*Workspace, error) {
+	email, err := ws.userEmail(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	client := ws.firebaseService.firestore
+	invitationRef := ws.invitations().Doc(token)
+	var workspace *Workspace
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(invitationRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrInvitationNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get invitation: %w", err)
+		}
+
+		var invitation WorkspaceInvitation
+		if err := doc.DataTo(&invitation); err != nil {
+			return fmt.Errorf("failed to parse invitation: %w", err)
+		}
+		if expiresAt, ok := parseTimestamp(invitation.ExpiresAt); ok && time.Now().After(expiresAt) {
+			return ErrInvitationExpired
+		}
+		if invitation.Email != email {
+			return ErrInvitationEmail
+		}
+
+		workspaceRef := ws.workspaces().Doc(invitation.WorkspaceID)
+		workspace, err = loadWorkspace(tx.Get(workspaceRef))
+		if err != nil {
+			return err
+		}
+
+		// Accepting never downgrades an existing member
+		if current, ok := workspace.Members[userID]; !ok || roleRank(invitation.Role) > roleRank(current) {
+			workspace.setMember(userID, invitation.Role)
+		}
+		workspace.Role = workspace.Members[userID]
+
+		if err := tx.Set(workspaceRef, workspace); err != nil {
+			return err
+		}
+		return tx.Delete(invitationRef)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s joined workspace %s as %s", userID, workspace.ID, workspace.Role)
+	return workspace, nil
+}
+
+// SetMemberRole changes a member's role (owners only)
+func (ws *WorkspaceService) SetMemberRole(ctx context.Context, userID, workspaceID, memberID string, role WorkspaceRole) (*Workspace, error) {
+	if _, err := ParseWorkspaceRole(string(role)); err != nil {
+		return nil, err
+	}
+
+	var previous WorkspaceRole
+	workspace, err := ws.updateWorkspace(ctx, userID, workspaceID, func(workspace *Workspace) error {
+		if workspace.Members[userID] != WorkspaceRoleOwner {
+			return ErrWorkspaceForbidden
+		}
+		var ok bool
+		if previous, ok = workspace.Members[memberID]; !ok {
+			return fmt.Errorf("user %s is not a member of this workspace", memberID)
+		}
+		workspace.setMember(memberID, role)
+		if workspace.ownerCount() == 0 {
+			return ErrLastWorkspaceOwner
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if previous != role {
+		ws.disconnectMember(workspaceID, memberID)
+	}
+	return workspace, nil
+}
+
+// RemoveMember removes a member; owners can remove anyone and members can remove themselves
+func (ws *WorkspaceService) RemoveMember(ctx context.Context, userID, workspaceID, memberID string) (*Workspace, error) {
+	workspace, err := ws.updateWorkspace(ctx, userID, workspaceID, func(workspace *Workspace) error {
+		if memberID != userID && workspace.Members[userID] != WorkspaceRoleOwner {
+			return ErrWorkspaceForbidden
+		}
+		if _, ok := workspace.Members[memberID]; !ok {
+			return fmt.Errorf("user %s is not a member of this workspace", memberID)
+		}
+		workspace.removeMember(memberID)
+		if workspace.ownerCount() == 0 {
+			return ErrLastWorkspaceOwner
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ws.disconnectMember(workspaceID, memberID)
+	return workspace, nil
+}
+
+// disconnectMember closes the member's subscriptions to the workspace's
+// changes. Sockets and event streams are authorized when they connect, so
+// they reconnect under the member's current role.
+func (ws *WorkspaceService) disconnectMember(workspaceID, memberID string) {
+	userDataService, err := NewUserDataService()
+	if err != nil {
+		log.Printf("Failed to disconnect member %s from workspace %s: %v", memberID, workspaceID, err)
+		return
+	}
+	userDataService.changeFeed.CloseSubscriber(WorkspaceNamespace(workspaceID), memberID)
+}
+
+// updateWorkspace applies a mutation to a workspace in a transaction. Only
+// members can see the workspace; the mutation enforces role requirements.
+func (ws *WorkspaceService) updateWorkspace(ctx context.Context, userID, workspaceID string, mutate func(*Workspace) error) (*Workspace, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	workspaceRef := ws.workspaces().Doc(workspaceID)
+	var workspace *Workspace
+	err := ws.firebaseService.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var err error
+		workspace, err = loadWorkspace(tx.Get(workspaceRef))
+		if err != nil {
+			return err
+		}
+		if _, ok := workspace.Members[userID]; !ok {
+			return ErrWorkspaceNotFound
+		}
+		if err := mutate(workspace); err != nil {
+			return err
+		}
+		return tx.Set(workspaceRef, workspace)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	workspace.Role = workspace.Members[userID]
+	return workspace, nil
+}
+
+// userEmail looks up the user's account email, normalized for comparison.
+// Anyone can register an address, so only verified ones are returned.
+func (ws *WorkspaceService) userEmail(ctx context.Context, userID string) (string, error) {
+	user, err := ws.firebaseService.GetUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if user.Email == "" {
+		return "", fmt.Errorf("account has no email address")
+	}
+	if !user.EmailVerified {
+		return "", ErrEmailNotVerified
+	}
+	return strings.ToLower(user.Email), nil
+}
+
+func (w *Workspace) setMember(userID string, role WorkspaceRole) {
+	if w.Members == nil {
+		w.Members = make(map[string]WorkspaceRole)
+	}
+	w.Members[userID] = role
+	w.syncMemberIDs()
+}
+
+func (w *Workspace) removeMember(userID string) {
+	delete(w.Members, userID)
+	w.syncMemberIDs()
+}
+
+func (w *Workspace) syncMemberIDs() {
+	w.MemberIDs = w.MemberIDs[:0]
+	for id := range w.Members {
+		w.MemberIDs = append(w.MemberIDs, id)
+	}
+	sort.Strings(w.MemberIDs)
+}
+
+func (w *Workspace) ownerCount() int {
+	count := 0
+	for _, role := range w.Members {
+		if role == WorkspaceRoleOwner {
+			count++
+		}
+	}
+	return count
+}
+
+// roleRank orders roles by privilege
+func roleRank(role WorkspaceRole) int {
+	switch role {
+	case WorkspaceRoleOwner:
+		return 3
+	case WorkspaceRoleEditor:
+		return 2
+	case WorkspaceRoleViewer:
+		return 1
+	default:
+		return 0
+	}
+}